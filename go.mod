@@ -6,6 +6,7 @@ require (
 	github.com/basakil/brm-config v0.0.0
 	github.com/gofrs/flock v0.13.0
 	github.com/google/uuid v1.6.0
+	golang.org/x/sys v0.37.0
 )
 
 require (
@@ -20,7 +21,6 @@ require (
 	github.com/mitchellh/reflectwalk v1.0.2 // indirect
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	go.yaml.in/yaml/v3 v3.0.3 // indirect
-	golang.org/x/sys v0.37.0 // indirect
 )
 
 // We should not need this line, snce we are using a workspace and the brm-config module is in the workspace
@@ -0,0 +1,226 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/server"
+)
+
+// Defaults for a Log
+const (
+	DefaultQueueSize  = 1024
+	DefaultMaxBackups = 5
+)
+
+// Record is one JSON line of the audit log
+type Record struct {
+	Timestamp  time.Time `json:"timestamp"`
+	ClientIP   string    `json:"clientIp,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Repository string    `json:"repository"`
+	Action     string    `json:"action"` // "push" or "delete"
+	Kind       string    `json:"kind"`   // "manifest" or "blob"
+	Digest     string    `json:"digest"`
+	Tag        string    `json:"tag,omitempty"`
+}
+
+// Log is an events.EventSink appending a Record per push and delete to a file.
+// Records are queued and written by a background goroutine through a buffered writer, flushed
+// whenever the queue drains, so logging never blocks requests; if the queue is full the record
+// is dropped and counted. The file is rotated to path.1 … path.<maxBackups> when it would exceed maxSize.
+type Log struct {
+	events.NopSink
+
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+
+	queue   chan Record
+	dropped atomic.Int64
+	failed  atomic.Int64
+	done    chan struct{}
+	mu      sync.RWMutex // Guards closed against sends, so records sent after Close are dropped
+	closed  bool
+}
+
+// NewLog opens (or creates) the audit log at path and starts its writer.
+// maxSize <= 0 disables rotation; maxBackups <= 0 uses DefaultMaxBackups.
+func NewLog(path string, maxSize int64, maxBackups int) (*Log, error) {
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	l := &Log{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		queue:      make(chan Record, DefaultQueueSize),
+		done:       make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// OnManifestPushed implements events.EventSink
+func (l *Log) OnManifestPushed(ctx context.Context, event events.Event) {
+	l.add(ctx, event, "push", "manifest")
+}
+
+// OnManifestDeleted implements events.EventSink
+func (l *Log) OnManifestDeleted(ctx context.Context, event events.Event) {
+	l.add(ctx, event, "delete", "manifest")
+}
+
+// OnBlobPushed implements events.EventSink
+func (l *Log) OnBlobPushed(ctx context.Context, event events.Event) {
+	l.add(ctx, event, "push", "blob")
+}
+
+// add queues the record for event without blocking
+func (l *Log) add(ctx context.Context, event events.Event, action, kind string) {
+	record := Record{
+		Timestamp:  event.Timestamp,
+		User:       server.UserFromContext(ctx),
+		Repository: event.Repository,
+		Action:     action,
+		Kind:       kind,
+		Digest:     event.Digest,
+		Tag:        event.Tag,
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	if ip := server.ClientIPFromContext(ctx); ip != nil {
+		record.ClientIP = ip.String()
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.dropped.Add(1)
+		return
+	}
+	select {
+	case l.queue <- record:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Path returns the path of the audit log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Rotation returns the size in bytes at which the file is rotated (0 = never) and the number of backups kept
+func (l *Log) Rotation() (maxSize int64, maxBackups int) {
+	return l.maxSize, l.maxBackups
+}
+
+// Dropped returns the number of records dropped because the queue was full or the log was closed
+func (l *Log) Dropped() int64 {
+	return l.dropped.Load()
+}
+
+// Failed returns the number of records that could not be written
+func (l *Log) Failed() int64 {
+	return l.failed.Load()
+}
+
+// Close writes the queued records and closes the file. Events sent after Close are dropped.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+	<-l.done
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to flush audit log: %w", err)
+	}
+	return l.file.Close()
+}
+
+// run writes queued records until the queue is closed
+func (l *Log) run() {
+	defer close(l.done)
+	for record := range l.queue {
+		if err := l.write(record); err != nil {
+			l.failed.Add(1)
+		}
+		if len(l.queue) == 0 {
+			if err := l.writer.Flush(); err != nil {
+				l.failed.Add(1)
+			}
+		}
+	}
+}
+
+// write appends one JSON line, rotating first if it would exceed maxSize
+func (l *Log) write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// open opens the log file for appending
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file = file
+	l.writer = bufio.NewWriter(file)
+	l.size = stat.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 -> path.N (dropping the oldest), moves the current file to path.1 and reopens
+func (l *Log) rotate() error {
+	if err := l.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush audit log: %w", err)
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.open()
+}
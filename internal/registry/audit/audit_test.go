@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/docker/private"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/server"
+	"github.com/basakil/brm-server/internal/storage"
+)
+
+// readRecords reads all JSON lines of an audit file
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestLogManifestPushAndDelete tests that pushing and deleting a manifest writes audit lines
+func TestLogManifestPushAndDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewLog(path, 0, 0)
+	if err != nil {
+		t.Fatalf("NewLog failed: %v", err)
+	}
+
+	artifactStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	service, err := private.NewDockerRegistryPrivateService("test-storage", "")
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	service.SetStorage(artifactStorage)
+	service.SetEventSink(auditLog)
+
+	ctx := server.WithUser(server.WithClientIP(context.Background(), net.ParseIP("10.1.2.3")), "alice")
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	digest := service.CalculateDigest(manifestData)
+	if err := service.PutManifest(ctx, "team/app", "v1", manifestData, docker.MediaTypeManifestV2); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	if _, err := service.DeleteManifest(ctx, "team/app", "v1"); err != nil {
+		t.Fatalf("DeleteManifest failed: %v", err)
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %+v", records)
+	}
+	for i, action := range []string{"push", "delete"} {
+		record := records[i]
+		if record.Action != action || record.Kind != "manifest" || record.Repository != "team/app" || record.Tag != "v1" ||
+			record.Digest != digest || record.ClientIP != "10.1.2.3" || record.User != "alice" || record.Timestamp.IsZero() {
+			t.Errorf("Unexpected %s record: %+v", action, record)
+		}
+	}
+
+	// Events arriving after Close (requests still in flight at shutdown) are dropped, not a panic
+	auditLog.OnManifestPushed(ctx, events.Event{Repository: "team/app", Digest: digest})
+	if dropped := auditLog.Dropped(); dropped != 1 {
+		t.Errorf("Expected the event after Close to be dropped, got %d dropped", dropped)
+	}
+}
+
+// TestLogRotation tests that the log is rotated by size, keeping maxBackups files
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewLog(path, 150, 2)
+	if err != nil {
+		t.Fatalf("NewLog failed: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		auditLog.OnBlobPushed(context.Background(), events.Event{Repository: "repo", Digest: "sha256:abc"})
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	total := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		total += len(readRecords(t, name))
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups, got %s.3 (%v)", path, err)
+	}
+	if total == 0 || total >= 6 {
+		t.Errorf("Expected the oldest records to be rotated out, got %d records", total)
+	}
+}
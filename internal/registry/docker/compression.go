@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressionMinSize is the minimum response size (in bytes) worth compressing
+const DefaultCompressionMinSize = 1024
+
+// CompressionConfig configures gzip compression of JSON responses
+type CompressionConfig struct {
+	// Enabled turns on gzip compression for JSON endpoints (manifests, catalog, tag lists).
+	Enabled bool `json:"enabled"`
+
+	// MinSize is the minimum response size in bytes to compress when Content-Length is known.
+	// If 0, DefaultCompressionMinSize is used.
+	MinSize int `json:"minSize,omitempty"`
+
+	// Level is the gzip compression level. If 0, gzip.DefaultCompression is used.
+	Level int `json:"level,omitempty"`
+}
+
+// isCompressibleContentType checks if the content type is a JSON document worth compressing.
+// Blob bodies (application/octet-stream, tar+gzip layers) are never compressed.
+func isCompressibleContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// acceptsGzip checks if the client advertised gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), "gzip") {
+			continue
+		}
+		// Honor an explicit "gzip;q=0" refusal
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if q, ok := strings.CutPrefix(param, "q="); ok {
+				if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GzipHandler wraps a handler serving JSON documents with gzip compression.
+// Compression is applied only when enabled, requested by the client via Accept-Encoding,
+// and the response is JSON that is not already encoded.
+func GzipHandler(cfg CompressionConfig, next http.HandlerFunc) http.HandlerFunc {
+	if !cfg.Enabled {
+		return next
+	}
+
+	minSize := cfg.MinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	level := cfg.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	writerPool := &sync.Pool{
+		New: func() interface{} {
+			gz, err := gzip.NewWriterLevel(nil, level)
+			if err != nil {
+				// Invalid level - fall back to default
+				gz, _ = gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+			}
+			return gz
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			accepted:       acceptsGzip(r),
+			minSize:        int64(minSize),
+			pool:           writerPool,
+		}
+		defer gw.finish()
+
+		next(gw, r)
+	}
+}
+
+// gzipResponseWriter decides on the first WriteHeader/Write whether to compress the body
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	accepted    bool
+	minSize     int64
+	pool        *sync.Pool
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+// WriteHeader inspects the response headers and enables compression if applicable
+func (g *gzipResponseWriter) WriteHeader(statusCode int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	header := g.Header()
+	if isCompressibleContentType(header.Get("Content-Type")) && header.Get("Content-Encoding") == "" {
+		header.Add("Vary", "Accept-Encoding")
+
+		compress := g.accepted && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+		if lengthStr := header.Get("Content-Length"); compress && lengthStr != "" {
+			if length, err := strconv.ParseInt(lengthStr, 10, 64); err == nil && length < g.minSize {
+				compress = false
+			}
+		}
+
+		if compress {
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", "gzip")
+			g.gz = g.pool.Get().(*gzip.Writer)
+			g.gz.Reset(g.ResponseWriter)
+		}
+	}
+
+	g.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the body, compressing it if compression was enabled
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the underlying ResponseWriter (used by http.ResponseController)
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// finish flushes and releases the gzip writer
+func (g *gzipResponseWriter) finish() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	g.gz.Reset(nil)
+	g.pool.Put(g.gz)
+	g.gz = nil
+}
@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/storage"
+)
+
+// Digest algorithms supported for content verification
+const (
+	DigestAlgorithmSHA256 = "sha256"
+	DigestAlgorithmSHA512 = "sha512"
+)
+
+// digestPattern is the OCI digest grammar: algorithm ":" encoded
+var digestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+// digestHexLengths are the encoded lengths (lowercase hex) of the registered digest algorithms
+var digestHexLengths = map[string]int{
+	DigestAlgorithmSHA256: 64,
+	DigestAlgorithmSHA512: 128,
+}
+
+// ValidateDigest returns DIGEST_INVALID unless digest follows the OCI digest grammar. Encoded parts
+// of sha256 and sha512 digests must be lowercase hex of the algorithm's length.
+func ValidateDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return ErrDigestInvalid(fmt.Sprintf("malformed digest: %q", digest))
+	}
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	if length, ok := digestHexLengths[algorithm]; ok {
+		if len(encoded) != length || strings.Trim(encoded, "0123456789abcdef") != "" {
+			return ErrDigestInvalid(fmt.Sprintf("malformed %s digest: %q", algorithm, digest))
+		}
+	}
+	return nil
+}
+
+// DigestAlgorithm returns the algorithm of an "<algorithm>:<encoded>" digest ("" if it has none)
+func DigestAlgorithm(digest string) string {
+	algorithm, _, ok := strings.Cut(digest, ":")
+	if !ok {
+		return ""
+	}
+	return algorithm
+}
+
+// NewDigestHasher returns a hash computing digests of the algorithm (nil if unsupported).
+// SHA-256 hashers are pooled: hand them back with ReleaseDigestHasher when done.
+func NewDigestHasher(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestAlgorithmSHA256:
+		return storage.AcquireSHA256()
+	case DigestAlgorithmSHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// ReleaseDigestHasher returns a hasher from NewDigestHasher(algorithm) to its pool, if it has one
+func ReleaseDigestHasher(algorithm string, hasher hash.Hash) {
+	if algorithm == DigestAlgorithmSHA256 {
+		storage.ReleaseSHA256(hasher)
+	}
+}
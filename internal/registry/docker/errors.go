@@ -2,8 +2,13 @@ package docker
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
 )
 
 // RegistryError represents an error response following OCI Distribution Spec
@@ -11,6 +16,9 @@ type RegistryError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 	Detail  string `json:"detail,omitempty"`
+	Status  int    `json:"-"` // Overrides the status derived from Code when set
+
+	RetryAfter time.Duration `json:"-"` // Sent as Retry-After (whole seconds) when set
 }
 
 // Error implements the error interface
@@ -23,6 +31,9 @@ func (e *RegistryError) Error() string {
 
 // HTTPStatus returns the HTTP status code for the error
 func (e *RegistryError) HTTPStatus() int {
+	if e.Status != 0 {
+		return e.Status
+	}
 	switch e.Code {
 	case "UNAUTHORIZED":
 		return http.StatusUnauthorized
@@ -32,23 +43,39 @@ func (e *RegistryError) HTTPStatus() int {
 		return http.StatusNotFound
 	case "BLOB_UPLOAD_UNKNOWN":
 		return http.StatusNotFound
-	case "BLOB_UPLOAD_INVALID", "MANIFEST_INVALID":
+	case "BLOB_UPLOAD_INVALID", "MANIFEST_INVALID", "PAGINATION_NUMBER_INVALID", "DIGEST_INVALID", "NAME_INVALID":
 		return http.StatusBadRequest
 	case "RANGE_INVALID":
 		return http.StatusRequestedRangeNotSatisfiable
 	case "UNSUPPORTED":
 		return http.StatusMethodNotAllowed
+	case "PRECONDITION_FAILED":
+		return http.StatusPreconditionFailed
+	case "UNAVAILABLE":
+		return http.StatusServiceUnavailable
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
+// AsRegistryError returns the RegistryError err carries, converting storage lock timeouts
+// (storage.ErrLockTimeout) to ErrUnavailable with a Retry-After of the storage's lock timeout
+func AsRegistryError(err error) (*RegistryError, bool) {
+	var regErr *RegistryError
+	if errors.As(err, &regErr) {
+		return regErr, true
+	}
+	var lockErr *storage.LockTimeoutError
+	if errors.As(err, &lockErr) {
+		return ErrUnavailable("storage is busy, retry later", lockErr.Timeout), true
+	}
+	return nil, false
+}
+
 // WriteError writes an error response in OCI Distribution Spec format
 func WriteError(w http.ResponseWriter, err error) {
-	var regErr *RegistryError
-	if registryError, ok := err.(*RegistryError); ok {
-		regErr = registryError
-	} else {
+	regErr, ok := AsRegistryError(err)
+	if !ok {
 		// Convert generic error to internal server error
 		regErr = &RegistryError{
 			Code:    "INTERNAL_ERROR",
@@ -58,6 +85,9 @@ func WriteError(w http.ResponseWriter, err error) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if regErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((regErr.RetryAfter+time.Second-1)/time.Second), 10))
+	}
 	w.WriteHeader(regErr.HTTPStatus())
 	json.NewEncoder(w).Encode(regErr)
 }
@@ -73,6 +103,15 @@ func ErrNameUnknown(name string) *RegistryError {
 	}
 }
 
+// ErrNameInvalid returns a NAME_INVALID error (400)
+func ErrNameInvalid(message string) *RegistryError {
+	return &RegistryError{
+		Code:    "NAME_INVALID",
+		Message: "invalid repository name",
+		Detail:  message,
+	}
+}
+
 // ErrBlobUnknown returns a BLOB_UNKNOWN error (404)
 func ErrBlobUnknown(digest string) *RegistryError {
 	return &RegistryError{
@@ -118,6 +157,25 @@ func ErrUnsupported(message string) *RegistryError {
 	}
 }
 
+// ErrUnavailable returns an UNAVAILABLE error (503) telling clients to retry the unchanged request
+// after retryAfter (rounded up to whole seconds in Retry-After), e.g. on lock contention
+func ErrUnavailable(message string, retryAfter time.Duration) *RegistryError {
+	return &RegistryError{
+		Code:       "UNAVAILABLE",
+		Message:    "service temporarily unavailable",
+		Detail:     message,
+		RetryAfter: retryAfter,
+	}
+}
+
+// ErrGatewayTimeout returns an UNAVAILABLE error with status 504, for upstream operations that
+// exceeded their deadline
+func ErrGatewayTimeout(message string) *RegistryError {
+	err := ErrUnavailable(message, 0)
+	err.Status = http.StatusGatewayTimeout
+	return err
+}
+
 // ErrBlobUploadUnknown returns a BLOB_UPLOAD_UNKNOWN error (404)
 func ErrBlobUploadUnknown(message string) *RegistryError {
 	return &RegistryError{
@@ -136,6 +194,22 @@ func ErrBlobUploadInvalid(message string) *RegistryError {
 	}
 }
 
+// ErrBlobUploadTooLarge returns a BLOB_UPLOAD_INVALID error with status 413, for uploads exceeding a size limit
+func ErrBlobUploadTooLarge(message string) *RegistryError {
+	err := ErrBlobUploadInvalid(message)
+	err.Status = http.StatusRequestEntityTooLarge
+	return err
+}
+
+// ErrDigestInvalid returns a DIGEST_INVALID error (400)
+func ErrDigestInvalid(message string) *RegistryError {
+	return &RegistryError{
+		Code:    "DIGEST_INVALID",
+		Message: "provided digest did not match uploaded content",
+		Detail:  message,
+	}
+}
+
 // ErrManifestInvalid returns a MANIFEST_INVALID error (400)
 func ErrManifestInvalid(message string) *RegistryError {
 	return &RegistryError{
@@ -144,3 +218,21 @@ func ErrManifestInvalid(message string) *RegistryError {
 		Detail:  message,
 	}
 }
+
+// ErrPaginationNumberInvalid returns a PAGINATION_NUMBER_INVALID error (400)
+func ErrPaginationNumberInvalid(message string) *RegistryError {
+	return &RegistryError{
+		Code:    "PAGINATION_NUMBER_INVALID",
+		Message: "invalid number of results requested",
+		Detail:  message,
+	}
+}
+
+// ErrPreconditionFailed returns a PRECONDITION_FAILED error (412)
+func ErrPreconditionFailed(message string) *RegistryError {
+	return &RegistryError{
+		Code:    "PRECONDITION_FAILED",
+		Message: "precondition failed",
+		Detail:  message,
+	}
+}
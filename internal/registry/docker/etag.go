@@ -0,0 +1,40 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BlobETag returns the entity tag of a blob: the quoted digest, a strong validator since blob
+// content is immutable by digest
+func BlobETag(digest string) string {
+	return `"` + digest + `"`
+}
+
+// ifNoneMatch reports whether the request's If-None-Match header lists etag or "*".
+// Entity tags compare weakly, as RFC 9110 requires for If-None-Match.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	for _, value := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServeBlobNotModified responds 304 Not Modified with the blob's ETag when the request's
+// If-None-Match matches it and exists confirms the blob is present (missing blobs must still
+// fail). Returns whether it responded; exists is only called for matching requests.
+func ServeBlobNotModified(w http.ResponseWriter, r *http.Request, digest string, exists func() bool) bool {
+	etag := BlobETag(digest)
+	if !ifNoneMatch(r, etag) || !exists() {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
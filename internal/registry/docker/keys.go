@@ -0,0 +1,50 @@
+package docker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KeyStrategy selects how registries key blob and manifest content in storage
+type KeyStrategy string
+
+// Key strategies
+const (
+	// KeyByDigest keys content by digest alone: identical content is stored once for every repository
+	KeyByDigest KeyStrategy = "digest"
+
+	// KeyByRepository keys content by digest and repository name: each repository stores its own copy,
+	// so storage-level access control and per-repository quotas can be enforced, at the cost of dedup
+	KeyByRepository KeyStrategy = "repository"
+)
+
+// ParseKeyStrategy parses a key strategy name ("" is KeyByDigest)
+func ParseKeyStrategy(value string) (KeyStrategy, error) {
+	switch strategy := KeyStrategy(value); strategy {
+	case "":
+		return KeyByDigest, nil
+	case KeyByDigest, KeyByRepository:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown key strategy: %s", value)
+	}
+}
+
+// Key returns the storage key of digest in repository name.
+// Namespaced keys start with the digest, so they never collide with other keyspaces that must not
+// resemble digests, and escape the name, so they stay flat (no path separators).
+func (k KeyStrategy) Key(name, digest string) string {
+	if k == KeyByRepository {
+		return digest + "@" + url.QueryEscape(name)
+	}
+	return digest
+}
+
+// Digest reverses Key for a key of repository name
+func (k KeyStrategy) Digest(name, key string) string {
+	if k == KeyByRepository {
+		return strings.TrimSuffix(key, "@"+url.QueryEscape(name))
+	}
+	return key
+}
@@ -11,17 +11,26 @@ type Manifest struct {
 	MediaType     string            `json:"mediaType"`
 	Config        *Descriptor       `json:"config,omitempty"`
 	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"` // Child manifests of an index / manifest list
 	Annotations   map[string]string `json:"annotations,omitempty"`
 	Raw           json.RawMessage   `json:"-"` // Store raw JSON for exact preservation
 }
 
-// Descriptor represents a content descriptor (blob or config)
+// Descriptor represents a content descriptor (blob, config or child manifest)
 type Descriptor struct {
 	MediaType   string            `json:"mediaType"`
 	Size        int64             `json:"size"`
 	Digest      string            `json:"digest"`
 	URLs        []string          `json:"urls,omitempty"`
 	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"` // Set on index entries
+}
+
+// Platform describes the platform an index entry targets
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
 }
 
 // ParseManifest parses a JSON manifest
@@ -59,6 +68,11 @@ const (
 	MediaTypeOCILayer         = "application/vnd.oci.image.layer.v1.tar+gzip"
 )
 
+// IsIndexMediaType checks if the media type is a manifest list / image index
+func IsIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeManifestList || mediaType == MediaTypeOCIManifestIndex
+}
+
 // IsManifestMediaType checks if the media type is a manifest type
 func IsManifestMediaType(mediaType string) bool {
 	return mediaType == MediaTypeManifestV2 ||
@@ -0,0 +1,175 @@
+package docker
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+)
+
+// CachedManifest is a manifest held in memory together with its media type and digest
+type CachedManifest struct {
+	Data      []byte
+	MediaType string
+	Digest    string
+}
+
+// manifestCacheStripes is the number of invalidation counters keys are spread over
+const manifestCacheStripes = 256
+
+// ManifestCache is a concurrency-safe LRU cache of manifests bounded by entry count.
+// Keys are chosen by the caller (typically "name:reference").
+type ManifestCache struct {
+	maxEntries  int
+	entries     map[string]*list.Element
+	order       *list.List // Front is most recently used
+	generations [manifestCacheStripes]uint64
+	epoch       uint64 // Bumped by RemoveDigest, which can't tell the keys it invalidates
+	mu          sync.Mutex
+}
+
+// manifestCacheItem is the value stored in the LRU list
+type manifestCacheItem struct {
+	key      string
+	manifest *CachedManifest
+}
+
+// NewManifestCache creates a new LRU manifest cache holding at most maxEntries manifests.
+// Returns nil if maxEntries <= 0 (caching disabled); all methods are safe to call on nil.
+func NewManifestCache(maxEntries int) *ManifestCache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	return &ManifestCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Get returns the cached manifest for key and marks it as recently used
+func (c *ManifestCache) Get(key string) (*CachedManifest, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*manifestCacheItem).manifest, true
+}
+
+// Generation returns the invalidation generation of key, which changes whenever Remove or
+// RemoveDigest may have invalidated it. Read it before loading a manifest to pass to AddIfCurrent.
+func (c *ManifestCache) Generation(key string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation(key)
+}
+
+// generation returns the invalidation generation of key; c.mu must be held
+func (c *ManifestCache) generation(key string) uint64 {
+	return c.epoch + c.generations[manifestCacheStripe(key)]
+}
+
+// manifestCacheStripe returns the index of the invalidation counter of key
+func manifestCacheStripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % manifestCacheStripes
+}
+
+// Add stores a manifest under key, evicting the least recently used entry if full
+func (c *ManifestCache) Add(key string, manifest *CachedManifest) {
+	if c == nil || manifest == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, manifest)
+}
+
+// AddIfCurrent stores a manifest under key like Add, unless key was invalidated since its
+// generation was read, so a manifest loaded before a concurrent push is never cached after it
+func (c *ManifestCache) AddIfCurrent(key string, generation uint64, manifest *CachedManifest) {
+	if c == nil || manifest == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation(key) == generation {
+		c.add(key, manifest)
+	}
+}
+
+// add stores a manifest under key; c.mu must be held
+func (c *ManifestCache) add(key string, manifest *CachedManifest) {
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*manifestCacheItem).manifest = manifest
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&manifestCacheItem{key: key, manifest: manifest})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*manifestCacheItem).key)
+	}
+}
+
+// Remove invalidates the entry for key
+func (c *ManifestCache) Remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generations[manifestCacheStripe(key)]++
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// RemoveDigest invalidates every entry holding the manifest with the given digest
+func (c *ManifestCache) RemoveDigest(digest string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	for key, elem := range c.entries {
+		if elem.Value.(*manifestCacheItem).manifest.Digest == digest {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Size returns the maximum number of cached manifests (0 if caching is disabled)
+func (c *ManifestCache) Size() int {
+	if c == nil {
+		return 0
+	}
+	return c.maxEntries
+}
+
+// Len returns the number of cached manifests
+func (c *ManifestCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
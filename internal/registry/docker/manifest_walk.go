@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxManifestDepth is the default nesting limit for index traversal.
+// Depth 0 is the root manifest; a regular index -> image manifest tree has depth 1.
+const DefaultMaxManifestDepth = 4
+
+var (
+	// ErrManifestTooDeep is returned when index nesting exceeds the configured depth
+	ErrManifestTooDeep = errors.New("manifest nesting exceeds maximum depth")
+
+	// ErrManifestCycle is returned when an index references itself directly or indirectly
+	ErrManifestCycle = errors.New("manifest reference cycle")
+)
+
+// ManifestFetcher returns the raw manifest with the given digest
+type ManifestFetcher func(ctx context.Context, digest string) ([]byte, error)
+
+// ManifestVisitor is called for every manifest in the tree with its digest and depth
+type ManifestVisitor func(digest string, manifest *Manifest, depth int) error
+
+// WalkManifest traverses a manifest and, for indexes, its child manifests depth-first.
+// Children are fetched with fetch. Traversal fails with ErrManifestTooDeep when a child would
+// exceed maxDepth (<= 0 means DefaultMaxManifestDepth) and with ErrManifestCycle when a digest
+// reappears on the current path. Shared children reached through different paths are visited once.
+func WalkManifest(ctx context.Context, rootDigest string, root []byte, maxDepth int, fetch ManifestFetcher, visit ManifestVisitor) error {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxManifestDepth
+	}
+	w := &manifestWalker{
+		maxDepth: maxDepth,
+		fetch:    fetch,
+		visit:    visit,
+		onPath:   make(map[string]bool),
+		visited:  make(map[string]bool),
+	}
+	return w.walk(ctx, rootDigest, root, 0)
+}
+
+// manifestWalker holds the traversal state of WalkManifest
+type manifestWalker struct {
+	maxDepth int
+	fetch    ManifestFetcher
+	visit    ManifestVisitor
+	onPath   map[string]bool // Digests on the current root-to-node path (cycle detection)
+	visited  map[string]bool // Digests already visited (deduplication)
+}
+
+func (w *manifestWalker) walk(ctx context.Context, digest string, data []byte, depth int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if w.onPath[digest] {
+		return fmt.Errorf("%w: %s", ErrManifestCycle, digest)
+	}
+	if w.visited[digest] {
+		return nil
+	}
+	if depth > w.maxDepth {
+		return fmt.Errorf("%w (%d) at %s", ErrManifestTooDeep, w.maxDepth, digest)
+	}
+
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", digest, err)
+	}
+	w.visited[digest] = true
+	if w.visit != nil {
+		if err := w.visit(digest, manifest, depth); err != nil {
+			return err
+		}
+	}
+
+	w.onPath[digest] = true
+	defer delete(w.onPath, digest)
+	for _, child := range manifest.Manifests {
+		if w.onPath[child.Digest] {
+			return fmt.Errorf("%w: %s", ErrManifestCycle, child.Digest)
+		}
+		childData, err := w.fetch(ctx, child.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch child manifest %s: %w", child.Digest, err)
+		}
+		if err := w.walk(ctx, child.Digest, childData, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testIndex builds an index whose entries point at the given child digests
+func testIndex(children ...string) []byte {
+	entries := make([]string, len(children))
+	for i, child := range children {
+		entries[i] = fmt.Sprintf(`{"mediaType":%q,"size":1,"digest":%q}`, MediaTypeOCIManifest, child)
+	}
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[%s]}`, MediaTypeOCIManifestIndex, strings.Join(entries, ",")))
+}
+
+// testImage builds an image manifest with one layer
+func testImage(layer string) []byte {
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":1,"digest":%q}]}`, MediaTypeOCIManifest, MediaTypeOCILayer, layer))
+}
+
+// mapFetcher serves manifests from a map
+func mapFetcher(manifests map[string][]byte) ManifestFetcher {
+	return func(ctx context.Context, digest string) ([]byte, error) {
+		data, ok := manifests[digest]
+		if !ok {
+			return nil, fmt.Errorf("manifest %s not found", digest)
+		}
+		return data, nil
+	}
+}
+
+// TestWalkManifestTwoLevelIndex tests that a regular index -> image tree is fully visited
+func TestWalkManifestTwoLevelIndex(t *testing.T) {
+	manifests := map[string][]byte{
+		"sha256:amd64": testImage("sha256:layer-amd64"),
+		"sha256:arm64": testImage("sha256:layer-arm64"),
+	}
+	visited := map[string]int{}
+	err := WalkManifest(context.Background(), "sha256:index", testIndex("sha256:amd64", "sha256:arm64"), 0, mapFetcher(manifests),
+		func(digest string, manifest *Manifest, depth int) error {
+			visited[digest] = depth
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("WalkManifest failed: %v", err)
+	}
+	if len(visited) != 3 || visited["sha256:index"] != 0 || visited["sha256:amd64"] != 1 || visited["sha256:arm64"] != 1 {
+		t.Errorf("Unexpected visit depths: %v", visited)
+	}
+}
+
+// TestWalkManifestRejectsCycleAndDepth tests that cyclic and over-deep indexes are rejected
+func TestWalkManifestRejectsCycleAndDepth(t *testing.T) {
+	cyclic := map[string][]byte{
+		"sha256:b": testIndex("sha256:a"),
+	}
+	err := WalkManifest(context.Background(), "sha256:a", testIndex("sha256:b"), 0, mapFetcher(cyclic), nil)
+	if !errors.Is(err, ErrManifestCycle) {
+		t.Errorf("Expected ErrManifestCycle, got %v", err)
+	}
+
+	// A chain of indexes: level0 -> level1 -> level2 -> level3 -> image
+	deep := map[string][]byte{
+		"sha256:level1": testIndex("sha256:level2"),
+		"sha256:level2": testIndex("sha256:level3"),
+		"sha256:level3": testIndex("sha256:image"),
+		"sha256:image":  testImage("sha256:layer"),
+	}
+	err = WalkManifest(context.Background(), "sha256:level0", testIndex("sha256:level1"), 2, mapFetcher(deep), nil)
+	if !errors.Is(err, ErrManifestTooDeep) {
+		t.Errorf("Expected ErrManifestTooDeep with max depth 2, got %v", err)
+	}
+	if err := WalkManifest(context.Background(), "sha256:level0", testIndex("sha256:level1"), 4, mapFetcher(deep), nil); err != nil {
+		t.Errorf("Expected chain to pass with max depth 4, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Default name limits.
+// DefaultMaxNameLength follows the distribution spec's recommended repository name limit;
+// DefaultMaxPathLength leaves room for the name, a sha512 digest reference and route segments.
+const (
+	DefaultMaxNameLength = 255
+	DefaultMaxPathLength = 1024
+)
+
+// NameLimits bounds request paths and repository names, which become storage keys.
+// A zero value uses the default.
+type NameLimits struct {
+	MaxName int // Maximum repository name length in bytes
+	MaxPath int // Maximum total request path length in bytes
+}
+
+// DefaultNameLimits returns the default name limits
+func DefaultNameLimits() NameLimits {
+	return NameLimits{MaxName: DefaultMaxNameLength, MaxPath: DefaultMaxPathLength}
+}
+
+// withDefaults returns l with zero limits replaced by the defaults
+func (l NameLimits) withDefaults() NameLimits {
+	if l.MaxName <= 0 {
+		l.MaxName = DefaultMaxNameLength
+	}
+	if l.MaxPath <= 0 {
+		l.MaxPath = DefaultMaxPathLength
+	}
+	return l
+}
+
+// Check returns an ErrNameInvalid error if path or name exceeds the limits
+func (l NameLimits) Check(path, name string) error {
+	l = l.withDefaults()
+	if len(path) > l.MaxPath {
+		return ErrNameInvalid(fmt.Sprintf("request path exceeds %d bytes", l.MaxPath))
+	}
+	if len(name) > l.MaxName {
+		return ErrNameInvalid(fmt.Sprintf("repository name exceeds %d bytes", l.MaxName))
+	}
+	return nil
+}
+
+// NameLimitHandler wraps a handler of a route with a {name} wildcard, rejecting requests whose
+// path or repository name exceeds limits with NAME_INVALID before they reach next.
+func NameLimitHandler(limits NameLimits, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := limits.Check(r.URL.Path, r.PathValue("name")); err != nil {
+			WriteError(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
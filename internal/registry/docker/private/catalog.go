@@ -0,0 +1,161 @@
+package private
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// catalogRepo marks the references listing repository names in the catalog index
+const catalogRepo = "repository"
+
+// getCatalogKey returns the key of the repository index. It lives in the reference-mapping keyspace
+// (so it can't collide with content) but has no ":" separator, so it never parses as a mapping.
+func (s *DockerRegistryPrivateService) getCatalogKey() string {
+	return s.refKeyPrefix + "_catalog"
+}
+
+// addToCatalog records name in the repository index if it isn't listed yet
+func (s *DockerRegistryPrivateService) addToCatalog(ctx context.Context, name string) error {
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+
+	meta, err := s.storage.GetMeta(ctx, s.getCatalogKey())
+	if err != nil {
+		// Missing index: build it from a full scan, which includes name, when the storage can list
+		// artifacts; otherwise the index starts with this push
+		if _, ok := s.storage.(storage.EnumerableStorage); !ok {
+			return s.writeCatalog(ctx, []string{name})
+		}
+		_, err := s.rebuildCatalog(ctx)
+		return err
+	}
+	for _, ref := range meta.References {
+		if ref.Repo == catalogRepo && ref.Name == name {
+			return nil
+		}
+	}
+	meta.References = append(meta.References, models.ArtifactReference{Name: name, Repo: catalogRepo, ReferencedTimestamp: time.Now().Unix()})
+	if _, err := s.storage.UpdateMeta(ctx, *meta); err != nil {
+		return fmt.Errorf("failed to update catalog index: %w", err)
+	}
+	return nil
+}
+
+// Catalog returns the repository names, sorted, from the persisted index.
+// A missing index is rebuilt with a full scan first. Repositories are added on their first
+// push and dropped by garbage collection once no tag or digest reference is left.
+func (s *DockerRegistryPrivateService) Catalog(ctx context.Context) ([]string, error) {
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+
+	meta, err := s.storage.GetMeta(ctx, s.getCatalogKey())
+	if err != nil {
+		return s.rebuildCatalog(ctx)
+	}
+	names := []string{}
+	for _, ref := range meta.References {
+		if ref.Repo == catalogRepo {
+			names = append(names, ref.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RebuildCatalog replaces the repository index with the result of a full scan and returns it
+func (s *DockerRegistryPrivateService) RebuildCatalog(ctx context.Context) ([]string, error) {
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+	return s.rebuildCatalog(ctx)
+}
+
+// rebuildCatalog scans and persists the index; the caller holds catalogMu
+func (s *DockerRegistryPrivateService) rebuildCatalog(ctx context.Context) ([]string, error) {
+	names, err := s.ScanRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeCatalog(ctx, names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// writeCatalog stores names as the repository index
+func (s *DockerRegistryPrivateService) writeCatalog(ctx context.Context, names []string) error {
+	now := time.Now().Unix()
+	refs := make([]models.ArtifactReference, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, models.ArtifactReference{Name: name, Repo: catalogRepo, ReferencedTimestamp: now})
+	}
+
+	catalogKey := s.getCatalogKey()
+	if meta, err := s.storage.GetMeta(ctx, catalogKey); err == nil {
+		meta.References = refs
+		if _, err := s.storage.UpdateMeta(ctx, *meta); err != nil {
+			return fmt.Errorf("failed to update catalog index: %w", err)
+		}
+		return nil
+	}
+	_, err := s.storage.Create(ctx, catalogKey, bytes.NewReader(nil), 0, &models.ArtifactMeta{
+		Hash:             catalogKey,
+		Length:           0,
+		CreatedTimestamp: now,
+		References:       refs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create catalog index: %w", err)
+	}
+	return nil
+}
+
+// pruneCatalog drops repositories that are neither in keep nor pushed to (per pushed) from the index
+func (s *DockerRegistryPrivateService) pruneCatalog(ctx context.Context, keep map[string]bool, pushed func(name string) bool) error {
+	s.catalogMu.Lock()
+	defer s.catalogMu.Unlock()
+
+	meta, err := s.storage.GetMeta(ctx, s.getCatalogKey())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, ref := range meta.References {
+		if ref.Repo == catalogRepo && (keep[ref.Name] || pushed(ref.Name)) {
+			names = append(names, ref.Name)
+		}
+	}
+	return s.writeCatalog(ctx, names)
+}
+
+// ScanRepositories returns the sorted names of repositories with at least one tag or digest reference,
+// by scanning every artifact. Requires storage implementing storage.EnumerableStorage.
+func (s *DockerRegistryPrivateService) ScanRepositories(ctx context.Context) ([]string, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+
+	seen := make(map[string]bool)
+	names := []string{}
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if !s.isRefKey(meta.Hash) {
+			return nil
+		}
+		if name, ok := s.repositoryFromRefKey(meta.Hash); ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan repositories: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
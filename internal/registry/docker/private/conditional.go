@@ -0,0 +1,64 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+)
+
+// ManifestCondition is a precondition on the digest a reference points at when a manifest is
+// pushed to it, so concurrent pushes moving the same tag can't silently overwrite each other.
+// The zero value imposes none.
+type ManifestCondition struct {
+	// IfMatch only moves the reference if it currently points at this digest ("*": at any digest)
+	IfMatch string
+	// IfNoneMatch only creates the reference if it doesn't exist yet
+	IfNoneMatch bool
+}
+
+// manifestConditionFromRequest reads the If-Match and If-None-Match: * headers of a manifest push.
+// Entity tags may be quoted; weak tags are compared like strong ones.
+func manifestConditionFromRequest(r *http.Request) ManifestCondition {
+	cond := ManifestCondition{IfMatch: unquoteETag(r.Header.Get("If-Match"))}
+	cond.IfNoneMatch = strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+	return cond
+}
+
+// unquoteETag strips the weak prefix and quotes of an entity tag
+func unquoteETag(tag string) string {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	return strings.Trim(tag, `"`)
+}
+
+// isZero reports whether cond imposes no precondition
+func (cond ManifestCondition) isZero() bool {
+	return cond.IfMatch == "" && !cond.IfNoneMatch
+}
+
+// check returns docker.ErrPreconditionFailed unless current ("" when the reference doesn't exist)
+// satisfies cond
+func (cond ManifestCondition) check(current string) error {
+	if cond.IfNoneMatch && current != "" {
+		return docker.ErrPreconditionFailed(fmt.Sprintf("reference already points at %s", current))
+	}
+	switch {
+	case cond.IfMatch == "":
+	case current == "":
+		return docker.ErrPreconditionFailed("reference does not exist")
+	case cond.IfMatch != "*" && cond.IfMatch != current:
+		return docker.ErrPreconditionFailed(fmt.Sprintf("reference points at %s, not %s", current, cond.IfMatch))
+	}
+	return nil
+}
+
+// currentRefDigest returns the digest the reference mapping at refKey points at ("" if none)
+func (s *DockerRegistryPrivateService) currentRefDigest(ctx context.Context, refKey string) string {
+	meta, err := s.storage.GetMeta(ctx, refKey)
+	if err != nil {
+		return ""
+	}
+	return s.resolveRefDigest(meta)
+}
@@ -0,0 +1,86 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// dedupTopBlobs is the number of most-shared blobs listed in a DedupReport
+const dedupTopBlobs = 10
+
+// SharedBlob is a blob and the number of repositories referencing it
+type SharedBlob struct {
+	Digest       string `json:"digest"`
+	Size         int64  `json:"size"`
+	Repositories int    `json:"repositories"`
+}
+
+// DedupReport quantifies the savings of content-addressed blob storage.
+// LogicalBytes is what storing each repository's blobs separately would take;
+// PhysicalBytes is what is actually stored. Ratio is LogicalBytes / PhysicalBytes (1 if empty).
+type DedupReport struct {
+	Blobs         int          `json:"blobs"`
+	References    int          `json:"references"`
+	LogicalBytes  int64        `json:"logicalBytes"`
+	PhysicalBytes int64        `json:"physicalBytes"`
+	Ratio         float64      `json:"ratio"`
+	MostShared    []SharedBlob `json:"mostShared"`
+}
+
+// DeduplicationReport walks all blobs and their repository references and reports logical vs physical bytes.
+// Requires storage implementing storage.EnumerableStorage; this scans every artifact.
+func (s *DockerRegistryPrivateService) DeduplicationReport(ctx context.Context) (*DedupReport, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+
+	report := &DedupReport{Ratio: 1}
+	var shared []SharedBlob
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		repos := 0
+		for _, ref := range meta.References {
+			if ref.Repo == "blob" {
+				repos++
+			}
+		}
+		if repos == 0 {
+			return nil
+		}
+		report.Blobs++
+		report.References += repos
+		report.PhysicalBytes += meta.Length
+		report.LogicalBytes += int64(repos) * meta.Length
+		if repos > 1 {
+			shared = append(shared, SharedBlob{Digest: meta.Hash, Size: meta.Length, Repositories: repos})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build deduplication report: %w", err)
+	}
+
+	if report.PhysicalBytes > 0 {
+		report.Ratio = float64(report.LogicalBytes) / float64(report.PhysicalBytes)
+	}
+
+	// Most-shared first; ties broken by the bytes saved, then digest
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Repositories != shared[j].Repositories {
+			return shared[i].Repositories > shared[j].Repositories
+		}
+		if shared[i].Size != shared[j].Size {
+			return shared[i].Size > shared[j].Size
+		}
+		return shared[i].Digest < shared[j].Digest
+	})
+	report.MostShared = shared[:min(len(shared), dedupTopBlobs)]
+	if report.MostShared == nil {
+		report.MostShared = []SharedBlob{}
+	}
+	return report, nil
+}
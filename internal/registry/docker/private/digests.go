@@ -0,0 +1,84 @@
+package private
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+)
+
+// DefaultDigestAlgorithms are the digest algorithms accepted unless SetAllowedDigestAlgorithms restricts them
+var DefaultDigestAlgorithms = []string{docker.DigestAlgorithmSHA256, docker.DigestAlgorithmSHA512}
+
+// SetAllowedDigestAlgorithms restricts the digest algorithms accepted for blob uploads and in pushed
+// manifests, e.g. to sha256 only for a single-algorithm store (empty restores DefaultDigestAlgorithms)
+func (s *DockerRegistryPrivateService) SetAllowedDigestAlgorithms(algorithms []string) error {
+	if len(algorithms) == 0 {
+		s.digestAlgorithms = nil
+		return nil
+	}
+	allowed := make(map[string]bool, len(algorithms))
+	for _, algorithm := range algorithms {
+		hasher := docker.NewDigestHasher(algorithm)
+		if hasher == nil {
+			return fmt.Errorf("unsupported digest algorithm: %s", algorithm)
+		}
+		docker.ReleaseDigestHasher(algorithm, hasher)
+		allowed[algorithm] = true
+	}
+	s.digestAlgorithms = allowed
+	return nil
+}
+
+// AllowedDigestAlgorithms returns the sorted digest algorithms accepted
+func (s *DockerRegistryPrivateService) AllowedDigestAlgorithms() []string {
+	if s.digestAlgorithms == nil {
+		return append([]string(nil), DefaultDigestAlgorithms...)
+	}
+	algorithms := make([]string, 0, len(s.digestAlgorithms))
+	for algorithm := range s.digestAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// checkDigestAlgorithm rejects digests of an algorithm that isn't allowed with DIGEST_INVALID
+func (s *DockerRegistryPrivateService) checkDigestAlgorithm(digest string) error {
+	algorithm := docker.DigestAlgorithm(digest)
+	if s.digestAlgorithms == nil {
+		for _, allowed := range DefaultDigestAlgorithms {
+			if algorithm == allowed {
+				return nil
+			}
+		}
+	} else if s.digestAlgorithms[algorithm] {
+		return nil
+	}
+	return docker.ErrDigestInvalid(fmt.Sprintf("digest algorithm %q is not allowed: %s", algorithm, digest))
+}
+
+// checkManifestDigests applies checkDigestAlgorithm to a digest reference and to every descriptor
+// the manifest references. Unparseable manifests are left to the caller.
+func (s *DockerRegistryPrivateService) checkManifestDigests(reference string, data []byte) error {
+	if strings.Contains(reference, ":") {
+		if err := s.checkDigestAlgorithm(reference); err != nil {
+			return err
+		}
+	}
+	manifest, err := docker.ParseManifest(data)
+	if err != nil {
+		return nil
+	}
+	descriptors := append(append([]docker.Descriptor{}, manifest.Layers...), manifest.Manifests...)
+	if manifest.Config != nil {
+		descriptors = append(descriptors, *manifest.Config)
+	}
+	for _, descriptor := range descriptors {
+		if err := s.checkDigestAlgorithm(descriptor.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// DefaultEagerGCLimit is the eager collection bound used when enabled through configuration
+const DefaultEagerGCLimit = 256
+
+// SetEagerGC makes DeleteManifest synchronously collect the content of the deleted manifest (the
+// manifest itself, its config and layers, and for indexes their children) that the repository's
+// remaining tags and digest references no longer reach, removing at most limit repository references
+// per delete (<= 0 disables, the default). Content shared with other manifests or repositories
+// survives, as with CollectGarbage; so does content within the GC grace period (see SetGCGracePeriod).
+// Whatever exceeds the limit, or is skipped while a collection is running, is left to CollectGarbage.
+// Requires storage implementing storage.EnumerableStorage.
+func (s *DockerRegistryPrivateService) SetEagerGC(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	s.eagerGCLimit = limit
+}
+
+// EagerGCLimit returns the references DeleteManifest may collect per call (0 = disabled)
+func (s *DockerRegistryPrivateService) EagerGCLimit() int {
+	return s.eagerGCLimit
+}
+
+// collectOrphans removes repository name's references to the candidate storage keys that its
+// remaining reference mappings don't reach, and returns the number of keys whose content was trashed
+func (s *DockerRegistryPrivateService) collectOrphans(ctx context.Context, name string, candidates map[string]bool) (int, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return 0, nil
+	}
+	if err := s.gc.start(); err != nil {
+		// A full collection is running and will handle it
+		return 0, nil
+	}
+	defer s.gc.finish()
+
+	// Mark: everything the repository's remaining mappings reach
+	prefix := s.getManifestRefKey(name, "")
+	var roots []string
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if strings.HasPrefix(meta.Hash, prefix) {
+			if digest := s.resolveRefDigest(meta); digest != "" {
+				roots = append(roots, digest)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan references: %w", err)
+	}
+	reachable, err := s.markReachable(ctx, name, roots)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark repository %s: %w", name, err)
+	}
+
+	// Sweep the unreachable candidates, with pushes held off
+	s.gc.sweep.Lock()
+	defer s.gc.sweep.Unlock()
+	keys := make([]string, 0, len(candidates))
+	for key := range candidates {
+		if !reachable[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	cutoff := time.Now().Add(-s.gcGracePeriod).Unix()
+	removed, reclaimed := 0, 0
+	for _, key := range keys {
+		if removed >= s.eagerGCLimit {
+			break
+		}
+		if s.gc.isTouched(key) {
+			continue
+		}
+		meta, err := s.storage.GetMeta(ctx, key)
+		if err != nil {
+			continue
+		}
+		for _, ref := range meta.References {
+			if ref.Name != name || (ref.Repo != "blob" && ref.Repo != "manifest") {
+				continue
+			}
+			if meta.CreatedTimestamp > cutoff || ref.ReferencedTimestamp > cutoff || removed >= s.eagerGCLimit {
+				continue
+			}
+			remaining, err := s.storage.Delete(ctx, key, ref)
+			if err != nil {
+				return reclaimed, fmt.Errorf("failed to remove %s reference of %s: %w", name, key, err)
+			}
+			removed++
+			if ref.Repo == "manifest" {
+				s.manifestCache.RemoveDigest(s.keyStrategy.Digest(name, key))
+			}
+			if remaining == nil {
+				reclaimed++
+			}
+		}
+	}
+	return reclaimed, nil
+}
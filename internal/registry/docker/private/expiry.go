@@ -0,0 +1,185 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// DefaultExpirySweepInterval is how often expired artifacts are deleted when TTLs are configured
+const DefaultExpirySweepInterval = 10 * time.Minute
+
+// ArtifactTTL makes content and tags pushed to repositories whose name starts with Prefix
+// ("" = every repository) expire TTL after the push
+type ArtifactTTL struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+// ExpiryReport summarizes a SweepExpired run: the storage keys of the deleted tag and digest
+// mappings and content (content is trashed by the storage)
+type ExpiryReport struct {
+	Swept []string `json:"swept"`
+}
+
+// SetArtifactTTLs makes pushed content expire (see ArtifactTTL); the longest matching prefix
+// applies. Each push records the expiry in the metadata of the manifest or blob and of the
+// reference mapping (ExpiresTimestamp). Content shared with a push without a TTL never expires, and
+// otherwise lives until the latest expiry of its pushes. While TTLs are configured, expired
+// artifacts are not found by manifest and blob requests (manifests that expire aren't cached) and
+// SweepExpired deletes them; tag listings show expired tags until swept. Nil disables expiry
+// (stored expiries are then ignored).
+func (s *DockerRegistryPrivateService) SetArtifactTTLs(ttls []ArtifactTTL) {
+	sorted := make([]ArtifactTTL, 0, len(ttls))
+	for _, ttl := range ttls {
+		if ttl.TTL > 0 {
+			sorted = append(sorted, ttl)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	s.artifactTTLs = sorted
+}
+
+// ArtifactTTLs returns the configured TTLs, longest prefix first
+func (s *DockerRegistryPrivateService) ArtifactTTLs() []ArtifactTTL {
+	return s.artifactTTLs
+}
+
+// SetExpirySweepInterval runs SweepExpired every interval in the background, replacing a previous
+// sweeper (0 stops it)
+func (s *DockerRegistryPrivateService) SetExpirySweepInterval(interval time.Duration) {
+	s.sweeperMu.Lock()
+	defer s.sweeperMu.Unlock()
+	if s.stopSweeper != nil {
+		close(s.stopSweeper)
+		s.stopSweeper = nil
+	}
+	s.expirySweepInterval = interval
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	s.stopSweeper = stop
+	go s.runExpirySweeper(interval, stop)
+}
+
+// ExpirySweepInterval returns the background sweep interval (0 = not sweeping)
+func (s *DockerRegistryPrivateService) ExpirySweepInterval() time.Duration {
+	s.sweeperMu.Lock()
+	defer s.sweeperMu.Unlock()
+	return s.expirySweepInterval
+}
+
+// runExpirySweeper sweeps expired artifacts every interval until stop is closed
+func (s *DockerRegistryPrivateService) runExpirySweeper(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Failures are retried on the next tick
+			_, _ = s.SweepExpired(context.Background())
+		}
+	}
+}
+
+// expiryEnabled reports whether expiries are recorded and enforced
+func (s *DockerRegistryPrivateService) expiryEnabled() bool {
+	return len(s.artifactTTLs) > 0
+}
+
+// expiresAt returns the expiry of a push to repository name now (0 = never)
+func (s *DockerRegistryPrivateService) expiresAt(name string) int64 {
+	for _, ttl := range s.artifactTTLs {
+		if strings.HasPrefix(name, ttl.Prefix) {
+			return time.Now().Add(ttl.TTL).Unix()
+		}
+	}
+	return 0
+}
+
+// isExpired reports whether meta expired, while expiry is enabled
+func (s *DockerRegistryPrivateService) isExpired(meta *models.ArtifactMeta) bool {
+	return s.expiryEnabled() && meta.ExpiresTimestamp != 0 && meta.ExpiresTimestamp <= time.Now().Unix()
+}
+
+// recordExpiry updates the expiry of the artifact at storageKey after a push that expires at
+// expires: never if either doesn't expire, otherwise the later of both. replace sets expires
+// regardless, for reference mappings a push fully owns.
+func (s *DockerRegistryPrivateService) recordExpiry(ctx context.Context, storageKey string, expires int64, replace bool) error {
+	if !s.expiryEnabled() {
+		return nil
+	}
+	meta, err := s.storage.GetMeta(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata of %s: %w", storageKey, err)
+	}
+	combined := expires
+	if !replace && (meta.ExpiresTimestamp == 0 || expires == 0) {
+		combined = 0
+	} else if !replace {
+		combined = max(meta.ExpiresTimestamp, expires)
+	}
+	if combined == meta.ExpiresTimestamp {
+		return nil
+	}
+	meta.ExpiresTimestamp = combined
+	if _, err := s.storage.UpdateMeta(ctx, *meta); err != nil {
+		return fmt.Errorf("failed to record expiry of %s: %w", storageKey, err)
+	}
+	return nil
+}
+
+// SweepExpired deletes expired manifests, blobs and reference mappings by dropping all their
+// references, so the storage trashes them. Requires storage implementing storage.EnumerableStorage;
+// without configured TTLs nothing expires. New pushes wait while deleting, and artifacts of pushes
+// in flight, or renewed by a push in the meantime, are kept.
+func (s *DockerRegistryPrivateService) SweepExpired(ctx context.Context) (*ExpiryReport, error) {
+	report := &ExpiryReport{Swept: []string{}}
+	if !s.expiryEnabled() {
+		return report, nil
+	}
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+
+	var expired []string
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if s.isExpired(meta) {
+			expired = append(expired, meta.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan storage: %w", err)
+	}
+	sort.Strings(expired)
+
+	s.gc.sweep.Lock()
+	defer s.gc.sweep.Unlock()
+	for _, key := range expired {
+		// A push in flight may be refreshing the expiry
+		if s.gc.isPushing(key) {
+			continue
+		}
+		meta, err := s.storage.GetMeta(ctx, key)
+		if err != nil || !s.isExpired(meta) {
+			continue
+		}
+		for _, ref := range meta.References {
+			if _, err := s.storage.Delete(ctx, key, ref); err != nil {
+				return report, fmt.Errorf("failed to delete expired %s: %w", key, err)
+			}
+		}
+		report.Swept = append(report.Swept, key)
+	}
+	return report, nil
+}
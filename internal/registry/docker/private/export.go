@@ -0,0 +1,159 @@
+package private
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+)
+
+// ociLayoutFile is the content of the oci-layout file of an image layout
+const ociLayoutFile = `{"imageLayoutVersion":"1.0.0"}`
+
+// LayoutExport is a resolved OCI image layout of a manifest, ready to be streamed
+type LayoutExport struct {
+	service   *DockerRegistryPrivateService
+	name      string
+	index     []byte
+	manifests []exportedManifest
+	blobs     []docker.Descriptor // Configs and layers, with their stored sizes
+}
+
+// exportedManifest is a manifest of a LayoutExport, kept in memory
+type exportedManifest struct {
+	digest string
+	data   []byte
+}
+
+// ExportOCILayout resolves reference in repository name and everything it reaches (child
+// manifests, configs and layers) into an OCI image layout, failing with MANIFEST_UNKNOWN or
+// BLOB_UNKNOWN if anything is missing, so errors are known before streaming starts. Missing
+// non-distributable layers (descriptors with URLs) are left out. A tag reference is recorded as
+// the org.opencontainers.image.ref.name annotation of the index entry.
+func (s *DockerRegistryPrivateService) ExportOCILayout(ctx context.Context, name, reference string) (*LayoutExport, error) {
+	data, mediaType, rootDigest, err := s.GetManifestWithDigest(ctx, name, reference)
+	if err != nil {
+		return nil, docker.ErrManifestUnknown(reference)
+	}
+	export := &LayoutExport{service: s, name: name}
+
+	seen := make(map[string]bool)
+	addBlob := func(descriptor docker.Descriptor) error {
+		if seen[descriptor.Digest] {
+			return nil
+		}
+		seen[descriptor.Digest] = true
+		exists, size, _ := s.CheckBlobExists(ctx, name, descriptor.Digest)
+		if !exists {
+			if len(descriptor.URLs) > 0 {
+				return nil
+			}
+			return docker.ErrBlobUnknown(descriptor.Digest)
+		}
+		descriptor.Size = size
+		export.blobs = append(export.blobs, descriptor)
+		return nil
+	}
+	fetch := func(ctx context.Context, digest string) ([]byte, error) {
+		childData, _, err := s.GetManifest(ctx, name, digest)
+		if err != nil {
+			return nil, docker.ErrManifestUnknown(digest)
+		}
+		return childData, nil
+	}
+	visit := func(digest string, manifest *docker.Manifest, depth int) error {
+		seen[digest] = true
+		export.manifests = append(export.manifests, exportedManifest{digest: digest, data: manifest.Raw})
+		if manifest.Config != nil {
+			if err := addBlob(*manifest.Config); err != nil {
+				return err
+			}
+		}
+		for _, layer := range manifest.Layers {
+			if err := addBlob(layer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := docker.WalkManifest(ctx, rootDigest, data, s.maxManifestDepth, fetch, visit); err != nil {
+		return nil, err
+	}
+
+	entry := docker.Descriptor{MediaType: mediaType, Size: int64(len(data)), Digest: rootDigest}
+	if !strings.Contains(reference, ":") {
+		entry.Annotations = map[string]string{ociRefNameAnnotation: reference}
+	}
+	index, err := json.Marshal(docker.Manifest{
+		SchemaVersion: 2,
+		MediaType:     docker.MediaTypeOCIManifestIndex,
+		Manifests:     []docker.Descriptor{entry},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode index.json: %w", err)
+	}
+	export.index = index
+	return export, nil
+}
+
+// Stream writes the layout as a tar stream to w, reading blobs from storage one at a time
+func (e *LayoutExport) Stream(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := writeFile("oci-layout", []byte(ociLayoutFile)); err != nil {
+		return err
+	}
+	if err := writeFile("index.json", e.index); err != nil {
+		return err
+	}
+	for _, manifest := range e.manifests {
+		if err := writeFile(layoutBlobPath(manifest.digest), manifest.data); err != nil {
+			return err
+		}
+	}
+	for _, blob := range e.blobs {
+		if err := e.streamBlob(ctx, tw, blob); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish layout archive: %w", err)
+	}
+	return nil
+}
+
+// streamBlob copies a blob from storage into the layout archive (StreamBlob closes the reader)
+func (e *LayoutExport) streamBlob(ctx context.Context, tw *tar.Writer, blob docker.Descriptor) error {
+	rc, _, err := e.service.GetBlob(ctx, e.name, blob.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", blob.Digest, err)
+	}
+
+	name := layoutBlobPath(blob.Digest)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: blob.Size, Typeflag: tar.TypeReg}); err != nil {
+		rc.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := docker.StreamBlob(ctx, tw, rc); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// layoutBlobPath returns the blobs/<algorithm>/<encoded> layout entry of digest
+func layoutBlobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
@@ -0,0 +1,371 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// DefaultGCGracePeriod protects blobs and references younger than this from garbage collection,
+// so blobs uploaded just before their manifest are not collected mid-push
+const DefaultGCGracePeriod = time.Hour
+
+// gcGuard keeps garbage collection consistent with concurrent pushes.
+// Pushes register the repository and storage keys they store or reference before transferring
+// anything. Starting a run records the keys of in-flight pushes, and pushes during the run record
+// theirs, so the sweep skips every key the mark phase may have missed. The sweep holds the lock
+// exclusively while it checks and removes references; pushes hold it shared only to register,
+// never for their body transfer, so a push either registers before a key is checked (and the key
+// is skipped) or after it was removed.
+type gcGuard struct {
+	sweep sync.RWMutex // Held shared by push registration, exclusively by the sweep
+
+	mu           sync.Mutex
+	running      bool
+	touched      map[string]bool
+	touchedRepos map[string]bool
+	pushing      map[string]int // Storage keys of in-flight pushes
+	pushingRepos map[string]int // Repositories of in-flight pushes
+}
+
+// beginPush registers a push of the storage keys to repository name, waiting for a sweep in
+// progress; call the returned func when done
+func (g *gcGuard) beginPush(name string, keys ...string) func() {
+	g.sweep.RLock()
+	defer g.sweep.RUnlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pushing == nil {
+		g.pushing = make(map[string]int)
+		g.pushingRepos = make(map[string]int)
+	}
+	g.pushingRepos[name]++
+	for _, key := range keys {
+		g.pushing[key]++
+	}
+	if g.running {
+		g.touchedRepos[name] = true
+		for _, key := range keys {
+			g.touched[key] = true
+		}
+	}
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.pushingRepos[name]--; g.pushingRepos[name] == 0 {
+			delete(g.pushingRepos, name)
+		}
+		for _, key := range keys {
+			if g.pushing[key]--; g.pushing[key] == 0 {
+				delete(g.pushing, key)
+			}
+		}
+	}
+}
+
+// start begins a collection, treating the keys and repositories of in-flight pushes as touched
+func (g *gcGuard) start() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.running {
+		return fmt.Errorf("garbage collection already running")
+	}
+	g.running = true
+	g.touched = make(map[string]bool, len(g.pushing))
+	g.touchedRepos = make(map[string]bool, len(g.pushingRepos))
+	for key := range g.pushing {
+		g.touched[key] = true
+	}
+	for name := range g.pushingRepos {
+		g.touchedRepos[name] = true
+	}
+	return nil
+}
+
+// isPushing reports whether a push of the storage key is in flight
+func (g *gcGuard) isPushing(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pushing[key] > 0
+}
+
+// isTouched reports whether a push stored or referenced the storage key since start
+func (g *gcGuard) isTouched(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.touched[key]
+}
+
+// isRepoTouched reports whether a push to repository name started since start
+func (g *gcGuard) isRepoTouched(name string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.touchedRepos[name]
+}
+
+// finish ends the collection
+func (g *gcGuard) finish() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running = false
+	g.touched = nil
+	g.touchedRepos = nil
+}
+
+// manifestDigests returns the digest of a manifest and of everything it references directly
+func manifestDigests(digest string, data []byte) []string {
+	digests := []string{digest}
+	manifest, err := docker.ParseManifest(data)
+	if err != nil {
+		return digests
+	}
+	if manifest.Config != nil {
+		digests = append(digests, manifest.Config.Digest)
+	}
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	for _, child := range manifest.Manifests {
+		digests = append(digests, child.Digest)
+	}
+	return digests
+}
+
+// GCEntry is a repository reference removed (or, in a dry run, to be removed) by garbage collection
+type GCEntry struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	Kind       string `json:"kind"` // "blob" or "manifest"
+	Size       int64  `json:"size"`
+}
+
+// GCReport summarizes a CollectGarbage run. Reclaimed lists the storage keys (the digests, unless
+// keyed by repository) whose content is trashed because no references remain; ReclaimedBytes is
+// their total size.
+// Protected counts unreachable references kept because they are within the grace period.
+type GCReport struct {
+	DryRun         bool      `json:"dryRun"`
+	Removed        []GCEntry `json:"removed"`
+	Reclaimed      []string  `json:"reclaimed"`
+	ReclaimedBytes int64     `json:"reclaimedBytes"`
+	Protected      int       `json:"protected"`
+}
+
+// SetGCGracePeriod sets the minimum age before garbage collection may remove anything: blobs whose
+// CreatedTimestamp, and references whose ReferencedTimestamp, is within the period are never collected
+// even if unreachable (< 0 restores DefaultGCGracePeriod, 0 disables the protection)
+func (s *DockerRegistryPrivateService) SetGCGracePeriod(period time.Duration) {
+	if period < 0 {
+		period = DefaultGCGracePeriod
+	}
+	s.gcGracePeriod = period
+}
+
+// GCGracePeriod returns the minimum age before garbage collection may remove anything
+func (s *DockerRegistryPrivateService) GCGracePeriod() time.Duration {
+	return s.gcGracePeriod
+}
+
+// CollectGarbage removes each repository's references to blobs and manifests that none of its
+// tags or digest references reach (through indexes, configs and layers). Content left without
+// references is trashed by the storage. With dryRun nothing is changed and the report lists what
+// a real run would remove. Requires storage implementing storage.EnumerableStorage, not shared
+// with proxy registries (their cached entries would look unreferenced).
+//
+// Collection runs online. Guarantee: content stored or referenced by a push (PutBlob or PutManifest)
+// that completes before the run or overlaps it is never collected by that run. Blobs uploaded ahead
+// of their manifest are only protected by the grace period (see SetGCGracePeriod), as nothing links
+// them yet; clients that skip an upload because a blob exists must push the manifest within it.
+func (s *DockerRegistryPrivateService) CollectGarbage(ctx context.Context, dryRun bool) (*GCReport, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+	if err := s.gc.start(); err != nil {
+		return nil, err
+	}
+	defer s.gc.finish()
+
+	// Mark: everything reachable from each repository's reference mappings
+	roots := make(map[string][]string)
+	var artifacts []*models.ArtifactMeta
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if !s.isRefKey(meta.Hash) {
+			artifacts = append(artifacts, meta)
+			return nil
+		}
+		name, ok := s.repositoryFromRefKey(meta.Hash)
+		if digest := s.resolveRefDigest(meta); ok && digest != "" {
+			roots[name] = append(roots[name], digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan storage: %w", err)
+	}
+
+	reachable := make(map[string]map[string]bool, len(roots))
+	for name, digests := range roots {
+		marked, err := s.markReachable(ctx, name, digests)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark repository %s: %w", name, err)
+		}
+		reachable[name] = marked
+	}
+
+	// Sweep: repository references to unreachable content, with pushes held off
+	s.gc.sweep.Lock()
+	defer s.gc.sweep.Unlock()
+	report := &GCReport{DryRun: dryRun, Removed: []GCEntry{}, Reclaimed: []string{}}
+	cutoff := time.Now().Add(-s.gcGracePeriod).Unix()
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Hash < artifacts[j].Hash })
+	for _, meta := range artifacts {
+		var removed []models.ArtifactReference
+		for _, ref := range meta.References {
+			if (ref.Repo != "blob" && ref.Repo != "manifest") || reachable[ref.Name][meta.Hash] {
+				continue
+			}
+			if meta.CreatedTimestamp > cutoff || ref.ReferencedTimestamp > cutoff {
+				report.Protected++
+				continue
+			}
+			removed = append(removed, ref)
+		}
+		if len(removed) == 0 || s.gc.isTouched(meta.Hash) {
+			continue
+		}
+
+		for _, ref := range removed {
+			report.Removed = append(report.Removed, GCEntry{Repository: ref.Name, Digest: s.keyStrategy.Digest(ref.Name, meta.Hash), Kind: ref.Repo, Size: meta.Length})
+		}
+		if countRemaining(meta.References, removed) == 0 {
+			report.Reclaimed = append(report.Reclaimed, meta.Hash)
+			report.ReclaimedBytes += meta.Length
+		}
+
+		if dryRun {
+			continue
+		}
+		for _, ref := range removed {
+			if _, err := s.storage.Delete(ctx, meta.Hash, ref); err != nil {
+				return report, fmt.Errorf("failed to remove %s reference of %s: %w", ref.Name, meta.Hash, err)
+			}
+			if ref.Repo == "manifest" {
+				s.manifestCache.RemoveDigest(s.keyStrategy.Digest(ref.Name, meta.Hash))
+			}
+		}
+	}
+
+	// Drop repositories without any tag or digest reference left from the catalog index
+	if !dryRun {
+		keep := make(map[string]bool, len(roots))
+		for name := range roots {
+			keep[name] = true
+		}
+		if err := s.pruneCatalog(ctx, keep, s.gc.isRepoTouched); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// markReachable returns the storage keys, in repository name, of the given manifests and everything they reference
+func (s *DockerRegistryPrivateService) markReachable(ctx context.Context, name string, digests []string) (map[string]bool, error) {
+	marked := make(map[string]bool)
+	mark := func(digest string) {
+		marked[s.getStorageKey(name, digest)] = true
+	}
+	visit := func(digest string, manifest *docker.Manifest, depth int) error {
+		mark(digest)
+		if manifest.Config != nil {
+			mark(manifest.Config.Digest)
+		}
+		for _, layer := range manifest.Layers {
+			mark(layer.Digest)
+		}
+		return nil
+	}
+	fetch := func(ctx context.Context, digest string) ([]byte, error) {
+		return s.readManifest(ctx, name, digest)
+	}
+
+	for _, digest := range digests {
+		if marked[s.getStorageKey(name, digest)] {
+			continue
+		}
+		mark(digest)
+		data, err := fetch(ctx, digest)
+		if err != nil {
+			// A dangling mapping keeps nothing else alive
+			continue
+		}
+		if err := docker.WalkManifest(ctx, digest, data, s.maxManifestDepth, fetch, visit); err != nil {
+			return nil, err
+		}
+	}
+	return marked, nil
+}
+
+// storageKeys returns the storage keys of digests in repository name
+func (s *DockerRegistryPrivateService) storageKeys(name string, digests []string) []string {
+	keys := make([]string, len(digests))
+	for i, digest := range digests {
+		keys[i] = s.getStorageKey(name, digest)
+	}
+	return keys
+}
+
+// readManifest reads manifest content of repository name by digest
+func (s *DockerRegistryPrivateService) readManifest(ctx context.Context, name, digest string) ([]byte, error) {
+	rc, _, err := s.storage.Read(ctx, models.ArtifactRange{
+		Hash:  s.getStorageKey(name, digest),
+		Range: models.ByteRange{Offset: 0, Length: -1},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", digest, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// repositoryFromRefKey reverses getManifestRefKey to the repository name
+func (s *DockerRegistryPrivateService) repositoryFromRefKey(key string) (string, bool) {
+	escaped, _, ok := strings.Cut(strings.TrimPrefix(key, s.refKeyPrefix), ":")
+	if !ok {
+		return "", false
+	}
+	name, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// countRemaining counts the references not in removed (matched by Name+Repo)
+func countRemaining(refs, removed []models.ArtifactReference) int {
+	remaining := 0
+	for _, ref := range refs {
+		found := false
+		for _, r := range removed {
+			if r.Name == ref.Name && r.Repo == ref.Repo {
+				found = true
+				break
+			}
+		}
+		if !found {
+			remaining++
+		}
+	}
+	return remaining
+}
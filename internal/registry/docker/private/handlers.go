@@ -1,9 +1,11 @@
 package private
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
@@ -12,41 +14,94 @@ import (
 
 // SetupRoutes configures HTTP routes for Docker registry API endpoints
 func SetupRoutes(mux *http.ServeMux, service *DockerRegistryPrivateService) {
+	// Routes with a {name} wildcard reject over-long paths and names
+	limits := service.NameLimits()
+
 	// API version check
 	mux.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
 		handleAPIVersion(w, r)
 	})
 
+	// Repository listing, served from the persisted catalog index
+	mux.HandleFunc("GET /v2/_catalog", docker.GzipHandler(service.CompressionConfig(), func(w http.ResponseWriter, r *http.Request) {
+		handleCatalog(w, r, service)
+	}))
+
+	// Tag listing, scanned from the reference mappings
+	mux.HandleFunc("GET /v2/{name}/tags/list", docker.NameLimitHandler(limits, docker.GzipHandler(service.CompressionConfig(), func(w http.ResponseWriter, r *http.Request) {
+		handleListTags(w, r, service)
+	})))
+
 	// Manifest endpoints (read)
-	mux.HandleFunc("GET /v2/{name}/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+	// JSON documents may be gzip-compressed; blob bodies are served as-is
+	mux.HandleFunc("GET /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, docker.GzipHandler(service.CompressionConfig(), func(w http.ResponseWriter, r *http.Request) {
 		handleGetManifest(w, r, service)
-	})
-	mux.HandleFunc("HEAD /v2/{name}/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.HandleFunc("HEAD /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleHeadManifest(w, r, service)
-	})
+	}))
 
 	// Manifest endpoints (write)
-	mux.HandleFunc("PUT /v2/{name}/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("PUT /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handlePutManifest(w, r, service)
-	})
+	}))
+	mux.HandleFunc("DELETE /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteManifest(w, r, service)
+	}))
 
 	// Blob endpoints (read)
-	mux.HandleFunc("GET /v2/{name}/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /v2/{name}/blobs/{digest}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleGetBlob(w, r, service)
-	})
-	mux.HandleFunc("HEAD /v2/{name}/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("HEAD /v2/{name}/blobs/{digest}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleHeadBlob(w, r, service)
-	})
+	}))
 
 	// Blob upload endpoints (write)
-	mux.HandleFunc("POST /v2/{name}/blobs/uploads/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("POST /v2/{name}/blobs/uploads/", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleStartBlobUpload(w, r, service)
-	})
-	mux.HandleFunc("PATCH /v2/{name}/blobs/uploads/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("PATCH /v2/{name}/blobs/uploads/{uuid}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleUploadBlobChunk(w, r, service)
-	})
-	mux.HandleFunc("PUT /v2/{name}/blobs/uploads/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("PUT /v2/{name}/blobs/uploads/{uuid}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleCompleteBlobUpload(w, r, service)
+	}))
+	mux.HandleFunc("GET /v2/{name}/blobs/uploads/{uuid}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
+		handleBlobUploadStatus(w, r, service)
+	}))
+	mux.HandleFunc("DELETE /v2/{name}/blobs/uploads/{uuid}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
+		handleCancelBlobUpload(w, r, service)
+	}))
+
+	// Admin endpoints (debugging)
+	mux.HandleFunc("GET /admin/repos/{name}/blobs", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
+		handleListRepositoryBlobs(w, r, service)
+	}))
+	mux.HandleFunc("POST /admin/repos/{name}/import", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
+		handleImportOCILayout(w, r, service)
+	}))
+	mux.HandleFunc("GET /admin/uploads", func(w http.ResponseWriter, r *http.Request) {
+		handleListUploadSessions(w, r, service)
+	})
+	mux.HandleFunc("GET /admin/export", func(w http.ResponseWriter, r *http.Request) {
+		handleExportOCILayout(w, r, service)
+	})
+	mux.HandleFunc("GET /admin/metrics/uploads", func(w http.ResponseWriter, r *http.Request) {
+		handleUploadMetrics(w, r, service)
+	})
+	mux.HandleFunc("GET /admin/dedup", func(w http.ResponseWriter, r *http.Request) {
+		handleDeduplicationReport(w, r, service)
+	})
+	// GET previews garbage collection (dry run); POST collects
+	mux.HandleFunc("GET /admin/gc", func(w http.ResponseWriter, r *http.Request) {
+		handleCollectGarbage(w, r, service, true)
+	})
+	mux.HandleFunc("POST /admin/gc", func(w http.ResponseWriter, r *http.Request) {
+		handleCollectGarbage(w, r, service, false)
+	})
+	mux.HandleFunc("POST /admin/catalog/rebuild", func(w http.ResponseWriter, r *http.Request) {
+		handleRebuildCatalog(w, r, service)
 	})
 }
 
@@ -61,6 +116,78 @@ func handleAPIVersion(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleCatalog handles GET /v2/_catalog, paginated with the optional n and last query parameters
+func handleCatalog(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	names, err := service.Catalog(r.Context())
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+	names, ok := paginate(w, r, "/v2/_catalog", names)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Repositories []string `json:"repositories"`
+	}{Repositories: names})
+}
+
+// handleListTags handles GET /v2/{name}/tags/list, paginated with the optional n and last query parameters
+func handleListTags(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name := r.PathValue("name")
+	tags, err := service.Tags(r.Context(), name)
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+	tags, ok := paginate(w, r, "/v2/"+name+"/tags/list", tags)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{Name: name, Tags: tags})
+}
+
+// paginate applies the n and last query parameters to sorted items, setting a Link header to the
+// next page at path when items remain. n=0 yields an empty page; n beyond the remaining items
+// returns all of them without a Link. Writes an error and returns false for an invalid n.
+// The result is never nil, so it encodes as a JSON array.
+func paginate(w http.ResponseWriter, r *http.Request, path string, items []string) ([]string, bool) {
+	query := r.URL.Query()
+	if last := query.Get("last"); last != "" {
+		start := 0
+		for start < len(items) && items[start] <= last {
+			start++
+		}
+		items = items[start:]
+	}
+	if n := query.Get("n"); n != "" {
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 0 {
+			docker.WriteError(w, docker.ErrPaginationNumberInvalid(n))
+			return nil, false
+		}
+		if limit < len(items) {
+			items = items[:limit]
+			if limit > 0 {
+				w.Header().Set("Link", fmt.Sprintf("<%s?n=%d&last=%s>; rel=\"next\"", path, limit, url.QueryEscape(items[limit-1])))
+			}
+		}
+	}
+	if items == nil {
+		items = []string{}
+	}
+	return items, true
+}
+
 // handleGetManifest handles GET /v2/{name}/manifests/{reference}
 func handleGetManifest(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
 	if r.Method != http.MethodGet {
@@ -74,7 +201,7 @@ func handleGetManifest(w http.ResponseWriter, r *http.Request, service *DockerRe
 		return
 	}
 
-	manifestData, mediaType, err := service.GetManifest(r.Context(), name, reference)
+	manifestData, mediaType, digest, err := service.GetManifestWithDigest(r.Context(), name, reference)
 	if err != nil {
 		docker.WriteError(w, docker.ErrManifestUnknown(reference))
 		return
@@ -83,9 +210,6 @@ func handleGetManifest(w http.ResponseWriter, r *http.Request, service *DockerRe
 	// Set headers per OCI Distribution Spec
 	w.Header().Set("Content-Type", mediaType)
 	w.Header().Set("Content-Length", strconv.Itoa(len(manifestData)))
-
-	// Calculate and set Docker-Content-Digest
-	digest := service.CalculateDigest(manifestData)
 	w.Header().Set("Docker-Content-Digest", digest)
 
 	w.WriteHeader(http.StatusOK)
@@ -142,16 +266,26 @@ func handlePutManifest(w http.ResponseWriter, r *http.Request, service *DockerRe
 	}
 	defer r.Body.Close()
 
+	// A body shorter (or longer) than declared is a truncated upload: never commit it
+	if r.ContentLength >= 0 && int64(len(manifestData)) != r.ContentLength {
+		docker.WriteError(w, docker.ErrManifestInvalid(fmt.Sprintf("manifest length %d does not match Content-Length %d", len(manifestData), r.ContentLength)))
+		return
+	}
+
 	// Get media type from Content-Type header
 	mediaType := r.Header.Get("Content-Type")
 	if mediaType == "" {
 		mediaType = docker.MediaTypeOCIManifest // Default
 	}
 
-	// Store manifest
-	err = service.PutManifest(r.Context(), name, reference, manifestData, mediaType)
+	// Store manifest, honoring If-Match / If-None-Match: * on the reference's current digest
+	err = service.PutManifestIf(r.Context(), name, reference, manifestData, mediaType, manifestConditionFromRequest(r))
 	if err != nil {
-		docker.WriteError(w, docker.ErrManifestInvalid(err.Error()))
+		if regErr, ok := docker.AsRegistryError(err); ok {
+			docker.WriteError(w, regErr)
+		} else {
+			docker.WriteError(w, docker.ErrManifestInvalid(err.Error()))
+		}
 		return
 	}
 
@@ -162,6 +296,22 @@ func handlePutManifest(w http.ResponseWriter, r *http.Request, service *DockerRe
 	w.WriteHeader(http.StatusCreated)
 }
 
+// handleDeleteManifest handles DELETE /v2/{name}/manifests/{reference}
+func handleDeleteManifest(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name, reference, err := parseManifestPath(r.URL.Path)
+	if err != nil {
+		docker.WriteError(w, docker.ErrNameUnknown(""))
+		return
+	}
+
+	if _, err := service.DeleteManifest(r.Context(), name, reference); err != nil {
+		docker.WriteError(w, docker.ErrManifestUnknown(reference))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 // handleGetBlob handles GET /v2/{name}/blobs/{digest}
 func handleGetBlob(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
 	if r.Method != http.MethodGet {
@@ -175,20 +325,41 @@ func handleGetBlob(w http.ResponseWriter, r *http.Request, service *DockerRegist
 		return
 	}
 
+	if !checkBlobDigest(w, service, digest) {
+		return
+	}
+
+	if serveBlobNotModified(w, r, service, name, digest) {
+		return
+	}
+
+	// Let the client fetch the content from the storage directly when possible
+	if location, ok := service.BlobRedirectURL(r.Context(), name, digest); ok {
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
 	blobReader, size, err := service.GetBlob(r.Context(), name, digest)
 	if err != nil {
 		docker.WriteError(w, docker.ErrBlobUnknown(digest))
 		return
 	}
-	defer blobReader.Close()
 
 	// Set headers
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Docker-Content-Digest", digest)
+	if service.BlobETags() {
+		w.Header().Set("ETag", docker.BlobETag(digest))
+	}
 
 	w.WriteHeader(http.StatusOK)
-	io.Copy(w, blobReader)
+
+	// Stream the body; StreamBlob stops and closes the reader as soon as the client
+	// disconnects, so abandoned transfers don't pin upstream or cache resources
+	docker.StreamBlob(r.Context(), w, blobReader)
 }
 
 // handleHeadBlob handles HEAD /v2/{name}/blobs/{digest}
@@ -204,6 +375,14 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request, service *DockerRegis
 		return
 	}
 
+	if !checkBlobDigest(w, service, digest) {
+		return
+	}
+
+	if serveBlobNotModified(w, r, service, name, digest) {
+		return
+	}
+
 	exists, size, err := service.CheckBlobExists(r.Context(), name, digest)
 	if err != nil {
 		docker.WriteError(w, docker.ErrBlobUnknown(digest))
@@ -218,9 +397,38 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request, service *DockerRegis
 	// Set headers
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Docker-Content-Digest", digest)
+	if service.BlobETags() {
+		w.Header().Set("ETag", docker.BlobETag(digest))
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// checkBlobDigest answers a blob request for a malformed digest with DIGEST_INVALID (BLOB_UNKNOWN
+// if configured, see SetMalformedDigestsNotFound) and reports whether the digest is well-formed
+func checkBlobDigest(w http.ResponseWriter, service *DockerRegistryPrivateService, digest string) bool {
+	err := docker.ValidateDigest(digest)
+	if err == nil {
+		return true
+	}
+	if service.MalformedDigestsNotFound() {
+		docker.WriteError(w, docker.ErrBlobUnknown(digest))
+	} else {
+		docker.WriteError(w, err)
+	}
+	return false
+}
+
+// serveBlobNotModified answers 304 when blob ETags are enabled and the client already has the blob
+func serveBlobNotModified(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService, name, digest string) bool {
+	if !service.BlobETags() {
+		return false
+	}
+	return docker.ServeBlobNotModified(w, r, digest, func() bool {
+		exists, _, err := service.CheckBlobExists(r.Context(), name, digest)
+		return err == nil && exists
+	})
+}
+
 // handleStartBlobUpload handles POST /v2/{name}/blobs/uploads/
 func handleStartBlobUpload(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
 	if r.Method != http.MethodPost {
@@ -234,6 +442,17 @@ func handleStartBlobUpload(w http.ResponseWriter, r *http.Request, service *Dock
 		return
 	}
 
+	// Resume an interrupted single-request upload with the chunked flow (resume = its digest)
+	if resume := r.URL.Query().Get("resume"); resume != "" {
+		uuid, offset, err := service.ResumeBlobUpload(r.Context(), name, resume)
+		if err != nil {
+			docker.WriteError(w, docker.ErrBlobUploadUnknown("failed to create upload session"))
+			return
+		}
+		writeUploadProgress(w, name, uuid, offset)
+		return
+	}
+
 	// Check for single-request upload (digest in query)
 	digest := r.URL.Query().Get("digest")
 	if digest != "" {
@@ -250,11 +469,34 @@ func handleStartBlobUpload(w http.ResponseWriter, r *http.Request, service *Dock
 	}
 
 	// Return session UUID in Location header
-	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uuid)
-	w.Header().Set("Location", location)
-	w.Header().Set("Range", "0-0")
+	writeUploadProgress(w, name, uuid, 0)
+}
+
+// writeUploadProgress answers an upload request that leaves the session open: 202 with the
+// session's Location, UUID and the byte range received so far, and an empty body
+func writeUploadProgress(w http.ResponseWriter, name, uuid string, size int64) {
+	writeUploadRange(w, name, uuid, size, http.StatusAccepted)
+}
+
+// writeUploadRange writes the headers of writeUploadProgress with the given status
+func writeUploadRange(w http.ResponseWriter, name, uuid string, size int64, status int) {
+	end := size - 1
+	if end < 0 {
+		end = 0 // Nothing received yet: "0-0", as the distribution spec has clients expect
+	}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uuid))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
 	w.Header().Set("Docker-Upload-UUID", uuid)
-	w.WriteHeader(http.StatusAccepted)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+// writeBlobCreated answers a completed upload: 201 with the blob's Location and digest, and an empty body
+func writeBlobCreated(w http.ResponseWriter, name, digest string) {
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
 }
 
 // handleSingleRequestBlobUpload handles POST /v2/{name}/blobs/uploads/?digest={digest}
@@ -272,10 +514,12 @@ func handleSingleRequestBlobUpload(w http.ResponseWriter, r *http.Request, servi
 		r.Body = io.NopCloser(strings.NewReader(string(data)))
 	}
 
-	// Upload blob directly
-	err := service.PutBlob(r.Context(), name, digest, r.Body, contentLength)
+	// Upload blob directly (resumable when uploads are staged)
+	err := service.PutBlobResumable(r.Context(), name, digest, r.Body, contentLength)
 	if err != nil {
-		if strings.Contains(err.Error(), "digest mismatch") {
+		if regErr, ok := docker.AsRegistryError(err); ok {
+			docker.WriteError(w, regErr)
+		} else if strings.Contains(err.Error(), "digest mismatch") {
 			docker.WriteError(w, docker.ErrBlobUploadInvalid("digest mismatch"))
 		} else {
 			docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
@@ -283,10 +527,7 @@ func handleSingleRequestBlobUpload(w http.ResponseWriter, r *http.Request, servi
 		return
 	}
 
-	// Set headers
-	w.Header().Set("Docker-Content-Digest", digest)
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
-	w.WriteHeader(http.StatusCreated)
+	writeBlobCreated(w, name, digest)
 }
 
 // handleUploadBlobChunk handles PATCH /v2/{name}/blobs/uploads/{uuid}
@@ -302,6 +543,11 @@ func handleUploadBlobChunk(w http.ResponseWriter, r *http.Request, service *Dock
 		return
 	}
 
+	if err := validateUploadUUIDHeader(r, uuid); err != nil {
+		docker.WriteError(w, docker.ErrBlobUploadInvalid(err.Error()))
+		return
+	}
+
 	// Parse Range header for offset
 	offset := int64(0)
 	rangeHeader := r.Header.Get("Content-Range")
@@ -324,14 +570,15 @@ func handleUploadBlobChunk(w http.ResponseWriter, r *http.Request, service *Dock
 	// Upload chunk
 	newOffset, err := service.UploadBlobChunk(r.Context(), name, uuid, r.Body, offset)
 	if err != nil {
-		docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
+		if regErr, ok := docker.AsRegistryError(err); ok {
+			docker.WriteError(w, regErr)
+		} else {
+			docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
+		}
 		return
 	}
 
-	// Set headers
-	w.Header().Set("Range", fmt.Sprintf("0-%d", newOffset-1))
-	w.Header().Set("Docker-Upload-UUID", uuid)
-	w.WriteHeader(http.StatusNoContent)
+	writeUploadProgress(w, name, uuid, newOffset)
 }
 
 // handleCompleteBlobUpload handles PUT /v2/{name}/blobs/uploads/{uuid}?digest={digest}
@@ -347,6 +594,11 @@ func handleCompleteBlobUpload(w http.ResponseWriter, r *http.Request, service *D
 		return
 	}
 
+	if err := validateUploadUUIDHeader(r, uuid); err != nil {
+		docker.WriteError(w, docker.ErrBlobUploadInvalid(err.Error()))
+		return
+	}
+
 	// Get digest from query parameter
 	digest := r.URL.Query().Get("digest")
 	if digest == "" {
@@ -357,7 +609,9 @@ func handleCompleteBlobUpload(w http.ResponseWriter, r *http.Request, service *D
 	// Complete upload (final chunk is in request body)
 	err = service.CompleteBlobUpload(r.Context(), name, uuid, digest, r.Body)
 	if err != nil {
-		if strings.Contains(err.Error(), "digest mismatch") {
+		if regErr, ok := docker.AsRegistryError(err); ok {
+			docker.WriteError(w, regErr)
+		} else if strings.Contains(err.Error(), "digest mismatch") {
 			docker.WriteError(w, docker.ErrBlobUploadInvalid("digest mismatch"))
 		} else {
 			docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
@@ -365,10 +619,149 @@ func handleCompleteBlobUpload(w http.ResponseWriter, r *http.Request, service *D
 		return
 	}
 
-	// Set headers
-	w.Header().Set("Docker-Content-Digest", digest)
-	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, digest))
-	w.WriteHeader(http.StatusCreated)
+	if service.UploadUUIDOnCompletion() {
+		w.Header().Set("Docker-Upload-UUID", uuid)
+	}
+	writeBlobCreated(w, name, digest)
+}
+
+// handleBlobUploadStatus handles GET /v2/{name}/blobs/uploads/{uuid}: 204 with the byte range received so far
+func handleBlobUploadStatus(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name, uuid := r.PathValue("name"), r.PathValue("uuid")
+	offset, err := service.BlobUploadStatus(r.Context(), name, uuid)
+	if err != nil {
+		docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
+		return
+	}
+	writeUploadRange(w, name, uuid, offset, http.StatusNoContent)
+}
+
+// handleCancelBlobUpload handles DELETE /v2/{name}/blobs/uploads/{uuid}
+func handleCancelBlobUpload(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name, uuid := r.PathValue("name"), r.PathValue("uuid")
+	if err := service.CancelBlobUpload(r.Context(), name, uuid); err != nil {
+		docker.WriteError(w, docker.ErrBlobUploadUnknown(err.Error()))
+		return
+	}
+	w.Header().Set("Docker-Upload-UUID", uuid)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListRepositoryBlobs handles GET /admin/repos/{name}/blobs
+func handleListRepositoryBlobs(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name := r.PathValue("name")
+	if name == "" {
+		docker.WriteError(w, docker.ErrNameUnknown(""))
+		return
+	}
+
+	blobs, err := service.ListRepositoryBlobs(r.Context(), name)
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Name  string     `json:"name"`
+		Blobs []BlobInfo `json:"blobs"`
+	}{Name: name, Blobs: blobs})
+}
+
+// handleImportOCILayout handles POST /admin/repos/{name}/import with an OCI image layout tar body
+func handleImportOCILayout(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	report, err := service.ImportOCILayout(r.Context(), r.PathValue("name"), r.Body)
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleExportOCILayout handles GET /admin/export?name=...&reference=..., streaming an OCI image layout tar
+func handleExportOCILayout(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	name, reference := r.URL.Query().Get("name"), r.URL.Query().Get("reference")
+	if name == "" || reference == "" {
+		docker.WriteError(w, docker.ErrNameInvalid("name and reference are required"))
+		return
+	}
+	if err := service.NameLimits().Check(r.URL.Path, name); err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	export, err := service.ExportOCILayout(r.Context(), name, reference)
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	// Failures while streaming can't be reported anymore; the client sees a truncated archive
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+	export.Stream(r.Context(), w)
+}
+
+// handleListUploadSessions handles GET /admin/uploads
+func handleListUploadSessions(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Uploads []UploadSessionInfo `json:"uploads"`
+	}{Uploads: service.UploadSessions()})
+}
+
+// handleUploadMetrics handles GET /admin/metrics/uploads
+func handleUploadMetrics(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(service.UploadMetrics())
+}
+
+// handleDeduplicationReport handles GET /admin/dedup
+func handleDeduplicationReport(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	report, err := service.DeduplicationReport(r.Context())
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleCollectGarbage handles GET (dry run) and POST /admin/gc
+func handleCollectGarbage(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService, dryRun bool) {
+	report, err := service.CollectGarbage(r.Context(), dryRun)
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleRebuildCatalog handles POST /admin/catalog/rebuild
+func handleRebuildCatalog(w http.ResponseWriter, r *http.Request, service *DockerRegistryPrivateService) {
+	names, err := service.RebuildCatalog(r.Context())
+	if err != nil {
+		docker.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Repositories []string `json:"repositories"`
+	}{Repositories: names})
 }
 
 // parseManifestPath extracts name and reference from /v2/{name}/manifests/{reference}
@@ -458,14 +851,50 @@ func parseBlobUploadPath(path string) (string, string, error) {
 	name := parts[0]
 	uuid := parts[1]
 
-	// Remove query string from uuid if present
-	if idx := strings.Index(uuid, "?"); idx >= 0 {
+	// Remove query string and fragment from uuid if present
+	if idx := strings.IndexAny(uuid, "?#"); idx >= 0 {
 		uuid = uuid[:idx]
 	}
 
+	// Decode percent-encoded characters so encoded separators can't slip through
+	decoded, err := url.PathUnescape(uuid)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid uuid encoding: %w", err)
+	}
+	uuid = decoded
+
 	if name == "" || uuid == "" {
 		return "", "", fmt.Errorf("name and uuid cannot be empty")
 	}
 
+	if !isValidUploadUUID(uuid) {
+		return "", "", fmt.Errorf("invalid uuid: %s", uuid)
+	}
+
 	return name, uuid, nil
 }
+
+// isValidUploadUUID checks that an upload session id only contains [A-Za-z0-9._-]
+func isValidUploadUUID(uuid string) bool {
+	for _, c := range uuid {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateUploadUUIDHeader checks that the Docker-Upload-UUID header, when present, matches the path UUID
+func validateUploadUUIDHeader(r *http.Request, uuid string) error {
+	headerUUID := strings.TrimSpace(r.Header.Get("Docker-Upload-UUID"))
+	if headerUUID == "" {
+		return nil
+	}
+	if headerUUID != uuid {
+		return fmt.Errorf("upload UUID header %s does not match upload %s", headerUUID, uuid)
+	}
+	return nil
+}
@@ -0,0 +1,1551 @@
+package private
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+
+	"github.com/gofrs/flock"
+)
+
+// setupTestMux creates a test service with its routes mounted on a new ServeMux
+func setupTestMux(t *testing.T) (*DockerRegistryPrivateService, *http.ServeMux) {
+	service, _ := setupTestService(t)
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	return service, mux
+}
+
+// largeManifest builds a manifest JSON document with many layers
+func largeManifest(layers int) []byte {
+	var sb strings.Builder
+	sb.WriteString(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[`)
+	for i := 0; i < layers; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","size":%d,"digest":"sha256:%064d"}`, i, i)
+	}
+	sb.WriteString("]}")
+	return []byte(sb.String())
+}
+
+// TestHandleGetManifestGzip tests that large manifests are gzip-encoded when requested
+func TestHandleGetManifestGzip(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetCompressionConfig(docker.CompressionConfig{Enabled: true})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	manifestData := largeManifest(500)
+	if err := service.PutManifest(ctx, "test-repo", "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/test-repo/manifests/latest", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got %q", rec.Header().Get("Vary"))
+	}
+	if rec.Body.Len() >= len(manifestData) {
+		t.Errorf("Expected compressed body smaller than %d bytes, got %d", len(manifestData), rec.Body.Len())
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	decoded, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress body: %v", err)
+	}
+	if !bytes.Equal(decoded, manifestData) {
+		t.Error("Decompressed manifest does not match the stored manifest")
+	}
+
+	// Without Accept-Encoding the manifest must be served uncompressed
+	req = httptest.NewRequest(http.MethodGet, "/v2/test-repo/manifests/latest", nil)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no Content-Encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), manifestData) {
+		t.Error("Uncompressed manifest does not match the stored manifest")
+	}
+}
+
+// TestHandleGetManifestGzipDisabled tests that compression is off by default
+func TestHandleGetManifestGzipDisabled(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	manifestData := largeManifest(500)
+	if err := service.PutManifest(ctx, "test-repo", "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/test-repo/manifests/latest", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no Content-Encoding when compression is disabled, got %q", rec.Header().Get("Content-Encoding"))
+	}
+}
+
+// TestHandleGetBlobNotCompressed tests that blob bodies are never gzip-encoded
+func TestHandleGetBlobNotCompressed(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetCompressionConfig(docker.CompressionConfig{Enabled: true, MinSize: 1})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	blobData := bytes.Repeat([]byte("compressible blob data "), 1000)
+	digest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/test-repo/blobs/"+digest, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Blob response must not be compressed, got Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), blobData) {
+		t.Error("Blob body does not match the stored blob")
+	}
+}
+
+// TestHandleListingGzip tests that listings are gzip-encoded when requested
+func TestHandleListingGzip(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetCompressionConfig(docker.CompressionConfig{Enabled: true, MinSize: 1})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	manifestData := largeManifest(1)
+	if err := service.PutManifest(ctx, "test-repo", "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	for _, path := range []string{"/v2/_catalog", "/v2/test-repo/tags/list"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", path, rec.Code)
+		}
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("GET %s: expected Content-Encoding gzip, got %q", path, rec.Header().Get("Content-Encoding"))
+		}
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("GET %s: failed to open gzip body: %v", path, err)
+		}
+		decoded, err := io.ReadAll(gz)
+		if err != nil || !json.Valid(decoded) {
+			t.Errorf("GET %s: expected a gzip-encoded JSON body, got %q (%v)", path, decoded, err)
+		}
+	}
+}
+
+// startTestUpload starts an upload session through the mux and returns its UUID
+func startTestUpload(t *testing.T, mux *http.ServeMux) string {
+	req := httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", rec.Code)
+	}
+	return rec.Header().Get("Docker-Upload-UUID")
+}
+
+// TestHandleUploadBlobChunkUUIDHeader tests Docker-Upload-UUID header validation on PATCH
+func TestHandleUploadBlobChunkUUIDHeader(t *testing.T) {
+	_, mux := setupTestMux(t)
+	uuid := startTestUpload(t, mux)
+
+	t.Run("matching_header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/v2/test-repo/blobs/uploads/"+uuid, strings.NewReader("chunk"))
+		req.Header.Set("Docker-Upload-UUID", uuid)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("mismatching_header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/v2/test-repo/blobs/uploads/"+uuid, strings.NewReader("chunk"))
+		req.Header.Set("Docker-Upload-UUID", "some-other-upload")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "BLOB_UPLOAD_INVALID") {
+			t.Errorf("Expected BLOB_UPLOAD_INVALID error, got %s", rec.Body.String())
+		}
+	})
+}
+
+// TestHandleBlobUploadResponseHeaders tests the exact headers of every upload response, as the
+// distribution spec defines them, and that an uploaded blob is immediately pullable as octet-stream
+func TestHandleBlobUploadResponseHeaders(t *testing.T) {
+	service, mux := setupTestMux(t)
+	do := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		return rec
+	}
+	expectHeaders := func(rec *httptest.ResponseRecorder, status int, want map[string]string) {
+		t.Helper()
+		if rec.Code != status {
+			t.Fatalf("Expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("Expected an empty body, got %q", rec.Body.String())
+		}
+		for key, value := range want {
+			if got := rec.Header().Get(key); got != value {
+				t.Errorf("Expected %s %q, got %q", key, value, got)
+			}
+		}
+		if len(rec.Header()) != len(want) {
+			t.Errorf("Expected exactly the headers %v, got %v", want, rec.Header())
+		}
+	}
+
+	// Monolithic upload
+	blob := []byte("monolithic layer")
+	digest := service.CalculateDigest(blob)
+	expectHeaders(do(http.MethodPost, "/v2/test-repo/blobs/uploads/?digest="+digest, blob), http.StatusCreated, map[string]string{
+		"Location":              "/v2/test-repo/blobs/" + digest,
+		"Docker-Content-Digest": digest,
+		"Content-Length":        "0",
+	})
+
+	// Chunked upload
+	rec := do(http.MethodPost, "/v2/test-repo/blobs/uploads/", nil)
+	uuid := rec.Header().Get("Docker-Upload-UUID")
+	location := "/v2/test-repo/blobs/uploads/" + uuid
+	expectHeaders(rec, http.StatusAccepted, map[string]string{
+		"Location":           location,
+		"Range":              "0-0",
+		"Docker-Upload-UUID": uuid,
+		"Content-Length":     "0",
+	})
+	chunked := []byte("chunked layer content")
+	expectHeaders(do(http.MethodPatch, location, chunked[:7]), http.StatusAccepted, map[string]string{
+		"Location":           location,
+		"Range":              "0-6",
+		"Docker-Upload-UUID": uuid,
+		"Content-Length":     "0",
+	})
+	chunkedDigest := service.CalculateDigest(chunked)
+	expectHeaders(do(http.MethodPut, location+"?digest="+chunkedDigest, chunked[7:]), http.StatusCreated, map[string]string{
+		"Location":              "/v2/test-repo/blobs/" + chunkedDigest,
+		"Docker-Content-Digest": chunkedDigest,
+		"Content-Length":        "0",
+	})
+
+	// Both are pullable right away
+	for _, d := range []string{digest, chunkedDigest} {
+		rec := do(http.MethodGet, "/v2/test-repo/blobs/"+d, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/octet-stream" || rec.Header().Get("Docker-Content-Digest") != d {
+			t.Errorf("Expected blob %s to be served as octet-stream, got %d %v", d, rec.Code, rec.Header())
+		}
+	}
+}
+
+// TestHandleUploadBlobChunkRange tests that PATCH responses report exactly the bytes received,
+// including zero-length chunks before and after data
+func TestHandleUploadBlobChunkRange(t *testing.T) {
+	_, mux := setupTestMux(t)
+	uuid := startTestUpload(t, mux)
+	location := "/v2/test-repo/blobs/uploads/" + uuid
+
+	for _, step := range []struct {
+		chunk string
+		want  string
+	}{
+		{"", "0-0"},
+		{"0123456789", "0-9"},
+		{"", "0-9"},
+		{"abc", "0-12"},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, location, strings.NewReader(step.chunk)))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Range"); got != step.want {
+			t.Errorf("Expected Range %q after a %d byte chunk, got %q", step.want, len(step.chunk), got)
+		}
+	}
+}
+
+// interruptedReader yields data, then fails like a dropped client connection
+type interruptedReader struct {
+	data []byte
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// TestHandleResumeSingleRequestUpload tests resuming an interrupted single-request upload with
+// the chunked flow from the last staged byte
+func TestHandleResumeSingleRequestUpload(t *testing.T) {
+	service, mux := setupTestMux(t)
+	service.SetUploadStaging(setupTestStorage(t), "test-staging")
+	blob := bytes.Repeat([]byte("large single-request layer "), 4000)
+	digest := service.CalculateDigest(blob)
+	received := len(blob) / 3
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/?digest="+digest, &interruptedReader{data: blob[:received]})
+	req.ContentLength = int64(len(blob))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code == http.StatusCreated {
+		t.Fatal("Expected the interrupted upload to fail")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/?resume="+digest, nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Range"), fmt.Sprintf("0-%d", received-1); got != want {
+		t.Fatalf("Expected Range %s of the staged bytes, got %s", want, got)
+	}
+	location := rec.Header().Get("Location")
+
+	req = httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(blob[received:]))
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d", received, len(blob)-1))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Range") != fmt.Sprintf("0-%d", len(blob)-1) {
+		t.Fatalf("Expected the rest to be appended, got %d Range %s", rec.Code, rec.Header().Get("Range"))
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, location+"?digest="+digest, nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/test-repo/blobs/"+digest, nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), blob) {
+		t.Fatalf("Expected the resumed blob to be pullable, got %d", rec.Code)
+	}
+
+	// The session was handed out once: resuming again starts from zero
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/?resume="+digest, nil))
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Range") != "0-0" || rec.Header().Get("Location") == location {
+		t.Errorf("Expected a new session, got %d Range %s at %s", rec.Code, rec.Header().Get("Range"), rec.Header().Get("Location"))
+	}
+}
+
+// TestHandleUploadUUIDLifecycle tests that every response of an upload session carries its
+// Docker-Upload-UUID, the completion included when enabled
+func TestHandleUploadUUIDLifecycle(t *testing.T) {
+	service, mux := setupTestMux(t)
+	service.SetUploadUUIDOnCompletion(true)
+	do := func(method, target string, body []byte, status int, uuid string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		if rec.Code != status {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, target, status, rec.Code, rec.Body.String())
+		}
+		if uuid != "" && rec.Header().Get("Docker-Upload-UUID") != uuid {
+			t.Errorf("%s %s: expected Docker-Upload-UUID %s, got %q", method, target, uuid, rec.Header().Get("Docker-Upload-UUID"))
+		}
+		return rec
+	}
+
+	blob := []byte("uuid lifecycle layer")
+	digest := service.CalculateDigest(blob)
+	uuid := do(http.MethodPost, "/v2/test-repo/blobs/uploads/", nil, http.StatusAccepted, "").Header().Get("Docker-Upload-UUID")
+	if uuid == "" {
+		t.Fatal("Expected the start response to carry Docker-Upload-UUID")
+	}
+	location := "/v2/test-repo/blobs/uploads/" + uuid
+	do(http.MethodPatch, location, blob[:5], http.StatusAccepted, uuid)
+	if rec := do(http.MethodGet, location, nil, http.StatusNoContent, uuid); rec.Header().Get("Range") != "0-4" {
+		t.Errorf("Expected status Range 0-4, got %q", rec.Header().Get("Range"))
+	}
+	do(http.MethodPut, location+"?digest="+digest, blob[5:], http.StatusCreated, uuid)
+	do(http.MethodGet, location, nil, http.StatusNotFound, "")
+
+	// A cancelled session is gone
+	uuid = do(http.MethodPost, "/v2/test-repo/blobs/uploads/", nil, http.StatusAccepted, "").Header().Get("Docker-Upload-UUID")
+	location = "/v2/test-repo/blobs/uploads/" + uuid
+	do(http.MethodDelete, location, nil, http.StatusNoContent, uuid)
+	do(http.MethodPatch, location, blob, http.StatusNotFound, "")
+	do(http.MethodDelete, location, nil, http.StatusNotFound, "")
+}
+
+// TestHandleListUploadSessions tests that active upload sessions are listed with their offsets
+func TestHandleListUploadSessions(t *testing.T) {
+	_, mux := setupTestMux(t)
+	first := startTestUpload(t, mux)
+	second := startTestUpload(t, mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/v2/test-repo/blobs/uploads/"+second, strings.NewReader("twelve bytes")))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/uploads", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Uploads []UploadSessionInfo `json:"uploads"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	offsets := map[string]int64{}
+	for _, upload := range body.Uploads {
+		if upload.Name != "test-repo" || upload.StartedAt.IsZero() || upload.AgeSeconds < 0 {
+			t.Errorf("Unexpected session %+v", upload)
+		}
+		offsets[upload.UUID] = upload.Offset
+	}
+	if !reflect.DeepEqual(offsets, map[string]int64{first: 0, second: 12}) {
+		t.Errorf("Expected sessions %s at 0 and %s at 12, got %v", first, second, offsets)
+	}
+}
+
+// TestHandleUploadBufferLimit tests that a PATCH growing a session past the buffer limit is
+// rejected with 413 and aborts the session
+func TestHandleUploadBufferLimit(t *testing.T) {
+	service, mux := setupTestMux(t)
+	service.SetMaxUploadBuffer(10)
+	uuid := startTestUpload(t, mux)
+	location := "/v2/test-repo/blobs/uploads/" + uuid
+	patch := func(chunk string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, location, strings.NewReader(chunk)))
+		return rec
+	}
+
+	if rec := patch("0123456"); rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected a chunk within the limit to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := patch("7890")
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "BLOB_UPLOAD_INVALID") {
+		t.Fatalf("Expected 413 BLOB_UPLOAD_INVALID past the limit, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	service.sessionsMutex.RLock()
+	_, exists := service.uploadSessions[uuid]
+	service.sessionsMutex.RUnlock()
+	if exists {
+		t.Error("Expected the session to be aborted")
+	}
+	if rec := patch("x"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the aborted session to be unknown, got %d", rec.Code)
+	}
+}
+
+// TestHandleUploadMetrics tests the upload size histograms and the oversize rejection counter
+func TestHandleUploadMetrics(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+	if err := service.SetUploadSizeBuckets([]int64{10, 100}); err != nil {
+		t.Fatalf("SetUploadSizeBuckets failed: %v", err)
+	}
+	if err := service.SetUploadSizeBuckets([]int64{100, 10}); err == nil {
+		t.Error("Expected decreasing buckets to be rejected")
+	}
+
+	for _, size := range []int{5, 10, 50, 500} {
+		data := bytes.Repeat([]byte("m"), size)
+		if err := service.PutBlob(ctx, "test-repo", service.CalculateDigest(data), bytes.NewReader(data), int64(size)); err != nil {
+			t.Fatalf("PutBlob failed: %v", err)
+		}
+	}
+	if err := service.PutManifest(ctx, "test-repo", "latest", []byte(`{"schemaVersion":2}`), docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	service.SetMaxUploadBuffer(10)
+	uuid := startTestUpload(t, mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/v2/test-repo/blobs/uploads/"+uuid, strings.NewReader("0123456789abc")))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 past the buffer limit, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/metrics/uploads", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var metrics UploadMetrics
+	if err := json.NewDecoder(rec.Body).Decode(&metrics); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(metrics.Blobs, SizeHistogram{Buckets: []int64{10, 100}, Counts: []int64{2, 1, 1}, Count: 4, Sum: 565}) {
+		t.Errorf("Unexpected blob histogram: %+v", metrics.Blobs)
+	}
+	if metrics.Manifests.Count != 1 || !reflect.DeepEqual(metrics.Manifests.Counts, []int64{0, 1, 0}) {
+		t.Errorf("Unexpected manifest histogram: %+v", metrics.Manifests)
+	}
+	if !reflect.DeepEqual(metrics.Rejections, map[string]int64{RejectUploadBuffer: 1}) {
+		t.Errorf("Expected one upload buffer rejection, got %v", metrics.Rejections)
+	}
+}
+
+// TestHandleLockTimeoutRetryAfter tests that pushes failing on a held artifact lock get 503
+// with a Retry-After derived from the storage lock timeout
+func TestHandleLockTimeoutRetryAfter(t *testing.T) {
+	lockDir := t.TempDir()
+	lockedStorage, err := storage.NewConcurrentArtifactStorage(setupTestStorage(t), lockDir, 1200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create concurrent storage: %v", err)
+	}
+	service, mux := setupTestMux(t)
+	service.SetStorage(lockedStorage)
+
+	blobData := []byte("contended layer")
+	digest := service.CalculateDigest(blobData)
+	lockPath := lockedStorage.GetLockPath(service.getStorageKey("test-repo", digest))
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		t.Fatalf("Failed to create lock directory: %v", err)
+	}
+	fileLock := flock.New(lockPath)
+	if err := fileLock.Lock(); err != nil {
+		t.Fatalf("Failed to hold the lock: %v", err)
+	}
+	defer fileLock.Unlock()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/?digest="+digest, bytes.NewReader(blobData)))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "UNAVAILABLE") {
+		t.Fatalf("Expected 503 UNAVAILABLE on lock timeout, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Expected Retry-After 2 (lock timeout rounded up), got %q", got)
+	}
+}
+
+// TestHandleBlobMalformedDigest tests that malformed blob digests get 400 DIGEST_INVALID, well-formed
+// but absent ones 404 BLOB_UNKNOWN, and that the legacy 404 can be configured
+func TestHandleBlobMalformedDigest(t *testing.T) {
+	service, mux := setupTestMux(t)
+	get := func(method, digest string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/v2/test-repo/blobs/"+digest, nil))
+		return rec
+	}
+
+	absent := "sha256:" + strings.Repeat("ab", 32)
+	for _, digest := range []string{"sha256:abc", "sha256:" + strings.Repeat("AB", 32), "nodigest", "SHA256:" + strings.Repeat("ab", 32)} {
+		if rec := get(http.MethodGet, digest); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "DIGEST_INVALID") {
+			t.Errorf("GET %s: expected 400 DIGEST_INVALID, got %d: %s", digest, rec.Code, rec.Body.String())
+		}
+		if rec := get(http.MethodHead, digest); rec.Code != http.StatusBadRequest {
+			t.Errorf("HEAD %s: expected 400, got %d", digest, rec.Code)
+		}
+	}
+	if rec := get(http.MethodGet, absent); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "BLOB_UNKNOWN") {
+		t.Errorf("Expected 404 BLOB_UNKNOWN for a well-formed absent digest, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get(http.MethodHead, absent); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected HEAD of a well-formed absent digest to be 404, got %d", rec.Code)
+	}
+
+	service.SetMalformedDigestsNotFound(true)
+	if rec := get(http.MethodGet, "sha256:abc"); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "BLOB_UNKNOWN") {
+		t.Errorf("Expected 404 BLOB_UNKNOWN for a malformed digest when configured, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleCompleteBlobUploadUUIDHeader tests Docker-Upload-UUID header validation on PUT
+func TestHandleCompleteBlobUploadUUIDHeader(t *testing.T) {
+	service, mux := setupTestMux(t)
+	uuid := startTestUpload(t, mux)
+
+	blobData := []byte("blob data")
+	digest := service.CalculateDigest(blobData)
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/blobs/uploads/"+uuid+"?digest="+digest, bytes.NewReader(blobData))
+	req.Header.Set("Docker-Upload-UUID", "some-other-upload")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for mismatched header, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/v2/test-repo/blobs/uploads/"+uuid+"?digest="+digest, bytes.NewReader(blobData))
+	req.Header.Set("Docker-Upload-UUID", uuid)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected status 201 for matching header, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestParseBlobUploadPath tests upload UUID parsing against stray fragments and encodings
+func TestParseBlobUploadPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		uuid    string
+		wantErr bool
+	}{
+		{path: "/v2/repo/blobs/uploads/123-0", uuid: "123-0"},
+		{path: "/v2/repo/blobs/uploads/123-0?digest=sha256:abc", uuid: "123-0"},
+		{path: "/v2/repo/blobs/uploads/123-0#frag", uuid: "123-0"},
+		{path: "/v2/repo/blobs/uploads/%31%32%33-0", uuid: "123-0"},
+		{path: "/v2/repo/blobs/uploads/..%2F..%2Fetc", wantErr: true},
+		{path: "/v2/repo/blobs/uploads/bad%zz", wantErr: true},
+		{path: "/v2/repo/blobs/uploads/#frag", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, uuid, err := parseBlobUploadPath(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got uuid %q", tt.path, uuid)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+		} else if uuid != tt.uuid {
+			t.Errorf("%s: expected uuid %q, got %q", tt.path, tt.uuid, uuid)
+		}
+	}
+}
+
+// TestHandleListRepositoryBlobs tests that the admin endpoint lists a repository's blobs with sizes
+func TestHandleListRepositoryBlobs(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	expected := map[string]int64{}
+	for _, data := range [][]byte{[]byte("first layer"), bytes.Repeat([]byte("second layer"), 100)} {
+		digest := service.CalculateDigest(data)
+		if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("PutBlob failed: %v", err)
+		}
+		expected[digest] = int64(len(data))
+	}
+	other := []byte("other repo layer")
+	if err := service.PutBlob(ctx, "other-repo", service.CalculateDigest(other), bytes.NewReader(other), int64(len(other))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/repos/test-repo/blobs", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Name  string     `json:"name"`
+		Blobs []BlobInfo `json:"blobs"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Name != "test-repo" || len(body.Blobs) != len(expected) {
+		t.Fatalf("Expected %d blobs for test-repo, got %+v", len(expected), body)
+	}
+	for _, blob := range body.Blobs {
+		if size, ok := expected[blob.Digest]; !ok || size != blob.Size {
+			t.Errorf("Unexpected blob %s with size %d", blob.Digest, blob.Size)
+		}
+	}
+}
+
+// TestHandleImportOCILayout tests importing an OCI image layout tarball and pulling the image
+func TestHandleImportOCILayout(t *testing.T) {
+	service, mux := setupTestMux(t)
+
+	layer := []byte("imported layer content")
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layerDigest := service.CalculateDigest(layer)
+	configDigest := service.CalculateDigest(config)
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":%d,"digest":"%s"},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","size":%d,"digest":"%s"}]}`,
+		docker.MediaTypeOCIManifest, len(config), configDigest, len(layer), layerDigest))
+	manifestDigest := service.CalculateDigest(manifest)
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","manifests":[{"mediaType":"%s","size":%d,"digest":"%s","annotations":{"org.opencontainers.image.ref.name":"v1"}}]}`,
+		docker.MediaTypeOCIManifestIndex, docker.MediaTypeOCIManifest, len(manifest), manifestDigest))
+
+	layout := func(entries map[string][]byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range []string{"oci-layout", "index.json", "blobs/sha256/" + strings.TrimPrefix(manifestDigest, "sha256:"),
+			"blobs/sha256/" + strings.TrimPrefix(configDigest, "sha256:"), "blobs/sha256/" + strings.TrimPrefix(layerDigest, "sha256:")} {
+			data, ok := entries[name]
+			if !ok {
+				continue
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatalf("Failed to write tar header: %v", err)
+			}
+			tw.Write(data)
+		}
+		tw.Close()
+		return &buf
+	}
+	entries := map[string][]byte{
+		"oci-layout": []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json": index,
+		"blobs/sha256/" + strings.TrimPrefix(manifestDigest, "sha256:"): manifest,
+		"blobs/sha256/" + strings.TrimPrefix(configDigest, "sha256:"):   config,
+		"blobs/sha256/" + strings.TrimPrefix(layerDigest, "sha256:"):    layer,
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/repos/imported/import", layout(entries)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report ImportReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if report.Blobs != 2 || !reflect.DeepEqual(report.Manifests, []string{manifestDigest}) || !reflect.DeepEqual(report.Tags, []string{"v1"}) {
+		t.Errorf("Unexpected import report: %+v", report)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/imported/manifests/v1", nil)
+	req.Header.Set("Accept", docker.MediaTypeOCIManifest)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), manifest) {
+		t.Fatalf("Expected the imported manifest by tag, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Docker-Content-Digest"); got != manifestDigest {
+		t.Errorf("Expected digest %s, got %s", manifestDigest, got)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/imported/blobs/"+layerDigest, nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), layer) {
+		t.Fatalf("Expected the imported layer, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A blob whose content doesn't match its file name is rejected
+	entries["blobs/sha256/"+strings.TrimPrefix(layerDigest, "sha256:")] = []byte("tampered")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/repos/tampered/import", layout(entries)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "DIGEST_INVALID") {
+		t.Errorf("Expected DIGEST_INVALID for a tampered blob, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A layout without index.json is rejected
+	delete(entries, "index.json")
+	entries["blobs/sha256/"+strings.TrimPrefix(layerDigest, "sha256:")] = layer
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/repos/noindex/import", layout(entries)))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "MANIFEST_INVALID") {
+		t.Errorf("Expected MANIFEST_INVALID without index.json, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleExportOCILayout tests exporting a pushed image index and re-importing the layout
+func TestHandleExportOCILayout(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	layer := bytes.Repeat([]byte("exported layer"), 1000)
+	config := []byte(`{"architecture":"arm64","os":"linux"}`)
+	for _, blob := range [][]byte{layer, config} {
+		if err := service.PutBlob(ctx, "export-src", service.CalculateDigest(blob), bytes.NewReader(blob), int64(len(blob))); err != nil {
+			t.Fatalf("PutBlob failed: %v", err)
+		}
+	}
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":%d,"digest":"%s"},"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","size":%d,"digest":"%s"}]}`,
+		docker.MediaTypeOCIManifest, len(config), service.CalculateDigest(config), len(layer), service.CalculateDigest(layer)))
+	manifestDigest := service.CalculateDigest(manifest)
+	if err := service.PutManifest(ctx, "export-src", manifestDigest, manifest, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","manifests":[{"mediaType":"%s","size":%d,"digest":"%s","platform":{"architecture":"arm64","os":"linux"}}]}`,
+		docker.MediaTypeOCIManifestIndex, docker.MediaTypeOCIManifest, len(manifest), manifestDigest))
+	if err := service.PutManifest(ctx, "export-src", "latest", index, docker.MediaTypeOCIManifestIndex); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export?name=export-src&reference=latest", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/x-tar" {
+		t.Errorf("Expected Content-Type application/x-tar, got %s", got)
+	}
+
+	archive := rec.Body
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/repos/export-dst/import", archive))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the export to import, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for reference, expected := range map[string][]byte{"latest": index, manifestDigest: manifest} {
+		data, _, digest, err := service.GetManifestWithDigest(ctx, "export-dst", reference)
+		if err != nil || !bytes.Equal(data, expected) || digest != service.CalculateDigest(expected) {
+			t.Errorf("Expected re-imported manifest %s to round-trip, got %s (%v)", reference, data, err)
+		}
+	}
+	for _, blob := range [][]byte{layer, config} {
+		rc, _, err := service.GetBlob(ctx, "export-dst", service.CalculateDigest(blob))
+		if err != nil {
+			t.Fatalf("Expected re-imported blob: %v", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if !bytes.Equal(data, blob) {
+			t.Errorf("Expected re-imported blob %s to round-trip", service.CalculateDigest(blob))
+		}
+	}
+
+	// Unknown references are reported before streaming starts
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export?name=export-src&reference=missing", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "MANIFEST_UNKNOWN") {
+		t.Errorf("Expected MANIFEST_UNKNOWN, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleDeduplicationReport tests logical vs physical bytes for a blob shared by three repositories
+func TestHandleDeduplicationReport(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	shared := bytes.Repeat([]byte("shared base layer"), 10)
+	sharedDigest := service.CalculateDigest(shared)
+	for _, repo := range []string{"app-one", "app-two", "app-three"} {
+		if err := service.PutBlob(ctx, repo, sharedDigest, bytes.NewReader(shared), int64(len(shared))); err != nil {
+			t.Fatalf("PutBlob to %s failed: %v", repo, err)
+		}
+	}
+	own := []byte("app-one config")
+	if err := service.PutBlob(ctx, "app-one", service.CalculateDigest(own), bytes.NewReader(own), int64(len(own))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dedup", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var report DedupReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	physical := int64(len(shared) + len(own))
+	logical := int64(3*len(shared) + len(own))
+	if report.Blobs != 2 || report.References != 4 || report.PhysicalBytes != physical || report.LogicalBytes != logical {
+		t.Errorf("Expected 2 blobs, 4 references, %d physical and %d logical bytes, got %+v", physical, logical, report)
+	}
+	if want := float64(logical) / float64(physical); report.Ratio != want {
+		t.Errorf("Expected ratio %f, got %f", want, report.Ratio)
+	}
+	if len(report.MostShared) != 1 || report.MostShared[0].Digest != sharedDigest || report.MostShared[0].Repositories != 3 {
+		t.Errorf("Expected the shared layer as most shared, got %+v", report.MostShared)
+	}
+}
+
+// TestHandleCollectGarbage tests that a dry run reports exactly what a real run then deletes
+func TestHandleCollectGarbage(t *testing.T) {
+	service, mux := setupTestMux(t)
+	service.SetGCGracePeriod(0)
+	ctx := context.Background()
+
+	blobs := map[string][]byte{"config": []byte(`{"architecture":"amd64"}`), "layer": []byte("layer"), "orphan": []byte("orphaned upload")}
+	digests := map[string]string{}
+	for name, data := range blobs {
+		digests[name] = service.CalculateDigest(data)
+		if err := service.PutBlob(ctx, "test-repo", digests[name], bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatalf("PutBlob %s failed: %v", name, err)
+		}
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		docker.MediaTypeManifestV2, docker.MediaTypeImageConfig, len(blobs["config"]), digests["config"],
+		docker.MediaTypeLayer, len(blobs["layer"]), digests["layer"])
+	if err := service.PutManifest(ctx, "test-repo", "latest", []byte(manifest), docker.MediaTypeManifestV2); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	collect := func(method string) GCReport {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/admin/gc", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", method, rec.Code, rec.Body.String())
+		}
+		var report GCReport
+		if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+			t.Fatalf("Failed to decode report: %v", err)
+		}
+		return report
+	}
+	exists := func(name string) bool {
+		found, _, _ := service.CheckBlobExists(ctx, "test-repo", digests[name])
+		return found
+	}
+
+	preview := collect(http.MethodGet)
+	if !preview.DryRun || len(preview.Reclaimed) != 1 || preview.Reclaimed[0] != digests["orphan"] ||
+		preview.ReclaimedBytes != int64(len(blobs["orphan"])) {
+		t.Fatalf("Expected dry run to report only the orphan, got %+v", preview)
+	}
+	for name := range blobs {
+		if !exists(name) {
+			t.Errorf("Expected dry run to keep %s", name)
+		}
+	}
+
+	collected := collect(http.MethodPost)
+	if collected.DryRun || !reflect.DeepEqual(collected.Removed, preview.Removed) || !reflect.DeepEqual(collected.Reclaimed, preview.Reclaimed) {
+		t.Errorf("Expected real run to remove the previewed set %+v, got %+v", preview, collected)
+	}
+	if exists("orphan") || !exists("config") || !exists("layer") {
+		t.Error("Expected only the orphan blob to be collected")
+	}
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Errorf("Expected tagged manifest to survive: %v", err)
+	}
+}
+
+// TestCollectGarbageGracePeriod tests that a fresh orphan survives GC within the grace window and is collected after it
+func TestCollectGarbageGracePeriod(t *testing.T) {
+	service, _ := setupTestMux(t)
+	ctx := context.Background()
+
+	orphan := []byte("just uploaded, manifest not pushed yet")
+	digest := service.CalculateDigest(orphan)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(orphan), int64(len(orphan))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	report, err := service.CollectGarbage(ctx, false)
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if len(report.Removed) != 0 || report.Protected != 1 {
+		t.Errorf("Expected the fresh orphan to be protected by the default grace period, got %+v", report)
+	}
+	if found, _, _ := service.CheckBlobExists(ctx, "test-repo", digest); !found {
+		t.Fatal("Expected fresh orphan to survive GC")
+	}
+
+	// Age the blob and its reference past the grace window
+	meta, err := service.storage.GetMeta(ctx, digest)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	aged := time.Now().Add(-DefaultGCGracePeriod - time.Minute).Unix()
+	meta.CreatedTimestamp = aged
+	for i := range meta.References {
+		meta.References[i].ReferencedTimestamp = aged
+	}
+	if _, err := service.storage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+
+	report, err = service.CollectGarbage(ctx, false)
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if len(report.Reclaimed) != 1 || report.Reclaimed[0] != digest {
+		t.Errorf("Expected the aged orphan to be collected, got %+v", report)
+	}
+	if found, _, _ := service.CheckBlobExists(ctx, "test-repo", digest); found {
+		t.Error("Expected aged orphan to be collected")
+	}
+}
+
+// TestHandleCatalog tests that the catalog index follows new-repository pushes and matches a full scan
+func TestHandleCatalog(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	catalog := func(query string) ([]string, http.Header) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/_catalog"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return body.Repositories, rec.Header()
+	}
+
+	if names, _ := catalog(""); len(names) != 0 {
+		t.Fatalf("Expected an empty catalog, got %v", names)
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	for _, ref := range []struct{ name, tag string }{{"zeta", "latest"}, {"alpha", "v1"}, {"alpha", "v2"}, {"team/app", "latest"}} {
+		if err := service.PutManifest(ctx, ref.name, ref.tag, manifest, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest %s:%s failed: %v", ref.name, ref.tag, err)
+		}
+	}
+
+	// The index is updated on push, without a rescan
+	meta, err := service.storage.GetMeta(ctx, service.getCatalogKey())
+	if err != nil {
+		t.Fatalf("Expected catalog index to exist: %v", err)
+	}
+	indexed := map[string]int{}
+	for _, ref := range meta.References {
+		indexed[ref.Name]++
+	}
+	if len(indexed) != 3 || indexed["alpha"] != 1 || indexed["zeta"] != 1 || indexed["team/app"] != 1 {
+		t.Errorf("Expected each new repository indexed once, got %v", meta.References)
+	}
+
+	scanned, err := service.ScanRepositories(ctx)
+	if err != nil {
+		t.Fatalf("ScanRepositories failed: %v", err)
+	}
+	names, _ := catalog("")
+	if !reflect.DeepEqual(names, scanned) || !reflect.DeepEqual(names, []string{"alpha", "team/app", "zeta"}) {
+		t.Errorf("Expected catalog %v to match the scan %v", names, scanned)
+	}
+
+	page, header := catalog("?n=2")
+	if !reflect.DeepEqual(page, []string{"alpha", "team/app"}) || !strings.Contains(header.Get("Link"), "last=team%2Fapp") {
+		t.Errorf("Expected first page with a next link, got %v (Link %q)", page, header.Get("Link"))
+	}
+	page, header = catalog("?n=2&last=team%2Fapp")
+	if !reflect.DeepEqual(page, []string{"zeta"}) || header.Get("Link") != "" {
+		t.Errorf("Expected last page without a next link, got %v (Link %q)", page, header.Get("Link"))
+	}
+
+	// A missing index is rebuilt from a full scan
+	for _, ref := range meta.References {
+		if _, err := service.storage.Delete(ctx, service.getCatalogKey(), ref); err != nil {
+			t.Fatalf("Failed to delete catalog index: %v", err)
+		}
+	}
+	if _, err := service.storage.GetMeta(ctx, service.getCatalogKey()); err == nil {
+		t.Fatal("Expected catalog index to be deleted")
+	}
+	if names, _ := catalog(""); !reflect.DeepEqual(names, scanned) {
+		t.Errorf("Expected rebuilt catalog %v, got %v", scanned, names)
+	}
+}
+
+// redirectStorage serves every artifact from a presigned-style URL
+type redirectStorage struct {
+	models.ArtifactStorage
+}
+
+func (r *redirectStorage) RedirectURL(ctx context.Context, hash string) (string, bool) {
+	return "https://objects.example.com/" + hash + "?signature=test", true
+}
+
+// TestHandleGetBlobRedirect tests that blob downloads redirect to the storage URL only when enabled
+func TestHandleGetBlobRedirect(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetStorage(&redirectStorage{ArtifactStorage: testStorage})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	blob := []byte("redirected layer")
+	digest := service.CalculateDigest(blob)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	get := func(digest string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/test-repo/blobs/"+digest, nil))
+		return rec
+	}
+
+	// Disabled by default: the content is streamed
+	if rec := get(digest); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), blob) {
+		t.Fatalf("Expected the blob to be streamed, got %d", rec.Code)
+	}
+
+	service.SetBlobRedirects(true)
+	rec := get(digest)
+	if rec.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := "https://objects.example.com/" + digest + "?signature=test"; rec.Header().Get("Location") != want {
+		t.Errorf("Expected Location %s, got %s", want, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Docker-Content-Digest") != digest {
+		t.Errorf("Expected Docker-Content-Digest %s, got %s", digest, rec.Header().Get("Docker-Content-Digest"))
+	}
+
+	// Unknown blobs are never redirected
+	if rec := get(service.CalculateDigest([]byte("missing"))); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown blob, got %d", rec.Code)
+	}
+}
+
+// TestHandlePutManifestShortBody tests that a body shorter than its declared Content-Length is rejected and not stored
+func TestHandlePutManifestShortBody(t *testing.T) {
+	service, mux := setupTestMux(t)
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/latest", strings.NewReader(manifest[:40]))
+	req.Header.Set("Content-Type", docker.MediaTypeOCIManifest)
+	req.ContentLength = int64(len(manifest))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "MANIFEST_INVALID") {
+		t.Fatalf("Expected 400 MANIFEST_INVALID, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, _, err := service.GetManifest(context.Background(), "test-repo", "latest"); err == nil {
+		t.Error("Expected the truncated manifest not to be stored")
+	}
+
+	// A complete body is accepted
+	req = httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/latest", strings.NewReader(manifest))
+	req.Header.Set("Content-Type", docker.MediaTypeOCIManifest)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandlePutManifestRequireJSON tests that a non-JSON manifest body is stored by default and
+// rejected with MANIFEST_INVALID once well-formed JSON is required
+func TestHandlePutManifestRequireJSON(t *testing.T) {
+	service, mux := setupTestMux(t)
+	put := func(tag, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/"+tag, strings.NewReader(body))
+		req.Header.Set("Content-Type", docker.MediaTypeOCIManifest)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put("raw", "not json at all"); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected a non-JSON manifest to be stored by default, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	service.SetRequireJSONManifests(true)
+	rec := put("garbage", `{"schemaVersion":2,`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "MANIFEST_INVALID") {
+		t.Fatalf("Expected 400 MANIFEST_INVALID, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, _, err := service.GetManifest(context.Background(), "test-repo", "garbage"); err == nil {
+		t.Error("Expected the non-JSON manifest not to be stored")
+	}
+	if rec := put("valid", `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`); rec.Code != http.StatusCreated {
+		t.Errorf("Expected a well-formed manifest to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleDigestAlgorithms tests that sha512 is accepted by default and rejected with
+// DIGEST_INVALID at upload and manifest-push time once only sha256 is allowed
+func TestHandleDigestAlgorithms(t *testing.T) {
+	service, mux := setupTestMux(t)
+
+	blob := []byte("sha512 addressed layer")
+	sum := sha512.Sum512(blob)
+	sha512Digest := "sha512:" + hex.EncodeToString(sum[:])
+	upload := func(digest string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v2/test-repo/blobs/uploads/?digest="+digest, bytes.NewReader(blob)))
+		return rec
+	}
+
+	if rec := upload(sha512Digest); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected sha512 upload to be accepted by default, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := service.SetAllowedDigestAlgorithms([]string{"sha256"}); err != nil {
+		t.Fatalf("SetAllowedDigestAlgorithms failed: %v", err)
+	}
+	other := []byte("another sha512 layer")
+	otherSum := sha512.Sum512(other)
+	rec := upload("sha512:" + hex.EncodeToString(otherSum[:]))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "DIGEST_INVALID") {
+		t.Errorf("Expected 400 DIGEST_INVALID for a sha512 upload, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := upload(service.CalculateDigest(blob)); rec.Code != http.StatusCreated {
+		t.Errorf("Expected sha256 upload to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		docker.MediaTypeOCIManifest, docker.MediaTypeLayer, len(blob), sha512Digest)
+	req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/latest", strings.NewReader(manifest))
+	req.Header.Set("Content-Type", docker.MediaTypeOCIManifest)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "DIGEST_INVALID") {
+		t.Errorf("Expected 400 DIGEST_INVALID for a manifest referencing sha512, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := service.SetAllowedDigestAlgorithms([]string{"md5"}); err == nil {
+		t.Error("Expected an unsupported algorithm to be refused")
+	}
+}
+
+// TestHandleNameLimits tests that names and paths at the limits are accepted and longer ones rejected
+func TestHandleNameLimits(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetNameLimits(docker.NameLimits{MaxName: 20, MaxPath: 120})
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	atLimit := strings.Repeat("a", 20)
+	manifestData := largeManifest(1)
+	if err := service.PutManifest(ctx, atLimit, "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	digest := "sha256:" + strings.Repeat("0", 64)
+
+	// Manifest paths are len("/v2/") + 20 + len("/manifests/") = 35 bytes before the reference
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"name at limit", http.MethodGet, "/v2/" + atLimit + "/manifests/latest", http.StatusOK},
+		{"name beyond limit", http.MethodGet, "/v2/" + atLimit + "b/manifests/latest", http.StatusBadRequest},
+		{"upload name beyond limit", http.MethodPost, "/v2/" + atLimit + "b/blobs/uploads/", http.StatusBadRequest},
+		{"blob name beyond limit", http.MethodGet, "/v2/" + atLimit + "b/blobs/" + digest, http.StatusBadRequest},
+		{"path at limit", http.MethodHead, "/v2/" + atLimit + "/manifests/" + strings.Repeat("t", 85), http.StatusNotFound},
+		{"path beyond limit", http.MethodGet, "/v2/" + atLimit + "/manifests/" + strings.Repeat("t", 86), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "NAME_INVALID") {
+				t.Errorf("Expected NAME_INVALID, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleListingEdgeCases tests empty listings, n=0 and n beyond the number of entries
+func TestHandleListingEdgeCases(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	get := func(path string) (int, string, http.Header) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String()), rec.Header()
+	}
+
+	// Empty registry
+	for _, path := range []string{"/v2/_catalog", "/v2/_catalog?n=0", "/v2/_catalog?n=10"} {
+		if code, body, _ := get(path); code != http.StatusOK || body != `{"repositories":[]}` {
+			t.Errorf("%s: expected an empty array, got %d %s", path, code, body)
+		}
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	for _, name := range []string{"app", "base", "tools"} {
+		if err := service.PutManifest(ctx, name, "latest", manifest, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest %s failed: %v", name, err)
+		}
+	}
+	tests := []struct {
+		path string
+		body string
+		link bool
+	}{
+		{"/v2/_catalog", `{"repositories":["app","base","tools"]}`, false},
+		{"/v2/_catalog?n=0", `{"repositories":[]}`, false},
+		{"/v2/_catalog?n=2", `{"repositories":["app","base"]}`, true},
+		{"/v2/_catalog?n=2&last=base", `{"repositories":["tools"]}`, false},
+		{"/v2/_catalog?n=100", `{"repositories":["app","base","tools"]}`, false},
+	}
+	for _, tt := range tests {
+		code, body, header := get(tt.path)
+		if code != http.StatusOK || body != tt.body {
+			t.Errorf("%s: expected %s, got %d %s", tt.path, tt.body, code, body)
+		}
+		if link := header.Get("Link"); (link != "") != tt.link {
+			t.Errorf("%s: unexpected Link header %q", tt.path, link)
+		}
+	}
+	if _, _, header := get("/v2/_catalog?n=2"); !strings.Contains(header.Get("Link"), "/v2/_catalog?n=2&last=base") {
+		t.Errorf("Unexpected next link %q", header.Get("Link"))
+	}
+}
+
+// TestHandleListTags tests the tag listing of a repository: 404 for an unknown repository, an empty
+// array for one without tags, and n/last pagination with a Link header to the next page
+func TestHandleListTags(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+
+	get := func(path string) (int, string, http.Header) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String()), rec.Header()
+	}
+
+	if code, _, _ := get("/v2/missing/tags/list"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for tags of an unknown repository, got %d", code)
+	}
+
+	// A repository pushed only by digest has no tags
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	if err := service.PutManifest(ctx, "app", service.CalculateDigest(manifest), manifest, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	if code, body, _ := get("/v2/app/tags/list"); code != http.StatusOK || body != `{"name":"app","tags":[]}` {
+		t.Errorf("Expected an empty tag array, got %d %s", code, body)
+	}
+
+	for _, tag := range []string{"v2", "v1", "latest"} {
+		if err := service.PutManifest(ctx, "app", tag, manifest, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest %s failed: %v", tag, err)
+		}
+	}
+	tests := []struct {
+		path string
+		body string
+		link bool
+	}{
+		{"/v2/app/tags/list", `{"name":"app","tags":["latest","v1","v2"]}`, false},
+		{"/v2/app/tags/list?n=0", `{"name":"app","tags":[]}`, false},
+		{"/v2/app/tags/list?n=2", `{"name":"app","tags":["latest","v1"]}`, true},
+		{"/v2/app/tags/list?n=2&last=v1", `{"name":"app","tags":["v2"]}`, false},
+		{"/v2/app/tags/list?n=100", `{"name":"app","tags":["latest","v1","v2"]}`, false},
+	}
+	for _, tt := range tests {
+		code, body, header := get(tt.path)
+		if code != http.StatusOK || body != tt.body {
+			t.Errorf("%s: expected %s, got %d %s", tt.path, tt.body, code, body)
+		}
+		if link := header.Get("Link"); (link != "") != tt.link {
+			t.Errorf("%s: unexpected Link header %q", tt.path, link)
+		}
+	}
+	if _, _, header := get("/v2/app/tags/list?n=2"); !strings.Contains(header.Get("Link"), "/v2/app/tags/list?n=2&last=v1") {
+		t.Errorf("Unexpected next link %q", header.Get("Link"))
+	}
+}
+
+// TestHandlePutManifestConditional tests If-Match and If-None-Match: * on manifest pushes
+func TestHandlePutManifestConditional(t *testing.T) {
+	service, mux := setupTestMux(t)
+	v1 := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"v":"1"}}`
+	v2 := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"v":"2"}}`
+	v3 := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"v":"3"}}`
+
+	put := func(manifest string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/latest", strings.NewReader(manifest))
+		req.Header.Set("Content-Type", docker.MediaTypeOCIManifest)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	current := func() string {
+		_, _, digest, err := service.GetManifestWithDigest(context.Background(), "test-repo", "latest")
+		if err != nil {
+			t.Fatalf("GetManifestWithDigest failed: %v", err)
+		}
+		return digest
+	}
+
+	// If-None-Match: * creates the tag only once
+	if rec := put(v1, map[string]string{"If-None-Match": "*"}); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected creation to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := put(v2, map[string]string{"If-None-Match": "*"}); rec.Code != http.StatusPreconditionFailed || !strings.Contains(rec.Body.String(), "PRECONDITION_FAILED") {
+		t.Fatalf("Expected 412 for an existing tag, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if digest := current(); digest != service.CalculateDigest([]byte(v1)) {
+		t.Fatalf("Expected the tag to stay at v1, got %s", digest)
+	}
+
+	// If-Match moves the tag only from the expected digest (quoted entity tags accepted)
+	if rec := put(v2, map[string]string{"If-Match": `"` + service.CalculateDigest([]byte(v1)) + `"`}); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the conditional move to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if digest := current(); digest != service.CalculateDigest([]byte(v2)) {
+		t.Fatalf("Expected the tag to move to v2, got %s", digest)
+	}
+	if rec := put(v3, map[string]string{"If-Match": service.CalculateDigest([]byte(v1))}); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 for a stale If-Match, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if digest := current(); digest != service.CalculateDigest([]byte(v2)) {
+		t.Errorf("Expected the tag to stay at v2, got %s", digest)
+	}
+
+	// If-Match on a missing tag fails
+	req := httptest.NewRequest(http.MethodPut, "/v2/test-repo/manifests/missing", strings.NewReader(v3))
+	req.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for If-Match on a missing tag, got %d", rec.Code)
+	}
+}
+
+// TestHandleBlobETags tests that blob responses carry the digest as ETag and that a matching
+// If-None-Match gets 304 without a body, only when blob ETags are enabled
+func TestHandleBlobETags(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+	blob := []byte("etag blob")
+	digest := service.CalculateDigest(blob)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	request := func(method, path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	path := "/v2/test-repo/blobs/" + digest
+
+	// Disabled by default
+	if rec := request(http.MethodGet, path, `"`+digest+`"`); rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" {
+		t.Fatalf("Expected 200 without ETag by default, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	service.SetBlobETags(true)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := request(method, path, "")
+		etag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || etag != `"`+digest+`"` {
+			t.Fatalf("%s: expected 200 with the digest as ETag, got %d %q", method, rec.Code, etag)
+		}
+		for _, ifNoneMatch := range []string{etag, `"sha256:other", ` + etag, "W/" + etag, "*"} {
+			rec = request(method, path, ifNoneMatch)
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+				t.Errorf("%s If-None-Match %s: expected an empty 304 with ETag, got %d (%d bytes)", method, ifNoneMatch, rec.Code, rec.Body.Len())
+			}
+		}
+		if rec = request(method, path, `"sha256:other"`); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 for a different ETag, got %d", method, rec.Code)
+		}
+	}
+
+	// A missing blob isn't reported as not modified
+	if rec := request(http.MethodGet, "/v2/test-repo/blobs/sha256:"+strings.Repeat("0", 64), "*"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing blob, got %d", rec.Code)
+	}
+}
+
+// metaFailingStorage fails every metadata read, so only stat-based paths can succeed
+type metaFailingStorage struct {
+	*storage.SimpleFileStorage
+}
+
+func (m *metaFailingStorage) GetMeta(ctx context.Context, hash string) (*models.ArtifactMeta, error) {
+	return nil, errors.New("metadata read")
+}
+
+// TestHandleHeadBlobStatOnly tests that HEAD on a blob reports its size from a stat, without reading metadata
+func TestHandleHeadBlobStatOnly(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	ctx := context.Background()
+
+	blob := bytes.Repeat([]byte("stat only"), 50)
+	digest := service.CalculateDigest(blob)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blob), int64(len(blob))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	service.SetStorage(&metaFailingStorage{SimpleFileStorage: testStorage.(*storage.SimpleFileStorage)})
+
+	head := func(digest string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/v2/test-repo/blobs/"+digest, nil))
+		return rec
+	}
+	rec := head(digest)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := strconv.Itoa(len(blob)); rec.Header().Get("Content-Length") != want {
+		t.Errorf("Expected Content-Length %s, got %s", want, rec.Header().Get("Content-Length"))
+	}
+	if rec := head(service.CalculateDigest([]byte("missing"))); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown blob, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,218 @@
+package private
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+)
+
+// importBufferLimit is the largest layout blob kept in memory during an import until it is known
+// whether it is a manifest; larger blobs are stored as they are read, so manifests can't exceed it
+const importBufferLimit = 4 << 20
+
+// ociRefNameAnnotation names the tag of an image layout index entry
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// ImportReport summarizes an ImportOCILayout run
+type ImportReport struct {
+	Repository string   `json:"repository"`
+	Blobs      int      `json:"blobs"`
+	Manifests  []string `json:"manifests"`
+	Tags       []string `json:"tags"`
+}
+
+// ImportOCILayout imports an OCI image layout tar stream (optionally gzip-compressed), as written by
+// "docker save" since Docker 25 or "skopeo copy ... oci-archive:", into repository name. Every blob
+// is stored under its descriptor digest and verified against it; the manifests reachable from
+// index.json are pushed children first, and index entries annotated with
+// org.opencontainers.image.ref.name are tagged with that name. Blobs stored before a failure stay
+// (unreferenced blobs are left to garbage collection).
+func (s *DockerRegistryPrivateService) ImportOCILayout(ctx context.Context, name string, r io.Reader) (*ImportReport, error) {
+	layout, err := readLayoutTar(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImportReport{Repository: name, Manifests: []string{}, Tags: []string{}}
+
+	var index *docker.Manifest
+	buffered := make(map[string][]byte)
+	for {
+		header, err := layout.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, docker.ErrBlobUploadInvalid(fmt.Sprintf("invalid layout archive: %v", err))
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		switch {
+		case entry == "index.json":
+			data, err := io.ReadAll(io.LimitReader(layout, importBufferLimit))
+			if err != nil {
+				return report, fmt.Errorf("failed to read index.json: %w", err)
+			}
+			if index, err = docker.ParseManifest(data); err != nil {
+				return report, docker.ErrManifestInvalid(fmt.Sprintf("invalid index.json: %v", err))
+			}
+		case strings.HasPrefix(entry, "blobs/"):
+			digest, err := layoutBlobDigest(entry)
+			if err != nil {
+				return report, err
+			}
+			if header.Size > importBufferLimit {
+				if err := s.PutBlob(ctx, name, digest, layout, header.Size); err != nil {
+					return report, fmt.Errorf("failed to import blob %s: %w", digest, err)
+				}
+				report.Blobs++
+				continue
+			}
+			data, err := io.ReadAll(layout)
+			if err != nil {
+				return report, fmt.Errorf("failed to read blob %s: %w", digest, err)
+			}
+			if err := verifyLayoutBlob(digest, data); err != nil {
+				return report, err
+			}
+			buffered[digest] = data
+		}
+	}
+	if index == nil {
+		return report, docker.ErrManifestInvalid("layout archive has no index.json")
+	}
+
+	// Push the manifests reachable from the index, children before their parents
+	maxDepth := s.maxManifestDepth
+	if maxDepth <= 0 {
+		maxDepth = docker.DefaultMaxManifestDepth
+	}
+	manifests := make(map[string]bool)
+	var pushManifest func(descriptor docker.Descriptor, depth int) error
+	pushManifest = func(descriptor docker.Descriptor, depth int) error {
+		if manifests[descriptor.Digest] {
+			return nil
+		}
+		if depth > maxDepth {
+			return docker.ErrManifestInvalid(fmt.Sprintf("manifest %s: %v", descriptor.Digest, docker.ErrManifestTooDeep))
+		}
+		data, ok := buffered[descriptor.Digest]
+		if !ok {
+			return docker.ErrManifestInvalid(fmt.Sprintf("layout archive lacks manifest %s", descriptor.Digest))
+		}
+		manifest, err := docker.ParseManifest(data)
+		if err != nil {
+			return docker.ErrManifestInvalid(fmt.Sprintf("invalid manifest %s: %v", descriptor.Digest, err))
+		}
+		for _, child := range manifest.Manifests {
+			if err := pushManifest(child, depth+1); err != nil {
+				return err
+			}
+		}
+		mediaType := descriptor.MediaType
+		if mediaType == "" {
+			mediaType = manifest.MediaType
+		}
+		if err := s.PutManifest(ctx, name, descriptor.Digest, data, mediaType); err != nil {
+			return fmt.Errorf("failed to import manifest %s: %w", descriptor.Digest, err)
+		}
+		manifests[descriptor.Digest] = true
+		report.Manifests = append(report.Manifests, descriptor.Digest)
+		return nil
+	}
+
+	// Blobs that aren't manifests go first, so manifests never reference missing content
+	reachable := make(map[string]bool)
+	for _, descriptor := range index.Manifests {
+		markLayoutManifests(descriptor.Digest, buffered, reachable, maxDepth, 0)
+	}
+	for digest, data := range buffered {
+		if reachable[digest] {
+			continue
+		}
+		if err := s.PutBlob(ctx, name, digest, bytes.NewReader(data), int64(len(data))); err != nil {
+			return report, fmt.Errorf("failed to import blob %s: %w", digest, err)
+		}
+		report.Blobs++
+	}
+
+	for _, descriptor := range index.Manifests {
+		if err := pushManifest(descriptor, 0); err != nil {
+			return report, err
+		}
+		if tag := descriptor.Annotations[ociRefNameAnnotation]; tag != "" {
+			if err := s.PutManifest(ctx, name, tag, buffered[descriptor.Digest], descriptor.MediaType); err != nil {
+				return report, fmt.Errorf("failed to tag %s: %w", tag, err)
+			}
+			report.Tags = append(report.Tags, tag)
+		}
+	}
+	return report, nil
+}
+
+// readLayoutTar returns a tar reader of r, decompressing it first if it is gzip-compressed
+func readLayoutTar(r io.Reader) (*tar.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, docker.ErrBlobUploadInvalid(fmt.Sprintf("invalid gzip layout archive: %v", err))
+		}
+		return tar.NewReader(gz), nil
+	}
+	return tar.NewReader(buffered), nil
+}
+
+// layoutBlobDigest returns the digest of a blobs/<algorithm>/<encoded> layout entry
+func layoutBlobDigest(entry string) (string, error) {
+	parts := strings.Split(entry, "/")
+	if len(parts) != 3 {
+		return "", docker.ErrDigestInvalid(fmt.Sprintf("unexpected layout entry %s", entry))
+	}
+	digest := parts[1] + ":" + parts[2]
+	if err := docker.ValidateDigest(digest); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// verifyLayoutBlob returns DIGEST_INVALID unless data hashes to digest
+func verifyLayoutBlob(digest string, data []byte) error {
+	algorithm := docker.DigestAlgorithm(digest)
+	hasher := docker.NewDigestHasher(algorithm)
+	if hasher == nil {
+		return docker.ErrDigestInvalid(fmt.Sprintf("unsupported digest algorithm: %s", digest))
+	}
+	defer docker.ReleaseDigestHasher(algorithm, hasher)
+	hasher.Write(data)
+	if actual := algorithm + ":" + hex.EncodeToString(hasher.Sum(nil)); actual != digest {
+		return docker.ErrDigestInvalid(fmt.Sprintf("layout blob %s has digest %s", digest, actual))
+	}
+	return nil
+}
+
+// markLayoutManifests marks digest and the child manifests it references, down to maxDepth
+func markLayoutManifests(digest string, buffered map[string][]byte, marked map[string]bool, maxDepth, depth int) {
+	data, ok := buffered[digest]
+	if !ok || marked[digest] || depth > maxDepth {
+		return
+	}
+	marked[digest] = true
+	if manifest, err := docker.ParseManifest(data); err == nil {
+		for _, child := range manifest.Manifests {
+			markLayoutManifests(child.Digest, buffered, marked, maxDepth, depth+1)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package private
 import (
 	"fmt"
 	"net"
+	"net/http"
 
 	"github.com/basakil/brm-server/internal/storage"
 	"github.com/basakil/brm-server/pkg/models"
@@ -76,3 +77,10 @@ func (d *DockerRegistryPrivate) GetServiceBinding() net.Addr {
 func (d *DockerRegistryPrivate) GetDescription() string {
 	return d.description
 }
+
+// Handlers returns a handler serving the registry's HTTP routes
+func (d *DockerRegistryPrivate) Handlers() http.Handler {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, d.service)
+	return mux
+}
@@ -2,27 +2,34 @@ package private
 
 import (
 	"net"
+	"os"
+	"sync"
 	"testing"
 
 	"github.com/basakil/brm-server/internal/storage"
 	"github.com/basakil/brm-server/pkg/models"
 )
 
-// setupTestRegistry creates a test registry instance
-func setupTestRegistry(t *testing.T) *DockerRegistryPrivate {
-	// Create test storage
-	baseDir := t.TempDir()
-	_, err := storage.NewSimpleFileStorage("test-storage", baseDir)
-	if err != nil {
-		t.Fatalf("Failed to create test storage: %v", err)
-	}
+// testStorageDir is the base directory of the "test-storage" alias, shared by the tests of this
+// package because the storage manager is a process-wide singleton
+var testStorageDir = sync.OnceValues(func() (string, error) {
+	return os.MkdirTemp("", "brm-private-test-storage-")
+})
 
-	// Register storage in manager
-	storageManager := storage.GetManager()
-	_, err = storageManager.Create("std.filestorage", "test-storage", baseDir)
+// registerTestStorage registers the "test-storage" alias test registries resolve their storage by
+func registerTestStorage(t *testing.T) {
+	baseDir, err := testStorageDir()
 	if err != nil {
-		// May already exist, that's okay
+		t.Fatalf("Failed to create test storage directory: %v", err)
 	}
+	if _, err := storage.GetManager().GetOrCreate("std.filestorage", "test-storage", baseDir); err != nil {
+		t.Fatalf("Failed to register test storage: %v", err)
+	}
+}
+
+// setupTestRegistry creates a test registry instance
+func setupTestRegistry(t *testing.T) *DockerRegistryPrivate {
+	registerTestStorage(t)
 
 	// Create service binding
 	serviceBinding := &models.ServiceBinding{
@@ -46,17 +53,7 @@ func setupTestRegistry(t *testing.T) *DockerRegistryPrivate {
 
 // TestNewDockerRegistryPrivate tests registry creation
 func TestNewDockerRegistryPrivate(t *testing.T) {
-	baseDir := t.TempDir()
-	_, err := storage.NewSimpleFileStorage("test-storage", baseDir)
-	if err != nil {
-		t.Fatalf("Failed to create test storage: %v", err)
-	}
-
-	storageManager := storage.GetManager()
-	_, err = storageManager.Create("std.filestorage", "test-storage", baseDir)
-	if err != nil {
-		// May already exist
-	}
+	registerTestStorage(t)
 
 	serviceBinding := &models.ServiceBinding{
 		IP:   "0.0.0.0",
@@ -5,13 +5,22 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/storage"
 	"github.com/basakil/brm-server/pkg/models"
+
+	"github.com/google/uuid"
 )
 
 // DockerRegistryPrivateService handles core registry logic for private registries
@@ -22,8 +31,90 @@ type DockerRegistryPrivateService struct {
 	// Blob upload session management
 	uploadSessions map[string]*UploadSession
 	sessionsMutex  sync.RWMutex
+
+	// HTTP response options
+	compression docker.CompressionConfig
+
+	// Limits on request path and repository name lengths (zero fields use the defaults)
+	nameLimits docker.NameLimits
+
+	// Optional in-memory manifest cache (nil when disabled)
+	manifestCache *docker.ManifestCache
+
+	// Key prefix for the reference-mapping keyspace
+	refKeyPrefix string
+
+	// How blob and manifest content is keyed in storage
+	keyStrategy docker.KeyStrategy
+
+	// Caps concurrent blob digest computations (nil = unlimited)
+	hashLimiter *storage.HashLimiter
+
+	// Nesting limit for index traversal (0 = docker.DefaultMaxManifestDepth)
+	maxManifestDepth int
+
+	// Record verified content digests in blob metadata (see SetRecordContentDigests)
+	recordContentDigests bool
+
+	// Redirect blob downloads to storage URLs when the storage supports it
+	blobRedirects bool
+
+	// Set blob ETags and honor If-None-Match on blob requests
+	blobETags bool
+
+	// Reject manifest pushes whose body isn't well-formed JSON
+	requireJSONManifests bool
+
+	// Reject pushes of a stored blob whose stored content doesn't match the digest
+	rejectConflictingBlobs bool
+
+	// Bytes an upload session may stage (0 = unlimited)
+	maxUploadBuffer int64
+
+	// Sizes of stored uploads and oversize rejections (see UploadMetrics)
+	uploadMetrics *uploadMetrics
+
+	// Send Docker-Upload-UUID on upload completion responses too
+	uploadUUIDOnCompletion bool
+
+	// Answer blob requests for malformed digests with BLOB_UNKNOWN instead of DIGEST_INVALID
+	malformedDigestsNotFound bool
+
+	// Expiry of pushed content, longest prefix first (nil = never expires, see SetArtifactTTLs)
+	artifactTTLs        []ArtifactTTL
+	sweeperMu           sync.Mutex
+	expirySweepInterval time.Duration
+	stopSweeper         chan struct{}
+
+	// Stages chunked uploads instead of the content storage (nil = content storage, see SetUploadStaging)
+	staging      models.ArtifactStorage
+	stagingAlias string
+
+	// Accepted digest algorithms (nil = DefaultDigestAlgorithms)
+	digestAlgorithms map[string]bool
+
+	// Receives push and delete notifications (never nil)
+	events events.EventSink
+
+	// Minimum reference age before garbage collection may remove it
+	gcGracePeriod time.Duration
+
+	// References DeleteManifest may collect eagerly per call (0 = eager collection disabled)
+	eagerGCLimit int
+
+	// Coordinates garbage collection with concurrent pushes
+	gc gcGuard
+
+	// Serializes updates of the persisted repository index
+	catalogMu sync.Mutex
+
+	// Serializes reference mapping updates with conditional pushes (see PutManifestIf)
+	refMu sync.Mutex
 }
 
+// DefaultRefKeyPrefix is the default prefix of reference-mapping (tag -> digest) keys
+const DefaultRefKeyPrefix = "manifest-ref:"
+
 // UploadSession tracks an active blob upload
 type UploadSession struct {
 	UUID      string
@@ -31,7 +122,11 @@ type UploadSession struct {
 	Size      int64
 	Offset    int64
 	CreatedAt time.Time
-	Data      *bytes.Buffer // Accumulated blob data (for chunked uploads)
+	TempHash  string // Key of the staged artifact accumulating the blob data (Offset bytes so far)
+
+	// Digest of the interrupted single-request upload the session holds, until ResumeBlobUpload
+	// hands it out ("" = not resumable)
+	ResumeDigest string
 }
 
 // NewDockerRegistryPrivateService creates a new private Docker registry service
@@ -42,6 +137,11 @@ func NewDockerRegistryPrivateService(
 	service := &DockerRegistryPrivateService{
 		description:    description,
 		uploadSessions: make(map[string]*UploadSession),
+		refKeyPrefix:   DefaultRefKeyPrefix,
+		keyStrategy:    docker.KeyByDigest,
+		events:         events.NopSink{},
+		gcGracePeriod:  DefaultGCGracePeriod,
+		uploadMetrics:  newUploadMetrics(DefaultUploadSizeBuckets),
 	}
 
 	// Start cleanup goroutine for expired sessions
@@ -55,38 +155,257 @@ func (s *DockerRegistryPrivateService) SetStorage(storage models.ArtifactStorage
 	s.storage = storage
 }
 
+// SetCompressionConfig sets the gzip compression options for JSON responses
+func (s *DockerRegistryPrivateService) SetCompressionConfig(cfg docker.CompressionConfig) {
+	s.compression = cfg
+}
+
+// CompressionConfig returns the gzip compression options for JSON responses
+func (s *DockerRegistryPrivateService) CompressionConfig() docker.CompressionConfig {
+	return s.compression
+}
+
+// SetNameLimits bounds request path and repository name lengths; longer requests are rejected with
+// NAME_INVALID. Zero fields use the defaults. Must be set before the routes are set up.
+func (s *DockerRegistryPrivateService) SetNameLimits(limits docker.NameLimits) {
+	s.nameLimits = limits
+}
+
+// NameLimits returns the request path and repository name length limits
+func (s *DockerRegistryPrivateService) NameLimits() docker.NameLimits {
+	return s.nameLimits
+}
+
+// SetManifestCache sets the in-memory manifest cache (nil disables caching)
+func (s *DockerRegistryPrivateService) SetManifestCache(cache *docker.ManifestCache) {
+	s.manifestCache = cache
+}
+
+// ManifestCache returns the in-memory manifest cache (nil if caching is disabled)
+func (s *DockerRegistryPrivateService) ManifestCache() *docker.ManifestCache {
+	return s.manifestCache
+}
+
+// SetRefKeyPrefix sets the prefix of reference-mapping keys (empty restores the default).
+// The prefix must not look like a digest algorithm or contain path separators,
+// so tag mappings and content blobs can never share a key.
+func (s *DockerRegistryPrivateService) SetRefKeyPrefix(prefix string) error {
+	if prefix == "" {
+		prefix = DefaultRefKeyPrefix
+	}
+	if strings.ContainsAny(prefix, "/\\") {
+		return fmt.Errorf("invalid reference key prefix %q: must not contain path separators", prefix)
+	}
+	if strings.HasPrefix(prefix, "sha256:") || strings.HasPrefix(prefix, "sha512:") {
+		return fmt.Errorf("invalid reference key prefix %q: must not resemble a digest", prefix)
+	}
+	s.refKeyPrefix = prefix
+	return nil
+}
+
+// RefKeyPrefix returns the prefix of reference-mapping keys
+func (s *DockerRegistryPrivateService) RefKeyPrefix() string {
+	return s.refKeyPrefix
+}
+
+// SetKeyStrategy sets how content is keyed in storage ("" restores docker.KeyByDigest).
+// Content stored under one strategy is not found under the other, so it must not change
+// once the storage holds content.
+func (s *DockerRegistryPrivateService) SetKeyStrategy(strategy docker.KeyStrategy) {
+	if strategy == "" {
+		strategy = docker.KeyByDigest
+	}
+	s.keyStrategy = strategy
+}
+
+// KeyStrategy returns how content is keyed in storage
+func (s *DockerRegistryPrivateService) KeyStrategy() docker.KeyStrategy {
+	return s.keyStrategy
+}
+
+// SetHashLimiter caps the number of concurrent blob digest computations, both of uploads as they
+// stream in and of re-hashed stored or staged blobs, as in integrity checks (nil = unlimited)
+func (s *DockerRegistryPrivateService) SetHashLimiter(limiter *storage.HashLimiter) {
+	s.hashLimiter = limiter
+}
+
+// HashLimiter returns the limiter capping concurrent blob digest computations
+func (s *DockerRegistryPrivateService) HashLimiter() *storage.HashLimiter {
+	return s.hashLimiter
+}
+
+// SetMaxManifestDepth sets the nesting limit for index traversal (<= 0 restores the default)
+func (s *DockerRegistryPrivateService) SetMaxManifestDepth(depth int) {
+	s.maxManifestDepth = depth
+}
+
+// MaxManifestDepth returns the configured nesting limit for index traversal (0 = the default)
+func (s *DockerRegistryPrivateService) MaxManifestDepth() int {
+	if s.maxManifestDepth < 0 {
+		return 0
+	}
+	return s.maxManifestDepth
+}
+
+// SetRecordContentDigests makes PutBlob record the verified content digest in blob metadata.
+// Pushes of a blob already stored without a recorded digest re-hash the stored content first,
+// failing with *models.IntegrityError if it doesn't match.
+func (s *DockerRegistryPrivateService) SetRecordContentDigests(record bool) {
+	s.recordContentDigests = record
+}
+
+// RecordContentDigests reports whether PutBlob records verified content digests in blob metadata
+func (s *DockerRegistryPrivateService) RecordContentDigests() bool {
+	return s.recordContentDigests
+}
+
+// SetBlobETags makes blob GET and HEAD responses carry the digest as ETag (see docker.BlobETag)
+// and answer a matching If-None-Match with 304, so clients skip re-transferring cached blobs
+func (s *DockerRegistryPrivateService) SetBlobETags(enabled bool) {
+	s.blobETags = enabled
+}
+
+// BlobETags reports whether blob responses carry ETags
+func (s *DockerRegistryPrivateService) BlobETags() bool {
+	return s.blobETags
+}
+
+// SetRequireJSONManifests rejects manifest pushes whose body isn't well-formed JSON with
+// MANIFEST_INVALID. Off by default, so arbitrary artifacts can still be stored as manifests.
+func (s *DockerRegistryPrivateService) SetRequireJSONManifests(enabled bool) {
+	s.requireJSONManifests = enabled
+}
+
+// RequireJSONManifests reports whether manifest pushes must be well-formed JSON
+func (s *DockerRegistryPrivateService) RequireJSONManifests() bool {
+	return s.requireJSONManifests
+}
+
+// SetRejectConflictingBlobs makes pushes of a blob that is already stored verify the stored
+// content matches the pushed digest (recorded content digest, or a re-hash of the stored blob) and
+// fail with DIGEST_INVALID if it doesn't, rather than adding a reference to corrupt content.
+func (s *DockerRegistryPrivateService) SetRejectConflictingBlobs(enabled bool) {
+	s.rejectConflictingBlobs = enabled
+}
+
+// RejectConflictingBlobs reports whether pushes verify already stored blob content
+func (s *DockerRegistryPrivateService) RejectConflictingBlobs() bool {
+	return s.rejectConflictingBlobs
+}
+
+// SetMaxUploadBuffer bounds the bytes a chunked upload session stages (0 = unlimited).
+// A chunk that would exceed it fails with docker.ErrBlobUploadTooLarge and aborts the session.
+func (s *DockerRegistryPrivateService) SetMaxUploadBuffer(limit int64) {
+	s.maxUploadBuffer = limit
+}
+
+// MaxUploadBuffer returns the per-session upload buffer limit (0 = unlimited)
+func (s *DockerRegistryPrivateService) MaxUploadBuffer() int64 {
+	return s.maxUploadBuffer
+}
+
+// SetMalformedDigestsNotFound answers blob requests for malformed digests with BLOB_UNKNOWN (404),
+// as before digests were validated, instead of DIGEST_INVALID (400), for clients relying on it
+func (s *DockerRegistryPrivateService) SetMalformedDigestsNotFound(enabled bool) {
+	s.malformedDigestsNotFound = enabled
+}
+
+// MalformedDigestsNotFound reports whether malformed blob digests are answered with BLOB_UNKNOWN
+func (s *DockerRegistryPrivateService) MalformedDigestsNotFound() bool {
+	return s.malformedDigestsNotFound
+}
+
+// SetUploadUUIDOnCompletion also sets Docker-Upload-UUID on the 201 response completing a chunked
+// upload, for clients that track sessions by it (every other upload response carries it)
+func (s *DockerRegistryPrivateService) SetUploadUUIDOnCompletion(enabled bool) {
+	s.uploadUUIDOnCompletion = enabled
+}
+
+// UploadUUIDOnCompletion reports whether upload completion responses carry Docker-Upload-UUID
+func (s *DockerRegistryPrivateService) UploadUUIDOnCompletion() bool {
+	return s.uploadUUIDOnCompletion
+}
+
+// SetBlobRedirects makes blob downloads redirect to a URL served by the storage (see
+// storage.RedirectStorage) instead of streaming through the registry. Off by default, as some
+// clients don't follow redirects.
+func (s *DockerRegistryPrivateService) SetBlobRedirects(enabled bool) {
+	s.blobRedirects = enabled
+}
+
+// BlobRedirects reports whether blob downloads redirect to storage URLs
+func (s *DockerRegistryPrivateService) BlobRedirects() bool {
+	return s.blobRedirects
+}
+
+// SetEventSink sets the sink notified of pushes and deletes (nil restores the no-op default)
+func (s *DockerRegistryPrivateService) SetEventSink(sink events.EventSink) {
+	if sink == nil {
+		sink = events.NopSink{}
+	}
+	s.events = sink
+}
+
+// EventSink returns the sink notified of pushes and deletes
+func (s *DockerRegistryPrivateService) EventSink() events.EventSink {
+	return s.events
+}
+
+// getManifestCacheKey generates the in-memory cache key for a manifest reference
+func (s *DockerRegistryPrivateService) getManifestCacheKey(name, reference string) string {
+	return name + ":" + reference
+}
+
 // cleanupExpiredSessions periodically removes expired upload sessions
 func (s *DockerRegistryPrivateService) cleanupExpiredSessions() {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		s.sessionsMutex.Lock()
-		now := time.Now()
-		for uuid, session := range s.uploadSessions {
-			if now.Sub(session.CreatedAt) > 1*time.Hour {
-				delete(s.uploadSessions, uuid)
-			}
+		s.removeExpiredSessions(time.Now().Add(-1 * time.Hour))
+	}
+}
+
+// removeExpiredSessions removes the upload sessions created before cutoff and their staged data
+func (s *DockerRegistryPrivateService) removeExpiredSessions(cutoff time.Time) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	for uuid, session := range s.uploadSessions {
+		if session.CreatedAt.Before(cutoff) {
+			delete(s.uploadSessions, uuid)
+			s.discardStaging(session)
 		}
-		s.sessionsMutex.Unlock()
 	}
 }
 
-// getStorageKey generates a storage key for a manifest or blob (using digest for content-addressable storage)
-func (s *DockerRegistryPrivateService) getStorageKey(digest string) string {
-	return digest
+// getStorageKey generates the storage key of a manifest or blob in repository name (see SetKeyStrategy)
+func (s *DockerRegistryPrivateService) getStorageKey(name, digest string) string {
+	return s.keyStrategy.Key(name, digest)
 }
 
-// getManifestRefKey generates a key for manifest reference mapping
+// getManifestRefKey generates a key for manifest reference mapping.
+// Name and reference are escaped so the key is flat (no path separators) and unambiguous.
 func (s *DockerRegistryPrivateService) getManifestRefKey(name, reference string) string {
-	return fmt.Sprintf("manifest-ref:%s:%s", name, reference)
+	return s.refKeyPrefix + url.QueryEscape(name) + ":" + url.QueryEscape(reference)
+}
+
+// isRefKey checks if a key belongs to the reference-mapping keyspace
+func (s *DockerRegistryPrivateService) isRefKey(key string) bool {
+	return strings.HasPrefix(key, s.refKeyPrefix)
+}
+
+// validateContentKey rejects digests that would address the reference-mapping keyspace
+func (s *DockerRegistryPrivateService) validateContentKey(digest string) error {
+	if digest == "" || s.isRefKey(digest) || strings.ContainsAny(digest, "/\\") {
+		return fmt.Errorf("invalid digest: %s", digest)
+	}
+	return nil
 }
 
 // calculateDigest calculates SHA256 digest
 func (s *DockerRegistryPrivateService) calculateDigest(data []byte) string {
-	hasher := sha256.New()
-	hasher.Write(data)
-	return "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
 
 // CalculateDigest calculates SHA256 digest (exported for use in handlers)
@@ -96,7 +415,8 @@ func (s *DockerRegistryPrivateService) CalculateDigest(data []byte) string {
 
 // validateDigest validates that the content matches the expected digest
 func (s *DockerRegistryPrivateService) validateDigest(reader io.Reader, expectedDigest string, size int64) error {
-	hasher := sha256.New()
+	hasher := storage.AcquireSHA256()
+	defer storage.ReleaseSHA256(hasher)
 	written, err := io.Copy(hasher, reader)
 	if err != nil {
 		return fmt.Errorf("failed to calculate digest: %w", err)
@@ -116,27 +436,37 @@ func (s *DockerRegistryPrivateService) validateDigest(reader io.Reader, expected
 
 // GetManifest retrieves a manifest by name and reference
 func (s *DockerRegistryPrivateService) GetManifest(ctx context.Context, name, reference string) ([]byte, string, error) {
+	manifestData, mediaType, _, err := s.GetManifestWithDigest(ctx, name, reference)
+	return manifestData, mediaType, err
+}
+
+// GetManifestWithDigest retrieves a manifest by name and reference along with its digest.
+// The in-memory manifest cache (if enabled) is checked before storage.
+func (s *DockerRegistryPrivateService) GetManifestWithDigest(ctx context.Context, name, reference string) ([]byte, string, string, error) {
+	cacheKey := s.getManifestCacheKey(name, reference)
+	if cached, ok := s.manifestCache.Get(cacheKey); ok {
+		return cached.Data, cached.MediaType, cached.Digest, nil
+	}
+	// A push or delete from here on must keep what this read loads out of the cache
+	generation := s.manifestCache.Generation(cacheKey)
+
 	// First, look up the digest from the reference mapping
 	refKey := s.getManifestRefKey(name, reference)
 	meta, err := s.storage.GetMeta(ctx, refKey)
 	if err != nil {
-		return nil, "", fmt.Errorf("manifest reference not found: %w", err)
+		return nil, "", "", fmt.Errorf("manifest reference not found: %w", err)
 	}
-
-	// Extract digest from metadata (stored in References with Repo="digest")
-	digest := ""
-	for _, ref := range meta.References {
-		if ref.Repo == "digest" {
-			digest = ref.Name
-			break
-		}
+	if s.isExpired(meta) {
+		return nil, "", "", fmt.Errorf("manifest reference expired: %s", reference)
 	}
+
+	digest := s.resolveRefDigest(meta)
 	if digest == "" {
-		return nil, "", fmt.Errorf("invalid manifest reference: digest not found")
+		return nil, "", "", fmt.Errorf("invalid manifest reference: digest not found")
 	}
 
 	// Retrieve manifest by digest
-	storageKey := s.getStorageKey(digest)
+	storageKey := s.getStorageKey(name, digest)
 	readReq := models.ArtifactRange{
 		Hash: storageKey,
 		Range: models.ByteRange{
@@ -147,56 +477,62 @@ func (s *DockerRegistryPrivateService) GetManifest(ctx context.Context, name, re
 
 	rc, _, err := s.storage.Read(ctx, readReq)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read manifest: %w", err)
+		return nil, "", "", fmt.Errorf("failed to read manifest: %w", err)
 	}
 	defer rc.Close()
 
 	manifestData, err := io.ReadAll(rc)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read manifest data: %w", err)
+		return nil, "", "", fmt.Errorf("failed to read manifest data: %w", err)
 	}
 
-	// Parse manifest to determine media type
-	manifest, err := docker.ParseManifest(manifestData)
-	if err != nil {
-		// If parsing fails, try to determine media type from content
-		// Default to OCI manifest
-		return manifestData, docker.MediaTypeOCIManifest, nil
+	// Use the media type recorded at push time; metadata written by older versions lacks it,
+	// so fall back to the parsed manifest, then to OCI manifest
+	mediaType := ""
+	expires := meta.ExpiresTimestamp
+	if contentMeta, err := s.storage.GetMeta(ctx, storageKey); err == nil {
+		if s.isExpired(contentMeta) {
+			return nil, "", "", fmt.Errorf("manifest expired: %s", digest)
+		}
+		mediaType = contentMeta.MediaType
+		expires = max(expires, contentMeta.ExpiresTimestamp)
+	}
+	if mediaType == "" {
+		mediaType = docker.MediaTypeOCIManifest
+		if manifest, err := docker.ParseManifest(manifestData); err == nil && manifest.MediaType != "" {
+			mediaType = manifest.MediaType
+		}
 	}
 
-	// Determine media type from parsed manifest
-	mediaType := docker.MediaTypeOCIManifest
-	if manifest.MediaType != "" {
-		mediaType = manifest.MediaType
+	// Cache hits aren't checked for expiry, so manifests that expire are always read from storage
+	if !s.expiryEnabled() || expires == 0 {
+		s.manifestCache.AddIfCurrent(cacheKey, generation, &docker.CachedManifest{
+			Data:      manifestData,
+			MediaType: mediaType,
+			Digest:    digest,
+		})
 	}
 
-	return manifestData, mediaType, nil
+	return manifestData, mediaType, digest, nil
 }
 
 // CheckManifestExists checks if a manifest exists
 func (s *DockerRegistryPrivateService) CheckManifestExists(ctx context.Context, name, reference string) (bool, string, error) {
 	refKey := s.getManifestRefKey(name, reference)
 	meta, err := s.storage.GetMeta(ctx, refKey)
-	if err != nil {
+	if err != nil || s.isExpired(meta) {
 		return false, "", nil // Not found, not an error
 	}
 
-	// Extract digest from References (stored with Repo="digest")
-	digest := ""
-	for _, ref := range meta.References {
-		if ref.Repo == "digest" {
-			digest = ref.Name
-			break
-		}
-	}
+	digest := s.resolveRefDigest(meta)
 	if digest == "" {
 		return false, "", nil
 	}
 
 	// Verify the manifest actually exists
-	storageKey := s.getStorageKey(digest)
-	_, err = s.storage.GetMeta(ctx, storageKey)
-	if err != nil {
+	storageKey := s.getStorageKey(name, digest)
+	contentMeta, err := s.storage.GetMeta(ctx, storageKey)
+	if err != nil || s.isExpired(contentMeta) {
 		return false, "", nil
 	}
 
@@ -205,15 +541,24 @@ func (s *DockerRegistryPrivateService) CheckManifestExists(ctx context.Context,
 
 // GetBlob retrieves a blob by digest
 func (s *DockerRegistryPrivateService) GetBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, error) {
-	storageKey := s.getStorageKey(digest)
-
-	// Check if blob exists
-	meta, err := s.storage.GetMeta(ctx, storageKey)
-	if err != nil {
+	if err := s.validateContentKey(digest); err != nil {
+		return nil, 0, err
+	}
+	storageKey := s.getStorageKey(name, digest)
+
+	// Check the blob is complete (metadata is written last) with stat calls only when supported;
+	// the size comes from the read, so the metadata is never decoded on this hot path unless
+	// its expiry must be checked
+	if exists, ok := s.storage.(storage.ExistsStorage); ok && !s.expiryEnabled() {
+		if _, metaExists, err := exists.Exists(ctx, storageKey); err != nil || !metaExists {
+			return nil, 0, fmt.Errorf("blob not found: %s", digest)
+		}
+	} else if meta, err := s.storage.GetMeta(ctx, storageKey); err != nil {
 		return nil, 0, fmt.Errorf("blob not found: %w", err)
+	} else if s.isExpired(meta) {
+		return nil, 0, fmt.Errorf("blob expired: %s", digest)
 	}
 
-	// Read blob
 	readReq := models.ArtifactRange{
 		Hash: storageKey,
 		Range: models.ByteRange{
@@ -222,19 +567,48 @@ func (s *DockerRegistryPrivateService) GetBlob(ctx context.Context, name, digest
 		},
 	}
 
-	rc, _, err := s.storage.Read(ctx, readReq)
+	rc, actualRange, err := s.storage.Read(ctx, readReq)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to read blob: %w", err)
 	}
 
-	return rc, meta.Length, nil
+	return rc, actualRange.Range.Length, nil
+}
+
+// BlobRedirectURL returns the storage URL to redirect a download of an existing blob to.
+// ok is false when redirects are disabled, unsupported by the storage, or the blob doesn't exist.
+func (s *DockerRegistryPrivateService) BlobRedirectURL(ctx context.Context, name, digest string) (string, bool) {
+	if !s.blobRedirects {
+		return "", false
+	}
+	redirect, ok := s.storage.(storage.RedirectStorage)
+	if !ok {
+		return "", false
+	}
+	if exists, _, _ := s.CheckBlobExists(ctx, name, digest); !exists {
+		return "", false
+	}
+	return redirect.RedirectURL(ctx, s.getStorageKey(name, digest))
 }
 
 // CheckBlobExists checks if a blob exists
 func (s *DockerRegistryPrivateService) CheckBlobExists(ctx context.Context, name, digest string) (bool, int64, error) {
-	storageKey := s.getStorageKey(digest)
+	if s.validateContentKey(digest) != nil {
+		return false, 0, nil
+	}
+	storageKey := s.getStorageKey(name, digest)
+
+	// Stat instead of decoding the metadata when supported, unless its expiry must be checked
+	if sizer, ok := s.storage.(storage.SizeStorage); ok && !s.expiryEnabled() {
+		size, exists, err := sizer.Size(ctx, storageKey)
+		if err != nil || !exists {
+			return false, 0, nil // Not found, not an error
+		}
+		return true, size, nil
+	}
+
 	meta, err := s.storage.GetMeta(ctx, storageKey)
-	if err != nil {
+	if err != nil || s.isExpired(meta) {
 		return false, 0, nil // Not found, not an error
 	}
 
@@ -243,9 +617,33 @@ func (s *DockerRegistryPrivateService) CheckBlobExists(ctx context.Context, name
 
 // PutManifest stores a manifest and creates a reference mapping
 func (s *DockerRegistryPrivateService) PutManifest(ctx context.Context, name, reference string, data []byte, mediaType string) error {
+	return s.PutManifestIf(ctx, name, reference, data, mediaType, ManifestCondition{})
+}
+
+// PutManifestIf stores a manifest like PutManifest, provided the reference's current target
+// satisfies cond; otherwise it fails with docker.ErrPreconditionFailed and stores nothing
+func (s *DockerRegistryPrivateService) PutManifestIf(ctx context.Context, name, reference string, data []byte, mediaType string, cond ManifestCondition) error {
+	if s.requireJSONManifests && !json.Valid(data) {
+		return docker.ErrManifestInvalid("manifest is not well-formed JSON")
+	}
+	if err := s.checkManifestDigests(reference, data); err != nil {
+		return err
+	}
+
+	// Hold mapping updates from the check until the reference is moved
+	refKey := s.getManifestRefKey(name, reference)
+	if !cond.isZero() {
+		s.refMu.Lock()
+		defer s.refMu.Unlock()
+		if err := cond.check(s.currentRefDigest(ctx, refKey)); err != nil {
+			return err
+		}
+	}
+
 	// Calculate digest
 	digest := s.calculateDigest(data)
-	storageKey := s.getStorageKey(digest)
+	storageKey := s.getStorageKey(name, digest)
+	defer s.gc.beginPush(name, append(s.storageKeys(name, manifestDigests(digest, data)), refKey)...)()
 
 	// Store manifest (content-addressable by digest)
 	ref := models.ArtifactReference{
@@ -253,23 +651,30 @@ func (s *DockerRegistryPrivateService) PutManifest(ctx context.Context, name, re
 		Repo:                "manifest",
 		ReferencedTimestamp: time.Now().Unix(),
 	}
+	expires := s.expiresAt(name)
 	meta := &models.ArtifactMeta{
 		Hash:             storageKey,
 		Length:           int64(len(data)),
 		CreatedTimestamp: time.Now().Unix(),
 		References:       []models.ArtifactReference{ref},
+		MediaType:        mediaType,
+		ExpiresTimestamp: expires,
 	}
 
 	// Store manifest data
 	_, err := s.storage.Create(ctx, storageKey, bytes.NewReader(data), int64(len(data)), meta)
 	if err != nil {
 		// If artifact exists (HashConflictError), merge references
-		if _, ok := err.(*models.HashConflictError); ok {
-			existingMeta, getErr := s.storage.GetMeta(ctx, storageKey)
-			if getErr == nil {
-				// Merge references
-				existingMeta.References = append(existingMeta.References, ref)
-				_, updateErr := s.storage.UpdateMeta(ctx, *existingMeta)
+		if isHashConflict(err) {
+			if _, getErr := s.storage.GetMeta(ctx, storageKey); getErr == nil {
+				// Merge references, retrying on concurrent metadata updates
+				_, updateErr := storage.ModifyMeta(ctx, s.storage, storageKey, func(existingMeta *models.ArtifactMeta) error {
+					existingMeta.References = append(existingMeta.References, ref)
+					if existingMeta.MediaType == "" {
+						existingMeta.MediaType = mediaType
+					}
+					return nil
+				})
 				if updateErr != nil {
 					return fmt.Errorf("failed to update manifest metadata: %w", updateErr)
 				}
@@ -278,111 +683,326 @@ func (s *DockerRegistryPrivateService) PutManifest(ctx context.Context, name, re
 			return fmt.Errorf("failed to store manifest: %w", err)
 		}
 	}
+	if err := s.recordExpiry(ctx, storageKey, expires, false); err != nil {
+		return err
+	}
+
+	// Create or move the reference mapping: name/reference -> digest
+	if cond.isZero() {
+		s.refMu.Lock()
+	}
+	err = s.setRefMapping(ctx, refKey, digest)
+	if err == nil {
+		err = s.recordExpiry(ctx, refKey, expires, true)
+	}
+	if cond.isZero() {
+		s.refMu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Invalidate the cached entry for the (possibly moved) reference
+	s.manifestCache.Remove(s.getManifestCacheKey(name, reference))
+	s.recordBlobMediaTypes(ctx, name, data)
+
+	if err := s.addToCatalog(ctx, name); err != nil {
+		return err
+	}
+
+	s.observeManifestUpload(int64(len(data)))
+	s.events.OnManifestPushed(ctx, s.manifestEvent(name, reference, digest, mediaType, int64(len(data))))
+	return nil
+}
+
+// recordBlobMediaTypes records the media types a manifest's config and layer descriptors declare
+// on the blobs' metadata, as pushing a blob doesn't declare one. Blobs that already have a media
+// type, or are missing, are left alone; failures only leave the media type unrecorded.
+func (s *DockerRegistryPrivateService) recordBlobMediaTypes(ctx context.Context, name string, data []byte) {
+	manifest, err := docker.ParseManifest(data)
+	if err != nil {
+		return
+	}
+	descriptors := manifest.Layers
+	if manifest.Config != nil {
+		descriptors = append([]docker.Descriptor{*manifest.Config}, descriptors...)
+	}
+	for _, descriptor := range descriptors {
+		if descriptor.MediaType == "" || s.validateContentKey(descriptor.Digest) != nil {
+			continue
+		}
+		meta, err := s.storage.GetMeta(ctx, s.getStorageKey(name, descriptor.Digest))
+		if err != nil || meta.MediaType != "" {
+			continue
+		}
+		meta.MediaType = descriptor.MediaType
+		_, _ = s.storage.UpdateMeta(ctx, *meta)
+	}
+}
+
+// manifestEvent builds the event for an operation on name/reference resolving to digest
+func (s *DockerRegistryPrivateService) manifestEvent(name, reference, digest, mediaType string, size int64) events.Event {
+	event := events.Event{Repository: name, Digest: digest, MediaType: mediaType, Size: size, Timestamp: time.Now()}
+	if !strings.Contains(reference, ":") {
+		event.Tag = reference
+	}
+	return event
+}
 
-	// Create reference mapping: name/reference -> digest
-	// Store the digest in the References field as a special reference
+// DeleteManifest deletes a tag or, when reference is a digest, the repository's manifest.
+// Deleting a tag only removes the reference mapping; deleting by digest also drops the repository's
+// reference from the manifest content, which is trashed once nothing else references it.
+// With eager collection enabled (see SetEagerGC), content of the manifest the repository no longer
+// reaches is collected too. Returns the number of storage keys whose content was trashed.
+func (s *DockerRegistryPrivateService) DeleteManifest(ctx context.Context, name, reference string) (int, error) {
 	refKey := s.getManifestRefKey(name, reference)
-	refMeta := &models.ArtifactMeta{
-		Hash:             refKey,
-		Length:           0,
-		CreatedTimestamp: time.Now().Unix(),
-		// Store digest in References as a special reference with Repo="digest"
-		References: []models.ArtifactReference{
-			{
-				Name:                digest, // Store digest in Name field
-				Repo:                "digest",
-				ReferencedTimestamp: time.Now().Unix(),
-			},
+	meta, err := s.storage.GetMeta(ctx, refKey)
+	if err != nil {
+		return 0, fmt.Errorf("manifest reference not found: %w", err)
+	}
+	digest := s.resolveRefDigest(meta)
+	if digest == "" {
+		return 0, fmt.Errorf("invalid manifest reference: digest not found")
+	}
+
+	// The manifest's content must be known before its own reference goes
+	var candidates map[string]bool
+	if s.eagerGCLimit > 0 {
+		if candidates, err = s.markReachable(ctx, name, []string{digest}); err != nil {
+			return 0, fmt.Errorf("failed to mark manifest content: %w", err)
+		}
+	}
+
+	// Mappings written by older versions may hold several digests; all of them go with the reference
+	digestRefs := []models.ArtifactReference{}
+	for _, ref := range meta.References {
+		if ref.Repo == refDigestRepo {
+			digestRefs = append(digestRefs, models.ArtifactReference{Name: ref.Name, Repo: refDigestRepo})
+		}
+	}
+	if len(digestRefs) == 0 {
+		digestRefs = append(digestRefs, models.ArtifactReference{Name: digest, Repo: refDigestRepo})
+	}
+	for _, ref := range digestRefs {
+		if _, err := s.storage.Delete(ctx, refKey, ref); err != nil {
+			return 0, fmt.Errorf("failed to delete manifest reference: %w", err)
+		}
+	}
+	s.manifestCache.Remove(s.getManifestCacheKey(name, reference))
+
+	reclaimed := 0
+	var size int64
+	if strings.Contains(reference, ":") {
+		storageKey := s.getStorageKey(name, digest)
+		if manifestMeta, err := s.storage.GetMeta(ctx, storageKey); err == nil {
+			size = manifestMeta.Length
+		}
+		remaining, err := s.storage.Delete(ctx, storageKey, models.ArtifactReference{Name: name, Repo: "manifest"})
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete manifest: %w", err)
+		}
+		// Tags of the deleted manifest are cached under their own keys
+		s.manifestCache.RemoveDigest(digest)
+		if remaining == nil {
+			reclaimed++
+		}
+	}
+
+	if candidates != nil {
+		collected, err := s.collectOrphans(ctx, name, candidates)
+		reclaimed += collected
+		if err != nil {
+			return reclaimed, err
+		}
+	}
+
+	s.events.OnManifestDeleted(ctx, s.manifestEvent(name, reference, digest, "", size))
+	return reclaimed, nil
+}
+
+// refDigestRepo marks the reference holding the digest in a reference mapping
+const refDigestRepo = "digest"
+
+// setRefMapping points a reference mapping at digest.
+// The digest is stored as the single reference {Repo: "digest", Name: digest}; an existing
+// mapping is replaced rather than merged so a moved tag never resolves to its old digest.
+func (s *DockerRegistryPrivateService) setRefMapping(ctx context.Context, refKey, digest string) error {
+	now := time.Now().Unix()
+	digestRef := []models.ArtifactReference{
+		{
+			Name:                digest,
+			Repo:                refDigestRepo,
+			ReferencedTimestamp: now,
 		},
 	}
 
+	// Moving an existing mapping retries on concurrent updates, so the last move always wins whole
+	if _, err := s.storage.GetMeta(ctx, refKey); err == nil {
+		_, err := storage.ModifyMeta(ctx, s.storage, refKey, func(existingRefMeta *models.ArtifactMeta) error {
+			existingRefMeta.References = digestRef
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update manifest reference: %w", err)
+		}
+		return nil
+	}
+
 	// Use empty reader for reference mapping (no data, just metadata)
-	_, err = s.storage.Create(ctx, refKey, bytes.NewReader([]byte{}), 0, refMeta)
+	refMeta := &models.ArtifactMeta{
+		Hash:             refKey,
+		Length:           0,
+		CreatedTimestamp: now,
+		References:       digestRef,
+	}
+	created, err := s.storage.Create(ctx, refKey, bytes.NewReader([]byte{}), 0, refMeta)
 	if err != nil {
-		// If reference already exists, update it
-		existingRefMeta, getErr := s.storage.GetMeta(ctx, refKey)
-		if getErr == nil {
-			// Update digest in References
-			existingRefMeta.References = []models.ArtifactReference{
-				{
-					Name:                digest,
-					Repo:                "digest",
-					ReferencedTimestamp: time.Now().Unix(),
-				},
-			}
-			_, updateErr := s.storage.UpdateMeta(ctx, *existingRefMeta)
-			if updateErr != nil {
-				return fmt.Errorf("failed to update manifest reference: %w", updateErr)
-			}
-		} else {
-			return fmt.Errorf("failed to create manifest reference: %w", err)
+		return fmt.Errorf("failed to create manifest reference: %w", err)
+	}
+	// A mapping created concurrently was merged into: keep this digest alone
+	if len(created.References) > 1 {
+		created.References = digestRef
+		if _, err := s.storage.UpdateMeta(ctx, *created); err != nil {
+			return fmt.Errorf("failed to update manifest reference: %w", err)
 		}
 	}
-
 	return nil
 }
 
+// resolveRefDigest extracts the digest from a reference mapping.
+// The digest reference is authoritative; if several exist (mappings written by older versions
+// that merged references) the most recent wins. Mappings that carry the digest in Hash are
+// accepted as a fallback.
+func (s *DockerRegistryPrivateService) resolveRefDigest(meta *models.ArtifactMeta) string {
+	digest := ""
+	var latest int64
+	for _, ref := range meta.References {
+		if ref.Repo == refDigestRepo && ref.Name != "" && (digest == "" || ref.ReferencedTimestamp >= latest) {
+			digest = ref.Name
+			latest = ref.ReferencedTimestamp
+		}
+	}
+	if digest == "" && meta.Hash != "" && !s.isRefKey(meta.Hash) {
+		digest = meta.Hash
+	}
+	return digest
+}
+
 // StartBlobUpload creates a new blob upload session
 func (s *DockerRegistryPrivateService) StartBlobUpload(ctx context.Context, name string) (string, error) {
 	// Generate UUID for session
-	uuid := fmt.Sprintf("%d-%d", time.Now().UnixNano(), len(s.uploadSessions))
+	sessionID := uuid.New().String()
 
 	session := &UploadSession{
-		UUID:      uuid,
+		UUID:      sessionID,
 		Name:      name,
 		Size:      0,
 		Offset:    0,
 		CreatedAt: time.Now(),
+		TempHash:  stagingKey(sessionID),
+	}
+	if err := s.startStaging(ctx, session); err != nil {
+		return "", err
 	}
 
 	s.sessionsMutex.Lock()
-	s.uploadSessions[uuid] = session
+	s.uploadSessions[sessionID] = session
 	s.sessionsMutex.Unlock()
 
-	return uuid, nil
+	return sessionID, nil
 }
 
-// UploadBlobChunk uploads a chunk of blob data to an existing session
-func (s *DockerRegistryPrivateService) UploadBlobChunk(ctx context.Context, name, uuid string, data io.Reader, offset int64) (int64, error) {
-	s.sessionsMutex.Lock()
+// BlobUploadStatus returns the number of bytes upload session uuid of repository name received
+func (s *DockerRegistryPrivateService) BlobUploadStatus(ctx context.Context, name, uuid string) (int64, error) {
+	s.sessionsMutex.RLock()
+	defer s.sessionsMutex.RUnlock()
 	session, exists := s.uploadSessions[uuid]
-	s.sessionsMutex.Unlock()
-
 	if !exists {
 		return 0, fmt.Errorf("upload session not found")
 	}
-
 	if session.Name != name {
 		return 0, fmt.Errorf("session name mismatch")
 	}
+	return session.Offset, nil
+}
 
-	// Read chunk data
-	chunkData, err := io.ReadAll(data)
-	if err != nil {
-		return 0, fmt.Errorf("failed to read chunk data: %w", err)
+// UploadSessionInfo describes an active upload session
+type UploadSessionInfo struct {
+	UUID         string    `json:"uuid"`
+	Name         string    `json:"name"`
+	Offset       int64     `json:"offset"`
+	Size         int64     `json:"size"`
+	StartedAt    time.Time `json:"startedAt"`
+	AgeSeconds   int64     `json:"ageSeconds"`
+	ResumeDigest string    `json:"resumeDigest,omitempty"`
+}
+
+// UploadSessions returns the active upload sessions, oldest first
+func (s *DockerRegistryPrivateService) UploadSessions() []UploadSessionInfo {
+	now := time.Now()
+	s.sessionsMutex.RLock()
+	sessions := make([]UploadSessionInfo, 0, len(s.uploadSessions))
+	for _, session := range s.uploadSessions {
+		sessions = append(sessions, UploadSessionInfo{
+			UUID:         session.UUID,
+			Name:         session.Name,
+			Offset:       session.Offset,
+			Size:         session.Size,
+			StartedAt:    session.CreatedAt,
+			AgeSeconds:   int64(now.Sub(session.CreatedAt) / time.Second),
+			ResumeDigest: session.ResumeDigest,
+		})
 	}
+	s.sessionsMutex.RUnlock()
 
-	chunkSize := int64(len(chunkData))
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].StartedAt.Equal(sessions[j].StartedAt) {
+			return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+		}
+		return sessions[i].UUID < sessions[j].UUID
+	})
+	return sessions
+}
 
-	// Update session - append chunk data
+// CancelBlobUpload ends upload session uuid of repository name, discarding the data it received
+func (s *DockerRegistryPrivateService) CancelBlobUpload(ctx context.Context, name, uuid string) error {
 	s.sessionsMutex.Lock()
-	if session.Data == nil {
-		session.Data = &bytes.Buffer{}
-	}
-	// Write chunk at specified offset (or append if offset matches current size)
-	if offset == session.Offset {
-		session.Data.Write(chunkData)
-		session.Offset += chunkSize
-		session.Size += chunkSize
-	} else {
-		// Offset mismatch - for now, just append (could implement proper offset handling)
-		session.Data.Write(chunkData)
-		session.Offset = int64(session.Data.Len())
-		session.Size = session.Offset
+	session, exists := s.uploadSessions[uuid]
+	if exists && session.Name == name {
+		delete(s.uploadSessions, uuid)
 	}
 	s.sessionsMutex.Unlock()
 
-	return session.Offset, nil
+	if !exists {
+		return fmt.Errorf("upload session not found")
+	}
+	if session.Name != name {
+		return fmt.Errorf("session name mismatch")
+	}
+	s.discardStaging(session)
+	return nil
+}
+
+// UploadBlobChunk uploads a chunk of blob data to an existing session
+func (s *DockerRegistryPrivateService) UploadBlobChunk(ctx context.Context, name, uuid string, data io.Reader, offset int64) (int64, error) {
+	s.sessionsMutex.Lock()
+	session, exists := s.uploadSessions[uuid]
+	s.sessionsMutex.Unlock()
+
+	if !exists {
+		return 0, fmt.Errorf("upload session not found")
+	}
+
+	if session.Name != name {
+		return 0, fmt.Errorf("session name mismatch")
+	}
+
+	offset, err := s.stageUploadChunk(ctx, session, data)
+	var regErr *docker.RegistryError
+	if errors.As(err, &regErr) {
+		s.abortUpload(session)
+	}
+	return offset, err
 }
 
 // CompleteBlobUpload finalizes a blob upload, validates digest, and stores the blob
@@ -403,60 +1023,41 @@ func (s *DockerRegistryPrivateService) CompleteBlobUpload(ctx context.Context, n
 		return fmt.Errorf("session name mismatch")
 	}
 
-	// Combine accumulated chunks with final chunk
-	var blobReader io.Reader
-	if session.Data != nil && session.Data.Len() > 0 {
-		if finalChunk != nil {
-			// Combine: accumulated data + final chunk
-			blobReader = io.MultiReader(bytes.NewReader(session.Data.Bytes()), finalChunk)
-		} else {
-			// Only accumulated data
-			blobReader = bytes.NewReader(session.Data.Bytes())
-		}
-	} else if finalChunk != nil {
-		// Only final chunk
-		blobReader = finalChunk
-	} else {
-		return fmt.Errorf("no blob data provided")
-	}
+	return s.completeStagedUpload(ctx, session, digest, finalChunk)
+}
 
-	// Calculate total size
-	totalSize := int64(-1)
-	if session.Data != nil {
-		totalSize = int64(session.Data.Len())
+// PutBlob uploads a blob directly in a single request with digest validation
+func (s *DockerRegistryPrivateService) PutBlob(ctx context.Context, name, digest string, reader io.Reader, size int64) error {
+	defer s.gc.beginPush(name, s.getStorageKey(name, digest))()
+	expires := s.expiresAt(name)
+	if err := s.putBlob(ctx, name, digest, reader, size, expires); err != nil {
+		return err
 	}
-	if finalChunk != nil {
-		// Read final chunk to get size (we'll need to buffer it for validation anyway)
-		finalData, err := io.ReadAll(finalChunk)
-		if err != nil {
-			return fmt.Errorf("failed to read final chunk: %w", err)
-		}
-		if totalSize >= 0 {
-			totalSize += int64(len(finalData))
-		} else {
-			totalSize = int64(len(finalData))
-		}
-		blobReader = io.MultiReader(
-			func() io.Reader {
-				if session.Data != nil && session.Data.Len() > 0 {
-					return bytes.NewReader(session.Data.Bytes())
-				}
-				return bytes.NewReader([]byte{})
-			}(),
-			bytes.NewReader(finalData),
-		)
+	if err := s.recordExpiry(ctx, s.getStorageKey(name, digest), expires, false); err != nil {
+		return err
 	}
-
-	// Use PutBlob to validate and store
-	return s.PutBlob(ctx, name, digest, blobReader, totalSize)
+	s.observeBlobUpload(size)
+	s.events.OnBlobPushed(ctx, events.Event{Repository: name, Digest: digest, Size: size, Timestamp: time.Now()})
+	return nil
 }
 
-// PutBlob uploads a blob directly in a single request with digest validation
-func (s *DockerRegistryPrivateService) PutBlob(ctx context.Context, name, digest string, reader io.Reader, size int64) error {
-	storageKey := s.getStorageKey(digest)
+// putBlob validates and stores a blob expiring at expires (0 = never), merging references if it already exists
+func (s *DockerRegistryPrivateService) putBlob(ctx context.Context, name, digest string, reader io.Reader, size, expires int64) error {
+	if err := s.validateContentKey(digest); err != nil {
+		return err
+	}
+	if err := s.checkDigestAlgorithm(digest); err != nil {
+		return err
+	}
+	storageKey := s.getStorageKey(name, digest)
 
 	// Use io.TeeReader to validate digest while streaming to storage
-	hasher := sha256.New()
+	algorithm := docker.DigestAlgorithm(digest)
+	hasher := docker.NewDigestHasher(algorithm)
+	if hasher == nil {
+		return docker.ErrDigestInvalid(fmt.Sprintf("unsupported digest algorithm: %s", digest))
+	}
+	defer docker.ReleaseDigestHasher(algorithm, hasher)
 	teeReader := io.TeeReader(reader, hasher)
 
 	// Store blob while calculating digest simultaneously
@@ -470,25 +1071,61 @@ func (s *DockerRegistryPrivateService) PutBlob(ctx context.Context, name, digest
 		Length:           size,
 		CreatedTimestamp: time.Now().Unix(),
 		References:       []models.ArtifactReference{ref},
+		ExpiresTimestamp: expires,
+	}
+
+	// A mismatching push only undoes what it stored, so note whether the blob, and this
+	// repository's reference to it, were stored before
+	existingMeta, getErr := s.storage.GetMeta(ctx, storageKey)
+	existed := getErr == nil
+	hadRef := existed && countRemaining(existingMeta.References, []models.ArtifactReference{ref}) < len(existingMeta.References)
+
+	// A blob stored before this push is only trusted once its content is verified
+	var verifyStored bool
+	if s.recordContentDigests || s.rejectConflictingBlobs {
+		verifyStored = existed
+		if existed && s.rejectConflictingBlobs {
+			if err := s.checkStoredBlob(ctx, existingMeta, digest); err != nil {
+				return err
+			}
+			verifyStored = false
+		}
 	}
 
+	// Hashing happens while streaming, so hold a hashing slot for the whole Create
+	if err := s.hashLimiter.Acquire(ctx); err != nil {
+		return err
+	}
 	_, err := s.storage.Create(ctx, storageKey, teeReader, size, meta)
+	if err == nil || isHashConflict(err) {
+		// Storage skips reading the content of blobs it already has; hash the rest of the upload
+		// so pushing a known blob to another repository is still verified against its digest
+		if _, copyErr := io.Copy(io.Discard, teeReader); copyErr != nil {
+			s.hashLimiter.Release()
+			return fmt.Errorf("failed to read blob: %w", copyErr)
+		}
+	}
+	s.hashLimiter.Release()
 	if err != nil {
 		// If artifact exists (HashConflictError), merge references
-		if _, ok := err.(*models.HashConflictError); ok {
-			existingMeta, getErr := s.storage.GetMeta(ctx, storageKey)
-			if getErr == nil {
-				// Merge references
-				existingMeta.References = append(existingMeta.References, ref)
-				_, updateErr := s.storage.UpdateMeta(ctx, *existingMeta)
+		if isHashConflict(err) {
+			// Verify the upload before touching the existing blob, so a bad push adds no reference
+			calculatedDigest := algorithm + ":" + hex.EncodeToString(hasher.Sum(nil))
+			if calculatedDigest != digest {
+				return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+			}
+			if _, getErr := s.storage.GetMeta(ctx, storageKey); getErr == nil {
+				// Merge references, retrying on concurrent metadata updates
+				_, updateErr := storage.ModifyMeta(ctx, s.storage, storageKey, func(existingMeta *models.ArtifactMeta) error {
+					existingMeta.References = append(existingMeta.References, ref)
+					return nil
+				})
 				if updateErr != nil {
 					return fmt.Errorf("failed to update blob metadata: %w", updateErr)
 				}
 			}
-			// Verify digest matches
-			calculatedDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
-			if calculatedDigest != digest {
-				return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+			if s.recordContentDigests {
+				return s.recordContentDigest(ctx, storageKey, digest, !existed || verifyStored)
 			}
 			return nil
 		}
@@ -496,13 +1133,210 @@ func (s *DockerRegistryPrivateService) PutBlob(ctx context.Context, name, digest
 	}
 
 	// Validate digest after storage
-	calculatedDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	calculatedDigest := algorithm + ":" + hex.EncodeToString(hasher.Sum(nil))
 	if calculatedDigest != digest {
-		// Clean up: delete the artifact we just created
-		// Note: This is a best-effort cleanup
-		_, _ = s.storage.Delete(ctx, storageKey, ref)
-		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+		if !existed {
+			// Clean up: delete the artifact we just created
+			// Note: This is a best-effort cleanup
+			_, _ = s.storage.Delete(ctx, storageKey, ref)
+			return fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+		}
+		// The blob was stored before: never delete it, only drop the reference this push added
+		if !hadRef {
+			_, _ = storage.ModifyMeta(ctx, s.storage, storageKey, func(existingMeta *models.ArtifactMeta) error {
+				refs := existingMeta.References[:0]
+				for _, r := range existingMeta.References {
+					if r.Name != ref.Name || r.Repo != ref.Repo {
+						refs = append(refs, r)
+					}
+				}
+				existingMeta.References = refs
+				return nil
+			})
+		}
+		return docker.ErrDigestInvalid(fmt.Sprintf("digest mismatch: expected %s, got %s", digest, calculatedDigest))
+	}
+
+	if s.recordContentDigests {
+		return s.recordContentDigest(ctx, storageKey, digest, verifyStored)
 	}
+	return nil
+}
 
+// checkStoredBlob returns docker.ErrDigestInvalid unless the already stored blob described by meta
+// holds the content of digest: its recorded content digest, or else its re-hashed content (sha256
+// digests only), must match. The stored blob is never overwritten either way.
+func (s *DockerRegistryPrivateService) checkStoredBlob(ctx context.Context, meta *models.ArtifactMeta, digest string) error {
+	stored := meta.ContentDigest
+	if stored == "" {
+		if docker.DigestAlgorithm(digest) != docker.DigestAlgorithmSHA256 {
+			return nil
+		}
+		actual, err := s.computeContentDigest(ctx, meta.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to verify stored blob: %w", err)
+		}
+		stored = actual
+	}
+	if stored != digest {
+		return docker.ErrDigestInvalid(fmt.Sprintf("stored blob content does not match %s", digest))
+	}
 	return nil
 }
+
+// recordContentDigest stores digest as the blob's verified content digest if none is recorded yet.
+// With verifyStored, the stored content was not written by this push, so it is re-hashed first.
+// Only sha256 digests are recorded, as integrity verification recomputes sha256.
+func (s *DockerRegistryPrivateService) recordContentDigest(ctx context.Context, storageKey, digest string, verifyStored bool) error {
+	if docker.DigestAlgorithm(digest) != docker.DigestAlgorithmSHA256 {
+		return nil
+	}
+	meta, err := s.storage.GetMeta(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to get blob metadata: %w", err)
+	}
+	if meta.ContentDigest != "" {
+		return nil
+	}
+
+	if verifyStored {
+		actual, err := s.computeContentDigest(ctx, storageKey)
+		if err != nil {
+			return err
+		}
+		if actual != digest {
+			return &models.IntegrityError{Hash: storageKey, Expected: digest, Actual: actual}
+		}
+	}
+
+	meta.ContentDigest = digest
+	if _, err := s.storage.UpdateMeta(ctx, *meta); err != nil {
+		return fmt.Errorf("failed to update blob metadata: %w", err)
+	}
+	return nil
+}
+
+// VerifyIntegrity recomputes the digest of a blob stored in repository name and compares it with
+// the recorded content digest (or the blob digest if none was recorded), catching on-disk corruption.
+// A mismatch is reported as *models.IntegrityError.
+func (s *DockerRegistryPrivateService) VerifyIntegrity(ctx context.Context, name, digest string) error {
+	if err := s.validateContentKey(digest); err != nil {
+		return err
+	}
+	storageKey := s.getStorageKey(name, digest)
+	if storageKey == digest {
+		if err := s.hashLimiter.Acquire(ctx); err != nil {
+			return err
+		}
+		defer s.hashLimiter.Release()
+		return storage.VerifyIntegrity(ctx, s.storage, storageKey)
+	}
+
+	// Namespaced keys don't carry the digest, so compare against the requested one
+	meta, err := s.storage.GetMeta(ctx, storageKey)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata for %s: %w", storageKey, err)
+	}
+	expected := meta.ContentDigest
+	if expected == "" && docker.DigestAlgorithm(digest) == docker.DigestAlgorithmSHA256 {
+		expected = digest
+	}
+	if expected == "" {
+		return fmt.Errorf("no content digest recorded for artifact %s", storageKey)
+	}
+	actual, err := s.computeContentDigest(ctx, storageKey)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &models.IntegrityError{Hash: storageKey, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// computeContentDigest re-hashes the stored content of storageKey once a hashing slot is free
+func (s *DockerRegistryPrivateService) computeContentDigest(ctx context.Context, storageKey string) (string, error) {
+	if err := s.hashLimiter.Acquire(ctx); err != nil {
+		return "", err
+	}
+	defer s.hashLimiter.Release()
+	return storage.ComputeContentDigest(ctx, s.storage, storageKey)
+}
+
+// isHashConflict reports whether err is a *models.HashConflictError
+func isHashConflict(err error) bool {
+	_, ok := err.(*models.HashConflictError)
+	return ok
+}
+
+// ManifestReferences resolves a manifest and returns the digests of everything it reaches:
+// child manifests of indexes (recursively, bounded by the max manifest depth), configs and layers.
+// The root digest is not included. Cycles and over-deep nesting are reported as errors.
+func (s *DockerRegistryPrivateService) ManifestReferences(ctx context.Context, name, reference string) ([]string, error) {
+	data, _, rootDigest, err := s.GetManifestWithDigest(ctx, name, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var digests []string
+	add := func(digest string) {
+		if digest != "" && !seen[digest] {
+			seen[digest] = true
+			digests = append(digests, digest)
+		}
+	}
+
+	fetch := func(ctx context.Context, digest string) ([]byte, error) {
+		childData, _, err := s.GetManifest(ctx, name, digest)
+		return childData, err
+	}
+	visit := func(digest string, manifest *docker.Manifest, depth int) error {
+		if depth > 0 {
+			add(digest)
+		}
+		if manifest.Config != nil {
+			add(manifest.Config.Digest)
+		}
+		for _, layer := range manifest.Layers {
+			add(layer.Digest)
+		}
+		return nil
+	}
+	if err := docker.WalkManifest(ctx, rootDigest, data, s.maxManifestDepth, fetch, visit); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// BlobInfo describes a blob referenced by a repository
+type BlobInfo struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// ListRepositoryBlobs returns the blobs referenced by repository name, sorted by digest.
+// Requires storage implementing storage.EnumerableStorage; this scans every artifact and is meant for debugging.
+func (s *DockerRegistryPrivateService) ListRepositoryBlobs(ctx context.Context, name string) ([]BlobInfo, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+
+	blobs := []BlobInfo{}
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		for _, ref := range meta.References {
+			if ref.Name == name && ref.Repo == "blob" {
+				blobs = append(blobs, BlobInfo{Digest: s.keyStrategy.Digest(name, meta.Hash), Size: meta.Length})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs for %s: %w", name, err)
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Digest < blobs[j].Digest })
+	return blobs, nil
+}
@@ -3,10 +3,21 @@ package private
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/registry/webhook"
 	"github.com/basakil/brm-server/internal/storage"
 	"github.com/basakil/brm-server/pkg/models"
 )
@@ -184,6 +195,199 @@ func TestDockerRegistryPrivateServicePutBlobDigestMismatch(t *testing.T) {
 	}
 }
 
+// TestDockerRegistryPrivateServicePutBlobMismatchKeepsStoredBlob tests that a push of the wrong content
+// under a stored blob's digest fails with DIGEST_INVALID, leaving the blob and its references intact
+func TestDockerRegistryPrivateServicePutBlobMismatchKeepsStoredBlob(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	ctx := context.Background()
+
+	blobData := []byte("good layer content")
+	digest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	// Same length, so storage takes the push as the already stored blob
+	badData := []byte("bad! layer content")
+	for _, name := range []string{"test-repo", "other-repo"} {
+		var regErr *docker.RegistryError
+		if err := service.PutBlob(ctx, name, digest, bytes.NewReader(badData), int64(len(badData))); !errors.As(err, &regErr) || regErr.Code != "DIGEST_INVALID" {
+			t.Fatalf("Expected DIGEST_INVALID for a bad push to %s, got %v", name, err)
+		}
+	}
+
+	meta, err := testStorage.GetMeta(ctx, digest)
+	if err != nil || len(meta.References) != 1 || meta.References[0].Name != "test-repo" {
+		t.Fatalf("Expected only the good push's reference, got %+v, %v", meta, err)
+	}
+	reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed after bad pushes: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, blobData) {
+		t.Errorf("Expected the stored blob content, got %q", data)
+	}
+}
+
+// TestDockerRegistryPrivateServicePutBlobConflictingContent tests that re-pushing an identical blob
+// just adds a reference, while a push onto stored content that doesn't match the digest (or a push
+// whose content doesn't match) is rejected without touching the stored blob
+func TestDockerRegistryPrivateServicePutBlobConflictingContent(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetRejectConflictingBlobs(true)
+	ctx := context.Background()
+
+	blobData := []byte("layer pushed by several repositories")
+	digest := service.CalculateDigest(blobData)
+	for _, name := range []string{"repo-a", "repo-b"} {
+		if err := service.PutBlob(ctx, name, digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+			t.Fatalf("PutBlob(%s) failed: %v", name, err)
+		}
+	}
+	meta, err := testStorage.GetMeta(ctx, digest)
+	if err != nil || len(meta.References) != 2 {
+		t.Fatalf("Expected identical pushes to share the blob with 2 references, got %+v, %v", meta, err)
+	}
+
+	// Content that doesn't hash to the digest never gains a reference
+	if err := service.PutBlob(ctx, "repo-c", digest, strings.NewReader("forged content"), 14); err == nil {
+		t.Error("Expected a push whose content doesn't match its digest to fail")
+	}
+
+	// Corrupt the stored copy: a correct push onto it is rejected rather than trusting it
+	if err := testStorage.Update(ctx, models.ArtifactRange{Hash: digest, Range: models.ByteRange{Offset: 0, Length: 1}}, strings.NewReader("X")); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	var regErr *docker.RegistryError
+	if err := service.PutBlob(ctx, "repo-c", digest, bytes.NewReader(blobData), int64(len(blobData))); !errors.As(err, &regErr) || regErr.Code != "DIGEST_INVALID" {
+		t.Fatalf("Expected DIGEST_INVALID when pushing onto conflicting content, got %v", err)
+	}
+
+	meta, err = testStorage.GetMeta(ctx, digest)
+	if err != nil || len(meta.References) != 2 {
+		t.Errorf("Expected rejected pushes to add no reference, got %+v, %v", meta, err)
+	}
+	reader, _, err := testStorage.Read(ctx, models.ArtifactRange{Hash: digest, Range: models.ByteRange{Offset: 0, Length: 1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	if first, _ := io.ReadAll(reader); string(first) != "X" {
+		t.Errorf("Expected the stored blob not to be overwritten, got first byte %q", first)
+	}
+}
+
+// TestDockerRegistryPrivateServiceArtifactTTL tests that content pushed to a repository with a TTL
+// is served until it expires, then not found, then swept, while content without a TTL stays
+func TestDockerRegistryPrivateServiceArtifactTTL(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetArtifactTTLs([]ArtifactTTL{{Prefix: "ci/", TTL: time.Second}})
+	ctx := context.Background()
+
+	layer := []byte("ephemeral ci layer")
+	layerDigest := service.CalculateDigest(layer)
+	if err := service.PutBlob(ctx, "ci/build", layerDigest, bytes.NewReader(layer), int64(len(layer))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	manifest := []byte(`{"schemaVersion":2,"layers":[{"digest":"` + layerDigest + `","size":18}]}`)
+	if err := service.PutManifest(ctx, "ci/build", "latest", manifest, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	kept := []byte("release layer")
+	keptDigest := service.CalculateDigest(kept)
+	if err := service.PutBlob(ctx, "release/app", keptDigest, bytes.NewReader(kept), int64(len(kept))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	// Served before expiry
+	reader, _, err := service.GetBlob(ctx, "ci/build", layerDigest)
+	if err != nil {
+		t.Fatalf("Expected the blob before expiry: %v", err)
+	}
+	reader.Close()
+	if _, _, err := service.GetManifest(ctx, "ci/build", "latest"); err != nil {
+		t.Fatalf("Expected the manifest before expiry: %v", err)
+	}
+	if meta, err := testStorage.GetMeta(ctx, layerDigest); err != nil || meta.ExpiresTimestamp == 0 {
+		t.Fatalf("Expected the expiry in the blob metadata, got %+v, %v", meta, err)
+	}
+
+	// Not found once expired
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if found, _, _ := service.CheckBlobExists(ctx, "ci/build", layerDigest); !found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the blob to expire")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, _, err := service.GetBlob(ctx, "ci/build", layerDigest); err == nil {
+		t.Error("Expected GetBlob of an expired blob to fail")
+	}
+	if _, _, err := service.GetManifest(ctx, "ci/build", "latest"); err == nil {
+		t.Error("Expected GetManifest of an expired tag to fail")
+	}
+	if found, _, _ := service.CheckBlobExists(ctx, "release/app", keptDigest); !found {
+		t.Error("Expected content without a TTL to be served")
+	}
+
+	// Swept
+	report, err := service.SweepExpired(ctx)
+	if err != nil {
+		t.Fatalf("SweepExpired failed: %v", err)
+	}
+	if len(report.Swept) != 3 {
+		t.Errorf("Expected the blob, manifest and tag mapping to be swept, got %v", report.Swept)
+	}
+	if _, err := testStorage.GetMeta(ctx, layerDigest); err == nil {
+		t.Error("Expected the expired blob to be deleted")
+	}
+	if _, err := testStorage.GetMeta(ctx, keptDigest); err != nil {
+		t.Errorf("Expected content without a TTL to survive the sweep: %v", err)
+	}
+}
+
+// TestDockerRegistryPrivateServiceVerifyIntegrity tests recorded content digests and re-verification after corruption
+func TestDockerRegistryPrivateServiceVerifyIntegrity(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetRecordContentDigests(true)
+	ctx := context.Background()
+
+	blobData := []byte("layer data to keep intact")
+	digest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	meta, err := testStorage.GetMeta(ctx, digest)
+	if err != nil || meta.ContentDigest != digest {
+		t.Fatalf("Expected recorded content digest %s, got %+v, %v", digest, meta, err)
+	}
+	if err := service.VerifyIntegrity(ctx, "test-repo", digest); err != nil {
+		t.Errorf("Expected clean store to verify, got %v", err)
+	}
+
+	// Flip the first byte on disk
+	if err := testStorage.Update(ctx, models.ArtifactRange{Hash: digest, Range: models.ByteRange{Offset: 0, Length: 1}}, strings.NewReader("X")); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	var integrityErr *models.IntegrityError
+	if err := service.VerifyIntegrity(ctx, "test-repo", digest); !errors.As(err, &integrityErr) || integrityErr.Expected != digest {
+		t.Errorf("Expected IntegrityError for corrupted store, got %v", err)
+	}
+
+	// A fresh push to another repository does not trust the corrupted copy without a recorded digest
+	meta.ContentDigest = ""
+	if _, err := testStorage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	if err := service.PutBlob(ctx, "other-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); !errors.As(err, &integrityErr) {
+		t.Errorf("Expected IntegrityError when pushing onto corrupted content, got %v", err)
+	}
+}
+
 // TestDockerRegistryPrivateServiceCheckBlobExists tests blob existence check
 func TestDockerRegistryPrivateServiceCheckBlobExists(t *testing.T) {
 	service, _ := setupTestService(t)
@@ -221,6 +425,64 @@ func TestDockerRegistryPrivateServiceCheckBlobExists(t *testing.T) {
 	}
 }
 
+// statCountingStorage counts the storage calls made while serving blobs
+type statCountingStorage struct {
+	*storage.SimpleFileStorage
+	getMeta, exists, read int
+}
+
+func (c *statCountingStorage) GetMeta(ctx context.Context, hash string) (*models.ArtifactMeta, error) {
+	c.getMeta++
+	return c.SimpleFileStorage.GetMeta(ctx, hash)
+}
+
+func (c *statCountingStorage) Exists(ctx context.Context, hash string) (bool, bool, error) {
+	c.exists++
+	return c.SimpleFileStorage.Exists(ctx, hash)
+}
+
+func (c *statCountingStorage) Read(ctx context.Context, req models.ArtifactRange) (io.ReadCloser, models.ArtifactRange, error) {
+	c.read++
+	return c.SimpleFileStorage.Read(ctx, req)
+}
+
+// TestDockerRegistryPrivateServiceGetBlobStatOnly tests that GetBlob serves the same content and
+// not-found results with stat calls and a single open, without decoding metadata
+func TestDockerRegistryPrivateServiceGetBlobStatOnly(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	ctx := context.Background()
+
+	blobData := bytes.Repeat([]byte("served blob"), 100)
+	digest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	missing := service.CalculateDigest([]byte("missing"))
+
+	counting := &statCountingStorage{SimpleFileStorage: testStorage.(*storage.SimpleFileStorage)}
+	metaOnly := struct{ models.ArtifactStorage }{testStorage} // Hides Exists: the GetMeta fallback
+	for _, backend := range []models.ArtifactStorage{metaOnly, counting} {
+		service.SetStorage(backend)
+		rc, size, err := service.GetBlob(ctx, "test-repo", digest)
+		if err != nil {
+			t.Fatalf("GetBlob failed: %v", err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(data, blobData) || size != int64(len(blobData)) {
+			t.Errorf("Expected the blob (%d bytes), got %d bytes, size %d (err=%v)", len(blobData), len(data), size, err)
+		}
+		if _, _, err := service.GetBlob(ctx, "test-repo", missing); err == nil {
+			t.Error("Expected GetBlob of a missing blob to fail")
+		}
+	}
+
+	// Two GetBlob calls: one stat pair each, one open for the existing blob, no metadata decode
+	if counting.getMeta != 0 || counting.exists != 2 || counting.read != 1 {
+		t.Errorf("Expected 0 GetMeta, 2 Exists and 1 Read, got %d, %d and %d", counting.getMeta, counting.exists, counting.read)
+	}
+}
+
 // TestDockerRegistryPrivateServiceBlobUploadSession tests blob upload session flow
 func TestDockerRegistryPrivateServiceBlobUploadSession(t *testing.T) {
 	service, _ := setupTestService(t)
@@ -288,6 +550,151 @@ func TestDockerRegistryPrivateServiceBlobUploadSession(t *testing.T) {
 	}
 }
 
+// TestDockerRegistryPrivateServiceBlobUploadStaging tests that chunked uploads are staged in a
+// separate storage and only the finalized blob lands in the content storage
+func TestDockerRegistryPrivateServiceBlobUploadStaging(t *testing.T) {
+	service, contentStorage := setupTestService(t)
+	stagingStorage := setupTestStorage(t)
+	service.SetUploadStaging(stagingStorage, "test-staging")
+	if service.UploadStagingAlias() != "test-staging" {
+		t.Errorf("Expected staging alias test-staging, got %q", service.UploadStagingAlias())
+	}
+	ctx := context.Background()
+	name := "test-repo"
+
+	uuid, err := service.StartBlobUpload(ctx, name)
+	if err != nil {
+		t.Fatalf("StartBlobUpload failed: %v", err)
+	}
+	chunk := []byte("staged chunk,")
+	offset, err := service.UploadBlobChunk(ctx, name, uuid, bytes.NewReader(chunk), 0)
+	if err != nil {
+		t.Fatalf("UploadBlobChunk failed: %v", err)
+	}
+	if offset != int64(len(chunk)) {
+		t.Errorf("Offset mismatch: expected %d, got %d", len(chunk), offset)
+	}
+	staged, _, err := stagingStorage.Read(ctx, models.ArtifactRange{Hash: stagingKey(uuid), Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Expected the chunk in the staging storage: %v", err)
+	}
+	stagedData, _ := io.ReadAll(staged)
+	staged.Close()
+	if !bytes.Equal(stagedData, chunk) {
+		t.Errorf("Staged data mismatch: got %q", stagedData)
+	}
+
+	finalChunk := []byte(" final chunk")
+	combinedData := append(chunk, finalChunk...)
+	digest := service.CalculateDigest(combinedData)
+	if err := service.CompleteBlobUpload(ctx, name, uuid, digest, bytes.NewReader(finalChunk)); err != nil {
+		t.Fatalf("CompleteBlobUpload failed: %v", err)
+	}
+
+	reader, _, err := contentStorage.Read(ctx, models.ArtifactRange{Hash: service.getStorageKey(name, digest), Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Expected the blob in the content storage: %v", err)
+	}
+	defer reader.Close()
+	if data, _ := io.ReadAll(reader); !bytes.Equal(data, combinedData) {
+		t.Errorf("Blob data mismatch: got %q", data)
+	}
+	if _, err := stagingStorage.GetMeta(ctx, stagingKey(uuid)); err == nil {
+		t.Error("Expected the staged upload to be removed after completion")
+	}
+	if _, err := stagingStorage.GetMeta(ctx, service.getStorageKey(name, digest)); err == nil {
+		t.Error("Expected the blob not to be stored in the staging storage")
+	}
+}
+
+// TestDockerRegistryPrivateServiceBlobUploadStagedInContentStorage tests that chunked uploads are
+// staged as a temp artifact of the content storage, moved to the blob's key once verified, and
+// removed with expired sessions
+func TestDockerRegistryPrivateServiceBlobUploadStagedInContentStorage(t *testing.T) {
+	service, contentStorage := setupTestService(t)
+	ctx := context.Background()
+	name := "test-repo"
+
+	uuid, err := service.StartBlobUpload(ctx, name)
+	if err != nil {
+		t.Fatalf("StartBlobUpload failed: %v", err)
+	}
+	chunk := []byte("staged on disk,")
+	if _, err := service.UploadBlobChunk(ctx, name, uuid, bytes.NewReader(chunk), 0); err != nil {
+		t.Fatalf("UploadBlobChunk failed: %v", err)
+	}
+	service.sessionsMutex.RLock()
+	tempHash := service.uploadSessions[uuid].TempHash
+	service.sessionsMutex.RUnlock()
+	staged, _, err := contentStorage.Read(ctx, models.ArtifactRange{Hash: tempHash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Expected the chunk staged in the content storage: %v", err)
+	}
+	stagedData, _ := io.ReadAll(staged)
+	staged.Close()
+	if !bytes.Equal(stagedData, chunk) {
+		t.Errorf("Staged data mismatch: got %q", stagedData)
+	}
+
+	// A wrong digest is refused and nothing is stored
+	finalChunk := []byte(" moved into place")
+	blob := append(append([]byte{}, chunk...), finalChunk...)
+	digest := service.CalculateDigest(blob)
+	wrong := service.CalculateDigest([]byte("something else"))
+	if err := service.CompleteBlobUpload(ctx, name, uuid, wrong, bytes.NewReader(finalChunk)); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("Expected a digest mismatch, got %v", err)
+	}
+	if _, err := contentStorage.GetMeta(ctx, tempHash); err == nil {
+		t.Error("Expected the staged upload to be removed after a failed completion")
+	}
+
+	uuid, err = service.StartBlobUpload(ctx, name)
+	if err != nil {
+		t.Fatalf("StartBlobUpload failed: %v", err)
+	}
+	if _, err := service.UploadBlobChunk(ctx, name, uuid, bytes.NewReader(chunk), 0); err != nil {
+		t.Fatalf("UploadBlobChunk failed: %v", err)
+	}
+	if err := service.CompleteBlobUpload(ctx, name, uuid, digest, bytes.NewReader(finalChunk)); err != nil {
+		t.Fatalf("CompleteBlobUpload failed: %v", err)
+	}
+	meta, err := contentStorage.GetMeta(ctx, service.getStorageKey(name, digest))
+	if err != nil {
+		t.Fatalf("Expected the blob in the content storage: %v", err)
+	}
+	if meta.Length != int64(len(blob)) || len(meta.References) != 1 || meta.References[0].Repo != "blob" || meta.References[0].Name != name {
+		t.Errorf("Expected the blob of %d bytes referenced by %s only, got %+v", len(blob), name, meta)
+	}
+	rc, _, err := service.GetBlob(ctx, name, digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(data, blob) {
+		t.Errorf("Blob data mismatch: got %q", data)
+	}
+	if _, err := contentStorage.GetMeta(ctx, stagingKey(uuid)); err == nil {
+		t.Error("Expected the staged upload to be moved away")
+	}
+
+	// Expired sessions take their staged data with them
+	uuid, err = service.StartBlobUpload(ctx, name)
+	if err != nil {
+		t.Fatalf("StartBlobUpload failed: %v", err)
+	}
+	if _, err := service.UploadBlobChunk(ctx, name, uuid, bytes.NewReader(chunk), 0); err != nil {
+		t.Fatalf("UploadBlobChunk failed: %v", err)
+	}
+	service.removeExpiredSessions(time.Now().Add(time.Minute))
+	if _, err := service.BlobUploadStatus(ctx, name, uuid); err == nil {
+		t.Error("Expected the expired session to be removed")
+	}
+	if _, err := contentStorage.GetMeta(ctx, stagingKey(uuid)); err == nil {
+		t.Error("Expected the staged data of the expired session to be removed")
+	}
+}
+
 // TestDockerRegistryPrivateServiceBlobUploadSessionNotFound tests completing non-existent session
 func TestDockerRegistryPrivateServiceBlobUploadSessionNotFound(t *testing.T) {
 	service, _ := setupTestService(t)
@@ -375,3 +782,848 @@ func TestDockerRegistryPrivateServiceCalculateDigest(t *testing.T) {
 		t.Errorf("Digest should start with 'sha256:', got %s", digest1)
 	}
 }
+
+// countingStorage wraps a storage and counts Read calls
+type countingStorage struct {
+	models.ArtifactStorage
+	reads int
+}
+
+func (c *countingStorage) Read(ctx context.Context, req models.ArtifactRange) (io.ReadCloser, models.ArtifactRange, error) {
+	c.reads++
+	return c.ArtifactStorage.Read(ctx, req)
+}
+
+// TestDockerRegistryPrivateServiceManifestCache tests that repeated GETs are served from memory
+func TestDockerRegistryPrivateServiceManifestCache(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	counting := &countingStorage{ArtifactStorage: testStorage}
+	service.SetStorage(counting)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	ctx := context.Background()
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	if err := service.PutManifest(ctx, "test-repo", "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	// First GET reads from storage
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	if counting.reads != 1 {
+		t.Fatalf("Expected 1 storage read, got %d", counting.reads)
+	}
+
+	// Second GET is served from memory
+	data, mediaType, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestWithDigest failed: %v", err)
+	}
+	if counting.reads != 1 {
+		t.Errorf("Expected second GET to be served from memory, got %d storage reads", counting.reads)
+	}
+	if !bytes.Equal(data, manifestData) {
+		t.Error("Cached manifest data mismatch")
+	}
+	if mediaType != docker.MediaTypeOCIManifest {
+		t.Errorf("Cached media type mismatch: got %s", mediaType)
+	}
+	if digest != service.CalculateDigest(manifestData) {
+		t.Errorf("Cached digest mismatch: got %s", digest)
+	}
+}
+
+// TestDockerRegistryPrivateServiceManifestCacheInvalidation tests that a push invalidates the cached entry
+func TestDockerRegistryPrivateServiceManifestCacheInvalidation(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	ctx := context.Background()
+
+	first := []byte(`{"schemaVersion":2,"annotations":{"v":"1"}}`)
+	second := []byte(`{"schemaVersion":2,"annotations":{"v":"2"}}`)
+
+	if err := service.PutManifest(ctx, "test-repo", "latest", first, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	cacheKey := service.getManifestCacheKey("test-repo", "latest")
+	if _, ok := service.manifestCache.Get(cacheKey); !ok {
+		t.Fatal("Expected manifest to be cached after GET")
+	}
+
+	// Pushing to the same tag must drop the cached entry
+	if err := service.PutManifest(ctx, "test-repo", "latest", second, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	if _, ok := service.manifestCache.Get(cacheKey); ok {
+		t.Error("Expected cached entry to be invalidated by push")
+	}
+	data, _, err := service.GetManifest(ctx, "test-repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	if !bytes.Equal(data, second) {
+		t.Error("Expected the moved tag to resolve to the new manifest")
+	}
+}
+
+// TestManifestCacheEviction tests LRU eviction by entry count
+func TestManifestCacheEviction(t *testing.T) {
+	cache := docker.NewManifestCache(2)
+	cache.Add("a", &docker.CachedManifest{Digest: "sha256:a"})
+	cache.Add("b", &docker.CachedManifest{Digest: "sha256:b"})
+
+	// Touch "a" so "b" becomes least recently used
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("Expected entry a")
+	}
+	cache.Add("c", &docker.CachedManifest{Digest: "sha256:c"})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected least recently used entry b to be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Error("Expected entry a to survive eviction")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+
+	cache.RemoveDigest("sha256:c")
+	if _, ok := cache.Get("c"); ok {
+		t.Error("Expected entry c to be removed by digest")
+	}
+}
+
+// TestManifestCacheAddIfCurrent tests that a manifest loaded before an invalidation isn't cached after it
+func TestManifestCacheAddIfCurrent(t *testing.T) {
+	cache := docker.NewManifestCache(10)
+
+	generation := cache.Generation("repo:latest")
+	cache.Remove("repo:latest")
+	cache.AddIfCurrent("repo:latest", generation, &docker.CachedManifest{Digest: "sha256:old"})
+	if _, ok := cache.Get("repo:latest"); ok {
+		t.Error("Expected a manifest loaded before Remove not to be cached")
+	}
+
+	generation = cache.Generation("repo:latest")
+	cache.RemoveDigest("sha256:other")
+	cache.AddIfCurrent("repo:latest", generation, &docker.CachedManifest{Digest: "sha256:old"})
+	if _, ok := cache.Get("repo:latest"); ok {
+		t.Error("Expected a manifest loaded before RemoveDigest not to be cached")
+	}
+
+	generation = cache.Generation("repo:latest")
+	cache.AddIfCurrent("repo:latest", generation, &docker.CachedManifest{Digest: "sha256:new"})
+	if _, ok := cache.Get("repo:latest"); !ok {
+		t.Error("Expected a manifest loaded without invalidation to be cached")
+	}
+}
+
+// TestDockerRegistryPrivateServiceManifestCacheDeleteByDigest tests that deleting a manifest by
+// digest drops the cached entries of its tags
+func TestDockerRegistryPrivateServiceManifestCacheDeleteByDigest(t *testing.T) {
+	service, _ := setupTestService(t)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	ctx := context.Background()
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	digest := service.CalculateDigest(manifestData)
+	for _, reference := range []string{"latest", digest} {
+		if err := service.PutManifest(ctx, "test-repo", reference, manifestData, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest failed: %v", err)
+		}
+	}
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+
+	if _, err := service.DeleteManifest(ctx, "test-repo", digest); err != nil {
+		t.Fatalf("DeleteManifest failed: %v", err)
+	}
+	if _, ok := service.manifestCache.Get(service.getManifestCacheKey("test-repo", "latest")); ok {
+		t.Error("Expected the tag's cached entry to be dropped with the manifest")
+	}
+}
+
+// TestDockerRegistryPrivateServiceRefKeyNoCollision tests that tag mappings can't collide with content blobs
+func TestDockerRegistryPrivateServiceRefKeyNoCollision(t *testing.T) {
+	service, _ := setupTestService(t)
+	ctx := context.Background()
+
+	blobData := []byte("real blob content")
+	digest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", digest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	// A repository/tag pair chosen to spell out the blob's digest
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	algo, hexPart := digest[:len("sha256")], digest[len("sha256:"):]
+	if err := service.PutManifest(ctx, algo, hexPart, manifestData, docker.MediaTypeOCIManifest); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	refKey := service.getManifestRefKey(algo, hexPart)
+	if refKey == service.getStorageKey("test-repo", digest) {
+		t.Fatalf("Reference key %s collides with blob key", refKey)
+	}
+
+	reader, size, err := service.GetBlob(ctx, "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(data, blobData) || size != int64(len(blobData)) {
+		t.Error("Blob content was affected by the reference mapping")
+	}
+
+	gotManifest, _, err := service.GetManifest(ctx, algo, hexPart)
+	if err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	if !bytes.Equal(gotManifest, manifestData) {
+		t.Error("Manifest content mismatch")
+	}
+
+	// Blob endpoints must not address the reference-mapping keyspace
+	if _, _, err := service.GetBlob(ctx, "test-repo", refKey); err == nil {
+		t.Error("Expected GetBlob to reject a reference-mapping key")
+	}
+	if err := service.PutBlob(ctx, "test-repo", refKey, bytes.NewReader(nil), 0); err == nil {
+		t.Error("Expected PutBlob to reject a reference-mapping key")
+	}
+}
+
+// TestDockerRegistryPrivateServiceRefKeyEscaping tests that names and references are escaped unambiguously
+func TestDockerRegistryPrivateServiceRefKeyEscaping(t *testing.T) {
+	service, _ := setupTestService(t)
+
+	if service.getManifestRefKey("a:b", "c") == service.getManifestRefKey("a", "b:c") {
+		t.Error("Expected distinct keys for a:b/c and a/b:c")
+	}
+	if key := service.getManifestRefKey("library/ubuntu", "latest"); strings.ContainsAny(key, "/\\") {
+		t.Errorf("Reference key must not contain path separators: %s", key)
+	}
+
+	if err := service.SetRefKeyPrefix("sha256:"); err == nil {
+		t.Error("Expected digest-like prefix to be rejected")
+	}
+	if err := service.SetRefKeyPrefix("refs/"); err == nil {
+		t.Error("Expected prefix with path separator to be rejected")
+	}
+	if err := service.SetRefKeyPrefix("tagmap:"); err != nil {
+		t.Fatalf("SetRefKeyPrefix failed: %v", err)
+	}
+	if key := service.getManifestRefKey("repo", "latest"); key != "tagmap:repo:latest" {
+		t.Errorf("Expected custom prefix key, got %s", key)
+	}
+}
+
+// TestDockerRegistryPrivateServiceRefMappingRoundTrip tests the reference mapping representation
+func TestDockerRegistryPrivateServiceRefMappingRoundTrip(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	ctx := context.Background()
+
+	first := []byte(`{"schemaVersion":2,"annotations":{"v":"1"}}`)
+	second := []byte(`{"schemaVersion":2,"annotations":{"v":"2"}}`)
+	for _, data := range [][]byte{first, second} {
+		if err := service.PutManifest(ctx, "test-repo", "latest", data, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest failed: %v", err)
+		}
+	}
+
+	// The mapping holds exactly one digest reference pointing at the latest push
+	refKey := service.getManifestRefKey("test-repo", "latest")
+	refMeta, err := testStorage.GetMeta(ctx, refKey)
+	if err != nil {
+		t.Fatalf("Reference mapping not found: %v", err)
+	}
+	if len(refMeta.References) != 1 || refMeta.References[0].Repo != "digest" || refMeta.References[0].Name != service.CalculateDigest(second) {
+		t.Fatalf("Unexpected reference mapping references: %+v", refMeta.References)
+	}
+
+	exists, digest, err := service.CheckManifestExists(ctx, "test-repo", "latest")
+	if err != nil || !exists || digest != service.CalculateDigest(second) {
+		t.Errorf("CheckManifestExists mismatch: exists=%v digest=%s err=%v", exists, digest, err)
+	}
+
+	// A mapping carrying the digest in Hash (no digest reference) still resolves
+	legacyKey := service.getManifestRefKey("test-repo", "legacy")
+	service.SetStorage(&hashRefStorage{
+		ArtifactStorage: testStorage,
+		refKey:          legacyKey,
+		digest:          service.CalculateDigest(first),
+	})
+	data, _, err := service.GetManifest(ctx, "test-repo", "legacy")
+	if err != nil {
+		t.Fatalf("GetManifest with digest in Hash failed: %v", err)
+	}
+	if !bytes.Equal(data, first) {
+		t.Error("Legacy mapping resolved to the wrong manifest")
+	}
+	exists, digest, _ = service.CheckManifestExists(ctx, "test-repo", "legacy")
+	if !exists || digest != service.CalculateDigest(first) {
+		t.Errorf("CheckManifestExists with digest in Hash mismatch: exists=%v digest=%s", exists, digest)
+	}
+}
+
+// hashRefStorage serves a reference mapping that stores the digest in Hash instead of References
+type hashRefStorage struct {
+	models.ArtifactStorage
+	refKey string
+	digest string
+}
+
+func (h *hashRefStorage) GetMeta(ctx context.Context, hash string) (*models.ArtifactMeta, error) {
+	if hash == h.refKey {
+		return &models.ArtifactMeta{Hash: h.digest, References: []models.ArtifactReference{}}, nil
+	}
+	return h.ArtifactStorage.GetMeta(ctx, hash)
+}
+
+// TestDockerRegistryPrivateServiceHashLimiter tests that concurrent PutBlob digest computation is capped
+func TestDockerRegistryPrivateServiceHashLimiter(t *testing.T) {
+	service, _ := setupTestService(t)
+	limiter := storage.NewHashLimiter(3)
+	service.SetHashLimiter(limiter)
+	ctx := context.Background()
+
+	const numUploads = 10
+	errs := make(chan error, numUploads)
+	for i := 0; i < numUploads; i++ {
+		go func(i int) {
+			data := bytes.Repeat([]byte(fmt.Sprintf("layer-%d", i)), 32*1024)
+			// Unbuffered pipe writes keep each upload streaming for a while
+			pr, pw := io.Pipe()
+			go func() {
+				for off := 0; off < len(data); off += 8192 {
+					pw.Write(data[off:min(off+8192, len(data))])
+				}
+				pw.Close()
+			}()
+			errs <- service.PutBlob(ctx, "test-repo", service.CalculateDigest(data), pr, int64(len(data)))
+		}(i)
+	}
+	for i := 0; i < numUploads; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("PutBlob failed: %v", err)
+		}
+	}
+
+	if peak := limiter.Peak(); peak < 1 || peak > 3 {
+		t.Errorf("Expected peak hashing concurrency between 1 and 3, got %d", peak)
+	}
+}
+
+// TestDockerRegistryPrivateServiceWebhook tests that a manifest push POSTs a signed event
+func TestDockerRegistryPrivateServiceWebhook(t *testing.T) {
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{body: body, signature: r.Header.Get(webhook.SignatureHeader)}
+	}))
+	defer target.Close()
+
+	service, _ := setupTestService(t)
+	notifier := webhook.NewNotifier([]webhook.Endpoint{{URL: target.URL, Secret: "s3cret"}}, 10)
+	defer notifier.Close()
+	service.SetEventSink(notifier)
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	if err := service.PutManifest(context.Background(), "test-repo", "v1", manifestData, docker.MediaTypeManifestV2); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	select {
+	case got := <-deliveries:
+		var event webhook.Event
+		if err := json.Unmarshal(got.body, &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if event.Repository != "test-repo" || event.Action != webhook.ActionPush || event.Kind != webhook.KindManifest ||
+			event.Tag != "v1" || event.Digest != service.CalculateDigest(manifestData) || event.Timestamp == 0 {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+		if got.signature != webhook.Sign("s3cret", got.body) {
+			t.Errorf("Expected signature %s, got %s", webhook.Sign("s3cret", got.body), got.signature)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for webhook delivery")
+	}
+}
+
+// recordingSink records the events it receives
+type recordingSink struct {
+	events.NopSink
+	mu      sync.Mutex
+	records []string
+	last    events.Event
+}
+
+func (r *recordingSink) record(kind string, event events.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, fmt.Sprintf("%s %s %s %s", kind, event.Repository, event.Tag, event.Digest))
+	r.last = event
+}
+
+func (r *recordingSink) OnManifestPushed(ctx context.Context, event events.Event) {
+	r.record("manifest-pushed", event)
+}
+
+func (r *recordingSink) OnManifestDeleted(ctx context.Context, event events.Event) {
+	r.record("manifest-deleted", event)
+}
+
+func (r *recordingSink) OnBlobPushed(ctx context.Context, event events.Event) {
+	r.record("blob-pushed", event)
+}
+
+// TestDockerRegistryPrivateServiceEventSink tests that pushes and deletes notify the event sink
+func TestDockerRegistryPrivateServiceEventSink(t *testing.T) {
+	service, _ := setupTestService(t)
+	sink := &recordingSink{}
+	service.SetEventSink(events.NewMultiSink(sink, nil))
+	ctx := context.Background()
+
+	blobData := []byte("layer")
+	blobDigest := service.CalculateDigest(blobData)
+	if err := service.PutBlob(ctx, "test-repo", blobDigest, bytes.NewReader(blobData), int64(len(blobData))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	if sink.last.Size != int64(len(blobData)) {
+		t.Errorf("Expected blob event size %d, got %d", len(blobData), sink.last.Size)
+	}
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	digest := service.CalculateDigest(manifestData)
+	for _, reference := range []string{"v1", digest} {
+		if err := service.PutManifest(ctx, "test-repo", reference, manifestData, docker.MediaTypeManifestV2); err != nil {
+			t.Fatalf("PutManifest %s failed: %v", reference, err)
+		}
+	}
+	if sink.last.MediaType != docker.MediaTypeManifestV2 {
+		t.Errorf("Expected manifest event media type, got %+v", sink.last)
+	}
+	for _, reference := range []string{"v1", digest} {
+		if _, err := service.DeleteManifest(ctx, "test-repo", reference); err != nil {
+			t.Fatalf("DeleteManifest %s failed: %v", reference, err)
+		}
+	}
+	if _, err := service.DeleteManifest(ctx, "test-repo", "v1"); err == nil {
+		t.Error("Expected error deleting a missing tag")
+	}
+	if _, _, err := service.GetManifest(ctx, "test-repo", digest); err == nil {
+		t.Error("Expected deleted manifest to be gone")
+	}
+
+	expected := []string{
+		"blob-pushed test-repo  " + blobDigest,
+		"manifest-pushed test-repo v1 " + digest,
+		"manifest-pushed test-repo  " + digest,
+		"manifest-deleted test-repo v1 " + digest,
+		"manifest-deleted test-repo  " + digest,
+	}
+	if !reflect.DeepEqual(sink.records, expected) {
+		t.Errorf("Expected events %q, got %q", expected, sink.records)
+	}
+}
+
+// pausingStorage pauses after each Walk (the GC mark phase) until resumed
+type pausingStorage struct {
+	models.ArtifactStorage
+	walked chan struct{}
+	resume chan struct{}
+}
+
+func (p *pausingStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	err := p.ArtifactStorage.(storage.EnumerableStorage).Walk(ctx, fn)
+	p.walked <- struct{}{}
+	<-p.resume
+	return err
+}
+
+// TestDockerRegistryPrivateServiceGCConcurrentPush tests that a push between GC's mark and sweep
+// is never collected, including a stale unreferenced layer the new manifest reuses
+func TestDockerRegistryPrivateServiceGCConcurrentPush(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetGCGracePeriod(0)
+	ctx := context.Background()
+
+	// An orphaned layer: GC would collect it, unless the concurrent push references it
+	reused := []byte("reused base layer")
+	reusedDigest := service.CalculateDigest(reused)
+	if err := service.PutBlob(ctx, "test-repo", reusedDigest, bytes.NewReader(reused), int64(len(reused))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	// Index the (empty) catalog up front so the push below does not walk the storage
+	if _, err := service.RebuildCatalog(ctx); err != nil {
+		t.Fatalf("RebuildCatalog failed: %v", err)
+	}
+
+	paused := &pausingStorage{ArtifactStorage: testStorage, walked: make(chan struct{}), resume: make(chan struct{})}
+	service.SetStorage(paused)
+	type result struct {
+		report *GCReport
+		err    error
+	}
+	gcDone := make(chan result, 1)
+	go func() {
+		report, err := service.CollectGarbage(ctx, false)
+		gcDone <- result{report, err}
+	}()
+	<-paused.walked
+
+	// Push a new layer and a manifest reusing the orphan while GC is between mark and sweep
+	layer := []byte("new layer")
+	layerDigest := service.CalculateDigest(layer)
+	if err := service.PutBlob(ctx, "test-repo", layerDigest, bytes.NewReader(layer), int64(len(layer))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q},{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		docker.MediaTypeManifestV2, docker.MediaTypeLayer, len(reused), reusedDigest, docker.MediaTypeLayer, len(layer), layerDigest)
+	if err := service.PutManifest(ctx, "test-repo", "v1", []byte(manifest), docker.MediaTypeManifestV2); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+
+	close(paused.resume)
+	res := <-gcDone
+	if res.err != nil {
+		t.Fatalf("CollectGarbage failed: %v", res.err)
+	}
+	if len(res.report.Removed) != 0 {
+		t.Errorf("Expected nothing collected during the push, got %+v", res.report.Removed)
+	}
+	if names, err := service.Catalog(ctx); err != nil || !reflect.DeepEqual(names, []string{"test-repo"}) {
+		t.Errorf("Expected the pushed repository to stay in the catalog, got %v (%v)", names, err)
+	}
+
+	// The next run sees the manifest and keeps everything it references
+	go func() { <-paused.walked }()
+	if _, err := service.CollectGarbage(ctx, false); err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	for _, digest := range []string{reusedDigest, layerDigest, service.CalculateDigest([]byte(manifest))} {
+		if _, err := testStorage.GetMeta(ctx, digest); err != nil {
+			t.Errorf("Expected %s to survive GC: %v", digest, err)
+		}
+	}
+}
+
+// TestDockerRegistryPrivateServiceGCSlowPush tests that GC doesn't wait for a push body in
+// transfer, and doesn't collect what the push stores
+func TestDockerRegistryPrivateServiceGCSlowPush(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	service.SetGCGracePeriod(0)
+	ctx := context.Background()
+
+	blobData := []byte("slowly uploaded layer")
+	digest := service.CalculateDigest(blobData)
+	pr, pw := io.Pipe()
+	pushDone := make(chan error, 1)
+	go func() {
+		pushDone <- service.PutBlob(ctx, "test-repo", digest, pr, int64(len(blobData)))
+	}()
+	if _, err := pw.Write(blobData[:4]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	gcDone := make(chan error, 1)
+	go func() {
+		_, err := service.CollectGarbage(ctx, false)
+		gcDone <- err
+	}()
+	select {
+	case err := <-gcDone:
+		if err != nil {
+			t.Fatalf("CollectGarbage failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CollectGarbage waited for the push body")
+	}
+
+	if _, err := pw.Write(blobData[4:]); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	pw.Close()
+	if err := <-pushDone; err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+	if _, err := testStorage.GetMeta(ctx, digest); err != nil {
+		t.Errorf("Expected the pushed blob to survive GC: %v", err)
+	}
+}
+
+// TestDockerRegistryPrivateServiceKeyStrategies tests that both key strategies store and serve
+// content, that digest keys dedup across repositories and that repository keys isolate them
+func TestDockerRegistryPrivateServiceKeyStrategies(t *testing.T) {
+	for _, strategy := range []docker.KeyStrategy{docker.KeyByDigest, docker.KeyByRepository} {
+		t.Run(string(strategy), func(t *testing.T) {
+			service, testStorage := setupTestService(t)
+			service.SetKeyStrategy(strategy)
+			service.SetGCGracePeriod(0)
+			ctx := context.Background()
+
+			layer := []byte("layer content")
+			layerDigest := service.CalculateDigest(layer)
+			if err := service.PutBlob(ctx, "repo-a", layerDigest, bytes.NewReader(layer), int64(len(layer))); err != nil {
+				t.Fatalf("PutBlob failed: %v", err)
+			}
+
+			isolated := strategy == docker.KeyByRepository
+			if exists, _, _ := service.CheckBlobExists(ctx, "repo-b", layerDigest); exists == isolated {
+				t.Errorf("Expected blob visible to another repository: %v, got %v", !isolated, exists)
+			}
+			if _, _, err := service.GetBlob(ctx, "repo-b", layerDigest); (err != nil) != isolated {
+				t.Errorf("Expected GetBlob from another repository to fail: %v, got err=%v", isolated, err)
+			}
+
+			// Pushing the same blob to repo-b stores it once, or once per repository
+			if err := service.PutBlob(ctx, "repo-b", layerDigest, bytes.NewReader(layer), int64(len(layer))); err != nil {
+				t.Fatalf("PutBlob to repo-b failed: %v", err)
+			}
+			keyA, keyB := strategy.Key("repo-a", layerDigest), strategy.Key("repo-b", layerDigest)
+			if (keyA != keyB) != isolated {
+				t.Errorf("Expected distinct keys per repository: %v, got %s and %s", isolated, keyA, keyB)
+			}
+			for _, key := range []string{keyA, keyB} {
+				if _, err := testStorage.GetMeta(ctx, key); err != nil {
+					t.Errorf("Expected content stored under %s: %v", key, err)
+				}
+			}
+
+			manifestData := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+				docker.MediaTypeOCIManifest, docker.MediaTypeLayer, len(layer), layerDigest))
+			if err := service.PutManifest(ctx, "repo-a", "latest", manifestData, docker.MediaTypeOCIManifest); err != nil {
+				t.Fatalf("PutManifest failed: %v", err)
+			}
+			data, _, digest, err := service.GetManifestWithDigest(ctx, "repo-a", "latest")
+			if err != nil || !bytes.Equal(data, manifestData) {
+				t.Fatalf("GetManifest mismatch (err=%v)", err)
+			}
+			if exists, _, _ := service.CheckManifestExists(ctx, "repo-b", digest); exists {
+				t.Error("Expected the manifest not to be tagged in repo-b")
+			}
+			if err := service.VerifyIntegrity(ctx, "repo-a", layerDigest); err != nil {
+				t.Errorf("VerifyIntegrity failed: %v", err)
+			}
+			blobs, err := service.ListRepositoryBlobs(ctx, "repo-b")
+			if err != nil || len(blobs) != 1 || blobs[0].Digest != layerDigest {
+				t.Errorf("Expected repo-b to list %s, got %v (err=%v)", layerDigest, blobs, err)
+			}
+
+			// repo-b's copy is unreferenced: collecting it never affects repo-a
+			if _, err := service.CollectGarbage(ctx, false); err != nil {
+				t.Fatalf("CollectGarbage failed: %v", err)
+			}
+			if _, _, err := service.GetBlob(ctx, "repo-a", layerDigest); err != nil {
+				t.Errorf("Expected repo-a's layer to survive garbage collection: %v", err)
+			}
+			if exists, _, _ := service.CheckBlobExists(ctx, "repo-b", layerDigest); exists == isolated {
+				t.Errorf("Expected repo-b's layer to remain visible: %v, got %v", !isolated, exists)
+			}
+		})
+	}
+}
+
+// TestDockerRegistryPrivateServiceEagerGC tests that deleting a manifest's last tag reclaims its
+// exclusive content in eager mode, while shared layers survive and the default defers to CollectGarbage
+func TestDockerRegistryPrivateServiceEagerGC(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T, eager bool) (*DockerRegistryPrivateService, map[string]string) {
+		service, _ := setupTestService(t)
+		service.SetGCGracePeriod(0)
+		if eager {
+			service.SetEagerGC(DefaultEagerGCLimit)
+		}
+		blobs := map[string][]byte{
+			"config1": []byte(`{"architecture":"amd64"}`), "config2": []byte(`{"architecture":"arm64"}`),
+			"shared": []byte("shared layer"), "exclusive": []byte("exclusive layer"),
+		}
+		digests := map[string]string{}
+		for name, data := range blobs {
+			digests[name] = service.CalculateDigest(data)
+			if err := service.PutBlob(ctx, "test-repo", digests[name], bytes.NewReader(data), int64(len(data))); err != nil {
+				t.Fatalf("PutBlob %s failed: %v", name, err)
+			}
+		}
+		manifest := func(config string, layers ...string) []byte {
+			var entries []string
+			for _, layer := range layers {
+				entries = append(entries, fmt.Sprintf(`{"mediaType":%q,"size":%d,"digest":%q}`, docker.MediaTypeLayer, len(blobs[layer]), digests[layer]))
+			}
+			return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[%s]}`,
+				docker.MediaTypeManifestV2, docker.MediaTypeImageConfig, len(blobs[config]), digests[config], strings.Join(entries, ",")))
+		}
+		first, second := manifest("config1", "shared", "exclusive"), manifest("config2", "shared")
+		digests["first"], digests["second"] = service.CalculateDigest(first), service.CalculateDigest(second)
+		for _, push := range []struct {
+			tag  string
+			data []byte
+		}{{"v1", first}, {"v1-alias", first}, {"v2", second}} {
+			if err := service.PutManifest(ctx, "test-repo", push.tag, push.data, docker.MediaTypeManifestV2); err != nil {
+				t.Fatalf("PutManifest %s failed: %v", push.tag, err)
+			}
+		}
+		return service, digests
+	}
+	exists := func(service *DockerRegistryPrivateService, digest string) bool {
+		_, err := service.storage.GetMeta(ctx, digest)
+		return err == nil
+	}
+
+	t.Run("eager", func(t *testing.T) {
+		service, digests := setup(t, true)
+		if reclaimed, err := service.DeleteManifest(ctx, "test-repo", "v1"); err != nil || reclaimed != 0 {
+			t.Fatalf("Expected nothing reclaimed while another tag remains, got %d, %v", reclaimed, err)
+		}
+		reclaimed, err := service.DeleteManifest(ctx, "test-repo", "v1-alias")
+		if err != nil {
+			t.Fatalf("DeleteManifest failed: %v", err)
+		}
+		if reclaimed != 3 {
+			t.Errorf("Expected the manifest, its config and exclusive layer reclaimed, got %d", reclaimed)
+		}
+		for _, name := range []string{"first", "config1", "exclusive"} {
+			if exists(service, digests[name]) {
+				t.Errorf("Expected %s to be reclaimed", name)
+			}
+		}
+		for _, name := range []string{"second", "config2", "shared"} {
+			if !exists(service, digests[name]) {
+				t.Errorf("Expected %s to survive", name)
+			}
+		}
+		if _, _, err := service.GetManifest(ctx, "test-repo", "v2"); err != nil {
+			t.Errorf("Expected v2 to stay pullable: %v", err)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		service, digests := setup(t, false)
+		for _, tag := range []string{"v1", "v1-alias"} {
+			if reclaimed, err := service.DeleteManifest(ctx, "test-repo", tag); err != nil || reclaimed != 0 {
+				t.Fatalf("Expected nothing reclaimed by default, got %d, %v", reclaimed, err)
+			}
+		}
+		if !exists(service, digests["exclusive"]) {
+			t.Error("Expected the exclusive layer to wait for CollectGarbage")
+		}
+		report, err := service.CollectGarbage(ctx, false)
+		if err != nil {
+			t.Fatalf("CollectGarbage failed: %v", err)
+		}
+		if len(report.Reclaimed) != 3 {
+			t.Errorf("Expected CollectGarbage to reclaim the same 3 keys, got %v", report.Reclaimed)
+		}
+	})
+}
+
+// TestDockerRegistryPrivateServiceRefMappingStaysMinimal tests that re-tagging replaces the mapping's
+// digest instead of accumulating references, and that merged legacy mappings collapse
+func TestDockerRegistryPrivateServiceRefMappingStaysMinimal(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	ctx := context.Background()
+	refKey := service.getManifestRefKey("test-repo", "latest")
+
+	mappingSize := func() int {
+		t.Helper()
+		meta, err := testStorage.GetMeta(ctx, refKey)
+		if err != nil {
+			t.Fatalf("Reference mapping not found: %v", err)
+		}
+		if len(meta.References) != 1 || meta.Length != 0 {
+			t.Fatalf("Expected a single digest reference and no data, got %+v", meta)
+		}
+		meta.Generation = 0 // Counts the moves by design
+		encoded, _ := json.Marshal(meta)
+		return len(encoded)
+	}
+
+	var firstSize, last int
+	for i := 0; i < 100; i++ {
+		data := []byte(fmt.Sprintf(`{"schemaVersion":2,"annotations":{"v":"%03d"}}`, i))
+		if err := service.PutManifest(ctx, "test-repo", "latest", data, docker.MediaTypeOCIManifest); err != nil {
+			t.Fatalf("PutManifest %d failed: %v", i, err)
+		}
+		if i == 0 {
+			firstSize = mappingSize()
+		}
+		last = i
+	}
+	if size := mappingSize(); size != firstSize {
+		t.Errorf("Mapping metadata grew from %d to %d bytes", firstSize, size)
+	}
+	_, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+	if err != nil || digest != service.CalculateDigest([]byte(fmt.Sprintf(`{"schemaVersion":2,"annotations":{"v":"%03d"}}`, last))) {
+		t.Errorf("Expected the latest push to win, got %s (%v)", digest, err)
+	}
+
+	// A legacy mapping with merged digests collapses on the next tag and deletes entirely
+	meta, err := testStorage.GetMeta(ctx, refKey)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	meta.References = append(meta.References,
+		models.ArtifactReference{Name: "sha256:" + strings.Repeat("a", 64), Repo: "digest", ReferencedTimestamp: 1},
+		models.ArtifactReference{Name: "sha256:" + strings.Repeat("b", 64), Repo: "digest", ReferencedTimestamp: 2})
+	if _, err := testStorage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	if _, err := service.DeleteManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("DeleteManifest failed: %v", err)
+	}
+	if _, err := testStorage.GetMeta(ctx, refKey); err == nil {
+		t.Error("Expected deleting the tag to remove every merged digest")
+	}
+}
+
+// TestDockerRegistryPrivateServiceMediaTypeRoundTrip tests that push-time media types are persisted
+// and served, with parsing as the fallback for metadata lacking them
+func TestDockerRegistryPrivateServiceMediaTypeRoundTrip(t *testing.T) {
+	service, testStorage := setupTestService(t)
+	ctx := context.Background()
+
+	layer := []byte("typed layer")
+	layerDigest := service.CalculateDigest(layer)
+	if err := service.PutBlob(ctx, "test-repo", layerDigest, bytes.NewReader(layer), int64(len(layer))); err != nil {
+		t.Fatalf("PutBlob failed: %v", err)
+	}
+
+	// The body doesn't declare its media type, so only the persisted one can tell
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"mediaType":"%s","size":%d,"digest":"%s"}]}`, docker.MediaTypeLayer, len(layer), layerDigest))
+	digest := service.CalculateDigest(manifest)
+	if err := service.PutManifest(ctx, "test-repo", "v1", manifest, docker.MediaTypeManifestV2); err != nil {
+		t.Fatalf("PutManifest failed: %v", err)
+	}
+	if _, mediaType, err := service.GetManifest(ctx, "test-repo", "v1"); err != nil || mediaType != docker.MediaTypeManifestV2 {
+		t.Errorf("Expected media type %s, got %q (%v)", docker.MediaTypeManifestV2, mediaType, err)
+	}
+	if meta, err := testStorage.GetMeta(ctx, service.getStorageKey("test-repo", layerDigest)); err != nil || meta.MediaType != docker.MediaTypeLayer {
+		t.Errorf("Expected the layer's descriptor media type to be recorded, got %+v (%v)", meta, err)
+	}
+
+	// Metadata written by older versions falls back to parsing
+	meta, err := testStorage.GetMeta(ctx, service.getStorageKey("test-repo", digest))
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	meta.MediaType = ""
+	if _, err := testStorage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	service.SetManifestCache(nil)
+	if _, mediaType, err := service.GetManifest(ctx, "test-repo", "v1"); err != nil || mediaType != docker.MediaTypeOCIManifest {
+		t.Errorf("Expected the parsed fallback %s, got %q (%v)", docker.MediaTypeOCIManifest, mediaType, err)
+	}
+}
@@ -0,0 +1,344 @@
+package private
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// stagingRef is the reference staged upload artifacts carry, and are deleted by
+var stagingRef = models.ArtifactReference{Name: "upload", Repo: "staging"}
+
+// stagingKey returns the key of the staged artifact of upload session uuid
+func stagingKey(uuid string) string {
+	return "upload-" + uuid
+}
+
+// SetUploadStaging stages chunked uploads in staging (registered as storageAlias) instead of the
+// content storage, e.g. on fast local disk while content lives on slower storage. CompleteBlobUpload
+// streams the staged blob into the content storage and removes it from staging. Single-request
+// uploads are staged too, so interrupted ones can be resumed (see PutBlobResumable). Nil restores
+// staging in the content storage; it must not change while uploads are in progress.
+func (s *DockerRegistryPrivateService) SetUploadStaging(staging models.ArtifactStorage, storageAlias string) {
+	s.staging = staging
+	s.stagingAlias = storageAlias
+	if staging == nil {
+		s.stagingAlias = ""
+	}
+}
+
+// UploadStagingAlias returns the storage alias uploads are staged in ("" = the content storage)
+func (s *DockerRegistryPrivateService) UploadStagingAlias() string {
+	return s.stagingAlias
+}
+
+// stagingStorage returns the storage upload sessions are staged in
+func (s *DockerRegistryPrivateService) stagingStorage() models.ArtifactStorage {
+	if s.staging != nil {
+		return s.staging
+	}
+	return s.storage
+}
+
+// startStaging creates the empty staged artifact of a new upload session
+func (s *DockerRegistryPrivateService) startStaging(ctx context.Context, session *UploadSession) error {
+	meta := &models.ArtifactMeta{
+		Hash:             session.TempHash,
+		CreatedTimestamp: time.Now().Unix(),
+		References:       []models.ArtifactReference{stagingRef},
+	}
+	if _, err := s.stagingStorage().Create(ctx, meta.Hash, bytes.NewReader(nil), 0, meta); err != nil {
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+	return nil
+}
+
+// stageChunk appends data to the staged artifact of session and returns the new session offset
+func (s *DockerRegistryPrivateService) stageChunk(ctx context.Context, session *UploadSession, data io.Reader) (int64, error) {
+	s.sessionsMutex.RLock()
+	offset := session.Offset
+	s.sessionsMutex.RUnlock()
+
+	counter := &countingReader{r: data}
+	err := s.stagingStorage().Update(ctx, models.ArtifactRange{
+		Hash:  session.TempHash,
+		Range: models.ByteRange{Offset: offset},
+	}, counter)
+
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	session.Offset = offset + counter.n
+	session.Size = session.Offset
+	if err != nil {
+		return session.Offset, fmt.Errorf("failed to stage chunk: %w", err)
+	}
+	return session.Offset, nil
+}
+
+// stageUploadChunk stages a chunk of the chunked upload session, failing with
+// docker.ErrBlobUploadTooLarge once the session would stage more than the upload buffer limit
+func (s *DockerRegistryPrivateService) stageUploadChunk(ctx context.Context, session *UploadSession, data io.Reader) (int64, error) {
+	if s.maxUploadBuffer <= 0 {
+		return s.stageChunk(ctx, session, data)
+	}
+	s.sessionsMutex.RLock()
+	remaining := s.maxUploadBuffer - session.Offset
+	s.sessionsMutex.RUnlock()
+
+	limited := &uploadLimitReader{r: data, remaining: max(remaining, 0)}
+	offset, err := s.stageChunk(ctx, session, limited)
+	if limited.exceeded {
+		// The byte past the limit was received too
+		s.rejectOversize(ctx, session.Name, RejectUploadBuffer, offset+1)
+		return offset, docker.ErrBlobUploadTooLarge(fmt.Sprintf("upload exceeds the %d byte session buffer limit", s.maxUploadBuffer))
+	}
+	return offset, err
+}
+
+// completeStagedUpload appends finalChunk (if any) to the staged blob of session, then moves it to
+// its content key when staged in the content storage (see moveStagedBlob), or else streams it into
+// the content storage. The staged artifact is removed whether or not that succeeds.
+func (s *DockerRegistryPrivateService) completeStagedUpload(ctx context.Context, session *UploadSession, digest string, finalChunk io.Reader) error {
+	defer s.discardStaging(session)
+	if finalChunk != nil {
+		if _, err := s.stageUploadChunk(ctx, session, finalChunk); err != nil {
+			return err
+		}
+	} else if session.Offset == 0 {
+		return fmt.Errorf("no blob data provided")
+	}
+
+	if moved, err := s.moveStagedBlob(ctx, session, digest); moved || err != nil {
+		return err
+	}
+	rc, _, err := s.stagingStorage().Read(ctx, models.ArtifactRange{
+		Hash:  session.TempHash,
+		Range: models.ByteRange{Offset: 0, Length: session.Offset},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read staged upload: %w", err)
+	}
+	defer rc.Close()
+	return s.PutBlob(ctx, session.Name, digest, rc, session.Offset)
+}
+
+// moveStagedBlob completes an upload staged in the content storage by moving the staged artifact
+// to the blob's content key once its digest is verified, instead of copying it. moved is false,
+// with nothing done, when the blob must be streamed instead: staged in another storage, a storage
+// without MoveIfAbsentStorage, or a blob already stored (PutBlob merges the reference into it).
+func (s *DockerRegistryPrivateService) moveStagedBlob(ctx context.Context, session *UploadSession, digest string) (bool, error) {
+	mover, ok := s.storage.(storage.MoveIfAbsentStorage)
+	if s.staging != nil || !ok {
+		return false, nil
+	}
+	if err := s.validateContentKey(digest); err != nil {
+		return false, err
+	}
+	if err := s.checkDigestAlgorithm(digest); err != nil {
+		return false, err
+	}
+	name := session.Name
+	storageKey := s.getStorageKey(name, digest)
+	if _, err := s.storage.GetMeta(ctx, storageKey); err == nil {
+		return false, nil
+	}
+	defer s.gc.beginPush(name, storageKey)()
+
+	calculatedDigest, err := s.stagedDigest(ctx, session, docker.DigestAlgorithm(digest))
+	if err != nil {
+		return false, err
+	}
+	if calculatedDigest != digest {
+		return false, fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+	}
+
+	// The move fails rather than replace a blob stored concurrently, which PutBlob merges into
+	if err := mover.MoveIfAbsent(ctx, session.TempHash, storageKey); err != nil {
+		if errors.Is(err, storage.ErrDestinationExists) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to store blob: %w", err)
+	}
+	// Swap the staging reference for the blob reference, keeping any merged in since the move
+	now := time.Now().Unix()
+	_, err = storage.ModifyMeta(ctx, s.storage, storageKey, func(meta *models.ArtifactMeta) error {
+		refs := make([]models.ArtifactReference, 0, len(meta.References)+1)
+		for _, ref := range meta.References {
+			if ref.Name == stagingRef.Name && ref.Repo == stagingRef.Repo {
+				continue
+			}
+			refs = append(refs, ref)
+		}
+		meta.References = append(refs, models.ArtifactReference{Name: name, Repo: "blob", ReferencedTimestamp: now})
+		meta.Length = session.Offset
+		meta.CreatedTimestamp = now
+		meta.ExpiresTimestamp = s.expiresAt(name)
+		// Only sha256 digests are recorded, as integrity verification recomputes sha256
+		if s.recordContentDigests && docker.DigestAlgorithm(digest) == docker.DigestAlgorithmSHA256 {
+			meta.ContentDigest = digest
+		}
+		return nil
+	})
+	if err != nil {
+		// Drop the moved blob rather than leave it referenced by staging only
+		_, _ = s.storage.Delete(context.Background(), storageKey, stagingRef)
+		return false, fmt.Errorf("failed to update blob metadata: %w", err)
+	}
+
+	s.observeBlobUpload(session.Offset)
+	s.events.OnBlobPushed(ctx, events.Event{Repository: name, Digest: digest, Size: session.Offset, Timestamp: time.Now()})
+	return true, nil
+}
+
+// stagedDigest reads the staged blob of session back and returns its digest with algorithm
+func (s *DockerRegistryPrivateService) stagedDigest(ctx context.Context, session *UploadSession, algorithm string) (string, error) {
+	hasher := docker.NewDigestHasher(algorithm)
+	if hasher == nil {
+		return "", docker.ErrDigestInvalid(fmt.Sprintf("unsupported digest algorithm: %s", algorithm))
+	}
+	defer docker.ReleaseDigestHasher(algorithm, hasher)
+
+	rc, _, err := s.stagingStorage().Read(ctx, models.ArtifactRange{
+		Hash:  session.TempHash,
+		Range: models.ByteRange{Offset: 0, Length: session.Offset},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read staged upload: %w", err)
+	}
+	defer rc.Close()
+	if err := s.hashLimiter.Acquire(ctx); err != nil {
+		return "", err
+	}
+	defer s.hashLimiter.Release()
+	if _, err := storage.CopyBuffer(hasher, rc); err != nil {
+		return "", fmt.Errorf("failed to read staged upload: %w", err)
+	}
+	return algorithm + ":" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// PutBlobResumable stores a single-request upload like PutBlob. With a staging storage, the body is
+// staged first: if it ends early (the client was interrupted), the staged bytes are kept as an
+// upload session that ResumeBlobUpload hands out for the same repository and digest, so the
+// client can continue with the chunked flow from the last byte received.
+func (s *DockerRegistryPrivateService) PutBlobResumable(ctx context.Context, name, digest string, reader io.Reader, size int64) error {
+	if s.staging == nil || size == 0 {
+		return s.PutBlob(ctx, name, digest, reader, size)
+	}
+	uuid, err := s.StartBlobUpload(ctx, name)
+	if err != nil {
+		return err
+	}
+	s.sessionsMutex.Lock()
+	session := s.uploadSessions[uuid]
+	s.sessionsMutex.Unlock()
+
+	offset, err := s.stageChunk(ctx, session, reader)
+	if err != nil || size > 0 && offset < size {
+		if offset == 0 {
+			s.abortUpload(session)
+			if err == nil {
+				err = fmt.Errorf("no blob data provided")
+			}
+			return err
+		}
+		s.markResumable(session, digest)
+		return docker.ErrBlobUploadInvalid(fmt.Sprintf("upload of %s interrupted after %d bytes; resume it with ?resume=%s", digest, offset, digest))
+	}
+
+	s.sessionsMutex.Lock()
+	delete(s.uploadSessions, uuid)
+	s.sessionsMutex.Unlock()
+	return s.completeStagedUpload(ctx, session, digest, nil)
+}
+
+// ResumeBlobUpload returns the upload session holding an interrupted single-request upload of
+// digest to repository name and the bytes received, or starts a new session if there is none.
+// A session is handed out once.
+func (s *DockerRegistryPrivateService) ResumeBlobUpload(ctx context.Context, name, digest string) (string, int64, error) {
+	s.sessionsMutex.Lock()
+	for uuid, session := range s.uploadSessions {
+		if session.Name == name && session.ResumeDigest == digest {
+			session.ResumeDigest = ""
+			offset := session.Offset
+			s.sessionsMutex.Unlock()
+			return uuid, offset, nil
+		}
+	}
+	s.sessionsMutex.Unlock()
+
+	uuid, err := s.StartBlobUpload(ctx, name)
+	return uuid, 0, err
+}
+
+// markResumable makes session the resumable upload of digest, replacing an older one
+func (s *DockerRegistryPrivateService) markResumable(session *UploadSession, digest string) {
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+	for uuid, other := range s.uploadSessions {
+		if other != session && other.Name == session.Name && other.ResumeDigest == digest {
+			delete(s.uploadSessions, uuid)
+			s.discardStaging(other)
+		}
+	}
+	session.ResumeDigest = digest
+}
+
+// abortUpload ends session and discards its staged data
+func (s *DockerRegistryPrivateService) abortUpload(session *UploadSession) {
+	s.sessionsMutex.Lock()
+	delete(s.uploadSessions, session.UUID)
+	s.sessionsMutex.Unlock()
+	s.discardStaging(session)
+}
+
+// discardStaging removes the staged artifact of an ended session
+func (s *DockerRegistryPrivateService) discardStaging(session *UploadSession) {
+	_, _ = s.stagingStorage().Delete(context.Background(), session.TempHash, stagingRef)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// uploadLimitReader passes through remaining bytes, then fails once more are read
+type uploadLimitReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+// errUploadLimit stops a staged write at the upload buffer limit
+var errUploadLimit = errors.New("upload buffer limit exceeded")
+
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	// Read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		l.exceeded = true
+		return n, errUploadLimit
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
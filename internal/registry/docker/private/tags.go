@@ -0,0 +1,52 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// Tags returns the sorted tags of repository name, scanned from its reference mappings
+// (digest references are not tags). A known repository without tags yields an empty list;
+// a repository absent from the catalog yields a NAME_UNKNOWN error.
+func (s *DockerRegistryPrivateService) Tags(ctx context.Context, name string) ([]string, error) {
+	enumerable, ok := s.storage.(storage.EnumerableStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage does not support listing artifacts")
+	}
+
+	prefix := s.refKeyPrefix + url.QueryEscape(name) + ":"
+	tags := []string{}
+	err := enumerable.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if !strings.HasPrefix(meta.Hash, prefix) {
+			return nil
+		}
+		reference, err := url.QueryUnescape(strings.TrimPrefix(meta.Hash, prefix))
+		if err != nil || strings.Contains(reference, ":") {
+			return nil
+		}
+		tags = append(tags, reference)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan tags: %w", err)
+	}
+	if len(tags) == 0 {
+		names, err := s.Catalog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(names, name) {
+			return nil, docker.ErrNameUnknown(name)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
@@ -0,0 +1,130 @@
+package private
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// DefaultUploadSizeBuckets are the default upper bounds, in bytes, of the upload size histogram
+var DefaultUploadSizeBuckets = []int64{1 << 10, 64 << 10, 1 << 20, 16 << 20, 128 << 20, 1 << 30}
+
+// Reasons of oversize upload rejections
+const (
+	RejectUploadBuffer = "uploadBuffer" // A chunked upload exceeded the session buffer limit
+)
+
+// SizeHistogram counts sizes per bucket: Counts[i] counts sizes <= Buckets[i] (and above the
+// previous bound); the last count is for sizes above every bound
+type SizeHistogram struct {
+	Buckets []int64 `json:"buckets"`
+	Counts  []int64 `json:"counts"`
+	Count   int64   `json:"count"`
+	Sum     int64   `json:"sum"`
+}
+
+// UploadMetrics is a snapshot of the upload size metrics
+type UploadMetrics struct {
+	Blobs      SizeHistogram    `json:"blobs"`
+	Manifests  SizeHistogram    `json:"manifests"`
+	Rejections map[string]int64 `json:"rejections"` // Oversize rejections by reason
+}
+
+// uploadMetrics records the sizes of stored blobs and manifests and oversize rejections
+type uploadMetrics struct {
+	mu         sync.Mutex
+	blobs      SizeHistogram
+	manifests  SizeHistogram
+	rejections map[string]int64
+}
+
+// newUploadMetrics creates empty metrics with the given bucket bounds
+func newUploadMetrics(buckets []int64) *uploadMetrics {
+	return &uploadMetrics{
+		blobs:      newSizeHistogram(buckets),
+		manifests:  newSizeHistogram(buckets),
+		rejections: make(map[string]int64),
+	}
+}
+
+// newSizeHistogram creates an empty histogram with the given bucket bounds
+func newSizeHistogram(buckets []int64) SizeHistogram {
+	return SizeHistogram{Buckets: slices.Clone(buckets), Counts: make([]int64, len(buckets)+1)}
+}
+
+// observe counts size in h
+func (h *SizeHistogram) observe(size int64) {
+	i, _ := slices.BinarySearch(h.Buckets, size)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += size
+}
+
+// snapshot returns a copy of h
+func (h *SizeHistogram) snapshot() SizeHistogram {
+	return SizeHistogram{Buckets: slices.Clone(h.Buckets), Counts: slices.Clone(h.Counts), Count: h.Count, Sum: h.Sum}
+}
+
+// SetUploadSizeBuckets sets the upper bounds, in bytes, of the upload size histogram buckets
+// (strictly increasing and positive; nil restores DefaultUploadSizeBuckets) and resets the metrics
+func (s *DockerRegistryPrivateService) SetUploadSizeBuckets(buckets []int64) error {
+	if buckets == nil {
+		buckets = DefaultUploadSizeBuckets
+	}
+	for i, bound := range buckets {
+		if bound <= 0 || i > 0 && bound <= buckets[i-1] {
+			return fmt.Errorf("upload size buckets must be positive and strictly increasing: %v", buckets)
+		}
+	}
+	s.uploadMetrics = newUploadMetrics(buckets)
+	return nil
+}
+
+// UploadSizeBuckets returns the upper bounds of the upload size histogram buckets
+func (s *DockerRegistryPrivateService) UploadSizeBuckets() []int64 {
+	return slices.Clone(s.uploadMetrics.blobs.Buckets)
+}
+
+// UploadMetrics returns a snapshot of the sizes of stored blobs and manifests and the oversize
+// rejections since startup
+func (s *DockerRegistryPrivateService) UploadMetrics() UploadMetrics {
+	m := s.uploadMetrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rejections := make(map[string]int64, len(m.rejections))
+	for reason, n := range m.rejections {
+		rejections[reason] = n
+	}
+	return UploadMetrics{Blobs: m.blobs.snapshot(), Manifests: m.manifests.snapshot(), Rejections: rejections}
+}
+
+// observeBlobUpload records the size of a stored blob (unknown sizes are skipped)
+func (s *DockerRegistryPrivateService) observeBlobUpload(size int64) {
+	if size < 0 {
+		return
+	}
+	m := s.uploadMetrics
+	m.mu.Lock()
+	m.blobs.observe(size)
+	m.mu.Unlock()
+}
+
+// observeManifestUpload records the size of a stored manifest
+func (s *DockerRegistryPrivateService) observeManifestUpload(size int64) {
+	m := s.uploadMetrics
+	m.mu.Lock()
+	m.manifests.observe(size)
+	m.mu.Unlock()
+}
+
+// rejectOversize counts and logs an upload to repository name rejected for reason; size is the
+// number of bytes received when it was rejected
+func (s *DockerRegistryPrivateService) rejectOversize(ctx context.Context, name, reason string, size int64) {
+	m := s.uploadMetrics
+	m.mu.Lock()
+	m.rejections[reason]++
+	m.mu.Unlock()
+	slog.InfoContext(ctx, "oversize upload rejected", "repository", name, "size", size, "reason", reason)
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+)
+
+// blobIndex records, per repository, the config and layer digests of the manifests served
+// for it, so blob pulls can be limited to content of manifests the client could pull.
+// All methods are safe to call on nil (enforcement disabled).
+type blobIndex struct {
+	digests map[string]map[string]bool // Repository name -> referenced blob digests
+	mu      sync.RWMutex
+}
+
+// newBlobIndex creates an empty blob reference index
+func newBlobIndex() *blobIndex {
+	return &blobIndex{digests: make(map[string]map[string]bool)}
+}
+
+// addManifest records the blobs referenced by a manifest served for repository name.
+// Indexes reference no blobs directly; their child manifests are recorded when pulled.
+func (b *blobIndex) addManifest(name string, data []byte) {
+	if b == nil {
+		return
+	}
+	manifest, err := docker.ParseManifest(data)
+	if err != nil {
+		return
+	}
+	var digests []string
+	if manifest.Config != nil {
+		digests = append(digests, manifest.Config.Digest)
+	}
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	if len(digests) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	repo := b.digests[name]
+	if repo == nil {
+		repo = make(map[string]bool)
+		b.digests[name] = repo
+	}
+	for _, digest := range digests {
+		repo[digest] = true
+	}
+}
+
+// allows reports whether digest may be served for repository name
+func (b *blobIndex) allows(name, digest string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.digests[name][digest]
+}
+
+// SetRequireManifestReference makes blob requests succeed only for blobs referenced by a manifest
+// previously served for the same repository, so the proxy can't be used as an arbitrary blob CDN.
+// Other blobs are reported as unknown. Off by default. The index is kept in memory, so after a
+// restart clients must pull the manifest again (as they do on every pull) before its blobs.
+func (s *DockerRegistryProxyService) SetRequireManifestReference(enabled bool) {
+	if !enabled {
+		s.blobIndex = nil
+		return
+	}
+	if s.blobIndex == nil {
+		s.blobIndex = newBlobIndex()
+	}
+}
+
+// RequireManifestReference reports whether blobs are only served when referenced by a served manifest
+func (s *DockerRegistryProxyService) RequireManifestReference() bool {
+	return s.blobIndex != nil
+}
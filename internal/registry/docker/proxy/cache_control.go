@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// noCacheKey is the context key marking requests that must bypass the cache
+type noCacheKey struct{}
+
+// WithNoCache returns a context whose requests bypass cached entries and fetch from upstream.
+// Fetched content still refreshes the cache unless the repository is configured as no-cache.
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// noCacheRequested reports whether ctx was marked with WithNoCache
+func noCacheRequested(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheKey{}).(bool)
+	return noCache
+}
+
+// requestsNoCache reports whether the request carries a Cache-Control: no-cache directive
+func requestsNoCache(r *http.Request) bool {
+	for _, value := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetNoCacheRepositories sets the repositories that are always proxied without caching:
+// their content is neither served from nor stored in the cache
+func (s *DockerRegistryProxyService) SetNoCacheRepositories(names []string) {
+	repos := make(map[string]bool, len(names))
+	for _, name := range names {
+		repos[name] = true
+	}
+	s.noCacheRepos = repos
+}
+
+// NoCacheRepositories returns the sorted repositories that are never cached
+func (s *DockerRegistryProxyService) NoCacheRepositories() []string {
+	names := make([]string, 0, len(s.noCacheRepos))
+	for name := range s.noCacheRepos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bypassCache reports whether a request for repository name must skip cached entries
+func (s *DockerRegistryProxyService) bypassCache(ctx context.Context, name string) bool {
+	return noCacheRequested(ctx) || s.noCacheRepos[name]
+}
+
+// cacheable reports whether content of repository name may be stored in the cache
+func (s *DockerRegistryProxyService) cacheable(name string) bool {
+	return !s.noCacheRepos[name]
+}
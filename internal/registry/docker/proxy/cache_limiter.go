@@ -0,0 +1,61 @@
+package proxy
+
+import "sync/atomic"
+
+// cacheLimiter caps the number of concurrent background cache writes.
+// Acquiring never waits: when every slot is taken the blob is streamed without caching.
+// All methods are safe to call on a nil limiter (no limit).
+type cacheLimiter struct {
+	slots   chan struct{}
+	active  atomic.Int64
+	peak    atomic.Int64
+	skipped atomic.Int64
+}
+
+// newCacheLimiter creates a limiter allowing size concurrent cache writes.
+// Returns nil if size <= 0 (unlimited).
+func newCacheLimiter(size int) *cacheLimiter {
+	if size <= 0 {
+		return nil
+	}
+	return &cacheLimiter{slots: make(chan struct{}, size)}
+}
+
+// tryAcquire takes a free slot; returns false (counting a skipped write) if none is free
+func (l *cacheLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		l.skipped.Add(1)
+		return false
+	}
+
+	active := l.active.Add(1)
+	for {
+		peak := l.peak.Load()
+		if active <= peak || l.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	return true
+}
+
+// release frees a slot taken by tryAcquire
+func (l *cacheLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.active.Add(-1)
+	<-l.slots
+}
+
+// size returns the maximum number of concurrent cache writes (0 means unlimited)
+func (l *cacheLimiter) size() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
@@ -5,31 +5,79 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/basakil/brm-server/pkg/models"
+	"github.com/basakil/brm-server/pkg/version"
 )
 
+// DefaultUserAgent is the User-Agent sent on upstream requests unless overridden
+var DefaultUserAgent = "brm-server/" + version.Version
+
 // DockerRegistryProxyClient handles HTTP communication with upstream Docker registries
 type DockerRegistryProxyClient struct {
 	baseURL    string
 	username   string
 	password   string
+	userAgent  string
+	library    bool // Prepend "library/" to single-segment names (see UpstreamRegistry.NormalizeLibrary)
 	httpClient *http.Client
 }
 
+// dockerHubHosts are the registry hosts of Docker Hub, whose official images live under library/
+var dockerHubHosts = map[string]bool{
+	"docker.io":               true,
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
 // NewDockerRegistryProxyClient creates a new client for upstream registry communication
 func NewDockerRegistryProxyClient(upstream *models.UpstreamRegistry) *DockerRegistryProxyClient {
 	return &DockerRegistryProxyClient{
-		baseURL:  upstream.URL,
-		username: upstream.Username,
-		password: upstream.Password,
+		baseURL:   upstream.URL,
+		username:  upstream.Username,
+		password:  upstream.Password,
+		userAgent: DefaultUserAgent,
+		library:   normalizesLibrary(upstream),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
 }
 
+// normalizesLibrary reports whether requests to upstream prepend "library/" to single-segment names
+func normalizesLibrary(upstream *models.UpstreamRegistry) bool {
+	if upstream.NormalizeLibrary != nil {
+		return *upstream.NormalizeLibrary
+	}
+	parsed, err := url.Parse(upstream.URL)
+	return err == nil && dockerHubHosts[strings.ToLower(parsed.Hostname())]
+}
+
+// upstreamName returns the repository name of name upstream
+func (c *DockerRegistryProxyClient) upstreamName(name string) string {
+	if c.library && !strings.Contains(name, "/") {
+		return "library/" + name
+	}
+	return name
+}
+
+// SetUserAgent sets the User-Agent header sent on upstream requests (empty restores the default)
+func (c *DockerRegistryProxyClient) SetUserAgent(userAgent string) {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	c.userAgent = userAgent
+}
+
+// UserAgent returns the User-Agent header sent on upstream requests
+func (c *DockerRegistryProxyClient) UserAgent() string {
+	return c.userAgent
+}
+
 // makeRequest makes an HTTP request to the upstream registry with authentication
 func (c *DockerRegistryProxyClient) makeRequest(ctx context.Context, method, path string, headers map[string]string) (*http.Response, error) {
 	url := c.baseURL + path
@@ -38,6 +86,8 @@ func (c *DockerRegistryProxyClient) makeRequest(ctx context.Context, method, pat
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	req.Header.Set("User-Agent", c.userAgent)
+
 	// Add authentication if credentials are provided
 	if c.username != "" && c.password != "" {
 		req.SetBasicAuth(c.username, c.password)
@@ -63,7 +113,7 @@ func (c *DockerRegistryProxyClient) makeRequest(ctx context.Context, method, pat
 
 // GetManifest fetches a manifest from the upstream registry
 func (c *DockerRegistryProxyClient) GetManifest(ctx context.Context, name, reference string) ([]byte, string, error) {
-	path := fmt.Sprintf("/v2/%s/manifests/%s", name, reference)
+	path := fmt.Sprintf("/v2/%s/manifests/%s", c.upstreamName(name), reference)
 	resp, err := c.makeRequest(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, "", err
@@ -84,15 +134,19 @@ func (c *DockerRegistryProxyClient) GetManifest(ctx context.Context, name, refer
 	return body, mediaType, nil
 }
 
-// CheckManifestExists checks if a manifest exists in the upstream registry
+// CheckManifestExists checks if a manifest exists in the upstream registry.
+// Server errors (5xx) are returned as errors rather than not-found.
 func (c *DockerRegistryProxyClient) CheckManifestExists(ctx context.Context, name, reference string) (bool, string, error) {
-	path := fmt.Sprintf("/v2/%s/manifests/%s", name, reference)
+	path := fmt.Sprintf("/v2/%s/manifests/%s", c.upstreamName(name), reference)
 	resp, err := c.makeRequest(ctx, http.MethodHead, path, nil)
 	if err != nil {
 		return false, "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return false, "", fmt.Errorf("upstream registry returned status %d", resp.StatusCode)
+	}
 	if resp.StatusCode == http.StatusOK {
 		digest := resp.Header.Get("Docker-Content-Digest")
 		return true, digest, nil
@@ -103,7 +157,7 @@ func (c *DockerRegistryProxyClient) CheckManifestExists(ctx context.Context, nam
 
 // GetBlob fetches a blob from the upstream registry
 func (c *DockerRegistryProxyClient) GetBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, error) {
-	path := fmt.Sprintf("/v2/%s/blobs/%s", name, digest)
+	path := fmt.Sprintf("/v2/%s/blobs/%s", c.upstreamName(name), digest)
 	resp, err := c.makeRequest(ctx, http.MethodGet, path, nil)
 	if err != nil {
 		return nil, 0, err
@@ -117,18 +171,37 @@ func (c *DockerRegistryProxyClient) GetBlob(ctx context.Context, name, digest st
 	return resp.Body, resp.ContentLength, nil
 }
 
-// CheckBlobExists checks if a blob exists in the upstream registry
+// CheckBlobExists checks if a blob exists in the upstream registry.
+// Server errors (5xx) are returned as errors rather than not-found.
 func (c *DockerRegistryProxyClient) CheckBlobExists(ctx context.Context, name, digest string) (bool, int64, error) {
-	path := fmt.Sprintf("/v2/%s/blobs/%s", name, digest)
+	path := fmt.Sprintf("/v2/%s/blobs/%s", c.upstreamName(name), digest)
 	resp, err := c.makeRequest(ctx, http.MethodHead, path, nil)
 	if err != nil {
 		return false, 0, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return false, 0, fmt.Errorf("upstream registry returned status %d", resp.StatusCode)
+	}
 	if resp.StatusCode == http.StatusOK {
 		return true, resp.ContentLength, nil
 	}
 
 	return false, 0, nil
 }
+
+// Ping checks that the upstream registry answers its API version endpoint.
+// Any non-5xx response (including 401 for registries requiring auth) counts as reachable.
+func (c *DockerRegistryProxyClient) Ping(ctx context.Context) error {
+	resp, err := c.makeRequest(ctx, http.MethodHead, "/v2/", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream registry returned status %d", resp.StatusCode)
+	}
+	return nil
+}
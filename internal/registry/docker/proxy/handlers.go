@@ -1,37 +1,72 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/basakil/brm-server/internal/registry/docker"
 )
 
 // SetupRoutes configures HTTP routes for Docker registry API endpoints
 func SetupRoutes(mux *http.ServeMux, service *DockerRegistryProxyService) {
+	// Routes with a {name} wildcard reject over-long paths and names
+	limits := service.NameLimits()
+
 	// API version check
 	mux.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
 		handleAPIVersion(w, r)
 	})
 
 	// Manifest endpoints
-	mux.HandleFunc("GET /v2/{name}/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+	// JSON documents may be gzip-compressed; blob bodies are served as-is
+	mux.HandleFunc("GET /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, docker.GzipHandler(service.CompressionConfig(), func(w http.ResponseWriter, r *http.Request) {
 		handleGetManifest(w, r, service)
-	})
-	mux.HandleFunc("HEAD /v2/{name}/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.HandleFunc("HEAD /v2/{name}/manifests/{reference}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleHeadManifest(w, r, service)
-	})
+	}))
 
 	// Blob endpoints
-	mux.HandleFunc("GET /v2/{name}/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /v2/{name}/blobs/{digest}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleGetBlob(w, r, service)
-	})
-	mux.HandleFunc("HEAD /v2/{name}/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("HEAD /v2/{name}/blobs/{digest}", docker.NameLimitHandler(limits, func(w http.ResponseWriter, r *http.Request) {
 		handleHeadBlob(w, r, service)
-	})
+	}))
+}
+
+// requestContext returns the request context, marked with WithNoCache for Cache-Control: no-cache requests
+// and with the media types the client accepts
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if requestsNoCache(r) {
+		ctx = WithNoCache(ctx)
+	}
+	if accept := r.Header.Values("Accept"); len(accept) > 0 {
+		ctx = WithAcceptedMediaTypes(ctx, accept)
+	}
+	return ctx
+}
+
+// setStaleWarning sets the StaleWarning header if the response is served from an expired cache entry
+func setStaleWarning(w http.ResponseWriter, stale *atomic.Bool) {
+	if stale.Load() {
+		w.Header().Set("Warning", StaleWarning)
+	}
+}
+
+// upstreamError returns the error response of a failed request: 504 when the upstream operation
+// timed out (see SetUpstreamTimeout), fallback otherwise
+func upstreamError(err error, fallback *docker.RegistryError) *docker.RegistryError {
+	if errors.Is(err, errUpstreamTimeout) {
+		return docker.ErrGatewayTimeout(err.Error())
+	}
+	return fallback
 }
 
 // handleAPIVersion handles GET /v2/ - API version check
@@ -58,18 +93,17 @@ func handleGetManifest(w http.ResponseWriter, r *http.Request, service *DockerRe
 		return
 	}
 
-	manifestData, mediaType, err := service.GetManifest(r.Context(), name, reference)
+	ctx, stale := withStaleReport(requestContext(r))
+	manifestData, mediaType, digest, err := service.GetManifestWithDigest(ctx, name, reference)
 	if err != nil {
-		docker.WriteError(w, docker.ErrManifestUnknown(reference))
+		docker.WriteError(w, upstreamError(err, docker.ErrManifestUnknown(reference)))
 		return
 	}
+	setStaleWarning(w, stale)
 
 	// Set headers per OCI Distribution Spec
 	w.Header().Set("Content-Type", mediaType)
 	w.Header().Set("Content-Length", strconv.Itoa(len(manifestData)))
-
-	// Calculate and set Docker-Content-Digest
-	digest := service.CalculateDigest(manifestData)
 	w.Header().Set("Docker-Content-Digest", digest)
 
 	w.WriteHeader(http.StatusOK)
@@ -89,9 +123,10 @@ func handleHeadManifest(w http.ResponseWriter, r *http.Request, service *DockerR
 		return
 	}
 
-	exists, digest, err := service.CheckManifestExists(r.Context(), name, reference)
+	ctx, stale := withStaleReport(requestContext(r))
+	exists, digest, err := service.CheckManifestExists(ctx, name, reference)
 	if err != nil {
-		docker.WriteError(w, docker.ErrManifestUnknown(reference))
+		docker.WriteError(w, upstreamError(err, docker.ErrManifestUnknown(reference)))
 		return
 	}
 
@@ -99,6 +134,7 @@ func handleHeadManifest(w http.ResponseWriter, r *http.Request, service *DockerR
 		docker.WriteError(w, docker.ErrManifestUnknown(reference))
 		return
 	}
+	setStaleWarning(w, stale)
 
 	// Set headers
 	w.Header().Set("Docker-Content-Digest", digest)
@@ -118,20 +154,30 @@ func handleGetBlob(w http.ResponseWriter, r *http.Request, service *DockerRegist
 		return
 	}
 
-	blobReader, size, err := service.GetBlob(r.Context(), name, digest)
+	ctx, stale := withStaleReport(requestContext(r))
+	if serveBlobNotModified(ctx, w, r, service, name, digest, stale) {
+		return
+	}
+	blobReader, size, err := service.GetBlob(ctx, name, digest)
 	if err != nil {
-		docker.WriteError(w, docker.ErrBlobUnknown(digest))
+		docker.WriteError(w, upstreamError(err, docker.ErrBlobUnknown(digest)))
 		return
 	}
-	defer blobReader.Close()
+	setStaleWarning(w, stale)
 
 	// Set headers
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Docker-Content-Digest", digest)
+	if service.BlobETags() {
+		w.Header().Set("ETag", docker.BlobETag(digest))
+	}
 
 	w.WriteHeader(http.StatusOK)
-	io.Copy(w, blobReader)
+
+	// Stream the body; StreamBlob stops and closes the reader as soon as the client
+	// disconnects, so abandoned transfers don't pin upstream or cache resources
+	docker.StreamBlob(r.Context(), w, blobReader)
 }
 
 // handleHeadBlob handles HEAD /v2/{name}/blobs/{digest}
@@ -147,9 +193,13 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request, service *DockerRegis
 		return
 	}
 
-	exists, size, err := service.CheckBlobExists(r.Context(), name, digest)
+	ctx, stale := withStaleReport(requestContext(r))
+	if serveBlobNotModified(ctx, w, r, service, name, digest, stale) {
+		return
+	}
+	exists, size, err := service.CheckBlobExists(ctx, name, digest)
 	if err != nil {
-		docker.WriteError(w, docker.ErrBlobUnknown(digest))
+		docker.WriteError(w, upstreamError(err, docker.ErrBlobUnknown(digest)))
 		return
 	}
 
@@ -157,13 +207,33 @@ func handleHeadBlob(w http.ResponseWriter, r *http.Request, service *DockerRegis
 		docker.WriteError(w, docker.ErrBlobUnknown(digest))
 		return
 	}
+	setStaleWarning(w, stale)
 
 	// Set headers
 	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	w.Header().Set("Docker-Content-Digest", digest)
+	if service.BlobETags() {
+		w.Header().Set("ETag", docker.BlobETag(digest))
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// serveBlobNotModified answers 304 when blob ETags are enabled and the blob can be served
+// (from cache or upstream) to a client that already has it
+func serveBlobNotModified(ctx context.Context, w http.ResponseWriter, r *http.Request, service *DockerRegistryProxyService, name, digest string, stale *atomic.Bool) bool {
+	if !service.BlobETags() {
+		return false
+	}
+	return docker.ServeBlobNotModified(w, r, digest, func() bool {
+		exists, _, err := service.CheckBlobExists(ctx, name, digest)
+		if err != nil || !exists {
+			return false
+		}
+		setStaleWarning(w, stale)
+		return true
+	})
+}
+
 // parseManifestPath extracts name and reference from /v2/{name}/manifests/{reference}
 func parseManifestPath(path string) (string, string, error) {
 	// Remove /v2/ prefix
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// LayerCodec re-encodes gzip-compressed layers for SetLayerRecompression
+type LayerCodec interface {
+	// Name identifies the codec in configuration
+	Name() string
+
+	// MediaType returns the media type of the re-encoded variant of a layer of the given media type
+	MediaType(source string) string
+
+	// NewWriter returns a writer encoding the uncompressed layer tar to w
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// GzipLayerCodec re-encodes layers with gzip at Level (0 = gzip.BestCompression). The media type is
+// unchanged, so every client is served the variant; useful when upstream compresses poorly.
+type GzipLayerCodec struct {
+	Level int
+}
+
+// Name implements LayerCodec
+func (c GzipLayerCodec) Name() string {
+	return "gzip"
+}
+
+// MediaType implements LayerCodec
+func (c GzipLayerCodec) MediaType(source string) string {
+	return source
+}
+
+// NewWriter implements LayerCodec
+func (c GzipLayerCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.BestCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewLayerCodec returns the built-in codec with the given name
+func NewLayerCodec(name string) (LayerCodec, error) {
+	switch name {
+	case "gzip":
+		return GzipLayerCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported layer recompression codec: %s", name)
+	}
+}
+
+// recompressedRepo marks the references linking a recompressed variant (blob or manifest) to the
+// digest of its original. Variants can't be fetched from upstream, so they never expire.
+const recompressedRepo = "recompressed"
+
+// variantKeyPrefix starts the digests of mappings from an original layer to its recompressed variant
+const variantKeyPrefix = "layer-variant:"
+
+// acceptKey is the context key carrying the media types a client accepts
+type acceptKey struct{}
+
+// WithAcceptedMediaTypes returns a context recording the media types listed in Accept header values,
+// which decide whether recompressed layers with a new media type may be served
+func WithAcceptedMediaTypes(ctx context.Context, accept []string) context.Context {
+	accepted := make(map[string]bool)
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			if mediaType := strings.TrimSpace(strings.Split(part, ";")[0]); mediaType != "" {
+				accepted[mediaType] = true
+			}
+		}
+	}
+	return context.WithValue(ctx, acceptKey{}, accepted)
+}
+
+// acceptsMediaType reports whether ctx was marked as accepting mediaType
+func acceptsMediaType(ctx context.Context, mediaType string) bool {
+	accepted, _ := ctx.Value(acceptKey{}).(map[string]bool)
+	return accepted[mediaType]
+}
+
+// SetLayerRecompression makes the proxy store a variant of each cached gzip layer re-encoded with
+// codec (nil disables), when it is smaller than the original. Clients get manifests pointing at the
+// variants (with their media types, digests and sizes) once cached, provided the variant keeps the
+// layer's media type or the manifest request's Accept header lists the variant's; others get the original.
+// Manifests pulled by digest and image indexes are served unchanged, as their digests must match.
+func (s *DockerRegistryProxyService) SetLayerRecompression(codec LayerCodec) {
+	s.layerCodec = codec
+}
+
+// LayerRecompression returns the layer recompression codec (nil when disabled)
+func (s *DockerRegistryProxyService) LayerRecompression() LayerCodec {
+	return s.layerCodec
+}
+
+// isRecompressed reports whether meta belongs to a recompressed variant
+func isRecompressed(meta *models.ArtifactMeta) bool {
+	for _, ref := range meta.References {
+		if ref.Repo == recompressedRepo {
+			return true
+		}
+	}
+	return false
+}
+
+// servableFromCache reports whether a cached entry of repository name may be served without upstream
+func (s *DockerRegistryProxyService) servableFromCache(ctx context.Context, name string, meta *models.ArtifactMeta) bool {
+	if meta == nil {
+		return false
+	}
+	return isRecompressed(meta) || (!s.isCacheExpired(meta) && !s.bypassCache(ctx, name))
+}
+
+// recompressLayer stores the recompressed variant of a cached blob of repository name, if it is a
+// gzip layer without a variant yet and re-encoding makes it smaller. Failures leave no variant.
+func (s *DockerRegistryProxyService) recompressLayer(ctx context.Context, name, digest string) error {
+	mappingKey := s.getCacheKey(name, variantKeyPrefix+digest)
+	if _, err := s.storage.GetMeta(ctx, mappingKey); err == nil {
+		return nil
+	}
+	cacheKey := s.getCacheKey(name, digest)
+	rc, original, err := s.storage.Read(ctx, models.ArtifactRange{Hash: cacheKey, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		return fmt.Errorf("failed to read cached layer: %w", err)
+	}
+	defer rc.Close()
+	layer, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil // Not a gzip layer (e.g. an image config)
+	}
+
+	// Encode to a temporary file first: the storage key is the digest of the encoded content
+	tmp, err := os.CreateTemp("", "brm-layer-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hasher := sha256.New()
+	encoder, err := s.layerCodec.NewWriter(io.MultiWriter(tmp, hasher))
+	if err != nil {
+		return fmt.Errorf("failed to create layer encoder: %w", err)
+	}
+	if _, err := io.Copy(encoder, layer); err != nil {
+		return fmt.Errorf("failed to recompress layer: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to recompress layer: %w", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to recompress layer: %w", err)
+	}
+	if size >= original.Range.Length {
+		return nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to recompress layer: %w", err)
+	}
+
+	now := time.Now().Unix()
+	variantDigest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	variantKey := s.getCacheKey(name, variantDigest)
+	_, err = s.storage.Create(ctx, variantKey, tmp, size, &models.ArtifactMeta{
+		Hash:             variantKey,
+		Length:           size,
+		CreatedTimestamp: now,
+		References: []models.ArtifactReference{
+			{Name: name, Repo: "blob", ReferencedTimestamp: now},
+			{Name: digest, Repo: recompressedRepo, ReferencedTimestamp: now},
+		},
+	})
+	if err != nil && !isHashConflict(err) {
+		return fmt.Errorf("failed to store recompressed layer: %w", err)
+	}
+
+	// The mapping is written last, so it only ever points at a stored variant
+	_, err = s.storage.Create(ctx, mappingKey, bytes.NewReader(nil), 0, &models.ArtifactMeta{
+		Hash:             mappingKey,
+		Length:           0,
+		CreatedTimestamp: now,
+		References:       []models.ArtifactReference{{Name: variantDigest, Repo: recompressedRepo, ReferencedTimestamp: now}},
+	})
+	if err != nil && !isHashConflict(err) {
+		return fmt.Errorf("failed to record recompressed layer: %w", err)
+	}
+	return nil
+}
+
+// layerVariant returns the digest and size of the stored recompressed variant of a layer
+func (s *DockerRegistryProxyService) layerVariant(ctx context.Context, name, digest string) (string, int64, bool) {
+	mapping, err := s.storage.GetMeta(ctx, s.getCacheKey(name, variantKeyPrefix+digest))
+	if err != nil {
+		return "", 0, false
+	}
+	for _, ref := range mapping.References {
+		if ref.Repo != recompressedRepo {
+			continue
+		}
+		if meta, err := s.storage.GetMeta(ctx, s.getCacheKey(name, ref.Name)); err == nil {
+			return ref.Name, meta.Length, true
+		}
+	}
+	return "", 0, false
+}
+
+// recompressManifest rewrites the layers of a manifest pulled by tag to their recompressed variants
+// the client may be served, stores the result under its own digest (so it can be pulled by digest)
+// and returns it. Without a servable variant the manifest is returned unchanged.
+func (s *DockerRegistryProxyService) recompressManifest(ctx context.Context, name, reference string, data []byte, mediaType, digest string) ([]byte, string) {
+	if s.layerCodec == nil || isDigestReference(reference) || !s.cacheable(name) {
+		return data, digest
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return data, digest
+	}
+	var layers []map[string]json.RawMessage
+	if err := json.Unmarshal(document["layers"], &layers); err != nil || len(layers) == 0 {
+		return data, digest
+	}
+
+	changed := false
+	for _, layer := range layers {
+		var layerType, layerDigest string
+		if json.Unmarshal(layer["mediaType"], &layerType) != nil || json.Unmarshal(layer["digest"], &layerDigest) != nil {
+			continue
+		}
+		if layerType != docker.MediaTypeLayer && layerType != docker.MediaTypeOCILayer {
+			continue
+		}
+		variantType := s.layerCodec.MediaType(layerType)
+		if variantType != layerType && !acceptsMediaType(ctx, variantType) {
+			continue
+		}
+		variantDigest, size, ok := s.layerVariant(ctx, name, layerDigest)
+		if !ok {
+			continue
+		}
+		layer["mediaType"], _ = json.Marshal(variantType)
+		layer["digest"], _ = json.Marshal(variantDigest)
+		layer["size"], _ = json.Marshal(size)
+		changed = true
+	}
+	if !changed {
+		return data, digest
+	}
+
+	var err error
+	if document["layers"], err = json.Marshal(layers); err != nil {
+		return data, digest
+	}
+	rewritten, err := json.Marshal(document)
+	if err != nil {
+		return data, digest
+	}
+	rewrittenDigest := s.calculateDigest(rewritten)
+	cacheKey := s.getCacheKey(name, rewrittenDigest)
+	if _, err := s.storage.GetMeta(ctx, cacheKey); err != nil {
+		now := time.Now().Unix()
+		_, err := s.storage.Create(ctx, cacheKey, bytes.NewReader(rewritten), int64(len(rewritten)), &models.ArtifactMeta{
+			Hash:             cacheKey,
+			Length:           int64(len(rewritten)),
+			CreatedTimestamp: now,
+			References: []models.ArtifactReference{
+				{Name: name, Repo: "manifest", ReferencedTimestamp: now},
+				{Name: digest, Repo: recompressedRepo, ReferencedTimestamp: now},
+			},
+		})
+		if err != nil && !isHashConflict(err) {
+			// Not pullable by digest without the stored copy
+			return data, digest
+		}
+	}
+	s.manifestCache.Add(s.getManifestCacheKey(name, rewrittenDigest), &docker.CachedManifest{
+		Data:      rewritten,
+		MediaType: mediaType,
+		Digest:    rewrittenDigest,
+	})
+	return rewritten, rewrittenDigest
+}
+
+// getRecompressedManifest returns a stored recompressed manifest of repository name by digest
+func (s *DockerRegistryProxyService) getRecompressedManifest(ctx context.Context, name, digest string) ([]byte, string, bool) {
+	cacheKey := s.getCacheKey(name, digest)
+	meta, err := s.storage.GetMeta(ctx, cacheKey)
+	if err != nil || !isRecompressed(meta) {
+		return nil, "", false
+	}
+	data, ok := s.readCachedManifest(ctx, cacheKey)
+	if !ok {
+		return nil, "", false
+	}
+	mediaType := docker.MediaTypeOCIManifest
+	if manifest, err := docker.ParseManifest(data); err == nil && manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+	return data, mediaType, true
+}
+
+// isHashConflict reports whether err is a *models.HashConflictError
+func isHashConflict(err error) bool {
+	_, ok := err.(*models.HashConflictError)
+	return ok
+}
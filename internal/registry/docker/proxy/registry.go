@@ -3,6 +3,7 @@ package proxy
 import (
 	"fmt"
 	"net"
+	"net/http"
 
 	"github.com/basakil/brm-server/pkg/models"
 
@@ -91,3 +92,10 @@ func (d *DockerRegistryProxy) GetServiceBinding() net.Addr {
 func (d *DockerRegistryProxy) GetCacheTTL() int64 {
 	return d.cacheTTL
 }
+
+// Handlers returns a handler serving the registry's HTTP routes
+func (d *DockerRegistryProxy) Handlers() http.Handler {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, d.service)
+	return mux
+}
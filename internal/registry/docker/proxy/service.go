@@ -5,41 +5,78 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/storage"
 	"github.com/basakil/brm-server/pkg/models"
 )
 
 // DockerRegistryProxyService handles core registry logic: cache management and upstream communication
 type DockerRegistryProxyService struct {
 	storage        models.ArtifactStorage
-	client         *DockerRegistryProxyClient
+	client         *upstreamSet // Primary upstream followed by its mirrors
 	cacheTTL       time.Duration
 	upstreamConfig *models.UpstreamRegistry
+	compression    docker.CompressionConfig
+	nameLimits     docker.NameLimits     // Limits on request path and repository name lengths
+	manifestCache  *docker.ManifestCache // Optional in-memory cache of manifests by digest
+	revalidate     bool                  // Treat every cached entry as expired (always check upstream)
+	tagCache       *tagCache             // Optional short-lived tag -> digest resolutions
+	events         events.EventSink      // Notified when upstream content is cached (never nil)
+	cacheWrites    *cacheLimiter         // Optional cap on concurrent background blob cache writes
+	noCacheRepos   map[string]bool       // Repositories proxied without caching
+	keyStrategy    docker.KeyStrategy    // How cached content is keyed in storage
+	blobIndex      *blobIndex            // Optional blobs referenced by served manifests, per repository
+	layerCodec     LayerCodec            // Optional re-encoding of cached layers (nil = disabled)
+	readyUpstream  bool                  // Whether readiness requires a reachable upstream
+	serveStale     bool                  // Serve expired cache entries when upstream fails
+	blobETags      bool                  // Set blob ETags and honor If-None-Match on blob requests
+	refresher      *tagRefresher         // Optional proactive refresh of popular tags
+	tagMappings    bool                  // Record tag -> digest mappings in storage (see SetTagMappings)
 }
 
+// Cache TTL semantics (seconds) for NewDockerRegistryProxyService:
+//   - > 0: cached entries expire after the given number of seconds
+//   - 0: default TTL (DefaultCacheTTL)
+//   - CacheTTLNeverExpire (< 0): cached entries never expire
+//
+// "Always revalidate" is a separate mode enabled with SetRevalidateAlways.
+const (
+	DefaultCacheTTL     = 168 * time.Hour // 7 days
+	CacheTTLNeverExpire = -1
+)
+
 // NewDockerRegistryProxyService creates a new Docker registry service
 func NewDockerRegistryProxyService(
 	storageAlias string,
 	upstream *models.UpstreamRegistry,
 	cacheTTL int64,
 ) (*DockerRegistryProxyService, error) {
-	// Create upstream client
-	client := NewDockerRegistryProxyClient(upstream)
+	// Create upstream clients (primary + mirrors)
+	client := newUpstreamSet(upstream)
 
-	// Determine cache TTL
-	ttl := 168 * time.Hour // Default 7 days
+	// Determine cache TTL (0 = no expiration)
+	ttl := DefaultCacheTTL
 	if cacheTTL > 0 {
 		ttl = time.Duration(cacheTTL) * time.Second
+	} else if cacheTTL < 0 {
+		ttl = 0
 	}
 
 	return &DockerRegistryProxyService{
 		client:         client,
 		cacheTTL:       ttl,
 		upstreamConfig: upstream,
+		events:         events.NopSink{},
+		keyStrategy:    docker.KeyByDigest,
 	}, nil
 }
 
@@ -48,15 +85,176 @@ func (s *DockerRegistryProxyService) SetStorage(storage models.ArtifactStorage)
 	s.storage = storage
 }
 
-// getCacheKey generates a cache key for a manifest or blob
+// UpstreamServedCounts returns how many requests each upstream (by base URL) has served
+func (s *DockerRegistryProxyService) UpstreamServedCounts() map[string]int64 {
+	return s.client.servedCounts()
+}
+
+// SetUserAgent sets the User-Agent header sent to the upstream and its mirrors
+func (s *DockerRegistryProxyService) SetUserAgent(userAgent string) {
+	s.client.setUserAgent(userAgent)
+}
+
+// UserAgent returns the User-Agent header sent to the upstream
+func (s *DockerRegistryProxyService) UserAgent() string {
+	return s.client.clients[0].UserAgent()
+}
+
+// SetUpstreamReadinessCheck makes the registry report not ready while no upstream (primary or mirror)
+// is reachable. Off by default, as cached content can still be served without upstream.
+func (s *DockerRegistryProxyService) SetUpstreamReadinessCheck(enabled bool) {
+	s.readyUpstream = enabled
+}
+
+// UpstreamReadinessCheck reports whether readiness requires a reachable upstream
+func (s *DockerRegistryProxyService) UpstreamReadinessCheck() bool {
+	return s.readyUpstream
+}
+
+// PingUpstream checks that the upstream or one of its mirrors is reachable
+func (s *DockerRegistryProxyService) PingUpstream(ctx context.Context) error {
+	return s.client.Ping(ctx)
+}
+
+// SetBlobETags makes blob GET and HEAD responses carry the digest as ETag (see docker.BlobETag)
+// and answer a matching If-None-Match with 304, so clients skip re-transferring cached blobs
+func (s *DockerRegistryProxyService) SetBlobETags(enabled bool) {
+	s.blobETags = enabled
+}
+
+// BlobETags reports whether blob responses carry ETags
+func (s *DockerRegistryProxyService) BlobETags() bool {
+	return s.blobETags
+}
+
+// SetRevalidateAlways makes every cached entry count as expired, so each request checks upstream
+func (s *DockerRegistryProxyService) SetRevalidateAlways(revalidate bool) {
+	s.revalidate = revalidate
+}
+
+// RevalidateAlways reports whether the always-revalidate mode is enabled
+func (s *DockerRegistryProxyService) RevalidateAlways() bool {
+	return s.revalidate
+}
+
+// SetKeyStrategy sets how cached content is keyed in storage ("" restores docker.KeyByDigest).
+// Entries cached under one strategy are not found under the other.
+func (s *DockerRegistryProxyService) SetKeyStrategy(strategy docker.KeyStrategy) {
+	if strategy == "" {
+		strategy = docker.KeyByDigest
+	}
+	s.keyStrategy = strategy
+}
+
+// KeyStrategy returns how cached content is keyed in storage
+func (s *DockerRegistryProxyService) KeyStrategy() docker.KeyStrategy {
+	return s.keyStrategy
+}
+
+// SetCompressionConfig sets the gzip compression options for JSON responses
+func (s *DockerRegistryProxyService) SetCompressionConfig(cfg docker.CompressionConfig) {
+	s.compression = cfg
+}
+
+// CompressionConfig returns the gzip compression options for JSON responses
+func (s *DockerRegistryProxyService) CompressionConfig() docker.CompressionConfig {
+	return s.compression
+}
+
+// SetNameLimits bounds request path and repository name lengths; longer requests are rejected with
+// NAME_INVALID. Zero fields use the defaults. Must be set before the routes are set up.
+func (s *DockerRegistryProxyService) SetNameLimits(limits docker.NameLimits) {
+	s.nameLimits = limits
+}
+
+// NameLimits returns the request path and repository name length limits
+func (s *DockerRegistryProxyService) NameLimits() docker.NameLimits {
+	return s.nameLimits
+}
+
+// SetManifestCache sets the in-memory manifest cache (nil disables caching)
+func (s *DockerRegistryProxyService) SetManifestCache(cache *docker.ManifestCache) {
+	s.manifestCache = cache
+}
+
+// ManifestCache returns the in-memory manifest cache (nil if caching is disabled)
+func (s *DockerRegistryProxyService) ManifestCache() *docker.ManifestCache {
+	return s.manifestCache
+}
+
+// SetUpstreamTimeout sets the overall deadline of each upstream operation (manifest fetches and
+// existence checks, across the upstream and its mirrors; for blobs, until upstream starts answering),
+// separate from the connection timeout. Operations exceeding it fail with 504. Zero disables it.
+func (s *DockerRegistryProxyService) SetUpstreamTimeout(timeout time.Duration) {
+	s.client.timeout = max(timeout, 0)
+}
+
+// UpstreamTimeout returns the deadline of each upstream operation (0 when disabled)
+func (s *DockerRegistryProxyService) UpstreamTimeout() time.Duration {
+	return s.client.timeout
+}
+
+// SetTagCacheTTL enables caching tag -> digest resolutions for ttl, so repeated tag pulls
+// within the window skip the upstream GET. Zero or negative disables the tag cache.
+func (s *DockerRegistryProxyService) SetTagCacheTTL(ttl time.Duration) {
+	s.tagCache = newTagCache(ttl)
+}
+
+// TagCacheTTL returns the tag cache TTL (0 when disabled)
+func (s *DockerRegistryProxyService) TagCacheTTL() time.Duration {
+	if s.tagCache == nil {
+		return 0
+	}
+	return s.tagCache.ttl
+}
+
+// SetEventSink sets the sink notified when upstream content is cached (nil restores the no-op default)
+func (s *DockerRegistryProxyService) SetEventSink(sink events.EventSink) {
+	if sink == nil {
+		sink = events.NopSink{}
+	}
+	s.events = sink
+}
+
+// SetCacheWriteConcurrency caps the number of blob cache misses written to storage concurrently
+// (<= 0 = unlimited). Misses beyond the limit are streamed to the client without being cached.
+func (s *DockerRegistryProxyService) SetCacheWriteConcurrency(limit int) {
+	s.cacheWrites = newCacheLimiter(limit)
+}
+
+// CacheWriteConcurrency returns the cap on concurrent blob cache writes (0 when unlimited)
+func (s *DockerRegistryProxyService) CacheWriteConcurrency() int {
+	return s.cacheWrites.size()
+}
+
+// CacheWriteStats returns the highest number of concurrent blob cache writes observed and the
+// number of misses streamed uncached because the limit was reached (both 0 when unlimited)
+func (s *DockerRegistryProxyService) CacheWriteStats() (peak int, skipped int64) {
+	if s.cacheWrites == nil {
+		return 0, 0
+	}
+	return int(s.cacheWrites.peak.Load()), s.cacheWrites.skipped.Load()
+}
+
+// getManifestCacheKey generates the in-memory cache key for a manifest digest.
+// Only digests are used as keys: tags are mutable upstream and must be resolved there.
+func (s *DockerRegistryProxyService) getManifestCacheKey(name, digest string) string {
+	return name + ":" + digest
+}
+
+// isDigestReference checks if a manifest reference is a content digest rather than a tag
+func isDigestReference(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+// getCacheKey generates the cache key of a manifest or blob in repository name (see SetKeyStrategy)
 func (s *DockerRegistryProxyService) getCacheKey(name, digest string) string {
-	// Use digest as hash for content-addressable storage
-	return digest
+	return s.keyStrategy.Key(name, digest)
 }
 
 // isCacheExpired checks if cached artifact has expired based on TTL
 func (s *DockerRegistryProxyService) isCacheExpired(meta *models.ArtifactMeta) bool {
-	if meta == nil {
+	if meta == nil || s.revalidate {
 		return true
 	}
 	if s.cacheTTL <= 0 {
@@ -68,6 +266,134 @@ func (s *DockerRegistryProxyService) isCacheExpired(meta *models.ArtifactMeta) b
 
 // GetManifest retrieves a manifest, checking cache first, then upstream
 func (s *DockerRegistryProxyService) GetManifest(ctx context.Context, name, reference string) ([]byte, string, error) {
+	manifestData, mediaType, _, err := s.GetManifestWithDigest(ctx, name, reference)
+	return manifestData, mediaType, err
+}
+
+// GetManifestWithDigest retrieves a manifest along with its digest.
+// Digest references are served from the in-memory manifest cache (if enabled) without contacting upstream.
+// Requests marked with WithNoCache, and no-cache repositories, always fetch from upstream.
+// With SetLayerRecompression, tag pulls may get the manifest rewritten to recompressed layers.
+func (s *DockerRegistryProxyService) GetManifestWithDigest(ctx context.Context, name, reference string) ([]byte, string, string, error) {
+	manifestData, mediaType, digest, err := s.resolveManifest(ctx, name, reference)
+	if err != nil {
+		return nil, "", "", err
+	}
+	s.blobIndex.addManifest(name, manifestData)
+	s.recordTagPull(name, reference)
+
+	if data, rewrittenDigest := s.recompressManifest(ctx, name, reference, manifestData, mediaType, digest); rewrittenDigest != digest {
+		s.blobIndex.addManifest(name, data)
+		return data, mediaType, rewrittenDigest, nil
+	}
+	return manifestData, mediaType, digest, nil
+}
+
+// resolveManifest retrieves a manifest as published upstream, or a stored recompressed manifest by digest
+func (s *DockerRegistryProxyService) resolveManifest(ctx context.Context, name, reference string) ([]byte, string, string, error) {
+	if !s.bypassCache(ctx, name) {
+		if cached, ok := s.lookupManifest(ctx, name, reference); ok {
+			return cached.Data, cached.MediaType, cached.Digest, nil
+		}
+	}
+	// Recompressed manifests exist only here, so never ask upstream for them
+	if s.layerCodec != nil && isDigestReference(reference) {
+		if data, mediaType, ok := s.getRecompressedManifest(ctx, name, reference); ok {
+			return data, mediaType, reference, nil
+		}
+	}
+
+	manifestData, mediaType, err := s.getManifest(ctx, name, reference)
+	if err != nil {
+		if stale, ok := s.staleManifest(ctx, name, reference); ok {
+			return stale.Data, stale.MediaType, stale.Digest, nil
+		}
+		return nil, "", "", err
+	}
+
+	digest := s.calculateDigest(manifestData)
+	if !s.cacheable(name) {
+		return manifestData, mediaType, digest, nil
+	}
+	s.manifestCache.Add(s.getManifestCacheKey(name, digest), &docker.CachedManifest{
+		Data:      manifestData,
+		MediaType: mediaType,
+		Digest:    digest,
+	})
+	if !isDigestReference(reference) {
+		s.tagCache.add(s.getManifestCacheKey(name, reference), digest, mediaType)
+		// A mapping write failure shouldn't break the request; the tag is resolved upstream next time
+		if s.tagMappings {
+			_ = s.writeTagMapping(ctx, name, reference, digest, mediaType)
+		}
+	}
+
+	return manifestData, mediaType, digest, nil
+}
+
+// lookupManifest serves a digest reference from the in-memory manifest cache, or a recently
+// resolved tag from the digest caches, falling back to storage with SetTagMappings, without
+// asking upstream
+func (s *DockerRegistryProxyService) lookupManifest(ctx context.Context, name, reference string) (*docker.CachedManifest, bool) {
+	if isDigestReference(reference) {
+		if cached, ok := s.manifestCache.Get(s.getManifestCacheKey(name, reference)); ok {
+			return cached, true
+		}
+		return s.lookupStoredManifest(ctx, name, reference)
+	}
+	if s.revalidate {
+		return nil, false
+	}
+	tagKey := s.getManifestCacheKey(name, reference)
+	if entry, ok := s.tagCache.get(tagKey); ok {
+		if data, ok := s.getCachedManifest(ctx, name, entry.digest); ok {
+			return &docker.CachedManifest{Data: data, MediaType: entry.mediaType, Digest: entry.digest}, true
+		}
+		s.tagCache.remove(tagKey)
+	}
+	return s.lookupStoredManifest(ctx, name, reference)
+}
+
+// getCachedManifest returns a manifest by digest from memory or unexpired storage cache
+func (s *DockerRegistryProxyService) getCachedManifest(ctx context.Context, name, digest string) ([]byte, bool) {
+	if cached, ok := s.manifestCache.Get(s.getManifestCacheKey(name, digest)); ok {
+		return cached.Data, true
+	}
+	return s.readCachedManifest(ctx, s.getCacheKey(name, digest))
+}
+
+// readCachedManifest reads a manifest from the storage cache if present and not expired
+func (s *DockerRegistryProxyService) readCachedManifest(ctx context.Context, cacheKey string) ([]byte, bool) {
+	meta, err := s.storage.GetMeta(ctx, cacheKey)
+	if err != nil || meta == nil || (s.isCacheExpired(meta) && !isRecompressed(meta)) {
+		return nil, false
+	}
+	return s.readStoredManifest(ctx, cacheKey)
+}
+
+// readStoredManifest reads a manifest from the storage cache regardless of its age
+func (s *DockerRegistryProxyService) readStoredManifest(ctx context.Context, cacheKey string) ([]byte, bool) {
+	readReq := models.ArtifactRange{
+		Hash: cacheKey,
+		Range: models.ByteRange{
+			Offset: 0,
+			Length: -1,
+		},
+	}
+	rc, _, err := s.storage.Read(ctx, readReq)
+	if err != nil {
+		return nil, false
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// getManifest fetches a manifest from upstream and keeps the storage cache up to date
+func (s *DockerRegistryProxyService) getManifest(ctx context.Context, name, reference string) ([]byte, string, error) {
 	// First, try to get from upstream to get the digest
 	manifestData, mediaType, err := s.client.GetManifest(ctx, name, reference)
 	if err != nil {
@@ -78,24 +404,13 @@ func (s *DockerRegistryProxyService) GetManifest(ctx context.Context, name, refe
 	digest := s.calculateDigest(manifestData)
 	cacheKey := s.getCacheKey(name, digest)
 
-	// Check cache
-	meta, err := s.storage.GetMeta(ctx, cacheKey)
-	if err == nil && meta != nil && !s.isCacheExpired(meta) {
-		// Cache hit - read from cache
-		readReq := models.ArtifactRange{
-			Hash: cacheKey,
-			Range: models.ByteRange{
-				Offset: 0,
-				Length: -1,
-			},
-		}
-		rc, _, err := s.storage.Read(ctx, readReq)
-		if err == nil {
-			defer rc.Close()
-			cachedData, err := io.ReadAll(rc)
-			if err == nil {
-				return cachedData, mediaType, nil
-			}
+	// Check cache (a no-cache request skips it and refreshes the entry below)
+	if !s.cacheable(name) {
+		return manifestData, mediaType, nil
+	}
+	if !noCacheRequested(ctx) {
+		if cachedData, ok := s.readCachedManifest(ctx, cacheKey); ok {
+			return cachedData, mediaType, nil
 		}
 	}
 
@@ -105,7 +420,7 @@ func (s *DockerRegistryProxyService) GetManifest(ctx context.Context, name, refe
 		Repo:                "manifest",
 		ReferencedTimestamp: time.Now().Unix(),
 	}
-	meta = &models.ArtifactMeta{
+	meta := &models.ArtifactMeta{
 		Hash:             cacheKey,
 		Length:           int64(len(manifestData)),
 		CreatedTimestamp: time.Now().Unix(),
@@ -113,29 +428,65 @@ func (s *DockerRegistryProxyService) GetManifest(ctx context.Context, name, refe
 	}
 
 	_, err = s.storage.Create(ctx, cacheKey, bytes.NewReader(manifestData), int64(len(manifestData)), meta)
-	if err != nil {
-		// Log error but continue - cache write failure shouldn't break the request
+	// A cache write failure shouldn't break the request; only notify when the entry was stored
+	if err == nil {
+		event := events.Event{Repository: name, Digest: digest, MediaType: mediaType, Size: int64(len(manifestData)), Timestamp: time.Now()}
+		if !isDigestReference(reference) {
+			event.Tag = reference
+		}
+		s.events.OnManifestCached(ctx, event)
 	}
 
 	return manifestData, mediaType, nil
 }
 
-// CheckManifestExists checks if a manifest exists
+// CheckManifestExists checks if a manifest exists.
+// With SetLayerRecompression the digest is the one GetManifestWithDigest would serve.
 func (s *DockerRegistryProxyService) CheckManifestExists(ctx context.Context, name, reference string) (bool, string, error) {
+	if s.layerCodec != nil && s.cacheable(name) {
+		if isDigestReference(reference) {
+			if _, _, ok := s.getRecompressedManifest(ctx, name, reference); ok {
+				return true, reference, nil
+			}
+		} else if _, _, digest, err := s.GetManifestWithDigest(ctx, name, reference); err == nil {
+			return true, digest, nil
+		}
+	}
+	if !isDigestReference(reference) && !s.revalidate && !s.bypassCache(ctx, name) {
+		if entry, ok := s.tagCache.get(s.getManifestCacheKey(name, reference)); ok {
+			s.recordTagPull(name, reference)
+			return true, entry.digest, nil
+		}
+	}
+	if !s.bypassCache(ctx, name) {
+		if cached, ok := s.lookupStoredManifest(ctx, name, reference); ok {
+			s.recordTagPull(name, reference)
+			return true, cached.Digest, nil
+		}
+	}
 	exists, digest, err := s.client.CheckManifestExists(ctx, name, reference)
 	if err != nil {
+		if stale, ok := s.staleManifest(ctx, name, reference); ok {
+			return true, stale.Digest, nil
+		}
 		return false, "", err
 	}
 	return exists, digest, nil
 }
 
-// GetBlob retrieves a blob, checking cache first, then upstream
+// GetBlob retrieves a blob, checking cache first, then upstream.
+// Requests marked with WithNoCache, and no-cache repositories, always fetch from upstream.
+// With SetRequireManifestReference, blobs no served manifest of the repository references fail
+// with docker.ErrBlobUnknown.
 func (s *DockerRegistryProxyService) GetBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, error) {
+	if !s.blobIndex.allows(name, digest) {
+		return nil, 0, docker.ErrBlobUnknown(digest)
+	}
 	cacheKey := s.getCacheKey(name, digest)
 
 	// Check cache
 	meta, err := s.storage.GetMeta(ctx, cacheKey)
-	if err == nil && meta != nil && !s.isCacheExpired(meta) {
+	if err == nil && s.servableFromCache(ctx, name, meta) {
 		// Cache hit - read from cache
 		readReq := models.ArtifactRange{
 			Hash: cacheKey,
@@ -153,9 +504,17 @@ func (s *DockerRegistryProxyService) GetBlob(ctx context.Context, name, digest s
 	// Cache miss or expired - fetch from upstream
 	blobReader, size, err := s.client.GetBlob(ctx, name, digest)
 	if err != nil {
+		if rc, size, ok := s.staleBlob(ctx, name, digest); ok {
+			return rc, size, nil
+		}
 		return nil, 0, fmt.Errorf("failed to fetch blob from upstream: %w", err)
 	}
 
+	// Never cached, or too many cache writes in flight: stream straight from upstream, uncached
+	if !s.cacheable(name) || !s.cacheWrites.tryAcquire() {
+		return blobReader, size, nil
+	}
+
 	// Use streaming approach: write to cache and response simultaneously
 	// Create pipes for cache and response streams
 	cacheReader, cacheWriter := io.Pipe()
@@ -177,20 +536,67 @@ func (s *DockerRegistryProxyService) GetBlob(ctx context.Context, name, digest s
 	// Channel to track cache write completion and errors
 	cacheDone := make(chan error, 1)
 	streamDone := make(chan error, 1)
+	cacheFinished := make(chan struct{})
+	streamFinished := make(chan struct{})
 
 	// Start goroutine to write to cache (non-blocking)
 	go func() {
+		defer close(cacheFinished)
+		defer s.cacheWrites.release()
 		defer cacheReader.Close()
-		_, err := s.storage.Create(ctx, cacheKey, cacheReader, size, meta)
+
+		// Verify the cached content against the requested digest so a truncated
+		// upstream body or an aborted stream never leaves a partial cache entry
+		hasher := storage.AcquireSHA256()
+		defer storage.ReleaseSHA256(hasher)
+		teeReader := io.TeeReader(cacheReader, hasher)
+		_, err := s.storage.Create(ctx, cacheKey, teeReader, size, meta)
+
+		// Drain whatever storage didn't consume (e.g. the artifact already existed, or the
+		// write failed) so the response stream is never cut off by the cache side
+		if _, drainErr := io.Copy(io.Discard, teeReader); drainErr != nil && err == nil {
+			err = drainErr
+		}
 		if err != nil {
 			cacheDone <- fmt.Errorf("failed to cache blob: %w", err)
 			return
 		}
+		if calculated := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); strings.HasPrefix(digest, "sha256:") && calculated != digest {
+			_, _ = s.storage.Delete(ctx, cacheKey, ref)
+			cacheDone <- fmt.Errorf("cached blob digest mismatch: expected %s, got %s", digest, calculated)
+			return
+		}
+		s.events.OnBlobCached(ctx, events.Event{Repository: name, Digest: digest, Size: size, Timestamp: time.Now()})
 		cacheDone <- nil
+
+		// Recompression outlives the request; a failure only means the original keeps being served
+		if s.layerCodec != nil {
+			_ = s.recompressLayer(context.WithoutCancel(ctx), name, digest)
+		}
 	}()
 
+	reader := &streamingBlobReader{
+		reader:         responseReader,
+		blobReader:     blobReader,
+		cacheWriter:    cacheWriter,
+		responseWriter: responseWriter,
+		cacheReader:    cacheReader,
+		cacheDone:      cacheDone,
+		streamDone:     streamDone,
+		cacheFinished:  cacheFinished,
+		streamFinished: streamFinished,
+		size:           size,
+		ctx:            ctx,
+	}
+
+	// Tear the stream down once the request is over (client gone, server shutdown), even if the
+	// caller never closes the reader, so neither goroutine outlives it
+	stopAbort := context.AfterFunc(ctx, reader.abort)
+
 	// Start goroutine to stream from upstream to both cache and response
 	go func() {
+		defer close(streamFinished)
+		defer stopAbort()
 		defer func() {
 			// Ensure all resources are closed on exit
 			responseWriter.Close()
@@ -205,7 +611,7 @@ func (s *DockerRegistryProxyService) GetBlob(ctx context.Context, name, digest s
 
 		// Copy from TeeReader to response pipe
 		// This streams data to both cache (via TeeReader -> cacheWriter) and response (via pipe)
-		_, err := io.Copy(responseWriter, teeReader)
+		_, err := storage.CopyBuffer(responseWriter, teeReader)
 		if err != nil {
 			// If copy fails, close pipes to signal error
 			responseWriter.CloseWithError(fmt.Errorf("failed to stream blob: %w", err))
@@ -214,37 +620,35 @@ func (s *DockerRegistryProxyService) GetBlob(ctx context.Context, name, digest s
 			return
 		}
 
+		reader.streamComplete.Store(true)
 		streamDone <- nil
 	}()
 
 	// Return response reader immediately (streaming starts in background)
 	// Wrap in a closer that handles cleanup and error monitoring
-	return &streamingBlobReader{
-		reader:         responseReader,
-		blobReader:     blobReader,
-		cacheWriter:    cacheWriter,
-		responseWriter: responseWriter,
-		cacheReader:    cacheReader,
-		cacheDone:      cacheDone,
-		streamDone:     streamDone,
-		size:           size,
-		ctx:            ctx,
-	}, size, nil
+	return reader, size, nil
 }
 
-// CheckBlobExists checks if a blob exists
+// CheckBlobExists checks if a blob exists (and, with SetRequireManifestReference, may be served)
 func (s *DockerRegistryProxyService) CheckBlobExists(ctx context.Context, name, digest string) (bool, int64, error) {
+	if !s.blobIndex.allows(name, digest) {
+		return false, 0, nil
+	}
 	cacheKey := s.getCacheKey(name, digest)
 
 	// Check cache first
 	meta, err := s.storage.GetMeta(ctx, cacheKey)
-	if err == nil && meta != nil && !s.isCacheExpired(meta) {
+	if err == nil && s.servableFromCache(ctx, name, meta) {
 		return true, meta.Length, nil
 	}
 
 	// Check upstream
 	exists, size, err := s.client.CheckBlobExists(ctx, name, digest)
 	if err != nil {
+		if meta != nil && s.staleAllowed(ctx, name) {
+			reportStale(ctx)
+			return true, meta.Length, nil
+		}
 		return false, 0, err
 	}
 	return exists, size, nil
@@ -252,9 +656,8 @@ func (s *DockerRegistryProxyService) CheckBlobExists(ctx context.Context, name,
 
 // CalculateDigest calculates SHA256 digest (exported for use in handlers)
 func (s *DockerRegistryProxyService) CalculateDigest(data []byte) string {
-	hasher := sha256.New()
-	hasher.Write(data)
-	return "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
 
 // calculateDigest calculates SHA256 digest (internal method)
@@ -262,6 +665,9 @@ func (s *DockerRegistryProxyService) calculateDigest(data []byte) string {
 	return s.CalculateDigest(data)
 }
 
+// errStreamAborted signals the cache writer that the client abandoned the stream
+var errStreamAborted = errors.New("blob stream aborted before completion")
+
 // streamingBlobReader wraps the response pipe reader and handles cleanup
 // It monitors both cache and stream operations for errors and ensures proper resource cleanup
 type streamingBlobReader struct {
@@ -272,6 +678,9 @@ type streamingBlobReader struct {
 	cacheReader    *io.PipeReader
 	cacheDone      chan error
 	streamDone     chan error
+	cacheFinished  chan struct{} // Closed when the cache-write goroutine exits
+	streamFinished chan struct{} // Closed when the upstream streaming goroutine exits
+	streamComplete atomic.Bool   // Set once the whole upstream body was streamed
 	size           int64
 	ctx            context.Context
 	closed         bool
@@ -309,6 +718,16 @@ func (s *streamingBlobReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// abort fails both pipes and closes the upstream body, unblocking the streaming and cache-write
+// goroutines wherever they are; an incomplete stream is never cached. It doesn't wait for them.
+func (s *streamingBlobReader) abort() {
+	if !s.streamComplete.Load() {
+		s.cacheWriter.CloseWithError(errStreamAborted)
+	}
+	s.reader.Close()
+	s.blobReader.Close()
+}
+
 func (s *streamingBlobReader) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -321,6 +740,12 @@ func (s *streamingBlobReader) Close() error {
 	var errs []error
 	closeErr := fmt.Errorf("close errors")
 
+	// If the client stops reading before the stream completed, abort the cache write
+	// first so the cache writer sees an error instead of a premature EOF
+	if !s.streamComplete.Load() && s.cacheWriter != nil {
+		s.cacheWriter.CloseWithError(errStreamAborted)
+	}
+
 	// Close response reader first (may block if writer is still active)
 	if s.reader != nil {
 		if err := s.reader.Close(); err != nil && err != io.ErrClosedPipe {
@@ -349,12 +774,8 @@ func (s *streamingBlobReader) Close() error {
 		}
 	}
 
-	// Close cache pipe reader
-	if s.cacheReader != nil {
-		if err := s.cacheReader.Close(); err != nil && err != io.ErrClosedPipe {
-			errs = append(errs, fmt.Errorf("cache reader: %w", err))
-		}
-	}
+	// The cache pipe reader is closed by the cache-write goroutine itself once it has
+	// consumed the final EOF (or the abort error); closing it here could fail a complete write
 
 	// Check for errors from goroutines (non-blocking with timeout)
 	// Cache errors are logged but don't fail the request
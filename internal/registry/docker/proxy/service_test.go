@@ -0,0 +1,1152 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// testDigest calculates the sha256 digest of data
+func testDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeUpstream is a minimal upstream registry serving fixed blobs and manifests
+type fakeUpstream struct {
+	blobs     map[string][]byte
+	manifests map[string][]byte // key: name/reference
+	requests  []string          // "METHOD path" of every request received
+	agents    []string          // User-Agent of every request received
+	down      atomic.Bool       // Answer every request with 503
+	mu        sync.Mutex
+}
+
+func newFakeUpstream() *fakeUpstream {
+	return &fakeUpstream{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+}
+
+// requestCount returns the number of requests received so far
+func (f *fakeUpstream) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+func (f *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.agents = append(f.agents, r.UserAgent())
+	f.mu.Unlock()
+	if f.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if idx := strings.Index(path, "/blobs/"); idx >= 0 {
+		data, ok := f.blobs[path[idx+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+		return
+	}
+	if idx := strings.Index(path, "/manifests/"); idx >= 0 {
+		data, ok := f.manifests[path[:idx]+"/"+path[idx+len("/manifests/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", testDigest(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// setupTestService creates a proxy service backed by temp storage and a fake upstream
+func setupTestService(t *testing.T) (*DockerRegistryProxyService, models.ArtifactStorage, *fakeUpstream) {
+	return setupTestServiceWithTTL(t, 0)
+}
+
+// setupTestServiceWithTTL creates a proxy service with the given cache TTL (seconds)
+func setupTestServiceWithTTL(t *testing.T, cacheTTL int64) (*DockerRegistryProxyService, models.ArtifactStorage, *fakeUpstream) {
+	upstream := newFakeUpstream()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	testStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+
+	service, err := NewDockerRegistryProxyService("test-storage", &models.UpstreamRegistry{URL: server.URL}, cacheTTL)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	service.SetStorage(testStorage)
+	return service, testStorage, upstream
+}
+
+// TestDockerRegistryProxyServiceGetBlobCaches tests that a fully read blob is cached
+func TestDockerRegistryProxyServiceGetBlobCaches(t *testing.T) {
+	service, testStorage, upstream := setupTestService(t)
+	ctx := context.Background()
+
+	blobData := bytes.Repeat([]byte("layer"), 1000)
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+
+	reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	reader.Close()
+
+	if !bytes.Equal(data, blobData) {
+		t.Fatal("Blob data mismatch")
+	}
+
+	<-reader.(*streamingBlobReader).cacheFinished
+
+	meta, err := testStorage.GetMeta(ctx, digest)
+	if err != nil {
+		t.Fatalf("Blob should be cached: %v", err)
+	}
+	if meta.Length != int64(len(blobData)) {
+		t.Errorf("Cached length mismatch: expected %d, got %d", len(blobData), meta.Length)
+	}
+}
+
+// TestDockerRegistryProxyServiceGetBlobClientAbort tests that an aborted read leaves no partial cache entry
+func TestDockerRegistryProxyServiceGetBlobClientAbort(t *testing.T) {
+	service, testStorage, upstream := setupTestService(t)
+	ctx := context.Background()
+
+	blobData := bytes.Repeat([]byte("0123456789abcdef"), 256*1024) // 4MB
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+
+	reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+
+	// Read a small prefix, then abandon the stream
+	buf := make([]byte, 4096)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatalf("Failed to read blob prefix: %v", err)
+	}
+	reader.Close()
+
+	select {
+	case <-reader.(*streamingBlobReader).cacheFinished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cache-write goroutine did not terminate after client abort")
+	}
+
+	if _, err := testStorage.GetMeta(ctx, digest); err == nil {
+		t.Error("Partial blob must not be cached after client abort")
+	}
+	if exists, _, err := testStorage.(*storage.SimpleFileStorage).Exists(ctx, digest); err != nil || exists {
+		t.Errorf("Partial blob data must not remain in storage (exists=%v, err=%v)", exists, err)
+	}
+}
+
+// TestDockerRegistryProxyServiceGetBlobContextDone tests that a stream the caller drops without
+// closing is torn down once the request context is done, leaving no goroutine or partial cache entry
+func TestDockerRegistryProxyServiceGetBlobContextDone(t *testing.T) {
+	service, testStorage, upstream := setupTestService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blobData := bytes.Repeat([]byte("0123456789abcdef"), 256*1024) // 4MB
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+
+	reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	streaming := reader.(*streamingBlobReader)
+	if _, err := io.ReadFull(reader, make([]byte, 4096)); err != nil {
+		t.Fatalf("Failed to read blob prefix: %v", err)
+	}
+
+	// Drop the reader without closing it, then end the request
+	cancel()
+	for name, done := range map[string]chan struct{}{"streaming": streaming.streamFinished, "cache-write": streaming.cacheFinished} {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The %s goroutine did not exit after the context was done", name)
+		}
+	}
+	if _, err := testStorage.GetMeta(context.Background(), digest); err == nil {
+		t.Error("Partial blob must not be cached after the context was done")
+	}
+}
+
+// gatedStorage holds every Create until the gate is opened, tracking how many run at once
+type gatedStorage struct {
+	models.ArtifactStorage
+	gate   chan struct{}
+	active atomic.Int64
+	peak   atomic.Int64
+}
+
+func (g *gatedStorage) Create(ctx context.Context, hash string, r io.Reader, size int64, meta *models.ArtifactMeta) (*models.ArtifactMeta, error) {
+	active := g.active.Add(1)
+	defer g.active.Add(-1)
+	for peak := g.peak.Load(); active > peak && !g.peak.CompareAndSwap(peak, active); peak = g.peak.Load() {
+	}
+	<-g.gate
+	return g.ArtifactStorage.Create(ctx, hash, r, size, meta)
+}
+
+// TestDockerRegistryProxyServiceCacheWriteConcurrency tests that a burst of unique misses is
+// fully served while at most the configured number of cache writes run
+func TestDockerRegistryProxyServiceCacheWriteConcurrency(t *testing.T) {
+	service, testStorage, upstream := setupTestService(t)
+	gated := &gatedStorage{ArtifactStorage: testStorage, gate: make(chan struct{})}
+	service.SetStorage(gated)
+	service.SetCacheWriteConcurrency(2)
+	ctx := context.Background()
+
+	const misses = 10
+	blobs := make(map[string][]byte, misses)
+	for i := 0; i < misses; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 64*1024)
+		blobs[testDigest(data)] = data
+		upstream.blobs[testDigest(data)] = data
+	}
+
+	var wg sync.WaitGroup
+	cached := make(map[*streamingBlobReader]string)
+	uncached := make(chan string, misses)
+	for digest, want := range blobs {
+		reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+		if err != nil {
+			t.Fatalf("GetBlob failed: %v", err)
+		}
+		if streaming, ok := reader.(*streamingBlobReader); ok {
+			cached[streaming] = digest
+		}
+		wg.Add(1)
+		go func(digest string, want []byte) {
+			defer wg.Done()
+			defer reader.Close()
+			data, err := io.ReadAll(reader)
+			if err != nil || !bytes.Equal(data, want) {
+				t.Errorf("Blob %s not served intact (err=%v)", digest, err)
+			}
+			if _, ok := reader.(*streamingBlobReader); !ok {
+				uncached <- digest
+			}
+		}(digest, want)
+	}
+
+	// Misses beyond the limit stream without waiting for the held cache writes
+	for i := 0; i < misses-2; i++ {
+		select {
+		case <-uncached:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Uncached misses were blocked by the cache-write limit")
+		}
+	}
+	if peak, skipped := service.CacheWriteStats(); peak != 2 || skipped != misses-2 {
+		t.Errorf("Expected 2 concurrent writes and %d skipped, got %d and %d", misses-2, peak, skipped)
+	}
+
+	close(gated.gate)
+	wg.Wait()
+	for reader := range cached {
+		<-reader.cacheFinished
+	}
+	if len(cached) != 2 || gated.peak.Load() != 2 {
+		t.Errorf("Expected 2 cached misses with at most 2 concurrent writes, got %d and %d", len(cached), gated.peak.Load())
+	}
+	for _, digest := range cached {
+		if _, err := testStorage.GetMeta(ctx, digest); err != nil {
+			t.Errorf("Expected admitted miss to be cached: %v", err)
+		}
+	}
+}
+
+// TestDockerRegistryProxyServiceManifestCacheByDigest tests that digest pulls are served from memory
+func TestDockerRegistryProxyServiceManifestCacheByDigest(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	ctx := context.Background()
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	digest := testDigest(manifestData)
+	upstream.manifests["test-repo/"+digest] = manifestData
+
+	for i := 0; i < 3; i++ {
+		data, _, gotDigest, err := service.GetManifestWithDigest(ctx, "test-repo", digest)
+		if err != nil {
+			t.Fatalf("GetManifestWithDigest failed: %v", err)
+		}
+		if !bytes.Equal(data, manifestData) || gotDigest != digest {
+			t.Fatal("Manifest data or digest mismatch")
+		}
+	}
+
+	if count := upstream.requestCount(); count != 1 {
+		t.Errorf("Expected a single upstream request, got %d", count)
+	}
+}
+
+// pullBlob fully reads a blob through the proxy and waits for its cache write to finish
+func pullBlob(t *testing.T, service *DockerRegistryProxyService, digest string) {
+	reader, _, err := service.GetBlob(context.Background(), "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	reader.Close()
+	if streaming, ok := reader.(*streamingBlobReader); ok {
+		<-streaming.cacheFinished
+	}
+}
+
+// TestDockerRegistryProxyServiceRevalidateAlways tests that every request checks upstream
+func TestDockerRegistryProxyServiceRevalidateAlways(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetRevalidateAlways(true)
+
+	blobData := []byte("revalidated blob")
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+
+	for i := 0; i < 3; i++ {
+		pullBlob(t, service, digest)
+	}
+
+	if count := upstream.requestCount(); count != 3 {
+		t.Errorf("Expected 3 upstream requests, got %d", count)
+	}
+}
+
+// TestDockerRegistryProxyServiceNeverExpire tests that cached entries are never re-fetched
+func TestDockerRegistryProxyServiceNeverExpire(t *testing.T) {
+	service, testStorage, upstream := setupTestServiceWithTTL(t, CacheTTLNeverExpire)
+	ctx := context.Background()
+
+	blobData := []byte("long-lived blob")
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+
+	pullBlob(t, service, digest)
+
+	// Age the cached entry far beyond the default TTL
+	meta, err := testStorage.GetMeta(ctx, digest)
+	if err != nil {
+		t.Fatalf("Blob should be cached: %v", err)
+	}
+	meta.CreatedTimestamp = 1
+	if _, err := testStorage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		pullBlob(t, service, digest)
+	}
+
+	if count := upstream.requestCount(); count != 1 {
+		t.Errorf("Expected a single upstream request, got %d", count)
+	}
+}
+
+// setupTestServiceWithMirror creates a proxy service whose primary upstream is served by
+// failingPrimary and whose single mirror is a healthy fake upstream
+func setupTestServiceWithMirror(t *testing.T, failingPrimary http.HandlerFunc) (*DockerRegistryProxyService, *fakeUpstream, string) {
+	primary := httptest.NewServer(failingPrimary)
+	t.Cleanup(primary.Close)
+	mirror := newFakeUpstream()
+	mirrorServer := httptest.NewServer(mirror)
+	t.Cleanup(mirrorServer.Close)
+
+	testStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+
+	upstream := &models.UpstreamRegistry{
+		URL:     primary.URL,
+		Mirrors: []models.UpstreamRegistry{{URL: mirrorServer.URL}},
+	}
+	service, err := NewDockerRegistryProxyService("test-storage", upstream, 0)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	service.SetStorage(testStorage)
+	return service, mirror, mirrorServer.URL
+}
+
+// TestDockerRegistryProxyServiceMirrorFallbackBlob tests that a blob is served by the mirror when the primary errors
+func TestDockerRegistryProxyServiceMirrorFallbackBlob(t *testing.T) {
+	service, mirror, mirrorURL := setupTestServiceWithMirror(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	blobData := []byte("mirrored blob")
+	digest := testDigest(blobData)
+	mirror.blobs[digest] = blobData
+
+	reader, _, err := service.GetBlob(context.Background(), "test-repo", digest)
+	if err != nil {
+		t.Fatalf("GetBlob failed: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	if !bytes.Equal(data, blobData) {
+		t.Fatal("Blob data mismatch")
+	}
+	<-reader.(*streamingBlobReader).cacheFinished
+
+	if served := service.UpstreamServedCounts()[mirrorURL]; served != 1 {
+		t.Errorf("Expected mirror to serve 1 request, got %d", served)
+	}
+}
+
+// TestDockerRegistryProxyServiceMirrorFallbackManifest tests that a manifest missing upstream is served by the mirror
+func TestDockerRegistryProxyServiceMirrorFallbackManifest(t *testing.T) {
+	service, mirror, mirrorURL := setupTestServiceWithMirror(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ctx := context.Background()
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	mirror.manifests["test-repo/latest"] = manifestData
+
+	exists, _, err := service.CheckManifestExists(ctx, "test-repo", "latest")
+	if err != nil || !exists {
+		t.Fatalf("Expected manifest to exist on mirror (exists=%v, err=%v)", exists, err)
+	}
+
+	data, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestWithDigest failed: %v", err)
+	}
+	if !bytes.Equal(data, manifestData) || digest != testDigest(manifestData) {
+		t.Fatal("Manifest data or digest mismatch")
+	}
+
+	if served := service.UpstreamServedCounts()[mirrorURL]; served < 2 {
+		t.Errorf("Expected mirror to serve at least 2 requests, got %d", served)
+	}
+}
+
+// TestDockerRegistryProxyServiceUserAgent tests that upstream requests carry the configured User-Agent
+func TestDockerRegistryProxyServiceUserAgent(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	ctx := context.Background()
+	upstream.blobs["sha256:abc"] = []byte("blob")
+
+	if _, _, err := service.CheckBlobExists(ctx, "test-repo", "sha256:abc"); err != nil {
+		t.Fatalf("CheckBlobExists failed: %v", err)
+	}
+	service.SetUserAgent("custom-agent/1.0")
+	if _, _, err := service.CheckManifestExists(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("CheckManifestExists failed: %v", err)
+	}
+
+	upstream.mu.Lock()
+	defer upstream.mu.Unlock()
+	if len(upstream.agents) != 2 {
+		t.Fatalf("Expected 2 upstream requests, got %d", len(upstream.agents))
+	}
+	if upstream.agents[0] != DefaultUserAgent {
+		t.Errorf("Expected default User-Agent %q, got %q", DefaultUserAgent, upstream.agents[0])
+	}
+	if upstream.agents[1] != "custom-agent/1.0" {
+		t.Errorf("Expected configured User-Agent, got %q", upstream.agents[1])
+	}
+}
+
+// TestDockerRegistryProxyServiceNormalizeLibrary tests that official image names get the library/ prefix upstream
+func TestDockerRegistryProxyServiceNormalizeLibrary(t *testing.T) {
+	upstream := newFakeUpstream()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	manifest := []byte(`{"schemaVersion":2}`)
+	upstream.manifests["library/ubuntu/latest"] = manifest
+	upstream.manifests["myorg/app/latest"] = manifest
+
+	normalize := true
+	service, err := NewDockerRegistryProxyService("test-storage", &models.UpstreamRegistry{URL: server.URL, NormalizeLibrary: &normalize}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	testStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+	service.SetStorage(testStorage)
+
+	ctx := context.Background()
+	for _, name := range []string{"ubuntu", "myorg/app"} {
+		data, _, err := service.GetManifest(ctx, name, "latest")
+		if err != nil || !bytes.Equal(data, manifest) {
+			t.Fatalf("Expected manifest of %s, got %q (%v)", name, data, err)
+		}
+	}
+	upstream.mu.Lock()
+	if !reflect.DeepEqual(upstream.requests, []string{"GET /v2/library/ubuntu/manifests/latest", "GET /v2/myorg/app/manifests/latest"}) {
+		t.Errorf("Unexpected upstream requests: %v", upstream.requests)
+	}
+	upstream.mu.Unlock()
+
+	// Only Docker Hub upstreams normalize by default
+	for url, expected := range map[string]bool{server.URL: false, "https://registry-1.docker.io": true, "https://index.docker.io/": true} {
+		if got := normalizesLibrary(&models.UpstreamRegistry{URL: url}); got != expected {
+			t.Errorf("Expected normalization %v for %s, got %v", expected, url, got)
+		}
+	}
+	disabled := false
+	if normalizesLibrary(&models.UpstreamRegistry{URL: "https://registry-1.docker.io", NormalizeLibrary: &disabled}) {
+		t.Error("Expected normalization disabled explicitly for Docker Hub")
+	}
+}
+
+// TestDockerRegistryProxyServiceTagCache tests that tag pulls within the window skip upstream and revalidate after it
+func TestDockerRegistryProxyServiceTagCache(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetTagCacheTTL(time.Minute)
+	now := time.Now()
+	service.tagCache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	upstream.manifests["test-repo/latest"] = manifestData
+
+	for i := 0; i < 3; i++ {
+		data, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+		if err != nil {
+			t.Fatalf("GetManifestWithDigest failed: %v", err)
+		}
+		if !bytes.Equal(data, manifestData) || digest != testDigest(manifestData) {
+			t.Fatal("Manifest data or digest mismatch")
+		}
+	}
+	if exists, digest, err := service.CheckManifestExists(ctx, "test-repo", "latest"); err != nil || !exists || digest != testDigest(manifestData) {
+		t.Fatalf("Expected cached tag to exist (exists=%v, digest=%s, err=%v)", exists, digest, err)
+	}
+	if count := upstream.requestCount(); count != 1 {
+		t.Errorf("Expected a single upstream request within the tag cache window, got %d", count)
+	}
+
+	// Move the tag upstream and let the window pass
+	updatedData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"v":"2"}}`)
+	upstream.mu.Lock()
+	upstream.manifests["test-repo/latest"] = updatedData
+	upstream.mu.Unlock()
+	now = now.Add(2 * time.Minute)
+
+	data, _, _, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestWithDigest failed: %v", err)
+	}
+	if !bytes.Equal(data, updatedData) {
+		t.Error("Expected the moved tag to be revalidated after the window")
+	}
+	if count := upstream.requestCount(); count != 2 {
+		t.Errorf("Expected a revalidating upstream request, got %d total", count)
+	}
+}
+
+// TestDockerRegistryProxyServiceTagRefresh tests that a popular tag is re-resolved before its tag
+// cache entry expires, so it keeps being served without asking upstream, while unpopular tags lapse
+func TestDockerRegistryProxyServiceTagRefresh(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetTagCacheTTL(time.Minute)
+	now := time.Now()
+	service.tagCache.now = func() time.Time { return now }
+	// The background loop must not run during the test; passes are triggered explicitly
+	service.SetTagRefresh(TagRefreshConfig{MinHits: 3, LookAhead: 10 * time.Second, Interval: time.Hour})
+	t.Cleanup(service.DisableTagRefresh)
+	ctx := context.Background()
+
+	upstream.manifests["test-repo/hot"] = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"t":"hot"}}`)
+	upstream.manifests["test-repo/cold"] = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"t":"cold"}}`)
+	pull := func(tag string) {
+		t.Helper()
+		if _, _, _, err := service.GetManifestWithDigest(ctx, "test-repo", tag); err != nil {
+			t.Fatalf("GetManifestWithDigest(%s) failed: %v", tag, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		pull("hot")
+	}
+	pull("cold")
+	if count := upstream.requestCount(); count != 2 {
+		t.Fatalf("Expected one upstream request per tag, got %d", count)
+	}
+
+	now = now.Add(30 * time.Second)
+	if refreshed := service.refreshHotTags(ctx, service.refresher); refreshed != 0 {
+		t.Errorf("Expected no refresh outside the look-ahead, got %d", refreshed)
+	}
+	now = now.Add(25 * time.Second)
+	if refreshed := service.refreshHotTags(ctx, service.refresher); refreshed != 1 {
+		t.Errorf("Expected the hot tag to be refreshed, got %d", refreshed)
+	}
+	if count := upstream.requestCount(); count != 3 {
+		t.Errorf("Expected a single refreshing upstream request, got %d total", count)
+	}
+
+	// Past the original expiry, the hot tag is still cached while the cold one goes upstream
+	now = now.Add(20 * time.Second)
+	pull("hot")
+	if count := upstream.requestCount(); count != 3 {
+		t.Errorf("Expected the refreshed hot tag to be served from the cache, got %d requests", count)
+	}
+	pull("cold")
+	if count := upstream.requestCount(); count != 4 {
+		t.Errorf("Expected the expired cold tag to be revalidated, got %d requests", count)
+	}
+}
+
+// TestDockerRegistryProxyServiceNoCacheRequest tests that a no-cache request bypasses a present
+// cache entry and re-fetches from upstream
+func TestDockerRegistryProxyServiceNoCacheRequest(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	ctx := context.Background()
+
+	blobData := []byte("blob content")
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	upstream.manifests["test-repo/"+testDigest(manifestData)] = manifestData
+
+	readBlob := func(ctx context.Context) {
+		t.Helper()
+		reader, _, err := service.GetBlob(ctx, "test-repo", digest)
+		if err != nil {
+			t.Fatalf("GetBlob failed: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(data, blobData) {
+			t.Fatalf("Blob data mismatch (err=%v)", err)
+		}
+		if streaming, ok := reader.(*streamingBlobReader); ok {
+			<-streaming.cacheFinished
+		}
+	}
+	getManifest := func(ctx context.Context) {
+		t.Helper()
+		if _, _, err := service.GetManifest(ctx, "test-repo", testDigest(manifestData)); err != nil {
+			t.Fatalf("GetManifest failed: %v", err)
+		}
+	}
+
+	// Warm the caches, then check that plain requests are served from them
+	readBlob(ctx)
+	getManifest(ctx)
+	warm := upstream.requestCount()
+	readBlob(ctx)
+	getManifest(ctx)
+	if count := upstream.requestCount(); count != warm {
+		t.Fatalf("Expected cached requests to skip upstream, got %d new requests", count-warm)
+	}
+
+	noCache := httptest.NewRequest(http.MethodGet, "/v2/test-repo/blobs/"+digest, nil)
+	noCache.Header.Set("Cache-Control", "max-age=0, no-cache")
+	readBlob(requestContext(noCache))
+	getManifest(requestContext(noCache))
+	if count := upstream.requestCount(); count != warm+2 {
+		t.Errorf("Expected no-cache requests to re-fetch both from upstream, got %d new requests", count-warm)
+	}
+}
+
+// TestDockerRegistryProxyServiceNoCacheRepository tests that a no-cache repository never stores content
+func TestDockerRegistryProxyServiceNoCacheRepository(t *testing.T) {
+	service, testStorage, upstream := setupTestService(t)
+	service.SetManifestCache(docker.NewManifestCache(10))
+	service.SetTagCacheTTL(time.Minute)
+	service.SetNoCacheRepositories([]string{"volatile"})
+	ctx := context.Background()
+
+	blobData := []byte("volatile blob")
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+	manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","annotations":{"v":"1"}}`)
+	upstream.manifests["volatile/latest"] = manifestData
+
+	for i := 0; i < 2; i++ {
+		reader, _, err := service.GetBlob(ctx, "volatile", digest)
+		if err != nil {
+			t.Fatalf("GetBlob failed: %v", err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(data, blobData) {
+			t.Fatalf("Blob data mismatch (err=%v)", err)
+		}
+		if _, _, err := service.GetManifest(ctx, "volatile", "latest"); err != nil {
+			t.Fatalf("GetManifest failed: %v", err)
+		}
+	}
+
+	if count := upstream.requestCount(); count != 4 {
+		t.Errorf("Expected every request to reach upstream, got %d requests", count)
+	}
+	for _, hash := range []string{digest, testDigest(manifestData)} {
+		if _, err := testStorage.GetMeta(ctx, hash); err == nil {
+			t.Errorf("Expected %s of a no-cache repository not to be stored", hash)
+		}
+	}
+	if _, ok := service.manifestCache.Get(service.getManifestCacheKey("volatile", testDigest(manifestData))); ok {
+		t.Error("Expected a no-cache repository manifest not to be cached in memory")
+	}
+}
+
+// TestDockerRegistryProxyServiceKeyStrategies tests that digest keys share cached blobs across
+// repositories while repository keys cache (and fetch) them separately per repository
+func TestDockerRegistryProxyServiceKeyStrategies(t *testing.T) {
+	for _, strategy := range []docker.KeyStrategy{docker.KeyByDigest, docker.KeyByRepository} {
+		t.Run(string(strategy), func(t *testing.T) {
+			service, testStorage, upstream := setupTestService(t)
+			service.SetKeyStrategy(strategy)
+			ctx := context.Background()
+
+			blobData := []byte("shared layer")
+			digest := testDigest(blobData)
+			upstream.blobs[digest] = blobData
+
+			for _, name := range []string{"repo-a", "repo-b", "repo-a"} {
+				reader, _, err := service.GetBlob(ctx, name, digest)
+				if err != nil {
+					t.Fatalf("GetBlob from %s failed: %v", name, err)
+				}
+				data, err := io.ReadAll(reader)
+				reader.Close()
+				if err != nil || !bytes.Equal(data, blobData) {
+					t.Fatalf("Blob data mismatch from %s (err=%v)", name, err)
+				}
+				if streaming, ok := reader.(*streamingBlobReader); ok {
+					<-streaming.cacheFinished
+				}
+			}
+
+			expected := 1
+			if strategy == docker.KeyByRepository {
+				expected = 2
+			}
+			if count := upstream.requestCount(); count != expected {
+				t.Errorf("Expected %d upstream requests, got %d", expected, count)
+			}
+			for _, name := range []string{"repo-a", "repo-b"} {
+				if _, err := testStorage.GetMeta(ctx, strategy.Key(name, digest)); err != nil {
+					t.Errorf("Expected the blob cached under %s: %v", strategy.Key(name, digest), err)
+				}
+			}
+		})
+	}
+}
+
+// TestDockerRegistryProxyServiceRequireManifestReference tests that with the mode enabled only
+// blobs referenced by a manifest pulled from the same repository are served
+func TestDockerRegistryProxyServiceRequireManifestReference(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetRequireManifestReference(true)
+	ctx := context.Background()
+
+	layer := []byte("referenced layer")
+	orphan := []byte("orphan blob")
+	layerDigest, orphanDigest := testDigest(layer), testDigest(orphan)
+	upstream.blobs[layerDigest] = layer
+	upstream.blobs[orphanDigest] = orphan
+	upstream.manifests["test-repo/latest"] = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","size":16,"digest":"` + layerDigest + `"}]}`)
+
+	// Not served before the manifest referencing it was pulled
+	var registryErr *docker.RegistryError
+	if _, _, err := service.GetBlob(ctx, "test-repo", layerDigest); !errors.As(err, &registryErr) || registryErr.Code != "BLOB_UNKNOWN" {
+		t.Fatalf("Expected BLOB_UNKNOWN before the manifest pull, got %v", err)
+	}
+
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	pullBlob(t, service, layerDigest)
+
+	if _, _, err := service.GetBlob(ctx, "test-repo", orphanDigest); !errors.As(err, &registryErr) || registryErr.Code != "BLOB_UNKNOWN" {
+		t.Errorf("Expected BLOB_UNKNOWN for an orphan blob, got %v", err)
+	}
+	if _, _, err := service.GetBlob(ctx, "other-repo", layerDigest); err == nil {
+		t.Error("Expected a blob referenced only in another repository to be rejected")
+	}
+	if exists, _, _ := service.CheckBlobExists(ctx, "test-repo", orphanDigest); exists {
+		t.Error("Expected an orphan blob to be reported missing")
+	}
+
+	// Default off: any blob upstream has is served
+	service.SetRequireManifestReference(false)
+	reader, _, err := service.GetBlob(ctx, "test-repo", orphanDigest)
+	if err != nil {
+		t.Fatalf("Expected orphan blob to be served with the mode disabled: %v", err)
+	}
+	io.Copy(io.Discard, reader)
+	reader.Close()
+	if streaming, ok := reader.(*streamingBlobReader); ok {
+		<-streaming.cacheFinished
+	}
+}
+
+// testVariantMediaType is the layer media type produced by deflateLayerCodec
+const testVariantMediaType = "application/vnd.brm.test.layer.v1.tar+deflate"
+
+// deflateLayerCodec re-encodes layers as raw deflate under a new media type
+type deflateLayerCodec struct{}
+
+func (deflateLayerCodec) Name() string                   { return "deflate" }
+func (deflateLayerCodec) MediaType(source string) string { return testVariantMediaType }
+func (deflateLayerCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.BestCompression)
+}
+
+// TestDockerRegistryProxyServiceLayerRecompression tests that a cached layer is recompressed, served
+// through a rewritten manifest to clients accepting the variant (also by digest), and that other
+// clients keep getting the original manifest and layer
+func TestDockerRegistryProxyServiceLayerRecompression(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetLayerRecompression(deflateLayerCodec{})
+	ctx := context.Background()
+	acceptingCtx := WithAcceptedMediaTypes(ctx, []string{docker.MediaTypeOCIManifest + ", " + testVariantMediaType + ";q=0.9"})
+
+	tarData := bytes.Repeat([]byte("uncompressed layer tar "), 500)
+	var gzipped bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&gzipped, gzip.NoCompression)
+	gz.Write(tarData)
+	gz.Close()
+	layer := gzipped.Bytes()
+	layerDigest := testDigest(layer)
+	upstream.blobs[layerDigest] = layer
+	original := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		docker.MediaTypeOCIManifest, docker.MediaTypeOCILayer, len(layer), layerDigest))
+	upstream.manifests["test-repo/latest"] = original
+
+	if _, _, err := service.GetManifest(ctx, "test-repo", "latest"); err != nil {
+		t.Fatalf("GetManifest failed: %v", err)
+	}
+	pullBlob(t, service, layerDigest)
+
+	// Non-supporting clients get the upstream manifest
+	data, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest")
+	if err != nil || !bytes.Equal(data, original) || digest != testDigest(original) {
+		t.Fatalf("Expected the original manifest without Accept support (err=%v)", err)
+	}
+
+	data, _, rewrittenDigest, err := service.GetManifestWithDigest(acceptingCtx, "test-repo", "latest")
+	if err != nil {
+		t.Fatalf("GetManifestWithDigest failed: %v", err)
+	}
+	if rewrittenDigest != testDigest(data) || rewrittenDigest == digest {
+		t.Fatalf("Expected a rewritten manifest with its own digest, got %s", rewrittenDigest)
+	}
+	manifest, err := docker.ParseManifest(data)
+	if err != nil || len(manifest.Layers) != 1 {
+		t.Fatalf("Failed to parse rewritten manifest (err=%v)", err)
+	}
+	variant := manifest.Layers[0]
+	if variant.MediaType != testVariantMediaType || variant.Digest == layerDigest || variant.Size >= int64(len(layer)) {
+		t.Fatalf("Expected a smaller variant layer descriptor, got %+v", variant)
+	}
+
+	// The variant round-trips to the original tar and matches its descriptor
+	reader, size, err := service.GetBlob(acceptingCtx, "test-repo", variant.Digest)
+	if err != nil {
+		t.Fatalf("GetBlob of the variant failed: %v", err)
+	}
+	encoded, _ := io.ReadAll(reader)
+	reader.Close()
+	if testDigest(encoded) != variant.Digest || size != variant.Size || int64(len(encoded)) != variant.Size {
+		t.Errorf("Variant content doesn't match its descriptor")
+	}
+	decoded, err := io.ReadAll(flate.NewReader(bytes.NewReader(encoded)))
+	if err != nil || !bytes.Equal(decoded, tarData) {
+		t.Errorf("Expected the variant to decode to the original tar (err=%v)", err)
+	}
+
+	// Pulls by the rewritten digest are served locally; HEAD reports the digest clients will get
+	requests := upstream.requestCount()
+	if data, _, err := service.GetManifest(acceptingCtx, "test-repo", rewrittenDigest); err != nil || testDigest(data) != rewrittenDigest {
+		t.Errorf("Expected the rewritten manifest by digest (err=%v)", err)
+	}
+	if count := upstream.requestCount(); count != requests {
+		t.Errorf("Expected no upstream request for the rewritten manifest, got %d", count-requests)
+	}
+	if _, headDigest, _ := service.CheckManifestExists(acceptingCtx, "test-repo", "latest"); headDigest != rewrittenDigest {
+		t.Errorf("Expected HEAD to report %s, got %s", rewrittenDigest, headDigest)
+	}
+	if _, headDigest, _ := service.CheckManifestExists(ctx, "test-repo", "latest"); headDigest != digest {
+		t.Errorf("Expected HEAD without Accept support to report %s, got %s", digest, headDigest)
+	}
+
+	reader, _, err = service.GetBlob(ctx, "test-repo", layerDigest)
+	if err != nil {
+		t.Fatalf("GetBlob of the original failed: %v", err)
+	}
+	served, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(served, layer) {
+		t.Error("Expected the original layer to still be served")
+	}
+}
+
+// TestDockerRegistryProxyServicePingUpstream tests that readiness pings fall back to mirrors
+func TestDockerRegistryProxyServicePingUpstream(t *testing.T) {
+	service, _, _ := setupTestServiceWithMirror(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if err := service.PingUpstream(context.Background()); err != nil {
+		t.Errorf("Expected reachable mirror, got %v", err)
+	}
+
+	unreachable, err := NewDockerRegistryProxyService("test-storage", &models.UpstreamRegistry{URL: "http://127.0.0.1:1"}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	if err := unreachable.PingUpstream(context.Background()); err == nil {
+		t.Error("Expected unreachable upstream to fail")
+	}
+}
+
+// TestDockerRegistryProxyServiceServeStaleOnError tests that expired cache entries are served
+// with a Warning header when upstream fails, only when the mode is enabled
+func TestDockerRegistryProxyServiceServeStaleOnError(t *testing.T) {
+	for _, serveStale := range []bool{true, false} {
+		t.Run(fmt.Sprintf("serveStale=%v", serveStale), func(t *testing.T) {
+			service, testStorage, upstream := setupTestService(t)
+			service.SetServeStaleOnError(serveStale)
+			service.SetTagCacheTTL(time.Minute)
+			now := time.Now()
+			service.tagCache.now = func() time.Time { return now }
+			ctx := context.Background()
+
+			blobData := []byte("stale blob")
+			blobDigest := testDigest(blobData)
+			upstream.blobs[blobDigest] = blobData
+			manifestData := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+			manifestDigest := testDigest(manifestData)
+			upstream.manifests["test-repo/latest"] = manifestData
+
+			pullBlob(t, service, blobDigest)
+			if _, _, _, err := service.GetManifestWithDigest(ctx, "test-repo", "latest"); err != nil {
+				t.Fatalf("GetManifestWithDigest failed: %v", err)
+			}
+
+			// Expire every cached entry, then take upstream down
+			for _, key := range []string{service.getCacheKey("test-repo", blobDigest), service.getCacheKey("test-repo", manifestDigest)} {
+				meta, err := testStorage.GetMeta(ctx, key)
+				if err != nil {
+					t.Fatalf("Expected %s to be cached: %v", key, err)
+				}
+				meta.CreatedTimestamp = 1
+				if _, err := testStorage.UpdateMeta(ctx, *meta); err != nil {
+					t.Fatalf("UpdateMeta failed: %v", err)
+				}
+			}
+			now = now.Add(2 * time.Minute)
+			upstream.down.Store(true)
+
+			mux := http.NewServeMux()
+			SetupRoutes(mux, service)
+			tests := []struct {
+				method, path string
+				body         []byte
+			}{
+				{http.MethodGet, "/v2/test-repo/manifests/latest", manifestData},
+				{http.MethodGet, "/v2/test-repo/manifests/" + manifestDigest, manifestData},
+				{http.MethodHead, "/v2/test-repo/manifests/latest", nil},
+				{http.MethodGet, "/v2/test-repo/blobs/" + blobDigest, blobData},
+				{http.MethodHead, "/v2/test-repo/blobs/" + blobDigest, nil},
+			}
+			for _, tt := range tests {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+				if !serveStale {
+					if rec.Code != http.StatusNotFound || rec.Header().Get("Warning") != "" {
+						t.Errorf("%s %s: expected 404 without Warning, got %d %q", tt.method, tt.path, rec.Code, rec.Header().Get("Warning"))
+					}
+					continue
+				}
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s %s: expected stale 200, got %d", tt.method, tt.path, rec.Code)
+					continue
+				}
+				if warning := rec.Header().Get("Warning"); warning != StaleWarning {
+					t.Errorf("%s %s: expected Warning %q, got %q", tt.method, tt.path, StaleWarning, warning)
+				}
+				if tt.body != nil && !bytes.Equal(rec.Body.Bytes(), tt.body) {
+					t.Errorf("%s %s: stale body mismatch", tt.method, tt.path)
+				}
+			}
+		})
+	}
+}
+
+// TestDockerRegistryProxyServiceBlobETags tests that a cached blob re-requested with its ETag gets 304
+func TestDockerRegistryProxyServiceBlobETags(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetBlobETags(true)
+	blobData := []byte("etag blob")
+	digest := testDigest(blobData)
+	upstream.blobs[digest] = blobData
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/test-repo/blobs/"+digest, nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag != `"`+digest+`"` || !bytes.Equal(rec.Body.Bytes(), blobData) {
+		t.Fatalf("Expected the blob with its digest as ETag, got %d %q", rec.Code, etag)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		req := httptest.NewRequest(method, "/v2/test-repo/blobs/"+digest, nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%s: expected an empty 304, got %d (%d bytes)", method, rec.Code, rec.Body.Len())
+		}
+	}
+}
+
+// TestDockerRegistryProxyServiceUpstreamTimeout tests that upstream operations outliving the
+// configured deadline are cancelled and answered with 504
+func TestDockerRegistryProxyServiceUpstreamTimeout(t *testing.T) {
+	cancelled := make(chan struct{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	testStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+	service, err := NewDockerRegistryProxyService("test-storage", &models.UpstreamRegistry{URL: server.URL}, 0)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	service.SetStorage(testStorage)
+	service.SetUpstreamTimeout(100 * time.Millisecond)
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+
+	digest := testDigest([]byte("slow"))
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/v2/test-repo/manifests/latest"},
+		{http.MethodHead, "/v2/test-repo/manifests/latest"},
+		{http.MethodGet, "/v2/test-repo/blobs/" + digest},
+		{http.MethodHead, "/v2/test-repo/blobs/" + digest},
+	} {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s %s: expected 504, got %d", req.method, req.path, rec.Code)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+			t.Errorf("%s %s: expected to fail at the deadline, took %s", req.method, req.path, elapsed)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s %s: upstream request was not cancelled", req.method, req.path)
+		}
+	}
+}
+
+// TestDockerRegistryProxyServiceTagMappings tests that with tag mappings, pulls by tag and by digest
+// are both served from storage, and that a revalidated tag that moved upstream is remapped
+func TestDockerRegistryProxyServiceTagMappings(t *testing.T) {
+	service, _, upstream := setupTestService(t)
+	service.SetTagMappings(true)
+	manifestV1 := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	upstream.manifests["test-repo/latest"] = manifestV1
+	ctx := context.Background()
+
+	if _, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest"); err != nil || digest != testDigest(manifestV1) {
+		t.Fatalf("Expected the upstream manifest, got %s: %v", digest, err)
+	}
+	fetched := upstream.requestCount()
+
+	for _, reference := range []string{"latest", testDigest(manifestV1)} {
+		data, mediaType, digest, err := service.GetManifestWithDigest(ctx, "test-repo", reference)
+		if err != nil || !bytes.Equal(data, manifestV1) || digest != testDigest(manifestV1) || mediaType != docker.MediaTypeOCIManifest {
+			t.Errorf("%s: expected the cached manifest, got %s %s: %v", reference, digest, mediaType, err)
+		}
+		if exists, digest, err := service.CheckManifestExists(ctx, "test-repo", reference); err != nil || !exists || digest != testDigest(manifestV1) {
+			t.Errorf("%s: expected the cached manifest to exist, got %v %s: %v", reference, exists, digest, err)
+		}
+	}
+	if n := upstream.requestCount(); n != fetched {
+		t.Errorf("Expected tag and digest pulls to be served from cache, got %d upstream requests", n-fetched)
+	}
+
+	// Move the tag upstream; revalidation picks it up and the mapping follows
+	manifestV2 := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],"annotations":{"v":"2"}}`)
+	upstream.manifests["test-repo/latest"] = manifestV2
+	service.SetRevalidateAlways(true)
+	if _, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest"); err != nil || digest != testDigest(manifestV2) {
+		t.Fatalf("Expected the moved tag after revalidation, got %s: %v", digest, err)
+	}
+	service.SetRevalidateAlways(false)
+	fetched = upstream.requestCount()
+	if _, _, digest, err := service.GetManifestWithDigest(ctx, "test-repo", "latest"); err != nil || digest != testDigest(manifestV2) {
+		t.Errorf("Expected the remapped tag, got %s: %v", digest, err)
+	}
+	if n := upstream.requestCount(); n != fetched {
+		t.Errorf("Expected the remapped tag to be served from cache, got %d upstream requests", n-fetched)
+	}
+}
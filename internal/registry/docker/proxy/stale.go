@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// StaleWarning is the Warning header value set on responses served from expired cache entries
+const StaleWarning = `110 - "Response is Stale"`
+
+// staleKey is the context key of the flag recording that stale content was served
+type staleKey struct{}
+
+// withStaleReport returns a context recording in the returned flag whether a request made with it
+// was served from an expired cache entry
+func withStaleReport(ctx context.Context) (context.Context, *atomic.Bool) {
+	served := new(atomic.Bool)
+	return context.WithValue(ctx, staleKey{}, served), served
+}
+
+// reportStale records on ctx (if made with withStaleReport) that stale content was served
+func reportStale(ctx context.Context) {
+	if served, ok := ctx.Value(staleKey{}).(*atomic.Bool); ok {
+		served.Store(true)
+	}
+}
+
+// SetServeStaleOnError makes requests whose upstream fetch fails serve the expired cached copy, if
+// any, instead of failing. Handlers mark such responses with a StaleWarning header. Tags are only
+// served stale while the tag cache (SetTagCacheTTL) or a tag mapping (SetTagMappings) still
+// remembers their digest. Off by default.
+func (s *DockerRegistryProxyService) SetServeStaleOnError(enabled bool) {
+	s.serveStale = enabled
+}
+
+// ServeStaleOnError reports whether expired cache entries are served when upstream fails
+func (s *DockerRegistryProxyService) ServeStaleOnError() bool {
+	return s.serveStale
+}
+
+// staleAllowed reports whether a failed upstream request of repository name may fall back to stale content
+func (s *DockerRegistryProxyService) staleAllowed(ctx context.Context, name string) bool {
+	return s.serveStale && !s.bypassCache(ctx, name)
+}
+
+// staleManifest returns the cached manifest of reference regardless of its age, after upstream failed
+func (s *DockerRegistryProxyService) staleManifest(ctx context.Context, name, reference string) (*docker.CachedManifest, bool) {
+	if !s.staleAllowed(ctx, name) {
+		return nil, false
+	}
+	digest, mediaType := reference, ""
+	if !isDigestReference(reference) {
+		entry, ok := s.tagCache.last(s.getManifestCacheKey(name, reference))
+		if ok {
+			digest, mediaType = entry.digest, entry.mediaType
+		} else if !s.tagMappings {
+			return nil, false
+		} else {
+			mapping, ok := s.readTagMapping(ctx, name, reference)
+			if !ok {
+				return nil, false
+			}
+			digest, mediaType = mapping.digest, mapping.mediaType
+		}
+	}
+
+	cached, ok := s.manifestCache.Get(s.getManifestCacheKey(name, digest))
+	if !ok {
+		data, ok := s.readStoredManifest(ctx, s.getCacheKey(name, digest))
+		if !ok {
+			return nil, false
+		}
+		if mediaType == "" {
+			mediaType = manifestMediaType(data)
+		}
+		cached = &docker.CachedManifest{Data: data, MediaType: mediaType, Digest: digest}
+	}
+	reportStale(ctx)
+	return cached, true
+}
+
+// staleBlob opens the cached blob of repository name regardless of its age, after upstream failed
+func (s *DockerRegistryProxyService) staleBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, bool) {
+	if !s.staleAllowed(ctx, name) {
+		return nil, 0, false
+	}
+	rc, actualRange, err := s.storage.Read(ctx, models.ArtifactRange{
+		Hash:  s.getCacheKey(name, digest),
+		Range: models.ByteRange{Offset: 0, Length: -1},
+	})
+	if err != nil {
+		return nil, 0, false
+	}
+	reportStale(ctx)
+	return rc, actualRange.Range.Length, true
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// tagCacheMaxEntries bounds the tag cache; expired entries are pruned once it is exceeded
+const tagCacheMaxEntries = 10000
+
+// tagCacheEntry is a resolved tag with its expiry time
+type tagCacheEntry struct {
+	digest    string
+	mediaType string
+	expires   time.Time
+}
+
+// tagCache maps "name:tag" to the manifest digest it resolved to upstream, for a short TTL.
+// Within the window, tag pulls are served from the digest caches without asking upstream.
+type tagCache struct {
+	ttl     time.Duration
+	entries map[string]tagCacheEntry
+	now     func() time.Time // Replaceable in tests
+	mu      sync.Mutex
+}
+
+// newTagCache creates a tag cache with the given TTL.
+// Returns nil if ttl <= 0 (disabled); all methods are safe to call on nil.
+func newTagCache(ttl time.Duration) *tagCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &tagCache{
+		ttl:     ttl,
+		entries: make(map[string]tagCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the unexpired entry for key
+func (c *tagCache) get(key string) (tagCacheEntry, bool) {
+	entry, ok := c.last(key)
+	if !ok || !c.now().Before(entry.expires) {
+		return tagCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// last returns the entry for key even if it expired (expired entries are kept until pruned),
+// for serving stale content when upstream fails
+func (c *tagCache) last(key string) (tagCacheEntry, bool) {
+	if c == nil {
+		return tagCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// add records that key resolved to digest
+func (c *tagCache) add(key, digest, mediaType string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= tagCacheMaxEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = tagCacheEntry{digest: digest, mediaType: mediaType, expires: now.Add(c.ttl)}
+}
+
+// remove drops the entry for key
+func (c *tagCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// tagMappingKeyPrefix is the prefix of the storage keys of tag -> digest mappings
+const tagMappingKeyPrefix = "proxy-tag:"
+
+// tagMappingRepo marks the reference holding the digest in a tag mapping
+const tagMappingRepo = "digest"
+
+// tagMapping is a tag -> digest resolution kept in storage
+type tagMapping struct {
+	digest    string
+	mediaType string
+	resolved  time.Time // When upstream last resolved the tag
+}
+
+// SetTagMappings makes manifest fetches also record a tag -> digest mapping in storage, next to the
+// manifest cached under its digest, so tag pulls resolve through the mapping and digest pulls are
+// served from the stored manifest, both without asking upstream. A mapping is trusted for the cache
+// TTL (never with SetRevalidateAlways), then resolved upstream again and replaced if the tag moved.
+// Off by default.
+func (s *DockerRegistryProxyService) SetTagMappings(enabled bool) {
+	s.tagMappings = enabled
+}
+
+// TagMappings reports whether manifests are cached by tag as well as by digest
+func (s *DockerRegistryProxyService) TagMappings() bool {
+	return s.tagMappings
+}
+
+// getTagMappingKey generates the storage key of the mapping of tag in repository name.
+// Name and tag are escaped so the key is flat (no path separators) and unambiguous.
+func (s *DockerRegistryProxyService) getTagMappingKey(name, tag string) string {
+	return tagMappingKeyPrefix + url.QueryEscape(name) + ":" + url.QueryEscape(tag)
+}
+
+// readTagMapping returns the stored mapping of tag in repository name, regardless of its age
+func (s *DockerRegistryProxyService) readTagMapping(ctx context.Context, name, tag string) (tagMapping, bool) {
+	meta, err := s.storage.GetMeta(ctx, s.getTagMappingKey(name, tag))
+	if err != nil || meta == nil {
+		return tagMapping{}, false
+	}
+	for _, ref := range meta.References {
+		if ref.Repo == tagMappingRepo && ref.Name != "" {
+			return tagMapping{digest: ref.Name, mediaType: meta.MediaType, resolved: time.Unix(ref.ReferencedTimestamp, 0)}, true
+		}
+	}
+	return tagMapping{}, false
+}
+
+// tagMappingExpired reports whether mapping must be resolved upstream again
+func (s *DockerRegistryProxyService) tagMappingExpired(mapping tagMapping) bool {
+	if s.revalidate {
+		return true
+	}
+	return s.cacheTTL > 0 && time.Since(mapping.resolved) > s.cacheTTL
+}
+
+// writeTagMapping records that tag in repository name resolved to digest upstream.
+// An existing mapping is replaced rather than merged so a moved tag never resolves to its old digest.
+func (s *DockerRegistryProxyService) writeTagMapping(ctx context.Context, name, tag, digest, mediaType string) error {
+	key := s.getTagMappingKey(name, tag)
+	now := time.Now().Unix()
+	digestRef := []models.ArtifactReference{{Name: digest, Repo: tagMappingRepo, ReferencedTimestamp: now}}
+
+	if _, err := s.storage.GetMeta(ctx, key); err == nil {
+		_, err := storage.ModifyMeta(ctx, s.storage, key, func(meta *models.ArtifactMeta) error {
+			meta.References = digestRef
+			meta.MediaType = mediaType
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update tag mapping: %w", err)
+		}
+		return nil
+	}
+
+	meta := &models.ArtifactMeta{
+		Hash:             key,
+		Length:           0,
+		CreatedTimestamp: now,
+		References:       digestRef,
+		MediaType:        mediaType,
+	}
+	created, err := s.storage.Create(ctx, key, bytes.NewReader(nil), 0, meta)
+	if err != nil {
+		return fmt.Errorf("failed to create tag mapping: %w", err)
+	}
+	// A mapping created concurrently was merged into: keep this digest alone
+	if len(created.References) > 1 {
+		created.References = digestRef
+		if _, err := s.storage.UpdateMeta(ctx, *created); err != nil {
+			return fmt.Errorf("failed to update tag mapping: %w", err)
+		}
+	}
+	return nil
+}
+
+// lookupStoredManifest serves reference from storage when tag mappings are enabled: a digest from
+// the unexpired manifest cached under it, a tag from the manifest its unexpired mapping points at
+// (content is immutable, so only the mapping's age matters)
+func (s *DockerRegistryProxyService) lookupStoredManifest(ctx context.Context, name, reference string) (*docker.CachedManifest, bool) {
+	if !s.tagMappings {
+		return nil, false
+	}
+	if isDigestReference(reference) {
+		data, ok := s.readCachedManifest(ctx, s.getCacheKey(name, reference))
+		if !ok {
+			return nil, false
+		}
+		return &docker.CachedManifest{Data: data, MediaType: manifestMediaType(data), Digest: reference}, true
+	}
+
+	mapping, ok := s.readTagMapping(ctx, name, reference)
+	if !ok || s.tagMappingExpired(mapping) {
+		return nil, false
+	}
+	data, ok := s.readStoredManifest(ctx, s.getCacheKey(name, mapping.digest))
+	if !ok {
+		return nil, false
+	}
+	mediaType := mapping.mediaType
+	if mediaType == "" {
+		mediaType = manifestMediaType(data)
+	}
+	return &docker.CachedManifest{Data: data, MediaType: mediaType, Digest: mapping.digest}, true
+}
+
+// manifestMediaType returns the media type a manifest declares, defaulting to an OCI image manifest
+func manifestMediaType(data []byte) string {
+	if manifest, err := docker.ParseManifest(data); err == nil && manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	return docker.MediaTypeOCIManifest
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tag refresh defaults (see SetTagRefresh)
+const (
+	DefaultTagRefreshMinHits   = 3
+	DefaultTagRefreshLookAhead = 10 * time.Second
+)
+
+// TagRefreshConfig configures the proactive refresh of popular tags.
+// A tag pulled at least MinHits times since its last resolution is re-resolved upstream in the
+// background once its tag cache entry is within LookAhead of expiring, so hot tags keep being
+// served from the cache. Interval is how often tags are checked (0 = half of LookAhead).
+type TagRefreshConfig struct {
+	MinHits   int
+	LookAhead time.Duration
+	Interval  time.Duration
+}
+
+// withDefaults fills zero fields with the defaults
+func (c TagRefreshConfig) withDefaults() TagRefreshConfig {
+	if c.MinHits <= 0 {
+		c.MinHits = DefaultTagRefreshMinHits
+	}
+	if c.LookAhead <= 0 {
+		c.LookAhead = DefaultTagRefreshLookAhead
+	}
+	if c.Interval <= 0 {
+		c.Interval = c.LookAhead / 2
+	}
+	return c
+}
+
+// trackedTag is a tag pulled since its last resolution
+type trackedTag struct {
+	name, tag string
+	hits      int
+}
+
+// tagRefresher counts tag pulls and runs the background refresh loop
+type tagRefresher struct {
+	config TagRefreshConfig
+	tags   map[string]*trackedTag // Tag cache key -> pulls
+	stop   chan struct{}
+	mu     sync.Mutex
+}
+
+// SetTagRefresh enables proactively refreshing popular tags before their tag cache entry expires
+// (see TagRefreshConfig), replacing any previous refresh loop. It requires the tag cache
+// (SetTagCacheTTL) and runs until DisableTagRefresh; zero fields use the defaults.
+func (s *DockerRegistryProxyService) SetTagRefresh(config TagRefreshConfig) {
+	s.DisableTagRefresh()
+	refresher := &tagRefresher{
+		config: config.withDefaults(),
+		tags:   make(map[string]*trackedTag),
+		stop:   make(chan struct{}),
+	}
+	s.refresher = refresher
+	go s.runTagRefresh(refresher)
+}
+
+// DisableTagRefresh stops the tag refresh loop, if running
+func (s *DockerRegistryProxyService) DisableTagRefresh() {
+	if s.refresher != nil {
+		close(s.refresher.stop)
+		s.refresher = nil
+	}
+}
+
+// TagRefresh returns the tag refresh settings and whether refreshing is enabled
+func (s *DockerRegistryProxyService) TagRefresh() (TagRefreshConfig, bool) {
+	if s.refresher == nil {
+		return TagRefreshConfig{}, false
+	}
+	return s.refresher.config, true
+}
+
+// runTagRefresh refreshes due tags every interval until the refresher is stopped
+func (s *DockerRegistryProxyService) runTagRefresh(refresher *tagRefresher) {
+	ticker := time.NewTicker(refresher.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-refresher.stop:
+			return
+		case <-ticker.C:
+			s.refreshHotTags(context.Background(), refresher)
+		}
+	}
+}
+
+// recordTagPull counts a pull of name:tag towards its popularity
+func (s *DockerRegistryProxyService) recordTagPull(name, reference string) {
+	refresher := s.refresher
+	if refresher == nil || s.tagCache == nil || isDigestReference(reference) {
+		return
+	}
+	key := s.getManifestCacheKey(name, reference)
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+	tracked, ok := refresher.tags[key]
+	if !ok {
+		tracked = &trackedTag{name: name, tag: reference}
+		refresher.tags[key] = tracked
+	}
+	tracked.hits++
+}
+
+// refreshHotTags re-resolves upstream the popular tags whose tag cache entry expires within the
+// look-ahead, and returns how many were refreshed. Every tag's count restarts once its entry is
+// renewed or gone, so popularity is measured per tag cache window.
+func (s *DockerRegistryProxyService) refreshHotTags(ctx context.Context, refresher *tagRefresher) int {
+	if s.tagCache == nil {
+		return 0
+	}
+	due := s.dueTags(refresher)
+	refreshed := 0
+	for _, tracked := range due {
+		if _, _, _, err := s.resolveManifest(WithNoCache(ctx), tracked.name, tracked.tag); err == nil {
+			refreshed++
+		}
+	}
+	return refreshed
+}
+
+// dueTags removes and returns the tracked tags to refresh now, dropping tags that are not
+// popular enough once their tag cache entry is due
+func (s *DockerRegistryProxyService) dueTags(refresher *tagRefresher) []*trackedTag {
+	now := s.tagCache.now()
+	refresher.mu.Lock()
+	defer refresher.mu.Unlock()
+
+	var due []*trackedTag
+	for key, tracked := range refresher.tags {
+		entry, ok := s.tagCache.last(key)
+		if ok && now.Before(entry.expires.Add(-refresher.config.LookAhead)) {
+			continue
+		}
+		delete(refresher.tags, key)
+		if ok && tracked.hits >= refresher.config.MinHits {
+			due = append(due, tracked)
+		}
+	}
+	return due
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// upstreamSet tries an ordered list of upstream clients (primary first, then mirrors).
+// A request falls through to the next upstream on error or not-found, and the upstream
+// that served each successful request is counted.
+type upstreamSet struct {
+	clients []*DockerRegistryProxyClient
+	served  map[string]int64 // upstream base URL -> number of requests served
+	timeout time.Duration    // Optional deadline of each operation across all upstreams (0 = none)
+	mu      sync.Mutex
+}
+
+// errUpstreamTimeout is wrapped by errors of upstream operations that exceeded their deadline
+var errUpstreamTimeout = errors.New("upstream operation timed out")
+
+// newUpstreamSet creates clients for the primary upstream and each of its mirrors
+func newUpstreamSet(upstream *models.UpstreamRegistry) *upstreamSet {
+	set := &upstreamSet{served: make(map[string]int64)}
+	set.clients = append(set.clients, NewDockerRegistryProxyClient(upstream))
+	for i := range upstream.Mirrors {
+		if upstream.Mirrors[i].URL == "" {
+			continue
+		}
+		set.clients = append(set.clients, NewDockerRegistryProxyClient(&upstream.Mirrors[i]))
+	}
+	return set
+}
+
+// setUserAgent sets the User-Agent header on every upstream client
+func (u *upstreamSet) setUserAgent(userAgent string) {
+	for _, client := range u.clients {
+		client.SetUserAgent(userAgent)
+	}
+}
+
+// recordServed counts a request served by the given upstream
+func (u *upstreamSet) recordServed(client *DockerRegistryProxyClient) {
+	u.mu.Lock()
+	u.served[client.baseURL]++
+	u.mu.Unlock()
+}
+
+// servedCounts returns a snapshot of how many requests each upstream served
+func (u *upstreamSet) servedCounts() map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := make(map[string]int64, len(u.served))
+	for url, n := range u.served {
+		counts[url] = n
+	}
+	return counts
+}
+
+// allFailed wraps the last error once every upstream has been tried
+func (u *upstreamSet) allFailed(err error) error {
+	if len(u.clients) == 1 {
+		return err
+	}
+	return fmt.Errorf("all %d upstreams failed, last error: %w", len(u.clients), err)
+}
+
+// withDeadline derives the context of one upstream operation, cancelled with errUpstreamTimeout
+// once the operation deadline passes
+func (u *upstreamSet) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if u.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, u.timeout, errUpstreamTimeout)
+}
+
+// failed is allFailed, reporting errUpstreamTimeout when the operation context hit its deadline
+func (u *upstreamSet) failed(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), errUpstreamTimeout) {
+		return fmt.Errorf("%w after %s: %w", errUpstreamTimeout, u.timeout, err)
+	}
+	return u.allFailed(err)
+}
+
+// cancelOnClose releases the context of an upstream blob body when it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and cancels its context
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// GetManifest fetches a manifest from the first upstream that has it
+func (u *upstreamSet) GetManifest(ctx context.Context, name, reference string) ([]byte, string, error) {
+	ctx, cancel := u.withDeadline(ctx)
+	defer cancel()
+	var lastErr error
+	for _, client := range u.clients {
+		data, mediaType, err := client.GetManifest(ctx, name, reference)
+		if err == nil {
+			u.recordServed(client)
+			return data, mediaType, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, "", u.failed(ctx, lastErr)
+}
+
+// CheckManifestExists checks the upstreams in order until one has the manifest.
+// Returns (false, "", nil) if every reachable upstream reports not-found.
+func (u *upstreamSet) CheckManifestExists(ctx context.Context, name, reference string) (bool, string, error) {
+	ctx, cancel := u.withDeadline(ctx)
+	defer cancel()
+	var lastErr error
+	notFound := false
+	for _, client := range u.clients {
+		exists, digest, err := client.CheckManifestExists(ctx, name, reference)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if exists {
+			u.recordServed(client)
+			return true, digest, nil
+		}
+		notFound = true
+	}
+	if notFound {
+		return false, "", nil
+	}
+	return false, "", u.failed(ctx, lastErr)
+}
+
+// GetBlob fetches a blob from the first upstream that has it. The operation deadline covers
+// waiting for an upstream to answer, not streaming the body, so large blobs aren't cut off.
+func (u *upstreamSet) GetBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	if u.timeout > 0 {
+		timer := time.AfterFunc(u.timeout, func() { cancel(errUpstreamTimeout) })
+		defer timer.Stop()
+	}
+	var lastErr error
+	for _, client := range u.clients {
+		rc, size, err := client.GetBlob(ctx, name, digest)
+		if err == nil {
+			u.recordServed(client)
+			return &cancelOnClose{ReadCloser: rc, cancel: func() { cancel(nil) }}, size, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	err := u.failed(ctx, lastErr)
+	cancel(nil)
+	return nil, 0, err
+}
+
+// CheckBlobExists checks the upstreams in order until one has the blob.
+// Returns (false, 0, nil) if every reachable upstream reports not-found.
+func (u *upstreamSet) CheckBlobExists(ctx context.Context, name, digest string) (bool, int64, error) {
+	ctx, cancel := u.withDeadline(ctx)
+	defer cancel()
+	var lastErr error
+	notFound := false
+	for _, client := range u.clients {
+		exists, size, err := client.CheckBlobExists(ctx, name, digest)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if exists {
+			u.recordServed(client)
+			return true, size, nil
+		}
+		notFound = true
+	}
+	if notFound {
+		return false, 0, nil
+	}
+	return false, 0, u.failed(ctx, lastErr)
+}
+
+// Ping succeeds if any upstream is reachable
+func (u *upstreamSet) Ping(ctx context.Context) error {
+	var lastErr error
+	for _, client := range u.clients {
+		err := client.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return u.allFailed(lastErr)
+}
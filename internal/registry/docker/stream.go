@@ -0,0 +1,34 @@
+package docker
+
+import (
+	"context"
+	"io"
+
+	"github.com/basakil/brm-server/internal/storage"
+)
+
+// contextReader stops reading as soon as the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// StreamBlob copies a blob body to the client.
+// The copy goes through a pooled buffer of storage.CopyBufferSize bytes. Copying stops on the first write error (client disconnected) or when ctx is done,
+// and the blob reader is closed immediately so upstream/cache resources are released.
+// Returns the number of bytes written and the error that stopped the copy, if any.
+func StreamBlob(ctx context.Context, w io.Writer, rc io.ReadCloser) (int64, error) {
+	written, err := storage.CopyBuffer(w, &contextReader{ctx: ctx, r: rc})
+	closeErr := rc.Close()
+	if err != nil {
+		return written, err
+	}
+	return written, closeErr
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Event describes a registry operation on an artifact
+type Event struct {
+	Repository string
+	Digest     string
+	Tag        string // Empty when the operation addressed the manifest by digest
+	MediaType  string // Manifest events only
+	Size       int64
+	Timestamp  time.Time
+}
+
+// EventSink receives notifications of registry operations. Services call it after the
+// operation succeeded, on the request goroutine: implementations must return quickly
+// (queue slow work) and be safe for concurrent use. ctx is the request context.
+type EventSink interface {
+	// OnManifestPushed is called after a manifest was stored under a tag or digest
+	OnManifestPushed(ctx context.Context, event Event)
+	// OnManifestDeleted is called after a tag or manifest was deleted
+	OnManifestDeleted(ctx context.Context, event Event)
+	// OnBlobPushed is called after a blob upload was stored
+	OnBlobPushed(ctx context.Context, event Event)
+	// OnManifestCached is called after a proxy stored a manifest fetched from upstream
+	OnManifestCached(ctx context.Context, event Event)
+	// OnBlobCached is called after a proxy stored a blob fetched from upstream
+	OnBlobCached(ctx context.Context, event Event)
+}
+
+// NopSink ignores all events; embed it to implement only some EventSink methods
+type NopSink struct{}
+
+func (NopSink) OnManifestPushed(context.Context, Event)  {}
+func (NopSink) OnManifestDeleted(context.Context, Event) {}
+func (NopSink) OnBlobPushed(context.Context, Event)      {}
+func (NopSink) OnManifestCached(context.Context, Event)  {}
+func (NopSink) OnBlobCached(context.Context, Event)      {}
+
+// MultiSink fans events out to several sinks, in order
+type MultiSink []EventSink
+
+// NewMultiSink combines sinks, skipping nil ones; returns NopSink if none remain
+// and the sink itself if there is only one
+func NewMultiSink(sinks ...EventSink) EventSink {
+	var multi MultiSink
+	for _, sink := range sinks {
+		if sink != nil {
+			multi = append(multi, sink)
+		}
+	}
+	switch len(multi) {
+	case 0:
+		return NopSink{}
+	case 1:
+		return multi[0]
+	}
+	return multi
+}
+
+func (m MultiSink) OnManifestPushed(ctx context.Context, event Event) {
+	for _, sink := range m {
+		sink.OnManifestPushed(ctx, event)
+	}
+}
+
+func (m MultiSink) OnManifestDeleted(ctx context.Context, event Event) {
+	for _, sink := range m {
+		sink.OnManifestDeleted(ctx, event)
+	}
+}
+
+func (m MultiSink) OnBlobPushed(ctx context.Context, event Event) {
+	for _, sink := range m {
+		sink.OnBlobPushed(ctx, event)
+	}
+}
+
+func (m MultiSink) OnManifestCached(ctx context.Context, event Event) {
+	for _, sink := range m {
+		sink.OnManifestCached(ctx, event)
+	}
+}
+
+func (m MultiSink) OnBlobCached(ctx context.Context, event Event) {
+	for _, sink := range m {
+		sink.OnBlobCached(ctx, event)
+	}
+}
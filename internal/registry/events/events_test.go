@@ -0,0 +1,35 @@
+package events
+
+import (
+	"context"
+	"testing"
+)
+
+// countingSink counts manifest pushes
+type countingSink struct {
+	NopSink
+	pushed int
+}
+
+func (c *countingSink) OnManifestPushed(ctx context.Context, event Event) {
+	c.pushed++
+}
+
+// TestNewMultiSink tests fan-out to every subscriber and the nil/single shortcuts
+func TestNewMultiSink(t *testing.T) {
+	if _, ok := NewMultiSink(nil).(NopSink); !ok {
+		t.Error("Expected NopSink for no sinks")
+	}
+	single := &countingSink{}
+	if NewMultiSink(single, nil) != EventSink(single) {
+		t.Error("Expected a single sink to be returned as-is")
+	}
+
+	first, second := &countingSink{}, &countingSink{}
+	sink := NewMultiSink(first, second)
+	sink.OnManifestPushed(context.Background(), Event{Repository: "repo"})
+	sink.OnBlobPushed(context.Background(), Event{Repository: "repo"})
+	if first.pushed != 1 || second.pushed != 1 {
+		t.Errorf("Expected both sinks notified once, got %d and %d", first.pushed, second.pushed)
+	}
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/basakil/brm-server/internal/registry/docker/proxy"
+	"github.com/basakil/brm-server/internal/server"
+	"github.com/basakil/brm-server/internal/storage"
+)
+
+// storageAliaser is implemented by registries backed by a StorageManager storage
+type storageAliaser interface {
+	GetStorageAlias() string
+}
+
+// ReadinessChecks returns the checks deciding whether the registries can serve, for
+// server.HealthHandler: at least one registry is loaded, every storage backing a registry passes
+// a write probe (storage.ProbeWritable), and proxies with SetUpstreamReadinessCheck enabled reach
+// their upstream. Storages shared by several registries are probed once.
+func (rm *RegistryManager) ReadinessChecks() []server.ReadinessCheck {
+	registries := rm.Registries()
+	checks := []server.ReadinessCheck{{
+		Name: "registries",
+		Check: func(ctx context.Context) error {
+			if len(registries) == 0 {
+				return fmt.Errorf("no registries loaded")
+			}
+			return nil
+		},
+	}}
+
+	aliases := make(map[string]bool)
+	for _, registry := range registries {
+		if impl, ok := registry.(storageAliaser); ok {
+			aliases[impl.GetStorageAlias()] = true
+		}
+		if impl, ok := registry.(*proxy.DockerRegistryProxy); ok && impl.Service().UpstreamReadinessCheck() {
+			service := impl.Service()
+			checks = append(checks, server.ReadinessCheck{
+				Name:  "upstream:" + registry.Alias(),
+				Check: service.PingUpstream,
+			})
+		}
+	}
+	sorted := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		sorted = append(sorted, alias)
+	}
+	sort.Strings(sorted)
+	for _, alias := range sorted {
+		checks = append(checks, server.ReadinessCheck{
+			Name: "storage:" + alias,
+			Check: func(ctx context.Context) error {
+				instance, err := storage.GetManager().Get(alias)
+				if err != nil {
+					return err
+				}
+				return storage.ProbeWritable(ctx, instance)
+			},
+		})
+	}
+	return checks
+}
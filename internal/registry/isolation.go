@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// Storage isolation modes (the top-level "storageIsolation" setting), deciding what LoadFromConfig
+// does when several registries share a storage alias, and with it their content and references
+const (
+	StorageIsolationWarn    = "warn"    // Default: load them, and report the sharing in Warnings
+	StorageIsolationEnforce = "enforce" // Fail loading
+)
+
+// sharedStorages returns, by storage alias, the sorted aliases of the registries sharing it
+func sharedStorages(registries []models.Registry) map[string][]string {
+	byStorage := make(map[string][]string)
+	for _, registry := range registries {
+		if impl, ok := registry.(storageAliaser); ok {
+			byStorage[impl.GetStorageAlias()] = append(byStorage[impl.GetStorageAlias()], registry.Alias())
+		}
+	}
+	for storageAlias, aliases := range byStorage {
+		if len(aliases) < 2 {
+			delete(byStorage, storageAlias)
+			continue
+		}
+		sort.Strings(aliases)
+	}
+	return byStorage
+}
+
+// checkStorageIsolation applies the isolation mode ("" = StorageIsolationWarn) to registries:
+// shared storages are recorded as warnings, or fail the check when isolation is enforced
+func (rm *RegistryManager) checkStorageIsolation(registries []models.Registry, mode string) error {
+	if mode != "" && mode != StorageIsolationWarn && mode != StorageIsolationEnforce {
+		return fmt.Errorf("invalid storageIsolation mode: %s", mode)
+	}
+	shared := sharedStorages(registries)
+	storageAliases := make([]string, 0, len(shared))
+	for storageAlias := range shared {
+		storageAliases = append(storageAliases, storageAlias)
+	}
+	sort.Strings(storageAliases)
+
+	var messages []string
+	for _, storageAlias := range storageAliases {
+		messages = append(messages, fmt.Sprintf("registries %s share storage %s", strings.Join(shared[storageAlias], ", "), storageAlias))
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	if mode == StorageIsolationEnforce {
+		return fmt.Errorf("storage isolation enforced: %s", strings.Join(messages, "; "))
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.warnings = append(rm.warnings, messages...)
+	return nil
+}
+
+// Warnings returns the configuration problems found while loading that didn't fail it,
+// such as registries sharing a storage
+func (rm *RegistryManager) Warnings() []string {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return append([]string(nil), rm.warnings...)
+}
+
+// remove drops the given registries, undoing a failed load
+func (rm *RegistryManager) remove(registries []models.Registry) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for _, registry := range registries {
+		delete(rm.registries, registry.Alias())
+	}
+}
@@ -4,14 +4,24 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/basakil/brm-server/pkg/models"
 
 	"github.com/basakil/brm-config/pkg/config"
+	"github.com/basakil/brm-server/internal/registry/audit"
+	"github.com/basakil/brm-server/internal/registry/docker"
 	"github.com/basakil/brm-server/internal/registry/docker/private"
 	"github.com/basakil/brm-server/internal/registry/docker/proxy"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/registry/raw"
+	"github.com/basakil/brm-server/internal/registry/webhook"
+	"github.com/basakil/brm-server/internal/storage"
 )
 
 var (
@@ -23,6 +33,7 @@ var (
 type RegistryManager struct {
 	registries map[string]models.Registry
 	factories  map[string]func(...interface{}) (models.Registry, error)
+	warnings   []string // Non-fatal problems found by LoadFromConfig
 	mu         sync.RWMutex
 }
 
@@ -117,6 +128,34 @@ func (rm *RegistryManager) init() {
 
 		return private.NewDockerRegistryPrivate(alias, storageAlias, serviceBinding, description)
 	})
+
+	// Register raw (arbitrary file) registry factory
+	// Parameters: [alias, serviceBinding, storageAlias]
+	rm.RegisterFactory("raw.registry", func(params ...interface{}) (models.Registry, error) {
+		if len(params) < 3 {
+			return nil, fmt.Errorf("raw.registry requires alias, serviceBinding, and storageAlias parameters")
+		}
+
+		alias, ok := params[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("raw.registry alias must be a string")
+		}
+
+		var serviceBinding net.Addr
+		if params[1] != nil {
+			serviceBinding, ok = params[1].(net.Addr)
+			if !ok {
+				return nil, fmt.Errorf("raw.registry serviceBinding must be net.Addr")
+			}
+		}
+
+		storageAlias, ok := params[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("raw.registry storageAlias must be a string")
+		}
+
+		return raw.NewRawRegistry(alias, storageAlias, serviceBinding)
+	})
 }
 
 // isValidDNSName validates that a string is a valid DNS name
@@ -180,12 +219,45 @@ func (rm *RegistryManager) Create(className, alias string, serviceBinding net.Ad
 		return nil, fmt.Errorf("failed to create registry instance: %w", err)
 	}
 
+	// Guard against misbehaving factories; SaveToConfig relies on these matching the request
+	if registry.ImplementationType() != className {
+		return nil, fmt.Errorf("registry class %s produced implementation type %q", className, registry.ImplementationType())
+	}
+	if registry.Alias() != alias {
+		return nil, fmt.Errorf("registry class %s produced alias %q instead of %q", className, registry.Alias(), alias)
+	}
+
 	// Store instance
 	rm.registries[alias] = registry
 
 	return registry, nil
 }
 
+// Registries returns all registry instances sorted by alias
+func (rm *RegistryManager) Registries() []models.Registry {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	result := make([]models.Registry, 0, len(rm.registries))
+	for _, registry := range rm.registries {
+		result = append(result, registry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Alias() < result[j].Alias() })
+	return result
+}
+
+// Get returns the registry with the given alias
+func (rm *RegistryManager) Get(alias string) (models.Registry, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	registry, exists := rm.registries[alias]
+	if !exists {
+		return nil, fmt.Errorf("registry not found: %s", alias)
+	}
+	return registry, nil
+}
+
 // convertServiceBinding converts net.Addr to *models.ServiceBinding
 func (rm *RegistryManager) convertServiceBinding(addr net.Addr) *models.ServiceBinding {
 	if addr == nil {
@@ -231,6 +303,78 @@ func (rm *RegistryManager) SaveToConfig() map[string]interface{} {
 			if desc := impl.GetDescription(); desc != "" {
 				params["description"] = desc
 			}
+			if prefix := impl.Service().RefKeyPrefix(); prefix != private.DefaultRefKeyPrefix {
+				params["refKeyPrefix"] = prefix
+			}
+			if strategy := impl.Service().KeyStrategy(); strategy != docker.KeyByDigest {
+				params["keyStrategy"] = string(strategy)
+			}
+			if compression := impl.Service().CompressionConfig(); compression != (docker.CompressionConfig{}) {
+				params["compression"] = compressionToConfig(compression)
+			}
+			if size := impl.Service().ManifestCache().Size(); size > 0 {
+				params["manifestCacheSize"] = size
+			}
+			if limit := impl.Service().EagerGCLimit(); limit > 0 {
+				params["eagerGC"] = true
+				params["eagerGCLimit"] = limit
+			}
+			if algorithms := impl.Service().AllowedDigestAlgorithms(); !slices.Equal(algorithms, private.DefaultDigestAlgorithms) {
+				params["digestAlgorithms"] = strings.Join(algorithms, ",")
+			}
+			if impl.Service().RecordContentDigests() {
+				params["recordContentDigests"] = true
+			}
+			if impl.Service().BlobRedirects() {
+				params["blobRedirects"] = true
+			}
+			if impl.Service().BlobETags() {
+				params["blobETags"] = true
+			}
+			if impl.Service().RequireJSONManifests() {
+				params["requireJSONManifests"] = true
+			}
+			if impl.Service().RejectConflictingBlobs() {
+				params["rejectConflictingBlobs"] = true
+			}
+			if limit := impl.Service().MaxUploadBuffer(); limit > 0 {
+				params["maxUploadBufferSize"] = limit
+			}
+			if buckets := impl.Service().UploadSizeBuckets(); !slices.Equal(buckets, private.DefaultUploadSizeBuckets) {
+				bounds := make([]string, len(buckets))
+				for i, bound := range buckets {
+					bounds[i] = strconv.FormatInt(bound, 10)
+				}
+				params["uploadSizeBuckets"] = strings.Join(bounds, ",")
+			}
+			if stagingAlias := impl.Service().UploadStagingAlias(); stagingAlias != "" {
+				params["uploadStagingStorage"] = stagingAlias
+			}
+			if impl.Service().UploadUUIDOnCompletion() {
+				params["uploadUUIDOnCompletion"] = true
+			}
+			if impl.Service().MalformedDigestsNotFound() {
+				params["malformedDigestsNotFound"] = true
+			}
+			if ttls := impl.Service().ArtifactTTLs(); len(ttls) > 0 {
+				ttlConfig := make(map[string]interface{}, len(ttls))
+				for i, ttl := range ttls {
+					ttlConfig[strconv.Itoa(i+1)] = map[string]interface{}{"prefix": ttl.Prefix, "ttl": ttl.TTL.String()}
+				}
+				params["artifactTTL"] = ttlConfig
+				params["expirySweepInterval"] = impl.Service().ExpirySweepInterval().String()
+			}
+			if period := impl.Service().GCGracePeriod(); period != private.DefaultGCGracePeriod {
+				params["gcGracePeriod"] = period.String()
+			}
+			if depth := impl.Service().MaxManifestDepth(); depth > 0 {
+				params["maxManifestDepth"] = depth
+			}
+			if size := impl.Service().HashLimiter().Size(); size > 0 {
+				params["hashConcurrency"] = size
+			}
+			eventSinksToConfig(impl.Service().EventSink(), params)
+			nameLimitsToConfig(impl.Service().NameLimits(), params)
 			regConfig["params"] = params
 			if sb := rm.convertServiceBinding(impl.GetServiceBinding()); sb != nil {
 				regConfig["serviceBinding"] = sb
@@ -241,11 +385,76 @@ func (rm *RegistryManager) SaveToConfig() map[string]interface{} {
 				"storageAlias": impl.GetStorageAlias(),
 			}
 			if upstream := impl.GetUpstream(); upstream != nil {
-				params["upstream"] = upstream
+				params["upstream"] = upstreamToConfig(upstream)
 			}
-			if cacheTTL := impl.GetCacheTTL(); cacheTTL > 0 {
+			if cacheTTL := impl.GetCacheTTL(); cacheTTL != 0 {
 				params["cacheTTL"] = cacheTTL
 			}
+			if userAgent := impl.Service().UserAgent(); userAgent != proxy.DefaultUserAgent {
+				params["userAgent"] = userAgent
+			}
+			if ttl := impl.Service().TagCacheTTL(); ttl > 0 {
+				params["tagCacheTTL"] = ttl.String()
+			}
+			if timeout := impl.Service().UpstreamTimeout(); timeout > 0 {
+				params["upstreamTimeout"] = timeout.String()
+			}
+			if impl.Service().RevalidateAlways() {
+				params["revalidate"] = "always"
+			}
+			if limit := impl.Service().CacheWriteConcurrency(); limit > 0 {
+				params["cacheWriteConcurrency"] = limit
+			}
+			if repos := impl.Service().NoCacheRepositories(); len(repos) > 0 {
+				params["noCacheRepositories"] = strings.Join(repos, ",")
+			}
+			if strategy := impl.Service().KeyStrategy(); strategy != docker.KeyByDigest {
+				params["keyStrategy"] = string(strategy)
+			}
+			if compression := impl.Service().CompressionConfig(); compression != (docker.CompressionConfig{}) {
+				params["compression"] = compressionToConfig(compression)
+			}
+			if size := impl.Service().ManifestCache().Size(); size > 0 {
+				params["manifestCacheSize"] = size
+			}
+			if impl.Service().TagMappings() {
+				params["tagMappings"] = true
+			}
+			if impl.Service().RequireManifestReference() {
+				params["requireManifestReference"] = true
+			}
+			if codec := impl.Service().LayerRecompression(); codec != nil {
+				params["layerRecompression"] = codec.Name()
+			}
+			if impl.Service().UpstreamReadinessCheck() {
+				params["readyCheckUpstream"] = true
+			}
+			if impl.Service().ServeStaleOnError() {
+				params["serveStaleOnError"] = true
+			}
+			if impl.Service().BlobETags() {
+				params["blobETags"] = true
+			}
+			if refreshConfig, ok := impl.Service().TagRefresh(); ok {
+				params["tagRefresh"] = map[string]interface{}{
+					"minHits":   refreshConfig.MinHits,
+					"lookAhead": refreshConfig.LookAhead.String(),
+					"interval":  refreshConfig.Interval.String(),
+				}
+			}
+			nameLimitsToConfig(impl.Service().NameLimits(), params)
+			regConfig["params"] = params
+			if sb := rm.convertServiceBinding(impl.GetServiceBinding()); sb != nil {
+				regConfig["serviceBinding"] = sb
+			}
+
+		case *raw.RawRegistry:
+			params := map[string]interface{}{
+				"storageAlias": impl.GetStorageAlias(),
+			}
+			if contentTypes := contentTypesToConfig(impl.Service().Detector().Overrides()); len(contentTypes) > 0 {
+				params["contentTypes"] = contentTypes
+			}
 			regConfig["params"] = params
 			if sb := rm.convertServiceBinding(impl.GetServiceBinding()); sb != nil {
 				regConfig["serviceBinding"] = sb
@@ -261,13 +470,16 @@ func (rm *RegistryManager) SaveToConfig() map[string]interface{} {
 	return result
 }
 
-// LoadFromConfig creates registry instances from configuration
+// LoadFromConfig creates registry instances from configuration.
+// Registries sharing a storage are handled per the top-level storageIsolation mode; if it is
+// enforced, none of the configured registries are kept.
 func (rm *RegistryManager) LoadFromConfig(cfg *config.Config) error {
 	registriesConfig := cfg.GetSubConfig("registries")
 	if registriesConfig == nil {
 		return nil // No registries configured
 	}
 
+	var loaded []models.Registry
 	aliases := registriesConfig.Keys()
 	for _, alias := range aliases {
 		registryConfig := registriesConfig.GetSubConfig(alias)
@@ -306,16 +518,9 @@ func (rm *RegistryManager) LoadFromConfig(cfg *config.Config) error {
 			if !paramsConfig.Exists("upstream") {
 				return fmt.Errorf("registry %s: upstream is required", alias)
 			}
-			upstreamConfig := paramsConfig.GetSubConfig("upstream")
-			upstreamURL := upstreamConfig.GetString("url")
-			if upstreamURL == "" {
-				return fmt.Errorf("registry %s: upstream.url is required", alias)
-			}
-			upstream := &models.UpstreamRegistry{
-				URL:      upstreamURL,
-				Username: upstreamConfig.GetString("username"),
-				Password: upstreamConfig.GetString("password"),
-				TTL:      int64(upstreamConfig.GetInt("ttl")),
+			upstream, err := loadUpstream(paramsConfig.GetSubConfig("upstream"), "upstream")
+			if err != nil {
+				return fmt.Errorf("registry %s: %w", alias, err)
 			}
 
 			cacheTTL := int64(paramsConfig.GetInt("cacheTTL"))
@@ -329,15 +534,590 @@ func (rm *RegistryManager) LoadFromConfig(cfg *config.Config) error {
 			description := paramsConfig.GetString("description")
 			params = []interface{}{storageAlias, description}
 
+		case "raw.registry":
+			storageAlias := paramsConfig.GetString("storageAlias")
+			if storageAlias == "" {
+				return fmt.Errorf("registry %s: storageAlias is required", alias)
+			}
+			params = []interface{}{storageAlias}
+
 		default:
 			return fmt.Errorf("registry %s: unknown class %s", alias, className)
 		}
 
 		// Create registry instance
-		_, err := rm.Create(className, alias, serviceBinding, params...)
+		registry, err := rm.Create(className, alias, serviceBinding, params...)
 		if err != nil {
 			return fmt.Errorf("failed to create registry %s: %w", alias, err)
 		}
+
+		// Apply optional service settings
+		if err := rm.applyOptions(registry, paramsConfig); err != nil {
+			return fmt.Errorf("registry %s: %w", alias, err)
+		}
+		loaded = append(loaded, registry)
+	}
+
+	if err := rm.checkStorageIsolation(loaded, cfg.GetString("storageIsolation")); err != nil {
+		rm.remove(loaded)
+		return err
+	}
+	return nil
+}
+
+// configBool reads an optional boolean value ("true"/"false") from configuration.
+// ok reports whether the key is set; a set value that isn't a boolean is an error.
+func configBool(cfg *config.Config, key string) (value bool, ok bool, err error) {
+	if !cfg.Exists(key) {
+		return false, false, nil
+	}
+	value, err = strconv.ParseBool(cfg.GetString(key))
+	if err != nil {
+		return false, false, err
+	}
+	return value, true, nil
+}
+
+// boolOption is a boolean registry param and the setter applying it
+type boolOption struct {
+	key string
+	set func(bool)
+}
+
+// applyBoolOptions applies each boolean param that is set in cfg
+func applyBoolOptions(cfg *config.Config, options []boolOption) error {
+	for _, option := range options {
+		value, ok, err := configBool(cfg, option.key)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", option.key, err)
+		}
+		if ok {
+			option.set(value)
+		}
+	}
+	return nil
+}
+
+// loadCompressionConfig extracts gzip compression options from configuration
+func loadCompressionConfig(cfg *config.Config) (docker.CompressionConfig, error) {
+	enabled, _, err := configBool(cfg, "enabled")
+	if err != nil {
+		return docker.CompressionConfig{}, fmt.Errorf("invalid compression.enabled: %w", err)
+	}
+	return docker.CompressionConfig{
+		Enabled: enabled,
+		MinSize: cfg.GetInt("minSize"),
+		Level:   cfg.GetInt("level"),
+	}, nil
+}
+
+// compressionToConfig is the inverse of loadCompressionConfig
+func compressionToConfig(compression docker.CompressionConfig) map[string]interface{} {
+	compressionConfig := map[string]interface{}{
+		"enabled": compression.Enabled,
+	}
+	if compression.MinSize != 0 {
+		compressionConfig["minSize"] = compression.MinSize
+	}
+	if compression.Level != 0 {
+		compressionConfig["level"] = compression.Level
+	}
+	return compressionConfig
+}
+
+// contentTypesToConfig returns the contentTypes params for a detector's overrides: the ones that
+// differ from raw.DefaultContentTypeOverrides, and an empty content type for removed defaults
+func contentTypesToConfig(overrides map[string]string) map[string]interface{} {
+	contentTypes := make(map[string]interface{})
+	for ext, contentType := range overrides {
+		if raw.DefaultContentTypeOverrides[ext] != contentType {
+			contentTypes[strings.TrimPrefix(ext, ".")] = contentType
+		}
+	}
+	for ext := range raw.DefaultContentTypeOverrides {
+		if _, ok := overrides[ext]; !ok {
+			contentTypes[strings.TrimPrefix(ext, ".")] = ""
+		}
+	}
+	return contentTypes
+}
+
+// loadNameLimits extracts request path and repository name length limits from registry params
+func loadNameLimits(cfg *config.Config) docker.NameLimits {
+	return docker.NameLimits{
+		MaxName: cfg.GetInt("maxNameLength"),
+		MaxPath: cfg.GetInt("maxPathLength"),
+	}
+}
+
+// loadTagRefreshConfig extracts the proxy tag refresh settings (zero fields use the defaults)
+func loadTagRefreshConfig(cfg *config.Config) (proxy.TagRefreshConfig, error) {
+	refreshConfig := proxy.TagRefreshConfig{MinHits: cfg.GetInt("minHits")}
+	for key, target := range map[string]*time.Duration{
+		"lookAhead": &refreshConfig.LookAhead,
+		"interval":  &refreshConfig.Interval,
+	} {
+		if value := cfg.GetString(key); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return proxy.TagRefreshConfig{}, fmt.Errorf("invalid tagRefresh %s: %w", key, err)
+			}
+			*target = parsed
+		}
+	}
+	return refreshConfig, nil
+}
+
+// loadArtifactTTLs reads the artifactTTL map of name -> {prefix, ttl}
+func loadArtifactTTLs(cfg *config.Config) ([]private.ArtifactTTL, error) {
+	names := cfg.Keys()
+	sort.Strings(names)
+	var ttls []private.ArtifactTTL
+	for _, name := range names {
+		entry := cfg.GetSubConfig(name)
+		if entry == nil || entry.GetString("ttl") == "" {
+			return nil, fmt.Errorf("artifactTTL %s requires a ttl", name)
+		}
+		ttl, err := time.ParseDuration(entry.GetString("ttl"))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid artifactTTL %s ttl %q", name, entry.GetString("ttl"))
+		}
+		ttls = append(ttls, private.ArtifactTTL{Prefix: entry.GetString("prefix"), TTL: ttl})
+	}
+	return ttls, nil
+}
+
+// nameLimitsToConfig records non-default name limits in SaveToConfig params
+func nameLimitsToConfig(limits docker.NameLimits, params map[string]interface{}) {
+	if limits.MaxName > 0 {
+		params["maxNameLength"] = limits.MaxName
+	}
+	if limits.MaxPath > 0 {
+		params["maxPathLength"] = limits.MaxPath
+	}
+}
+
+// upstreamToConfig converts an upstream for SaveToConfig, keying mirrors by position
+// so the result round-trips through loadUpstream
+func upstreamToConfig(upstream *models.UpstreamRegistry) interface{} {
+	if len(upstream.Mirrors) == 0 {
+		return upstream
+	}
+	mirrors := make(map[string]interface{}, len(upstream.Mirrors))
+	for i := range upstream.Mirrors {
+		mirrors[strconv.Itoa(i+1)] = upstreamToConfig(&upstream.Mirrors[i])
+	}
+	upstreamConfig := map[string]interface{}{
+		"url":      upstream.URL,
+		"username": upstream.Username,
+		"password": upstream.Password,
+		"ttl":      upstream.TTL,
+		"mirrors":  mirrors,
+	}
+	if upstream.NormalizeLibrary != nil {
+		upstreamConfig["normalizeLibrary"] = *upstream.NormalizeLibrary
+	}
+	return upstreamConfig
+}
+
+// loadUpstream reads an upstream registry and its optional mirrors.
+// Mirrors are keyed by their position ("1", "2", ...) and tried in that order.
+// path is the key path of the section, used in error messages.
+func loadUpstream(upstreamConfig *config.Config, path string) (*models.UpstreamRegistry, error) {
+	if upstreamConfig == nil {
+		return nil, fmt.Errorf("%s is required", path)
+	}
+	upstreamURL := upstreamConfig.GetString("url")
+	if upstreamURL == "" {
+		return nil, fmt.Errorf("%s.url is required", path)
+	}
+	upstream := &models.UpstreamRegistry{
+		URL:      upstreamURL,
+		Username: upstreamConfig.GetString("username"),
+		Password: upstreamConfig.GetString("password"),
+		TTL:      int64(upstreamConfig.GetInt("ttl")),
+	}
+	normalize, ok, err := configBool(upstreamConfig, "normalizeLibrary")
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s.normalizeLibrary: %w", path, err)
+	}
+	if ok {
+		upstream.NormalizeLibrary = &normalize
+	}
+
+	mirrorsConfig := upstreamConfig.GetSubConfig("mirrors")
+	if mirrorsConfig == nil {
+		return upstream, nil
+	}
+	keys := mirrorsConfig.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, errA := strconv.Atoi(keys[i])
+		b, errB := strconv.Atoi(keys[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		mirrorPath := path + ".mirrors." + key
+		mirror, err := loadUpstream(mirrorsConfig.GetSubConfig(key), mirrorPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(mirror.Mirrors) > 0 {
+			return nil, fmt.Errorf("%s: nested mirrors are not supported", mirrorPath)
+		}
+		upstream.Mirrors = append(upstream.Mirrors, *mirror)
+	}
+	return upstream, nil
+}
+
+// loadNotifier builds a webhook notifier from the webhooks configuration:
+// an "endpoints" map of name -> {url, secret}, plus optional queueSize, maxRetries and retryDelay
+func loadNotifier(webhooksConfig *config.Config) (*webhook.Notifier, error) {
+	endpointsConfig := webhooksConfig.GetSubConfig("endpoints")
+	if endpointsConfig == nil {
+		return nil, fmt.Errorf("webhooks: endpoints are required")
+	}
+	names := endpointsConfig.Keys()
+	sort.Strings(names)
+
+	var endpoints []webhook.Endpoint
+	for _, name := range names {
+		endpointConfig := endpointsConfig.GetSubConfig(name)
+		if endpointConfig == nil || endpointConfig.GetString("url") == "" {
+			return nil, fmt.Errorf("webhooks: endpoint %s requires a url", name)
+		}
+		endpoints = append(endpoints, webhook.Endpoint{
+			URL:    endpointConfig.GetString("url"),
+			Secret: endpointConfig.GetString("secret"),
+		})
+	}
+
+	notifier := webhook.NewNotifier(endpoints, webhooksConfig.GetInt("queueSize"))
+	if webhooksConfig.Exists("maxRetries") || webhooksConfig.Exists("retryDelay") {
+		retryDelay := webhook.DefaultRetryDelay
+		if value := webhooksConfig.GetString("retryDelay"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				notifier.Close()
+				return nil, fmt.Errorf("webhooks: invalid retryDelay: %w", err)
+			}
+			retryDelay = parsed
+		}
+		maxRetries := webhook.DefaultMaxRetries
+		if webhooksConfig.Exists("maxRetries") {
+			maxRetries = webhooksConfig.GetInt("maxRetries")
+		}
+		notifier.SetRetry(maxRetries, retryDelay)
+	}
+	return notifier, nil
+}
+
+// eventSinksToConfig writes the webhooks and audit params of the notifier and audit log among
+// the event sinks, if any. Endpoint secrets are written as configured, like upstream credentials.
+func eventSinksToConfig(sink events.EventSink, params map[string]interface{}) {
+	sinks, ok := sink.(events.MultiSink)
+	if !ok {
+		sinks = events.MultiSink{sink}
+	}
+	for _, sink := range sinks {
+		switch sink := sink.(type) {
+		case *webhook.Notifier:
+			params["webhooks"] = notifierToConfig(sink)
+		case *audit.Log:
+			maxSize, maxBackups := sink.Rotation()
+			auditConfig := map[string]interface{}{"file": sink.Path()}
+			if maxSize > 0 {
+				auditConfig["maxSize"] = maxSize
+			}
+			if maxBackups != audit.DefaultMaxBackups {
+				auditConfig["maxBackups"] = maxBackups
+			}
+			params["audit"] = auditConfig
+		}
+	}
+}
+
+// notifierToConfig is the inverse of loadNotifier
+func notifierToConfig(notifier *webhook.Notifier) map[string]interface{} {
+	endpoints := notifier.Endpoints()
+	endpointsConfig := make(map[string]interface{}, len(endpoints))
+	for i, endpoint := range endpoints {
+		endpointConfig := map[string]interface{}{"url": endpoint.URL}
+		if endpoint.Secret != "" {
+			endpointConfig["secret"] = endpoint.Secret
+		}
+		endpointsConfig[strconv.Itoa(i+1)] = endpointConfig
+	}
+	webhooksConfig := map[string]interface{}{"endpoints": endpointsConfig}
+	if size := notifier.QueueSize(); size != webhook.DefaultQueueSize {
+		webhooksConfig["queueSize"] = size
+	}
+	if maxRetries, delay := notifier.Retry(); maxRetries != webhook.DefaultMaxRetries || delay != webhook.DefaultRetryDelay {
+		webhooksConfig["maxRetries"] = maxRetries
+		webhooksConfig["retryDelay"] = delay.String()
+	}
+	return webhooksConfig
+}
+
+// loadAuditLog opens the audit log from the audit configuration: file (required), maxSize in bytes
+// (0 disables rotation) and maxBackups
+func loadAuditLog(auditConfig *config.Config) (*audit.Log, error) {
+	path := auditConfig.GetString("file")
+	if path == "" {
+		return nil, fmt.Errorf("audit: file is required")
+	}
+	return audit.NewLog(path, int64(auditConfig.GetInt("maxSize")), auditConfig.GetInt("maxBackups"))
+}
+
+// applyOptions applies optional, implementation-specific settings from the params configuration
+func (rm *RegistryManager) applyOptions(registry models.Registry, paramsConfig *config.Config) error {
+	if paramsConfig == nil {
+		return nil
+	}
+
+	switch impl := registry.(type) {
+	case *private.DockerRegistryPrivate:
+		if paramsConfig.Exists("compression") {
+			compression, err := loadCompressionConfig(paramsConfig.GetSubConfig("compression"))
+			if err != nil {
+				return err
+			}
+			impl.Service().SetCompressionConfig(compression)
+		}
+		if size := paramsConfig.GetInt("manifestCacheSize"); size > 0 {
+			impl.Service().SetManifestCache(docker.NewManifestCache(size))
+		}
+		// maxNameLength and maxPathLength bound repository names and request paths (0 = default)
+		impl.Service().SetNameLimits(loadNameLimits(paramsConfig))
+		if prefix := paramsConfig.GetString("refKeyPrefix"); prefix != "" {
+			if err := impl.Service().SetRefKeyPrefix(prefix); err != nil {
+				return err
+			}
+		}
+		// keyStrategy: "digest" (default) dedups content across repositories, "repository" isolates them
+		strategy, err := docker.ParseKeyStrategy(paramsConfig.GetString("keyStrategy"))
+		if err != nil {
+			return err
+		}
+		impl.Service().SetKeyStrategy(strategy)
+		var sinks []events.EventSink
+		if paramsConfig.Exists("webhooks") {
+			notifier, err := loadNotifier(paramsConfig.GetSubConfig("webhooks"))
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, notifier)
+		}
+		if paramsConfig.Exists("audit") {
+			auditLog, err := loadAuditLog(paramsConfig.GetSubConfig("audit"))
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, auditLog)
+		}
+		if len(sinks) > 0 {
+			impl.Service().SetEventSink(events.NewMultiSink(sinks...))
+		}
+		// digestAlgorithms (comma-separated, e.g. "sha256") restricts accepted digest algorithms
+		if value := paramsConfig.GetString("digestAlgorithms"); value != "" {
+			var algorithms []string
+			for _, algorithm := range strings.Split(value, ",") {
+				if algorithm = strings.TrimSpace(algorithm); algorithm != "" {
+					algorithms = append(algorithms, algorithm)
+				}
+			}
+			if err := impl.Service().SetAllowedDigestAlgorithms(algorithms); err != nil {
+				return fmt.Errorf("invalid digestAlgorithms: %w", err)
+			}
+		}
+		if err := applyBoolOptions(paramsConfig, []boolOption{
+			// recordContentDigests records the verified content digest of pushed blobs in their metadata
+			{"recordContentDigests", impl.Service().SetRecordContentDigests},
+			// blobRedirects serves blob downloads as redirects to storage URLs, if the storage supports it
+			{"blobRedirects", impl.Service().SetBlobRedirects},
+			// blobETags sets blob ETags and answers a matching If-None-Match with 304
+			{"blobETags", impl.Service().SetBlobETags},
+			// requireJSONManifests rejects manifest pushes that aren't well-formed JSON
+			{"requireJSONManifests", impl.Service().SetRequireJSONManifests},
+			// rejectConflictingBlobs fails pushes of a stored blob whose stored content doesn't match the digest
+			{"rejectConflictingBlobs", impl.Service().SetRejectConflictingBlobs},
+			// uploadUUIDOnCompletion sets Docker-Upload-UUID on upload completion responses too
+			{"uploadUUIDOnCompletion", impl.Service().SetUploadUUIDOnCompletion},
+			// malformedDigestsNotFound answers blob requests for malformed digests with 404 instead of 400
+			{"malformedDigestsNotFound", impl.Service().SetMalformedDigestsNotFound},
+		}); err != nil {
+			return err
+		}
+		// maxUploadBufferSize caps the bytes a chunked upload session stages (0 = unlimited)
+		if limit := paramsConfig.GetInt("maxUploadBufferSize"); limit > 0 {
+			impl.Service().SetMaxUploadBuffer(int64(limit))
+		}
+		// uploadSizeBuckets (comma-separated byte counts) sets the upload size histogram buckets
+		if value := paramsConfig.GetString("uploadSizeBuckets"); value != "" {
+			var buckets []int64
+			for _, bound := range strings.Split(value, ",") {
+				size, err := strconv.ParseInt(strings.TrimSpace(bound), 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid uploadSizeBuckets: %w", err)
+				}
+				buckets = append(buckets, size)
+			}
+			if err := impl.Service().SetUploadSizeBuckets(buckets); err != nil {
+				return fmt.Errorf("invalid uploadSizeBuckets: %w", err)
+			}
+		}
+		// uploadStagingStorage stages chunked uploads in another storage (e.g. fast local disk) instead of the content storage
+		if stagingAlias := paramsConfig.GetString("uploadStagingStorage"); stagingAlias != "" {
+			staging, err := storage.GetManager().Get(stagingAlias)
+			if err != nil {
+				return fmt.Errorf("invalid uploadStagingStorage: %w", err)
+			}
+			impl.Service().SetUploadStaging(staging, stagingAlias)
+		}
+		if value := paramsConfig.GetString("gcGracePeriod"); value != "" {
+			period, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid gcGracePeriod: %w", err)
+			}
+			impl.Service().SetGCGracePeriod(period)
+		}
+		// artifactTTL expires pushed content per repository prefix; expired artifacts are swept
+		// every expirySweepInterval
+		if ttlConfig := paramsConfig.GetSubConfig("artifactTTL"); ttlConfig != nil {
+			ttls, err := loadArtifactTTLs(ttlConfig)
+			if err != nil {
+				return err
+			}
+			interval := private.DefaultExpirySweepInterval
+			if value := paramsConfig.GetString("expirySweepInterval"); value != "" {
+				if interval, err = time.ParseDuration(value); err != nil {
+					return fmt.Errorf("invalid expirySweepInterval: %w", err)
+				}
+			}
+			impl.Service().SetArtifactTTLs(ttls)
+			impl.Service().SetExpirySweepInterval(interval)
+		}
+		if depth := paramsConfig.GetInt("maxManifestDepth"); depth > 0 {
+			impl.Service().SetMaxManifestDepth(depth)
+		}
+		// eagerGC collects a deleted manifest's orphaned content on delete, up to eagerGCLimit references
+		enabled, _, err := configBool(paramsConfig, "eagerGC")
+		if err != nil {
+			return fmt.Errorf("invalid eagerGC: %w", err)
+		}
+		if enabled {
+			limit := paramsConfig.GetInt("eagerGCLimit")
+			if limit <= 0 {
+				limit = private.DefaultEagerGCLimit
+			}
+			impl.Service().SetEagerGC(limit)
+		}
+		// hashConcurrency caps concurrent blob digest computations (0 = unlimited)
+		if limiter := storage.NewHashLimiter(paramsConfig.GetInt("hashConcurrency")); limiter != nil {
+			impl.Service().SetHashLimiter(limiter)
+		}
+
+	case *proxy.DockerRegistryProxy:
+		if paramsConfig.Exists("compression") {
+			compression, err := loadCompressionConfig(paramsConfig.GetSubConfig("compression"))
+			if err != nil {
+				return err
+			}
+			impl.Service().SetCompressionConfig(compression)
+		}
+		if size := paramsConfig.GetInt("manifestCacheSize"); size > 0 {
+			impl.Service().SetManifestCache(docker.NewManifestCache(size))
+		}
+		// maxNameLength and maxPathLength bound repository names and request paths (0 = default)
+		impl.Service().SetNameLimits(loadNameLimits(paramsConfig))
+		if userAgent := paramsConfig.GetString("userAgent"); userAgent != "" {
+			impl.Service().SetUserAgent(userAgent)
+		}
+		// keyStrategy: "digest" (default) shares cached content across repositories, "repository" isolates them
+		strategy, err := docker.ParseKeyStrategy(paramsConfig.GetString("keyStrategy"))
+		if err != nil {
+			return err
+		}
+		impl.Service().SetKeyStrategy(strategy)
+		// tagCacheTTL (duration, e.g. "30s") caches tag -> digest resolutions; empty disables
+		if ttl := paramsConfig.GetString("tagCacheTTL"); ttl != "" {
+			parsed, err := time.ParseDuration(ttl)
+			if err != nil {
+				return fmt.Errorf("invalid tagCacheTTL: %w", err)
+			}
+			impl.Service().SetTagCacheTTL(parsed)
+		}
+		// upstreamTimeout (duration, e.g. "60s") bounds each upstream operation, failing it with 504; empty disables
+		if timeout := paramsConfig.GetString("upstreamTimeout"); timeout != "" {
+			parsed, err := time.ParseDuration(timeout)
+			if err != nil {
+				return fmt.Errorf("invalid upstreamTimeout: %w", err)
+			}
+			impl.Service().SetUpstreamTimeout(parsed)
+		}
+		// revalidate: "always" checks upstream on every request; "ttl" (default) honors cacheTTL
+		switch revalidate := paramsConfig.GetString("revalidate"); revalidate {
+		case "", "ttl":
+		case "always":
+			impl.Service().SetRevalidateAlways(true)
+		default:
+			return fmt.Errorf("invalid revalidate mode: %s", revalidate)
+		}
+		// cacheWriteConcurrency caps concurrent blob cache writes; misses beyond it are not cached (0 = unlimited)
+		if limit := paramsConfig.GetInt("cacheWriteConcurrency"); limit > 0 {
+			impl.Service().SetCacheWriteConcurrency(limit)
+		}
+		// noCacheRepositories (comma-separated) are always proxied without caching
+		if value := paramsConfig.GetString("noCacheRepositories"); value != "" {
+			var repos []string
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					repos = append(repos, name)
+				}
+			}
+			impl.Service().SetNoCacheRepositories(repos)
+		}
+		if err := applyBoolOptions(paramsConfig, []boolOption{
+			// tagMappings caches manifests by tag (a tag -> digest mapping in storage) as well as by digest
+			{"tagMappings", impl.Service().SetTagMappings},
+			// requireManifestReference only serves blobs referenced by a manifest pulled from the same repository
+			{"requireManifestReference", impl.Service().SetRequireManifestReference},
+			// readyCheckUpstream makes readiness require a reachable upstream (or mirror)
+			{"readyCheckUpstream", impl.Service().SetUpstreamReadinessCheck},
+			// serveStaleOnError serves expired cached content when the upstream fetch fails
+			{"serveStaleOnError", impl.Service().SetServeStaleOnError},
+			// blobETags sets blob ETags and answers a matching If-None-Match with 304
+			{"blobETags", impl.Service().SetBlobETags},
+		}); err != nil {
+			return err
+		}
+		// layerRecompression names a codec re-encoding cached layers (e.g. "gzip"); empty disables
+		if name := paramsConfig.GetString("layerRecompression"); name != "" {
+			codec, err := proxy.NewLayerCodec(name)
+			if err != nil {
+				return err
+			}
+			impl.Service().SetLayerRecompression(codec)
+		}
+		// tagRefresh re-resolves popular tags shortly before their tag cache entry expires:
+		// minHits pulls per tag cache window, lookAhead and interval are durations (e.g. "10s")
+		if paramsConfig.Exists("tagRefresh") {
+			refreshConfig, err := loadTagRefreshConfig(paramsConfig.GetSubConfig("tagRefresh"))
+			if err != nil {
+				return err
+			}
+			impl.Service().SetTagRefresh(refreshConfig)
+		}
+
+	case *raw.RawRegistry:
+		// contentTypes maps file extensions (without the dot) to Content-Type overrides
+		if contentTypes := paramsConfig.GetSubConfig("contentTypes"); contentTypes != nil {
+			for _, ext := range contentTypes.Keys() {
+				impl.Service().Detector().SetOverride(ext, contentTypes.GetString(ext))
+			}
+		}
 	}
 
 	return nil
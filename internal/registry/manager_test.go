@@ -0,0 +1,368 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/audit"
+	"github.com/basakil/brm-server/internal/registry/docker"
+	"github.com/basakil/brm-server/internal/registry/docker/private"
+	"github.com/basakil/brm-server/internal/registry/events"
+	"github.com/basakil/brm-server/internal/registry/raw"
+	"github.com/basakil/brm-server/internal/registry/webhook"
+	"github.com/basakil/brm-server/internal/server"
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// fakeRegistry is a minimal registry with configurable identity
+type fakeRegistry struct {
+	models.BaseRegistry
+}
+
+// Handlers returns a handler that serves nothing
+func (f *fakeRegistry) Handlers() http.Handler {
+	return http.NotFoundHandler()
+}
+
+// newFakeRegistryFactory returns a factory producing registries with the given implementation type
+func newFakeRegistryFactory(implementationType string) func(...interface{}) (models.Registry, error) {
+	return func(params ...interface{}) (models.Registry, error) {
+		registry := &fakeRegistry{}
+		registry.SetAlias(params[0].(string))
+		registry.SetType(models.RegistryTypePrivate)
+		registry.SetImplementationType(implementationType)
+		return registry, nil
+	}
+}
+
+// TestRegistryManagerCreateImplementationTypeMismatch tests that a factory producing the wrong implementation type is rejected
+func TestRegistryManagerCreateImplementationTypeMismatch(t *testing.T) {
+	rm := GetManager()
+	rm.RegisterFactory("test.misbehaving", newFakeRegistryFactory("test.other"))
+	rm.RegisterFactory("test.wellbehaved", newFakeRegistryFactory("test.wellbehaved"))
+
+	_, err := rm.Create("test.misbehaving", "misbehaving-registry", nil)
+	if err == nil || !strings.Contains(err.Error(), "implementation type") {
+		t.Fatalf("Expected implementation type mismatch error, got %v", err)
+	}
+	rm.mu.RLock()
+	_, stored := rm.registries["misbehaving-registry"]
+	rm.mu.RUnlock()
+	if stored {
+		t.Error("Mismatched registry must not be stored")
+	}
+
+	registry, err := rm.Create("test.wellbehaved", "wellbehaved-registry", nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if registry.ImplementationType() != "test.wellbehaved" {
+		t.Errorf("Unexpected implementation type %s", registry.ImplementationType())
+	}
+}
+
+// TestRegistryManagerGenericHandlers tests mounting private and proxy registries through Registry.Handlers
+func TestRegistryManagerGenericHandlers(t *testing.T) {
+	if _, err := storage.GetManager().Create("std.filestorage", "handlers-storage", t.TempDir()); err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	rm := GetManager()
+	if _, err := rm.Create("docker.registry.private", "handlers-private", nil, "handlers-storage", ""); err != nil {
+		t.Fatalf("Failed to create private registry: %v", err)
+	}
+	upstream := &models.UpstreamRegistry{URL: "http://127.0.0.1:1"}
+	if _, err := rm.Create("docker.registry", "handlers-proxy", nil, "handlers-storage", upstream); err != nil {
+		t.Fatalf("Failed to create proxy registry: %v", err)
+	}
+
+	mounted := 0
+	for _, registry := range rm.Registries() {
+		if !strings.HasPrefix(registry.Alias(), "handlers-") {
+			continue
+		}
+		mounted++
+
+		server := httptest.NewServer(registry.Handlers())
+		resp, err := http.Get(server.URL + "/v2/")
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: GET /v2/ failed: %v", registry.Alias(), err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", registry.Alias(), resp.StatusCode)
+		}
+		if resp.Header.Get("Docker-Distribution-API-Version") != "registry/2.0" {
+			t.Errorf("%s: missing Docker-Distribution-API-Version header", registry.Alias())
+		}
+	}
+	if mounted != 2 {
+		t.Errorf("Expected 2 mounted registries, got %d", mounted)
+	}
+}
+
+// TestRegistryManagerReadinessChecks tests that /readyz fails while /livez passes once a
+// registry's storage directory becomes unwritable
+func TestRegistryManagerReadinessChecks(t *testing.T) {
+	baseDir := filepath.Join(t.TempDir(), "storage")
+	if _, err := storage.GetManager().Create("std.filestorage", "ready-storage", baseDir); err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	rm := GetManager()
+	if _, err := rm.Create("docker.registry.private", "ready-private", nil, "ready-storage", ""); err != nil {
+		t.Fatalf("Failed to create private registry: %v", err)
+	}
+
+	// Only check this test's storage: other tests' storages may be gone
+	checks := func() []server.ReadinessCheck {
+		var result []server.ReadinessCheck
+		for _, check := range rm.ReadinessChecks() {
+			if check.Name == "registries" || check.Name == "storage:ready-storage" {
+				result = append(result, check)
+			}
+		}
+		return result
+	}
+	handler := server.HealthHandler(http.NotFoundHandler(), time.Second, checks)
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := status(server.ReadyPath); code != http.StatusOK {
+		t.Fatalf("Expected ready with writable storage, got %d", code)
+	}
+	if len(checks()) != 2 {
+		t.Fatalf("Expected registries and storage checks, got %d", len(checks()))
+	}
+
+	// Replace the storage directory with a file: unwritable even when running as root
+	if err := os.RemoveAll(baseDir); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if err := os.WriteFile(baseDir, nil, 0444); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if code := status(server.ReadyPath); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with unwritable storage, got %d", code)
+	}
+	if code := status(server.LivePath); code != http.StatusOK {
+		t.Errorf("Expected live with unwritable storage, got %d", code)
+	}
+}
+
+// TestRegistryManagerRootHandler tests a full push and pull through the default registry mounted at the root
+func TestRegistryManagerRootHandler(t *testing.T) {
+	if _, err := storage.GetManager().Create("std.filestorage", "root-storage", t.TempDir()); err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	rm := GetManager()
+	if _, err := rm.Create("docker.registry.private", "root-private", nil, "root-storage", ""); err != nil {
+		t.Fatalf("Failed to create private registry: %v", err)
+	}
+
+	if _, err := rm.RootHandler(server.Config{}); err == nil {
+		t.Error("Expected an error without a default registry")
+	}
+	if _, err := rm.RootHandler(server.Config{DefaultRegistry: "root-missing"}); err == nil {
+		t.Error("Expected an error for an unknown default registry")
+	}
+	handler, err := rm.RootHandler(server.Config{DefaultRegistry: "root-private"})
+	if err != nil {
+		t.Fatalf("RootHandler failed: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path string, body []byte, contentType string, want int) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		if resp.StatusCode != want {
+			data, _ := io.ReadAll(resp.Body)
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, want, resp.StatusCode, data)
+		}
+		return resp
+	}
+	digestOf := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	// Push a layer through an upload session, then a manifest referencing it
+	layer := []byte("root layer")
+	resp := do(http.MethodPost, "/v2/app/blobs/uploads/", nil, "", http.StatusAccepted)
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	sep := "?"
+	if strings.Contains(location, "?") {
+		sep = "&"
+	}
+	do(http.MethodPut, location+sep+"digest="+digestOf(layer), layer, "application/octet-stream", http.StatusCreated).Body.Close()
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","size":%d,"digest":"%s"}]}`, len(layer), digestOf(layer)))
+	do(http.MethodPut, "/v2/app/manifests/v1", manifest, "application/vnd.oci.image.manifest.v1+json", http.StatusCreated).Body.Close()
+
+	// Pull both back
+	resp = do(http.MethodGet, "/v2/app/manifests/v1", nil, "", http.StatusOK)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(data, manifest) || resp.Header.Get("Docker-Content-Digest") != digestOf(manifest) {
+		t.Error("Pulled manifest mismatch")
+	}
+	resp = do(http.MethodGet, "/v2/app/blobs/"+digestOf(layer), nil, "", http.StatusOK)
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(data, layer) {
+		t.Error("Pulled layer mismatch")
+	}
+}
+
+// TestRegistryManagerStorageIsolation tests the warn and enforce modes with two registries sharing a storage
+func TestRegistryManagerStorageIsolation(t *testing.T) {
+	for _, name := range []string{"isolation-shared", "isolation-own"} {
+		if _, err := storage.GetManager().Create("std.filestorage", name, t.TempDir()); err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+	}
+	rm := GetManager()
+	var registries []models.Registry
+	for _, spec := range []struct{ alias, storageAlias string }{
+		{"isolation-a", "isolation-shared"},
+		{"isolation-b", "isolation-shared"},
+		{"isolation-c", "isolation-own"},
+	} {
+		registry, err := rm.Create("docker.registry.private", spec.alias, nil, spec.storageAlias, "")
+		if err != nil {
+			t.Fatalf("Failed to create registry %s: %v", spec.alias, err)
+		}
+		registries = append(registries, registry)
+	}
+	want := "registries isolation-a, isolation-b share storage isolation-shared"
+
+	// Isolated registries pass in either mode
+	if err := rm.checkStorageIsolation(registries[1:], StorageIsolationEnforce); err != nil {
+		t.Errorf("Expected isolated registries to pass, got %v", err)
+	}
+
+	for _, mode := range []string{"", StorageIsolationWarn} {
+		before := len(rm.Warnings())
+		if err := rm.checkStorageIsolation(registries, mode); err != nil {
+			t.Fatalf("Mode %q: expected a warning, not an error: %v", mode, err)
+		}
+		warnings := rm.Warnings()
+		if len(warnings) != before+1 || warnings[len(warnings)-1] != want {
+			t.Errorf("Mode %q: expected warning %q, got %v", mode, want, warnings[before:])
+		}
+	}
+
+	before := len(rm.Warnings())
+	err := rm.checkStorageIsolation(registries, StorageIsolationEnforce)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected enforced isolation to fail with %q, got %v", want, err)
+	}
+	if len(rm.Warnings()) != before {
+		t.Error("Expected enforced isolation not to record warnings")
+	}
+	if err := rm.checkStorageIsolation(registries, "strict"); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
+
+// TestRegistryManagerSaveToConfig tests that SaveToConfig records non-default private and raw registry options
+func TestRegistryManagerSaveToConfig(t *testing.T) {
+	if _, err := storage.GetManager().Create("std.filestorage", "save-config-storage", t.TempDir()); err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	rm := GetManager()
+	registry, err := rm.Create("docker.registry.private", "save-config-private", nil, "save-config-storage", "")
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+	service := registry.(*private.DockerRegistryPrivate).Service()
+	if err := service.SetRefKeyPrefix("tags:"); err != nil {
+		t.Fatalf("SetRefKeyPrefix failed: %v", err)
+	}
+	service.SetManifestCache(docker.NewManifestCache(64))
+	service.SetCompressionConfig(docker.CompressionConfig{Enabled: true, MinSize: 512})
+	service.SetHashLimiter(storage.NewHashLimiter(4))
+	service.SetMaxManifestDepth(3)
+	service.SetGCGracePeriod(0)
+	service.SetRecordContentDigests(true)
+	service.SetBlobRedirects(true)
+	if err := service.SetAllowedDigestAlgorithms([]string{"sha256"}); err != nil {
+		t.Fatalf("SetAllowedDigestAlgorithms failed: %v", err)
+	}
+	notifier := webhook.NewNotifier([]webhook.Endpoint{{URL: "http://hooks.example/push", Secret: "s3cret"}}, 10)
+	defer notifier.Close()
+	notifier.SetRetry(5, 2*time.Second)
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.NewLog(auditPath, 1<<20, 2)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditLog.Close()
+	service.SetEventSink(events.NewMultiSink(notifier, auditLog))
+
+	rawRegistry, err := rm.Create("raw.registry", "save-config-raw", nil, "save-config-storage")
+	if err != nil {
+		t.Fatalf("Failed to create raw registry: %v", err)
+	}
+	detector := rawRegistry.(*raw.RawRegistry).Service().Detector()
+	detector.SetOverride("wasm", "application/wasm")
+	detector.SetOverride("yml", "")
+
+	saved := rm.SaveToConfig()
+	params := saved["save-config-private"].(map[string]interface{})["params"].(map[string]interface{})
+	want := map[string]interface{}{
+		"refKeyPrefix":         "tags:",
+		"manifestCacheSize":    64,
+		"compression":          map[string]interface{}{"enabled": true, "minSize": 512},
+		"hashConcurrency":      4,
+		"maxManifestDepth":     3,
+		"gcGracePeriod":        "0s",
+		"recordContentDigests": true,
+		"blobRedirects":        true,
+		"digestAlgorithms":     "sha256",
+		"webhooks": map[string]interface{}{
+			"endpoints": map[string]interface{}{
+				"1": map[string]interface{}{"url": "http://hooks.example/push", "secret": "s3cret"},
+			},
+			"queueSize":  10,
+			"maxRetries": 5,
+			"retryDelay": "2s",
+		},
+		"audit": map[string]interface{}{"file": auditPath, "maxSize": int64(1 << 20), "maxBackups": 2},
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(params[key], value) {
+			t.Errorf("Expected params[%q] = %v, got %v", key, value, params[key])
+		}
+	}
+
+	rawParams := saved["save-config-raw"].(map[string]interface{})["params"].(map[string]interface{})
+	wantContentTypes := map[string]interface{}{"wasm": "application/wasm", "yml": ""}
+	if !reflect.DeepEqual(rawParams["contentTypes"], wantContentTypes) {
+		t.Errorf("Expected contentTypes %v, got %v", wantContentTypes, rawParams["contentTypes"])
+	}
+}
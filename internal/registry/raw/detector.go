@@ -0,0 +1,73 @@
+package raw
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType considers
+const sniffLen = 512
+
+// DefaultContentTypeOverrides maps extensions to content types that sniffing can't detect
+var DefaultContentTypeOverrides = map[string]string{
+	".json": "application/json",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+}
+
+// ContentTypeDetector determines the Content-Type of raw artifacts.
+// Extension overrides take precedence; otherwise the content is sniffed with http.DetectContentType.
+type ContentTypeDetector struct {
+	overrides map[string]string // lower-case extension including the dot -> content type
+	mu        sync.RWMutex
+}
+
+// NewContentTypeDetector creates a detector preloaded with DefaultContentTypeOverrides
+func NewContentTypeDetector() *ContentTypeDetector {
+	d := &ContentTypeDetector{overrides: make(map[string]string)}
+	for ext, contentType := range DefaultContentTypeOverrides {
+		d.overrides[ext] = contentType
+	}
+	return d
+}
+
+// SetOverride maps a file extension (with or without the leading dot) to a content type.
+// An empty content type removes the override.
+func (d *ContentTypeDetector) SetOverride(ext, contentType string) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if contentType == "" {
+		delete(d.overrides, ext)
+		return
+	}
+	d.overrides[ext] = contentType
+}
+
+// Overrides returns a copy of the extension (with the leading dot) to content type overrides
+func (d *ContentTypeDetector) Overrides() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	overrides := make(map[string]string, len(d.overrides))
+	for ext, contentType := range d.overrides {
+		overrides[ext] = contentType
+	}
+	return overrides
+}
+
+// Detect returns the content type for an artifact name and its leading bytes
+func (d *ContentTypeDetector) Detect(name string, head []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	d.mu.RLock()
+	contentType, ok := d.overrides[ext]
+	d.mu.RUnlock()
+	if ok {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,157 @@
+package raw
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// SetupRoutes configures HTTP routes for raw artifact endpoints
+func SetupRoutes(mux *http.ServeMux, service *RawRegistryService) {
+	// GET also serves HEAD
+	mux.HandleFunc("GET /raw/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetArtifact(w, r, service)
+	})
+	mux.HandleFunc("PUT /raw/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		handlePutArtifact(w, r, service)
+	})
+	mux.HandleFunc("DELETE /raw/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteArtifact(w, r, service)
+	})
+}
+
+// handleGetArtifact handles GET and HEAD /raw/{name...}, including single-range requests
+func handleGetArtifact(w http.ResponseWriter, r *http.Request, service *RawRegistryService) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "artifact name required", http.StatusBadRequest)
+		return
+	}
+
+	hash, size, contentType, err := service.Stat(r.Context(), name)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", `"`+hash+`"`)
+
+	byteRange := models.ByteRange{Offset: 0, Length: -1}
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" {
+		parsed, err := parseRange(header, size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		byteRange = parsed
+		status = http.StatusPartialContent
+	}
+
+	if r.Method == http.MethodHead {
+		length := size
+		if status == http.StatusPartialContent {
+			length = byteRange.Length
+			w.Header().Set("Content-Range", contentRange(byteRange, size))
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+		return
+	}
+
+	rc, actual, err := service.Read(r.Context(), hash, byteRange)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", contentRange(actual, size))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(actual.Length, 10))
+	w.WriteHeader(status)
+	io.Copy(w, rc)
+}
+
+// handlePutArtifact handles PUT /raw/{name...}
+func handlePutArtifact(w http.ResponseWriter, r *http.Request, service *RawRegistryService) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "artifact name required", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := service.Put(r.Context(), name, r.Body, r.ContentLength)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/raw/"+name)
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleDeleteArtifact handles DELETE /raw/{name...}
+func handleDeleteArtifact(w http.ResponseWriter, r *http.Request, service *RawRegistryService) {
+	name := r.PathValue("name")
+	if name == "" {
+		http.Error(w, "artifact name required", http.StatusBadRequest)
+		return
+	}
+
+	if err := service.Delete(r.Context(), name); err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseRange parses a single-range "bytes=start-end", "bytes=start-" or "bytes=-suffix" header
+func parseRange(header string, size int64) (models.ByteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return models.ByteRange{}, fmt.Errorf("unsupported range: %s", header)
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return models.ByteRange{}, fmt.Errorf("invalid range: %s", header)
+	}
+
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return models.ByteRange{}, fmt.Errorf("invalid range: %s", header)
+		}
+		suffix = min(suffix, size)
+		return models.ByteRange{Offset: size - suffix, Length: suffix}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return models.ByteRange{}, fmt.Errorf("invalid range: %s", header)
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return models.ByteRange{}, fmt.Errorf("invalid range: %s", header)
+		}
+		end = min(end, size-1)
+	}
+	return models.ByteRange{Offset: start, Length: end - start + 1}, nil
+}
+
+// contentRange formats a Content-Range header value
+func contentRange(byteRange models.ByteRange, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", byteRange.Offset, byteRange.Offset+byteRange.Length-1, size)
+}
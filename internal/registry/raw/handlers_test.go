@@ -0,0 +1,205 @@
+package raw
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
+)
+
+// setupTestMux creates a raw registry service backed by temp storage with its routes mounted
+func setupTestMux(t *testing.T) (*RawRegistryService, *http.ServeMux) {
+	testStorage, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+	service := NewRawRegistryService()
+	service.SetStorage(testStorage)
+	mux := http.NewServeMux()
+	SetupRoutes(mux, service)
+	return service, mux
+}
+
+// putAndGet stores data under path through the mux and returns the GET response
+func putAndGet(t *testing.T, mux *http.ServeMux, path string, data []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, path, bytes.NewReader(data))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT %s: expected status 201, got %d: %s", path, rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected status 200, got %d", path, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("GET %s: body does not match stored data", path)
+	}
+	return rec
+}
+
+// TestRawArtifactContentType tests that stored files are served with the detected Content-Type
+func TestRawArtifactContentType(t *testing.T) {
+	_, mux := setupTestMux(t)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+	rec := putAndGet(t, mux, "/raw/images/logo.bin", png)
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected sniffed image/png, got %s", ct)
+	}
+
+	rec = putAndGet(t, mux, "/raw/config/settings.json", []byte(`{"key":"value"}`))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %s", ct)
+	}
+}
+
+// TestRawArtifactOverrideAndReplace tests extension overrides and that PUT replaces existing content
+func TestRawArtifactOverrideAndReplace(t *testing.T) {
+	service, mux := setupTestMux(t)
+	service.Detector().SetOverride("tgz", "application/gzip")
+
+	putAndGet(t, mux, "/raw/dist/app.tgz", []byte("first version"))
+	rec := putAndGet(t, mux, "/raw/dist/app.tgz", []byte("second, longer version"))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Expected override application/gzip, got %s", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/raw/dist/missing.tgz", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for missing artifact, got %d", rec.Code)
+	}
+}
+
+// TestRawArtifactLifecycle tests uploading, fetching, ranged reads, HEAD and DELETE
+func TestRawArtifactLifecycle(t *testing.T) {
+	_, mux := setupTestMux(t)
+	data := []byte("0123456789abcdefghij")
+	putAndGet(t, mux, "/raw/files/data.txt", data)
+
+	ranges := []struct {
+		header       string
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+	}
+	for _, rng := range ranges {
+		req := httptest.NewRequest(http.MethodGet, "/raw/files/data.txt", nil)
+		req.Header.Set("Range", rng.header)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("%s: expected status 206, got %d", rng.header, rec.Code)
+		}
+		if rec.Body.String() != rng.body || rec.Header().Get("Content-Range") != rng.contentRange {
+			t.Errorf("%s: got body %q and Content-Range %q", rng.header, rec.Body.String(), rec.Header().Get("Content-Range"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/raw/files/data.txt", nil)
+	req.Header.Set("Range", "bytes=50-")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected status 416 for out-of-bounds range, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/raw/files/data.txt", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "20" || rec.Body.Len() != 0 {
+		t.Errorf("Unexpected HEAD response: status %d, length %s", rec.Code, rec.Header().Get("Content-Length"))
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/raw/files/data.txt", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 for DELETE, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/raw/files/data.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after DELETE, got %d", rec.Code)
+	}
+}
+
+// TestRawArtifactSharedContent tests that identical content under two names is stored once and survives deleting one name
+func TestRawArtifactSharedContent(t *testing.T) {
+	service, mux := setupTestMux(t)
+	ctx := context.Background()
+	data := []byte("shared content")
+
+	putAndGet(t, mux, "/raw/a.txt", data)
+	putAndGet(t, mux, "/raw/b.txt", data)
+	hashA, _, _, _ := service.Stat(ctx, "a.txt")
+	hashB, _, _, _ := service.Stat(ctx, "b.txt")
+	if hashA == "" || hashA != hashB {
+		t.Fatalf("Expected both names to share one content hash, got %q and %q", hashA, hashB)
+	}
+
+	if err := service.Delete(ctx, "a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/raw/b.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Errorf("Expected b.txt to survive deleting a.txt, got status %d", rec.Code)
+	}
+}
+
+// TestRawArtifactConcurrentReplace tests that concurrent PUTs of one name leave it pointing at one
+// of the uploads, with every other upload's content released
+func TestRawArtifactConcurrentReplace(t *testing.T) {
+	simple, err := storage.NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test storage: %v", err)
+	}
+	concurrent, err := storage.NewConcurrentArtifactStorage(simple, t.TempDir(), 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create concurrent storage: %v", err)
+	}
+	service := NewRawRegistryService()
+	service.SetStorage(concurrent)
+	ctx := context.Background()
+
+	const numPuts = 8
+	hashes := make(chan string, numPuts)
+	for i := 0; i < numPuts; i++ {
+		go func(i int) {
+			data := []byte(fmt.Sprintf("upload %d", i))
+			hash, err := service.Put(ctx, "contended.txt", bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Errorf("Put failed: %v", err)
+			}
+			hashes <- hash
+		}(i)
+	}
+
+	stored := make([]string, 0, numPuts)
+	for i := 0; i < numPuts; i++ {
+		stored = append(stored, <-hashes)
+	}
+
+	final, _, _, err := service.Stat(ctx, "contended.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	for _, hash := range stored {
+		if hash == "" || hash == final {
+			continue
+		}
+		if meta, err := simple.GetMeta(ctx, hash); err == nil {
+			t.Errorf("Expected replaced content %s to be released, still referenced by %+v", hash, meta.References)
+		}
+	}
+}
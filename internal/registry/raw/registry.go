@@ -0,0 +1,67 @@
+package raw
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// RawRegistry implements a private registry for arbitrary (non-Docker) files
+type RawRegistry struct {
+	models.BaseRegistry
+	storageAlias   string
+	serviceBinding net.Addr
+	service        *RawRegistryService
+}
+
+// NewRawRegistry creates a new raw registry instance backed by the storage registered under storageAlias
+func NewRawRegistry(alias string, storageAlias string, serviceBinding net.Addr) (*RawRegistry, error) {
+	if storageAlias == "" {
+		return nil, fmt.Errorf("storageAlias cannot be empty")
+	}
+
+	// Resolve storage from StorageManager
+	storageInstance, err := storage.GetManager().Get(storageAlias)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage by alias %s: %w", storageAlias, err)
+	}
+
+	service := NewRawRegistryService()
+	service.SetStorage(storageInstance)
+
+	registry := &RawRegistry{
+		storageAlias:   storageAlias,
+		serviceBinding: serviceBinding,
+		service:        service,
+	}
+	registry.BaseRegistry.SetAlias(alias)
+	registry.BaseRegistry.SetType(models.RegistryTypePrivate)
+	registry.BaseRegistry.SetImplementationType("raw.registry")
+
+	return registry, nil
+}
+
+// Service returns the raw registry service instance
+func (r *RawRegistry) Service() *RawRegistryService {
+	return r.service
+}
+
+// GetStorageAlias returns the storage alias
+func (r *RawRegistry) GetStorageAlias() string {
+	return r.storageAlias
+}
+
+// GetServiceBinding returns the service binding
+func (r *RawRegistry) GetServiceBinding() net.Addr {
+	return r.serviceBinding
+}
+
+// Handlers returns a handler serving the registry's HTTP routes
+func (r *RawRegistry) Handlers() http.Handler {
+	mux := http.NewServeMux()
+	SetupRoutes(mux, r.service)
+	return mux
+}
@@ -0,0 +1,203 @@
+package raw
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/basakil/brm-server/internal/storage"
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// rawKeyPrefix namespaces the name -> hash mapping keys in shared storage
+const rawKeyPrefix = "raw:"
+
+// rawRepo is the reference repo recorded on raw artifact content (Name is the artifact name)
+const rawRepo = "raw"
+
+// hashRepo is the reference repo of a mapping entry (Name is the content hash)
+const hashRepo = "hash"
+
+// RawRegistryService stores arbitrary files by name in an ArtifactStorage.
+// Content is stored under its SHA-256 (computed on upload by HashComputingArtifactStorage);
+// an empty mapping artifact per name records which content hash the name points to.
+type RawRegistryService struct {
+	storage  models.ArtifactStorage
+	detector *ContentTypeDetector
+}
+
+// NewRawRegistryService creates a new raw registry service with the default content-type detector
+func NewRawRegistryService() *RawRegistryService {
+	return &RawRegistryService{
+		detector: NewContentTypeDetector(),
+	}
+}
+
+// SetStorage sets the storage backend (called after storage is resolved).
+// Storage that doesn't compute hashes itself is wrapped with HashComputingArtifactStorage.
+func (s *RawRegistryService) SetStorage(artifactStorage models.ArtifactStorage) {
+	if _, ok := artifactStorage.(*storage.HashComputingArtifactStorage); !ok {
+		artifactStorage = storage.NewHashComputingArtifactStorage(artifactStorage)
+	}
+	s.storage = artifactStorage
+}
+
+// Detector returns the content-type detector, e.g. to add extension overrides
+func (s *RawRegistryService) Detector() *ContentTypeDetector {
+	return s.detector
+}
+
+// getMappingKey maps an artifact name to the key of its name -> hash mapping.
+// Names may contain slashes and arbitrary characters, so they are hashed into a file-safe key.
+func (s *RawRegistryService) getMappingKey(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return rawKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// resolve returns the content hash the name points to
+func (s *RawRegistryService) resolve(ctx context.Context, name string) (string, error) {
+	meta, err := s.storage.GetMeta(ctx, s.getMappingKey(name))
+	if err != nil {
+		return "", fmt.Errorf("artifact not found: %w", err)
+	}
+	for _, ref := range meta.References {
+		if ref.Repo == hashRepo {
+			return ref.Name, nil
+		}
+	}
+	return "", fmt.Errorf("artifact not found: mapping for %s has no content hash", name)
+}
+
+// contentRef is the reference recorded on the content of the named artifact
+func contentRef(name string) models.ArtifactReference {
+	return models.ArtifactReference{Name: name, Repo: rawRepo, ReferencedTimestamp: time.Now().Unix()}
+}
+
+// Put stores the artifact under name, replacing any existing content, and returns the content hash
+func (s *RawRegistryService) Put(ctx context.Context, name string, r io.Reader, size int64) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("artifact name cannot be empty")
+	}
+
+	// Store the content; an empty hash makes the storage compute it while streaming
+	meta, err := s.storage.Create(ctx, "", r, size, &models.ArtifactMeta{
+		Length:           size,
+		CreatedTimestamp: time.Now().Unix(),
+		References:       []models.ArtifactReference{contentRef(name)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store artifact %s: %w", name, err)
+	}
+	hash := meta.Hash
+
+	previous, err := s.setMapping(ctx, name, hash)
+	if err != nil {
+		return "", err
+	}
+
+	// Drop the name's reference from the content the mapping pointed to before
+	for _, previousHash := range previous {
+		if _, err := s.storage.Delete(ctx, previousHash, contentRef(name)); err != nil {
+			return "", fmt.Errorf("failed to release previous content of %s: %w", name, err)
+		}
+	}
+	return hash, nil
+}
+
+// setMapping points name at the content hash, creating or replacing its mapping entry, and returns
+// the other content hashes the mapping pointed to. The mapping only changes through ModifyMeta, so
+// of concurrent Puts of one name each replaced hash is returned to exactly one of them.
+func (s *RawRegistryService) setMapping(ctx context.Context, name, hash string) ([]string, error) {
+	mappingKey := s.getMappingKey(name)
+	ref := models.ArtifactReference{Name: hash, Repo: hashRepo, ReferencedTimestamp: time.Now().Unix()}
+
+	// A new mapping entry starts without references: merging into one created concurrently would
+	// expose this hash before the swap below
+	if _, err := s.storage.GetMeta(ctx, mappingKey); err != nil {
+		_, err := s.storage.Create(ctx, mappingKey, bytes.NewReader(nil), 0, &models.ArtifactMeta{
+			Hash:             mappingKey,
+			Length:           0,
+			CreatedTimestamp: time.Now().Unix(),
+			References:       []models.ArtifactReference{},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mapping for %s: %w", name, err)
+		}
+	}
+
+	var previous []string
+	_, err := storage.ModifyMeta(ctx, s.storage, mappingKey, func(meta *models.ArtifactMeta) error {
+		previous = previous[:0]
+		for _, existing := range meta.References {
+			if existing.Repo == hashRepo && existing.Name != hash {
+				previous = append(previous, existing.Name)
+			}
+		}
+		meta.References = []models.ArtifactReference{ref}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update mapping for %s: %w", name, err)
+	}
+	return previous, nil
+}
+
+// Stat returns the content hash, total size and detected content type of the named artifact
+func (s *RawRegistryService) Stat(ctx context.Context, name string) (string, int64, string, error) {
+	hash, err := s.resolve(ctx, name)
+	if err != nil {
+		return "", 0, "", err
+	}
+	meta, err := s.storage.GetMeta(ctx, hash)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("artifact content not found: %w", err)
+	}
+
+	// Sniff the leading bytes of the content
+	rc, _, err := s.storage.Read(ctx, models.ArtifactRange{
+		Hash:  hash,
+		Range: models.ByteRange{Offset: 0, Length: sniffLen},
+	})
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to read artifact: %w", err)
+	}
+	defer rc.Close()
+	head, err := io.ReadAll(rc)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("failed to read artifact: %w", err)
+	}
+
+	return hash, meta.Length, s.detector.Detect(name, head), nil
+}
+
+// Read returns the requested byte range of the content with the given hash (Length -1 reads to the end).
+// The caller must close the returned reader.
+func (s *RawRegistryService) Read(ctx context.Context, hash string, byteRange models.ByteRange) (io.ReadCloser, models.ByteRange, error) {
+	rc, actual, err := s.storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: byteRange})
+	if err != nil {
+		return nil, models.ByteRange{}, fmt.Errorf("failed to read artifact: %w", err)
+	}
+	return rc, actual.Range, nil
+}
+
+// Delete removes the named artifact; its content is trashed once nothing references it
+func (s *RawRegistryService) Delete(ctx context.Context, name string) error {
+	hash, err := s.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	mappingKey := s.getMappingKey(name)
+	mappingRef := models.ArtifactReference{Name: hash, Repo: hashRepo}
+	if _, err := s.storage.Delete(ctx, mappingKey, mappingRef); err != nil {
+		return fmt.Errorf("failed to delete mapping for %s: %w", name, err)
+	}
+	if _, err := s.storage.Delete(ctx, hash, contentRef(name)); err != nil {
+		return fmt.Errorf("failed to delete content of %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/basakil/brm-server/internal/server"
+)
+
+// RootHandler returns the handlers of the registry named by cfg.DefaultRegistry, to serve at the
+// root of a single server binding. Call it at startup, after LoadFromConfig: it fails when no
+// default registry is configured or the named one isn't loaded.
+func (rm *RegistryManager) RootHandler(cfg server.Config) (http.Handler, error) {
+	if cfg.DefaultRegistry == "" {
+		return nil, fmt.Errorf("server: no defaultRegistry configured")
+	}
+	registry, err := rm.Get(cfg.DefaultRegistry)
+	if err != nil {
+		return nil, fmt.Errorf("server: invalid defaultRegistry: %w", err)
+	}
+	return registry.Handlers(), nil
+}
@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basakil/brm-server/internal/registry/events"
+)
+
+// Event actions
+const (
+	ActionPush   = "push"
+	ActionDelete = "delete"
+)
+
+// Event kinds
+const (
+	KindManifest = "manifest"
+	KindBlob     = "blob"
+)
+
+// SignatureHeader carries "sha256=<hex HMAC-SHA256 of the body>" when the endpoint has a secret
+const SignatureHeader = "X-BRM-Signature"
+
+// Defaults for a Notifier
+const (
+	DefaultQueueSize  = 100
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = time.Second
+	DefaultTimeout    = 10 * time.Second
+)
+
+// Event is the JSON payload POSTed to webhook endpoints
+type Event struct {
+	Repository string `json:"repository"`
+	Action     string `json:"action"`
+	Kind       string `json:"kind"`
+	Digest     string `json:"digest"`
+	Tag        string `json:"tag,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// Endpoint is a webhook target; Secret, if set, signs each delivery
+type Endpoint struct {
+	URL    string
+	Secret string
+}
+
+// Notifier delivers events to endpoints asynchronously. Events are queued in a bounded queue
+// and delivered in order by a single worker, with retries; when the queue is full new events
+// are dropped so slow endpoints never block the registry. All methods are safe on a nil Notifier.
+// Notifier is an events.EventSink for pushes and deletes; proxy cache fills are not delivered.
+type Notifier struct {
+	events.NopSink
+
+	endpoints  []Endpoint
+	client     *http.Client
+	queue      chan Event
+	maxRetries int
+	retryDelay time.Duration
+
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewNotifier creates a notifier for the endpoints and starts its delivery worker.
+// queueSize <= 0 uses DefaultQueueSize.
+func NewNotifier(endpoints []Endpoint, queueSize int) *Notifier {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	n := &Notifier{
+		endpoints:  endpoints,
+		client:     &http.Client{Timeout: DefaultTimeout},
+		queue:      make(chan Event, queueSize),
+		maxRetries: DefaultMaxRetries,
+		retryDelay: DefaultRetryDelay,
+		done:       make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// SetRetry sets the number of retries per delivery and the initial delay between them (doubled after each attempt).
+// Must be called before events are sent.
+func (n *Notifier) SetRetry(maxRetries int, delay time.Duration) {
+	n.maxRetries = maxRetries
+	n.retryDelay = delay
+}
+
+// Endpoints returns the endpoints events are delivered to
+func (n *Notifier) Endpoints() []Endpoint {
+	if n == nil {
+		return nil
+	}
+	return append([]Endpoint(nil), n.endpoints...)
+}
+
+// QueueSize returns the capacity of the event queue
+func (n *Notifier) QueueSize() int {
+	if n == nil {
+		return 0
+	}
+	return cap(n.queue)
+}
+
+// Retry returns the number of retries per delivery and the initial delay between them
+func (n *Notifier) Retry() (maxRetries int, delay time.Duration) {
+	if n == nil {
+		return 0, 0
+	}
+	return n.maxRetries, n.retryDelay
+}
+
+// Notify queues an event without blocking; returns false if the queue is full and the event was dropped
+func (n *Notifier) Notify(event Event) bool {
+	if n == nil {
+		return true
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	select {
+	case n.queue <- event:
+		return true
+	default:
+		n.dropped.Add(1)
+		return false
+	}
+}
+
+// OnManifestPushed implements events.EventSink
+func (n *Notifier) OnManifestPushed(ctx context.Context, event events.Event) {
+	n.Notify(newEvent(event, ActionPush, KindManifest))
+}
+
+// OnManifestDeleted implements events.EventSink
+func (n *Notifier) OnManifestDeleted(ctx context.Context, event events.Event) {
+	n.Notify(newEvent(event, ActionDelete, KindManifest))
+}
+
+// OnBlobPushed implements events.EventSink
+func (n *Notifier) OnBlobPushed(ctx context.Context, event events.Event) {
+	n.Notify(newEvent(event, ActionPush, KindBlob))
+}
+
+// newEvent converts a registry event to the webhook payload
+func newEvent(event events.Event, action, kind string) Event {
+	payload := Event{
+		Repository: event.Repository,
+		Action:     action,
+		Kind:       kind,
+		Digest:     event.Digest,
+		Tag:        event.Tag,
+	}
+	if !event.Timestamp.IsZero() {
+		payload.Timestamp = event.Timestamp.Unix()
+	}
+	return payload
+}
+
+// Dropped returns the number of events dropped because the queue was full
+func (n *Notifier) Dropped() int64 {
+	if n == nil {
+		return 0
+	}
+	return n.dropped.Load()
+}
+
+// Failed returns the number of deliveries that failed after all retries
+func (n *Notifier) Failed() int64 {
+	if n == nil {
+		return 0
+	}
+	return n.failed.Load()
+}
+
+// Close stops accepting events and waits until the queued ones are delivered.
+// Notify must not be called after Close.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() { close(n.queue) })
+	<-n.done
+}
+
+// run delivers queued events to every endpoint until the queue is closed
+func (n *Notifier) run() {
+	defer close(n.done)
+	for event := range n.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			n.failed.Add(int64(len(n.endpoints)))
+			continue
+		}
+		for _, endpoint := range n.endpoints {
+			if err := n.deliver(endpoint, body); err != nil {
+				n.failed.Add(1)
+			}
+		}
+	}
+}
+
+// deliver POSTs body to the endpoint, retrying failures with exponential backoff
+func (n *Notifier) deliver(endpoint Endpoint, body []byte) error {
+	delay := n.retryDelay
+	var err error
+	for attempt := 0; attempt <= n.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if err = n.post(endpoint, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// post sends one delivery attempt
+func (n *Notifier) post(endpoint Endpoint, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if endpoint.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(endpoint.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery to %s failed: %w", endpoint.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook delivery to %s failed with status %d", endpoint.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value for body signed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestNotifierRetriesAndDrops tests that failed deliveries are retried and a full queue drops events
+func TestNotifierRetriesAndDrops(t *testing.T) {
+	var attempts atomic.Int32
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	notifier := NewNotifier([]Endpoint{{URL: target.URL}}, 1)
+	notifier.SetRetry(3, time.Millisecond)
+
+	// The worker blocks on the first event, one more fits the queue, the third is dropped
+	if !notifier.Notify(Event{Repository: "a"}) {
+		t.Fatal("Expected first event to be queued")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifier.queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	notifier.Notify(Event{Repository: "b"})
+	if notifier.Notify(Event{Repository: "c"}) {
+		t.Error("Expected event to be dropped when the queue is full")
+	}
+
+	close(release)
+	notifier.Close()
+	if notifier.Dropped() != 1 || notifier.Failed() != 0 {
+		t.Errorf("Expected 1 dropped and 0 failed, got %d and %d", notifier.Dropped(), notifier.Failed())
+	}
+	// First event: two 503s then success; second event: success
+	if got := attempts.Load(); got != 4 {
+		t.Errorf("Expected 4 delivery attempts, got %d", got)
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPKey is the context key for the resolved client IP
+type clientIPKey struct{}
+
+// TrustedProxies holds the CIDR ranges of proxies allowed to set forwarding headers
+type TrustedProxies struct {
+	networks []*net.IPNet
+}
+
+// ParseTrustedProxies parses a list of CIDRs (or bare IPs) into a TrustedProxies allowlist
+func ParseTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		// Bare IPs are treated as single-host networks
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %s", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %s: %w", cidr, err)
+		}
+		tp.networks = append(tp.networks, network)
+	}
+	return tp, nil
+}
+
+// Contains checks if the IP belongs to a trusted proxy network
+func (tp *TrustedProxies) Contains(ip net.IP) bool {
+	if tp == nil || ip == nil {
+		return false
+	}
+	for _, network := range tp.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP extracts the IP of the immediate peer from RemoteAddr
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// ResolveClientIP determines the real client IP for a request.
+// Forwarding headers are honored only when the immediate peer is a trusted proxy,
+// so untrusted clients cannot spoof their address.
+// X-Forwarded-For is walked right-to-left, skipping trusted proxies; the first untrusted
+// hop is the client. X-Real-IP is used when X-Forwarded-For is absent.
+func (tp *TrustedProxies) ResolveClientIP(r *http.Request) net.IP {
+	peer := peerIP(r)
+	if !tp.Contains(peer) {
+		return peer
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		var hops []string
+		for _, value := range forwarded {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+
+		var client net.IP
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// Malformed hop - stop at the last address we could verify
+				break
+			}
+			client = ip
+			if !tp.Contains(ip) {
+				return ip
+			}
+		}
+		if client != nil {
+			return client
+		}
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP
+	}
+
+	return peer
+}
+
+// ClientIPMiddleware resolves the client IP and stores it in the request context
+func ClientIPMiddleware(tp *TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := tp.ResolveClientIP(r)
+		next.ServeHTTP(w, r.WithContext(WithClientIP(r.Context(), ip)))
+	})
+}
+
+// WithClientIP returns a copy of ctx carrying the client IP
+func WithClientIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP stored by ClientIPMiddleware, or nil if absent
+func ClientIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientIPKey{}).(net.IP)
+	return ip
+}
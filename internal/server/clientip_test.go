@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// resolveThroughMiddleware runs a request through ClientIPMiddleware and returns the IP seen by the handler
+func resolveThroughMiddleware(t *testing.T, tp *TrustedProxies, req *http.Request) net.IP {
+	var seen net.IP
+	handler := ClientIPMiddleware(tp, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientIPFromContext(r.Context())
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return seen
+}
+
+// TestClientIPTrustedPeer tests that forwarding headers are honored from a trusted proxy
+func TestClientIPTrustedPeer(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	t.Run("x_forwarded_for", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = "10.1.2.3:45678"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.9.9.9")
+
+		ip := resolveThroughMiddleware(t, tp, req)
+		if !ip.Equal(net.ParseIP("203.0.113.7")) {
+			t.Errorf("Expected client IP 203.0.113.7, got %v", ip)
+		}
+	})
+
+	t.Run("x_forwarded_for_spoofed_prefix", func(t *testing.T) {
+		// The client prepended a fake hop; only the address added by our proxy is trusted
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = "192.168.1.1:45678"
+		req.Header.Set("X-Forwarded-For", "1.1.1.1, 198.51.100.20")
+
+		ip := resolveThroughMiddleware(t, tp, req)
+		if !ip.Equal(net.ParseIP("198.51.100.20")) {
+			t.Errorf("Expected client IP 198.51.100.20, got %v", ip)
+		}
+	})
+
+	t.Run("x_real_ip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = "10.1.2.3:45678"
+		req.Header.Set("X-Real-IP", "198.51.100.5")
+
+		ip := resolveThroughMiddleware(t, tp, req)
+		if !ip.Equal(net.ParseIP("198.51.100.5")) {
+			t.Errorf("Expected client IP 198.51.100.5, got %v", ip)
+		}
+	})
+}
+
+// TestClientIPUntrustedPeer tests that forwarding headers from untrusted peers are ignored
+func TestClientIPUntrustedPeer(t *testing.T) {
+	tp, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.RemoteAddr = "203.0.113.50:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Real-IP", "5.6.7.8")
+
+	ip := resolveThroughMiddleware(t, tp, req)
+	if !ip.Equal(net.ParseIP("203.0.113.50")) {
+		t.Errorf("Expected peer IP 203.0.113.50, got %v", ip)
+	}
+}
+
+// TestParseTrustedProxiesInvalid tests CIDR validation
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected error for invalid trusted proxy")
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/99"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// DebugGoroutinesPath reports goroutine and memory statistics (see DebugHandler)
+const DebugGoroutinesPath = "/debug/goroutines"
+
+// DebugConfig enables the runtime introspection endpoint.
+// Token is the bearer token requests must present; empty disables the endpoint.
+// TokenFile names a file holding the token instead (see ReadTokenFile), so it needn't be kept in the
+// configuration; a missing, unreadable or empty file fails startup rather than leaving the endpoint
+// disabled or open.
+// Stacks additionally allows full goroutine stack dumps (?stacks=true), which can be large and
+// expose internals, so they are off unless explicitly enabled.
+type DebugConfig struct {
+	Token     string
+	TokenFile string
+	Stacks    bool
+}
+
+// Enabled reports whether the debug endpoint is served
+func (c DebugConfig) Enabled() bool {
+	return c.Token != ""
+}
+
+// ReadTokenFile returns the token stored in path, without surrounding whitespace.
+// An empty token is an error, so a truncated secret can't silently disable authentication.
+func ReadTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// debugStatus is the JSON body of DebugGoroutinesPath responses
+type debugStatus struct {
+	Goroutines int         `json:"goroutines"`
+	Memory     memoryStats `json:"memory"`
+	Stacks     string      `json:"stacks,omitempty"`
+}
+
+// memoryStats is the subset of runtime.MemStats useful to spot leaks
+type memoryStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	StackInuse   uint64 `json:"stackInuse"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+// DebugHandler serves DebugGoroutinesPath (GET) when cfg is enabled and passes other requests to next.
+// Requests without the configured bearer token get 401. The response carries the goroutine count
+// and memory statistics, plus a dump of all goroutine stacks for ?stacks=true when cfg.Stacks is set.
+func DebugHandler(next http.Handler, cfg DebugConfig) http.Handler {
+	if !cfg.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DebugGoroutinesPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		status := debugStatus{
+			Goroutines: runtime.NumGoroutine(),
+			Memory: memoryStats{
+				Alloc:        mem.Alloc,
+				TotalAlloc:   mem.TotalAlloc,
+				Sys:          mem.Sys,
+				HeapObjects:  mem.HeapObjects,
+				HeapInuse:    mem.HeapInuse,
+				StackInuse:   mem.StackInuse,
+				NumGC:        mem.NumGC,
+				PauseTotalNs: mem.PauseTotalNs,
+			},
+		}
+		if cfg.Stacks && r.URL.Query().Get("stacks") == "true" {
+			status.Stacks = goroutineStacks()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// goroutineStacks returns the stacks of all goroutines, growing the buffer until they fit
+func goroutineStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
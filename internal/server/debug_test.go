@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDebugHandler tests authentication, the reported goroutine count and gated stack dumps
+func TestDebugHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	get := func(handler http.Handler, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(DebugHandler(next, DebugConfig{}), DebugGoroutinesPath, ""); rec.Code != http.StatusTeapot {
+		t.Errorf("Expected the endpoint to be disabled without a token, got %d", rec.Code)
+	}
+
+	handler := DebugHandler(next, DebugConfig{Token: "secret"})
+	for _, token := range []string{"", "wrong"} {
+		if rec := get(handler, DebugGoroutinesPath, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+	if rec := get(handler, "/v2/", ""); rec.Code != http.StatusTeapot {
+		t.Errorf("Expected other paths to reach next, got %d", rec.Code)
+	}
+
+	// Park a known number of goroutines so the count is at least that
+	release := make(chan struct{})
+	defer close(release)
+	for i := 0; i < 10; i++ {
+		go func() { <-release }()
+	}
+	rec := get(handler, DebugGoroutinesPath+"?stacks=true", "secret")
+	var status debugStatus
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid body %q: %v", rec.Body.String(), err)
+	}
+	if status.Goroutines < 11 || status.Goroutines > 10000 {
+		t.Errorf("Implausible goroutine count %d", status.Goroutines)
+	}
+	if status.Memory.Sys == 0 || status.Memory.Alloc == 0 {
+		t.Errorf("Expected memory statistics, got %+v", status.Memory)
+	}
+	if status.Stacks != "" {
+		t.Error("Expected no stack dump unless stacks are enabled")
+	}
+
+	rec = get(DebugHandler(next, DebugConfig{Token: "secret", Stacks: true}), DebugGoroutinesPath+"?stacks=true", "secret")
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid body %q: %v", rec.Body.String(), err)
+	}
+	if !strings.Contains(status.Stacks, "goroutine ") {
+		t.Errorf("Expected a goroutine stack dump, got %q", status.Stacks)
+	}
+}
+
+// TestReadTokenFile tests that missing and empty token files are errors rather than no token
+func TestReadTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadTokenFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing token file")
+	}
+
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTokenFile(path); err == nil {
+		t.Error("Expected an error for an empty token file")
+	}
+
+	if err := os.WriteFile(path, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ReadTokenFile(path)
+	if err != nil {
+		t.Fatalf("ReadTokenFile failed: %v", err)
+	}
+	if token != "secret" {
+		t.Errorf("Expected token %q, got %q", "secret", token)
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health endpoint paths.
+// LivePath reports that the process is alive and serving; ReadyPath reports whether it can
+// actually serve artifacts, for load balancers to route around instances that can't.
+const (
+	LivePath  = "/livez"
+	ReadyPath = "/readyz"
+)
+
+// DefaultReadyTimeout bounds the readiness checks of one ReadyPath request
+const DefaultReadyTimeout = 5 * time.Second
+
+// ReadinessCheck is a named dependency check; Check returns nil when the dependency is ready
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// healthStatus is the JSON body of health responses
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthHandler serves LivePath and ReadyPath (GET or HEAD) and passes other requests to next.
+// LivePath always returns 200. ReadyPath runs the checks returned by checks concurrently, bounded
+// by timeout (<= 0 uses DefaultReadyTimeout), and returns 200 if all pass, 503 otherwise,
+// with each check's result in the body.
+func HealthHandler(next http.Handler, timeout time.Duration, checks func() []ReadinessCheck) http.Handler {
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != LivePath && r.URL.Path != ReadyPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status := healthStatus{Status: "ok"}
+		code := http.StatusOK
+		if r.URL.Path == ReadyPath {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			var ready bool
+			if status.Checks, ready = runChecks(ctx, checks()); !ready {
+				status.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(status)
+		}
+	})
+}
+
+// runChecks runs checks concurrently and returns each result ("ok" or the error) by name,
+// and whether all passed
+func runChecks(ctx context.Context, checks []ReadinessCheck) (map[string]string, bool) {
+	results := make(map[string]string, len(checks))
+	ready := true
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := check.Check(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if result != "ok" {
+				ready = false
+			}
+			results[check.Name] = result
+		}()
+	}
+	wg.Wait()
+	return results, ready
+}
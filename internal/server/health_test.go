@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHealthHandler tests liveness, readiness and pass-through routing
+func TestHealthHandler(t *testing.T) {
+	var failing error
+	checks := func() []ReadinessCheck {
+		return []ReadinessCheck{
+			{Name: "always", Check: func(ctx context.Context) error { return nil }},
+			{Name: "toggled", Check: func(ctx context.Context) error { return failing }},
+		}
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := HealthHandler(next, time.Second, checks)
+
+	get := func(path string) (int, healthStatus) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var status healthStatus
+		if rec.Code != http.StatusTeapot {
+			if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+				t.Fatalf("%s: invalid body %q: %v", path, rec.Body.String(), err)
+			}
+		}
+		return rec.Code, status
+	}
+
+	if code, _ := get(ReadyPath); code != http.StatusOK {
+		t.Errorf("Expected ready, got %d", code)
+	}
+
+	failing = errors.New("disk full")
+	code, status := get(ReadyPath)
+	if code != http.StatusServiceUnavailable || status.Status != "unavailable" {
+		t.Errorf("Expected 503 unavailable, got %d %q", code, status.Status)
+	}
+	if status.Checks["toggled"] != "disk full" || status.Checks["always"] != "ok" {
+		t.Errorf("Unexpected check results: %v", status.Checks)
+	}
+	if code, status := get(LivePath); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("Expected live while not ready, got %d %q", code, status.Status)
+	}
+	if code, _ := get("/v2/"); code != http.StatusTeapot {
+		t.Errorf("Expected other paths to reach next, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, ReadyPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+// TestHealthHandlerTimeout tests that readiness checks are bounded by the timeout
+func TestHealthHandlerTimeout(t *testing.T) {
+	checks := func() []ReadinessCheck {
+		return []ReadinessCheck{{Name: "slow", Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}}}
+	}
+	handler := HealthHandler(http.NotFoundHandler(), 50*time.Millisecond, checks)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ReadyPath, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after timeout, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/basakil/brm-config/pkg/config"
+)
+
+// Default server settings
+const (
+	DefaultHost              = "0.0.0.0"
+	DefaultPort              = 8080
+	DefaultAddr              = "0.0.0.0:8080"
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultMetadataTimeout   = 30 * time.Second
+)
+
+// Config holds the HTTP server settings.
+// ReadTimeout and WriteTimeout cover the whole request/response, so they default to 0 (disabled)
+// to avoid cutting off large blob uploads and downloads; ReadHeaderTimeout guards against slow-loris clients.
+// RouteTimeouts override the server-wide deadlines per request (see RouteTimeoutMiddleware).
+// HTTP2 enables HTTP/2 over TLS (negotiated via ALPN); H2C enables cleartext HTTP/2 with prior knowledge,
+// for deployments behind a proxy that terminates TLS. HTTP/1.1 is always served.
+// TLS enables HTTPS serving (see TLSConfig).
+// ReadyTimeout bounds the readiness checks of HealthHandler.
+// DefaultRegistry names the registry whose handlers are mounted at the root of the server, for
+// single-registry deployments without per-binding routing (see registry.RegistryManager.RootHandler).
+// Debug enables the token-protected runtime introspection endpoint (see DebugHandler).
+// TrustedProxies lists the proxies whose forwarding headers name the client IP (see ClientIPMiddleware).
+type Config struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	RouteTimeouts     RouteTimeouts
+	HTTP2             bool
+	H2C               bool
+	TLS               TLSConfig
+	ReadyTimeout      time.Duration
+	DefaultRegistry   string
+	Debug             DebugConfig
+	TrustedProxies    *TrustedProxies
+}
+
+// DefaultConfig returns the default server configuration
+func DefaultConfig() Config {
+	return Config{
+		Addr:              DefaultAddr,
+		ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+		RouteTimeouts:     RouteTimeouts{Metadata: DefaultMetadataTimeout},
+		HTTP2:             true,
+		ReadyTimeout:      DefaultReadyTimeout,
+	}
+}
+
+// LoadConfig reads the server configuration from the "server" section, falling back to defaults
+func LoadConfig(cfg *config.Config) (Config, error) {
+	result := DefaultConfig()
+	if cfg == nil {
+		return result, nil
+	}
+	serverConfig := cfg.GetSubConfig("server")
+	if serverConfig == nil {
+		return result, nil
+	}
+
+	// addr sets host:port at once; host and port override its parts
+	if addr := serverConfig.GetString("addr"); addr != "" {
+		result.Addr = addr
+	}
+	if serverConfig.Exists("host") || serverConfig.Exists("port") {
+		host, port, err := net.SplitHostPort(result.Addr)
+		if err != nil {
+			return result, fmt.Errorf("server: invalid addr %s: %w", result.Addr, err)
+		}
+		if serverConfig.Exists("host") {
+			host = serverConfig.GetString("host")
+		}
+		if serverConfig.Exists("port") {
+			port = serverConfig.GetString("port")
+		}
+		result.Addr = net.JoinHostPort(host, port)
+	}
+	if err := ValidateAddr(result.Addr); err != nil {
+		return result, fmt.Errorf("server: %w", err)
+	}
+
+	durations := []struct {
+		key    string
+		target *time.Duration
+	}{
+		{"readHeaderTimeout", &result.ReadHeaderTimeout},
+		{"readTimeout", &result.ReadTimeout},
+		{"writeTimeout", &result.WriteTimeout},
+		{"idleTimeout", &result.IdleTimeout},
+		{"metadataTimeout", &result.RouteTimeouts.Metadata},
+		{"blobTimeout", &result.RouteTimeouts.Blob},
+		{"readyTimeout", &result.ReadyTimeout},
+	}
+	for _, d := range durations {
+		value := serverConfig.GetString(d.key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return result, fmt.Errorf("server: invalid %s: %w", d.key, err)
+		}
+		*d.target = parsed
+	}
+
+	flags := []struct {
+		key    string
+		target *bool
+	}{
+		{"http2", &result.HTTP2},
+		{"h2c", &result.H2C},
+	}
+	for _, f := range flags {
+		value := serverConfig.GetString(f.key)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return result, fmt.Errorf("server: invalid %s: %w", f.key, err)
+		}
+		*f.target = parsed
+	}
+
+	result.DefaultRegistry = serverConfig.GetString("defaultRegistry")
+
+	if value := serverConfig.GetString("trustedProxies"); value != "" {
+		trusted, err := ParseTrustedProxies(strings.Split(value, ","))
+		if err != nil {
+			return result, fmt.Errorf("server: invalid trustedProxies: %w", err)
+		}
+		result.TrustedProxies = trusted
+	}
+
+	if debugConfig := serverConfig.GetSubConfig("debug"); debugConfig != nil {
+		result.Debug.Token = debugConfig.GetString("token")
+		if tokenFile := debugConfig.GetString("tokenFile"); tokenFile != "" {
+			if result.Debug.Token != "" {
+				return result, fmt.Errorf("server: debug token and tokenFile are mutually exclusive")
+			}
+			token, err := ReadTokenFile(tokenFile)
+			if err != nil {
+				return result, fmt.Errorf("server: invalid debug tokenFile: %w", err)
+			}
+			result.Debug.Token = token
+			result.Debug.TokenFile = tokenFile
+		}
+		if value := debugConfig.GetString("stacks"); value != "" {
+			stacks, err := strconv.ParseBool(value)
+			if err != nil {
+				return result, fmt.Errorf("server: invalid debug stacks: %w", err)
+			}
+			result.Debug.Stacks = stacks
+		}
+	}
+
+	if tlsConfig := serverConfig.GetSubConfig("tls"); tlsConfig != nil {
+		result.TLS = TLSConfig{
+			CertFile:     tlsConfig.GetString("certFile"),
+			KeyFile:      tlsConfig.GetString("keyFile"),
+			ClientCAFile: tlsConfig.GetString("clientCAFile"),
+			MinVersion:   tlsConfig.GetString("minVersion"),
+			CipherSuites: parseCipherSuites(tlsConfig.GetString("cipherSuites")),
+		}
+		if (result.TLS.CertFile == "") != (result.TLS.KeyFile == "") {
+			return result, fmt.Errorf("server: tls requires both certFile and keyFile")
+		}
+	}
+
+	return result, nil
+}
+
+// ValidateAddr checks that addr is a valid "host:port" listen address.
+// The host may be empty (all interfaces), an IP address of a specific interface, or a hostname.
+func ValidateAddr(addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid listen address %q: port must be between 0 and 65535", addr)
+	}
+	if host != "" && net.ParseIP(host) == nil && !isValidHostname(host) {
+		return fmt.Errorf("invalid listen address %q: invalid host", addr)
+	}
+	return nil
+}
+
+// isValidHostname checks the characters and label lengths of a DNS hostname
+func isValidHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Server wraps an http.Server configured from Config
+type Server struct {
+	config     Config
+	httpServer *http.Server
+	certs      *certReloader // Non-nil when TLS is enabled
+	listener   net.Listener  // Set by Listen
+}
+
+// New creates a new server serving handler with the given configuration.
+// The handler is wrapped with ClientIPMiddleware and RouteTimeoutMiddleware.
+// Returns an error if TLS is configured but its certificate or settings can't be loaded.
+func New(cfg Config, handler http.Handler) (*Server, error) {
+	srv := &Server{
+		config: cfg,
+		httpServer: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           ClientIPMiddleware(cfg.TrustedProxies, RouteTimeoutMiddleware(cfg.RouteTimeouts, handler)),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			Protocols:         protocols(cfg),
+		},
+	}
+
+	if cfg.TLS.Enabled() {
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := buildTLSConfig(cfg.TLS, certs)
+		if err != nil {
+			return nil, err
+		}
+		srv.certs = certs
+		srv.httpServer.TLSConfig = tlsConfig
+	}
+
+	return srv, nil
+}
+
+// protocols returns the HTTP protocols the server accepts for the given configuration
+func protocols(cfg Config) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(cfg.HTTP2)
+	p.SetUnencryptedHTTP2(cfg.H2C)
+	return p
+}
+
+// Config returns the server configuration
+func (s *Server) Config() Config {
+	return s.config
+}
+
+// HTTPServer returns the underlying http.Server
+func (s *Server) HTTPServer() *http.Server {
+	return s.httpServer
+}
+
+// Listen binds the configured address without serving yet, and returns the resolved address
+// (useful with port 0, which picks an ephemeral port)
+func (s *Server) Listen() (net.Addr, error) {
+	if s.listener != nil {
+		return s.listener.Addr(), nil
+	}
+	if err := ValidateAddr(s.httpServer.Addr); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", s.httpServer.Addr, err)
+	}
+	s.listener = listener
+	return listener.Addr(), nil
+}
+
+// Addr returns the address the server is bound to, or nil before Listen/Start
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Start listens on the configured address (unless Listen was called) and serves requests
+// until Shutdown is called. Returns nil after a graceful shutdown.
+func (s *Server) Start() error {
+	if _, err := s.Listen(); err != nil {
+		return fmt.Errorf("server error: %w", err)
+	}
+	return s.Serve(s.listener)
+}
+
+// Serve serves requests on listener (over TLS when configured) until Shutdown is called.
+// Returns nil after a graceful shutdown.
+func (s *Server) Serve(listener net.Listener) error {
+	var err error
+	if s.certs != nil {
+		err = s.httpServer.ServeTLS(listener, "", "")
+	} else {
+		err = s.httpServer.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server error: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for active requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestServerTimeouts tests that the http.Server is configured with the supplied timeouts
+func TestServerTimeouts(t *testing.T) {
+	cfg := Config{
+		Addr:              "127.0.0.1:0",
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      45 * time.Second,
+		IdleTimeout:       90 * time.Second,
+	}
+
+	srv, err := New(cfg, http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	httpServer := srv.HTTPServer()
+
+	if httpServer.Addr != cfg.Addr {
+		t.Errorf("Expected addr %s, got %s", cfg.Addr, httpServer.Addr)
+	}
+	if httpServer.ReadHeaderTimeout != cfg.ReadHeaderTimeout {
+		t.Errorf("Expected ReadHeaderTimeout %v, got %v", cfg.ReadHeaderTimeout, httpServer.ReadHeaderTimeout)
+	}
+	if httpServer.ReadTimeout != cfg.ReadTimeout {
+		t.Errorf("Expected ReadTimeout %v, got %v", cfg.ReadTimeout, httpServer.ReadTimeout)
+	}
+	if httpServer.WriteTimeout != cfg.WriteTimeout {
+		t.Errorf("Expected WriteTimeout %v, got %v", cfg.WriteTimeout, httpServer.WriteTimeout)
+	}
+	if httpServer.IdleTimeout != cfg.IdleTimeout {
+		t.Errorf("Expected IdleTimeout %v, got %v", cfg.IdleTimeout, httpServer.IdleTimeout)
+	}
+}
+
+// TestDefaultConfig tests that defaults guard headers but leave streaming unbounded
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.ReadHeaderTimeout <= 0 {
+		t.Error("Expected a positive default ReadHeaderTimeout")
+	}
+	if cfg.WriteTimeout != 0 {
+		t.Errorf("Expected WriteTimeout disabled by default, got %v", cfg.WriteTimeout)
+	}
+
+	loaded, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Errorf("Expected defaults for nil config, got %+v", loaded)
+	}
+}
+
+// TestServerClientIP tests that the server resolves client IPs through its trusted proxies
+func TestServerClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	var seen net.IP
+	cfg := DefaultConfig()
+	cfg.TrustedProxies = trusted
+	srv, err := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientIPFromContext(r.Context())
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	srv.HTTPServer().Handler.ServeHTTP(httptest.NewRecorder(), req)
+	if !seen.Equal(net.ParseIP("203.0.113.7")) {
+		t.Errorf("Expected client IP 203.0.113.7, got %v", seen)
+	}
+}
+
+// TestServerH2C tests that a cleartext HTTP/2 client can perform GET /v2/ when h2c is enabled
+func TestServerH2C(t *testing.T) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "HTTP/%d", r.ProtoMajor)
+	})
+
+	cfg := DefaultConfig()
+	cfg.H2C = true
+	srv, err := New(cfg, handler)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.HTTPServer().Close() })
+
+	// Client speaks only cleartext HTTP/2 with prior knowledge
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	resp, err := client.Get("http://" + listener.Addr().String() + "/v2/")
+	if err != nil {
+		t.Fatalf("h2c GET /v2/ failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2" {
+		t.Errorf("Expected HTTP/2, got %s (handler saw %s)", resp.Proto, body)
+	}
+}
+
+// TestServerListenEphemeral tests binding 127.0.0.1:0 and connecting to the resolved address
+func TestServerListenEphemeral(t *testing.T) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	srv, err := New(cfg, handler)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	addr, err := srv.Listen()
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.Equal(net.ParseIP("127.0.0.1")) || tcpAddr.Port == 0 {
+		t.Fatalf("Expected a resolved 127.0.0.1 address, got %v", addr)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start() }()
+
+	resp, err := http.Get("http://" + addr.String() + "/v2/")
+	if err != nil {
+		t.Fatalf("GET /v2/ failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected nil from Start after shutdown, got %v", err)
+	}
+}
+
+// TestValidateAddr tests listen address validation
+func TestValidateAddr(t *testing.T) {
+	valid := []string{":8080", "0.0.0.0:8080", "127.0.0.1:0", "[::1]:5000", "localhost:80"}
+	for _, addr := range valid {
+		if err := ValidateAddr(addr); err != nil {
+			t.Errorf("Expected %q to be valid: %v", addr, err)
+		}
+	}
+	invalid := []string{"8080", "0.0.0.0:70000", "0.0.0.0:http", "bad_host:80", "127.0.0.1"}
+	for _, addr := range invalid {
+		if err := ValidateAddr(addr); err == nil {
+			t.Errorf("Expected %q to be invalid", addr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// RouteTimeouts holds per-route deadlines.
+// Metadata covers manifests, tags, version checks and other small JSON exchanges;
+// Blob covers streaming routes (see streamingRoutes), which may legitimately take a long time.
+// A zero Metadata timeout keeps the server-wide deadlines; a zero Blob timeout removes them.
+type RouteTimeouts struct {
+	Metadata time.Duration
+	Blob     time.Duration
+}
+
+// streamingRoutes lists the routes that stream request or response bodies of unbounded size,
+// in http.ServeMux pattern syntax; all other routes are metadata routes
+var streamingRoutes = []string{
+	"GET /v2/{name}/blobs/{digest}",
+	"POST /v2/{name}/blobs/uploads/",
+	"PATCH /v2/{name}/blobs/uploads/{uuid}",
+	"PUT /v2/{name}/blobs/uploads/{uuid}",
+	"GET /raw/{name...}",
+	"PUT /raw/{name...}",
+	"POST /admin/repos/{name}/import",
+	"GET /admin/export",
+}
+
+// streamingRouteMux matches requests against streamingRoutes
+var streamingRouteMux = func() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, pattern := range streamingRoutes {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return mux
+}()
+
+// isStreamingRoute checks if the request targets one of streamingRoutes
+func isStreamingRoute(r *http.Request) bool {
+	_, pattern := streamingRouteMux.Handler(r)
+	return pattern != ""
+}
+
+// deadline converts a timeout into an absolute deadline; zero means no deadline
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// RouteTimeoutMiddleware adjusts connection deadlines per request using http.ResponseController.
+// Streaming routes get the Blob timeout (or none), so server-wide ReadTimeout/WriteTimeout values
+// can stay short without cutting off large transfers; all other routes get the Metadata timeout.
+func RouteTimeoutMiddleware(timeouts RouteTimeouts, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if isStreamingRoute(r) {
+			// Errors mean the writer doesn't support deadlines (e.g. in tests); nothing to adjust
+			_ = rc.SetReadDeadline(deadline(timeouts.Blob))
+			_ = rc.SetWriteDeadline(deadline(timeouts.Blob))
+		} else if timeouts.Metadata > 0 {
+			_ = rc.SetReadDeadline(deadline(timeouts.Metadata))
+			_ = rc.SetWriteDeadline(deadline(timeouts.Metadata))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTimeoutTestServer starts a server with a short global WriteTimeout and per-route timeouts
+func startTimeoutTestServer(t *testing.T, handler http.Handler) *httptest.Server {
+	srv, err := New(Config{
+		WriteTimeout:  200 * time.Millisecond,
+		RouteTimeouts: RouteTimeouts{Metadata: 100 * time.Millisecond},
+	}, handler)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(srv.HTTPServer().Handler)
+	ts.Config.WriteTimeout = srv.HTTPServer().WriteTimeout
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestRouteTimeoutMetadata tests that a slow metadata response is cut off
+func TestRouteTimeoutMetadata(t *testing.T) {
+	ts := startTimeoutTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(`{"schemaVersion":2}`))
+	}))
+
+	resp, err := http.Get(ts.URL + "/v2/test-repo/manifests/latest")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Fatal("Expected slow metadata request to time out")
+	}
+}
+
+// TestRouteTimeoutBlobStreaming tests that a slow but progressing blob transfer outlives the global WriteTimeout
+func TestRouteTimeoutBlobStreaming(t *testing.T) {
+	const chunk = "0123456789"
+	const chunks = 5
+
+	ts := startTimeoutTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		for i := 0; i < chunks; i++ {
+			w.Write([]byte(chunk))
+			rc.Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+
+	resp, err := http.Get(ts.URL + "/v2/test-repo/blobs/sha256:abc")
+	if err != nil {
+		t.Fatalf("Blob request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Blob transfer was cut off: %v", err)
+	}
+	if string(data) != strings.Repeat(chunk, chunks) {
+		t.Errorf("Expected %d bytes, got %d", len(chunk)*chunks, len(data))
+	}
+}
+
+// TestIsStreamingRoute tests that streaming routes are classified by route, not by path substring
+func TestIsStreamingRoute(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodGet, "/v2/test-repo/blobs/sha256:abc", true},
+		{http.MethodHead, "/v2/test-repo/blobs/sha256:abc", true}, // GET patterns match HEAD too
+		{http.MethodPatch, "/v2/test-repo/blobs/uploads/123", true},
+		{http.MethodPut, "/v2/test-repo/blobs/uploads/123", true},
+		{http.MethodGet, "/raw/dir/file.tar.gz", true},
+		{http.MethodPut, "/raw/file.bin", true},
+		{http.MethodDelete, "/raw/file.bin", false},
+		{http.MethodGet, "/v2/test-repo/manifests/latest", false},
+		{http.MethodGet, "/admin/repos/test-repo/blobs", false},
+		{http.MethodPost, "/admin/repos/test-repo/import", true},
+		{http.MethodGet, "/admin/export", true},
+		{http.MethodGet, "/admin/uploads", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := isStreamingRoute(r); got != tt.want {
+			t.Errorf("isStreamingRoute(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// TLSConfig holds the TLS serving settings.
+// TLS is enabled when both CertFile and KeyFile are set. ClientCAFile enables mutual TLS:
+// clients must present a certificate signed by one of its CAs.
+// MinVersion is "1.0", "1.1", "1.2" or "1.3" (default "1.2"); CipherSuites are Go cipher suite
+// names (see tls.CipherSuites) and only apply to TLS 1.2 and below.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	MinVersion   string
+	CipherSuites []string
+}
+
+// Enabled reports whether TLS serving is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// tlsVersions maps configuration values to TLS protocol versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves the current certificate and reloads it from disk on demand
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// newCertReloader loads the initial certificate
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload reads the certificate and key from disk; the previous certificate is kept on error
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// buildTLSConfig creates the tls.Config for the given settings, with certificates served by reloader
+func buildTLSConfig(cfg TLSConfig, reloader *certReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS minVersion: %s", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		known := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			known[suite.Name] = suite.ID
+		}
+		for _, name := range cfg.CipherSuites {
+			id, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure TLS cipher suite: %s", name)
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// parseCipherSuites splits a comma-separated list of cipher suite names
+func parseCipherSuites(value string) []string {
+	var suites []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			suites = append(suites, name)
+		}
+	}
+	return suites
+}
+
+// ReloadTLS reloads the TLS certificate and key from disk.
+// New connections use the reloaded certificate; if loading fails, the previous one stays in use.
+func (s *Server) ReloadTLS() error {
+	if s.certs == nil {
+		return fmt.Errorf("TLS is not enabled")
+	}
+	return s.certs.reload()
+}
+
+// WatchReloadSignal reloads the TLS certificate whenever the process receives SIGHUP, until ctx is done.
+// onError, if not nil, is called with reload failures.
+func (s *Server) WatchReloadSignal(ctx context.Context, onError func(error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := s.ReloadTLS(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
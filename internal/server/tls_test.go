@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a self-signed certificate for 127.0.0.1 and returns the cert and key paths
+func writeSelfSignedCert(t *testing.T, dir, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// TestServerTLS tests HTTPS serving with a self-signed certificate and certificate reload
+func TestServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir, "first")
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cfg := DefaultConfig()
+	cfg.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"}
+	srv, err := New(cfg, handler)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.HTTPServer().Close() })
+
+	// get performs GET /v2/ and returns the common name of the served certificate
+	get := func() string {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/v2/")
+		if err != nil {
+			t.Fatalf("HTTPS GET /v2/ failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", resp.StatusCode)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if name := get(); name != "first" {
+		t.Errorf("Expected certificate 'first', got %s", name)
+	}
+
+	writeSelfSignedCert(t, dir, "second")
+	if err := srv.ReloadTLS(); err != nil {
+		t.Fatalf("ReloadTLS failed: %v", err)
+	}
+	if name := get(); name != "second" {
+		t.Errorf("Expected reloaded certificate 'second', got %s", name)
+	}
+}
+
+// TestBuildTLSConfigInvalid tests that invalid TLS settings are rejected
+func TestBuildTLSConfigInvalid(t *testing.T) {
+	if _, err := buildTLSConfig(TLSConfig{MinVersion: "2.0"}, &certReloader{}); err == nil {
+		t.Error("Expected error for invalid minVersion")
+	}
+	if _, err := buildTLSConfig(TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, &certReloader{}); err == nil {
+		t.Error("Expected error for insecure cipher suite")
+	}
+	if _, err := buildTLSConfig(TLSConfig{ClientCAFile: filepath.Join(t.TempDir(), "missing.pem")}, &certReloader{}); err == nil {
+		t.Error("Expected error for missing client CA file")
+	}
+}
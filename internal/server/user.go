@@ -0,0 +1,17 @@
+package server
+
+import "context"
+
+// userKey is the context key for the authenticated user name
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying the authenticated user name
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the authenticated user name, or "" for anonymous requests
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
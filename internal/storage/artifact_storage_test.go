@@ -86,6 +86,11 @@ func TestArtifactStorageFullWorkflow(t *testing.T) {
 	testArtifactStorageFullWorkflow(t, storage)
 }
 
+func TestArtifactStorageMediaType(t *testing.T) {
+	storage, _ := setupTestStorage(t)
+	testArtifactStorageMediaType(t, storage)
+}
+
 func TestArtifactStorageConcurrent(t *testing.T) {
 	storage, _ := setupTestStorage(t)
 	ctx := context.Background()
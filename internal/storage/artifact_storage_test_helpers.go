@@ -739,6 +739,65 @@ func testArtifactStorageUpdateMeta(t *testing.T, storage models.ArtifactStorage)
 			t.Errorf("Expected name new, got %s", ref.Name)
 		}
 	})
+
+	t.Run("generation", func(t *testing.T) {
+		hash := "metatest4"
+		testData := []byte("test")
+		expectGeneration := func(step string, meta *models.ArtifactMeta, want int64) {
+			t.Helper()
+			if meta.Generation != want {
+				t.Errorf("%s: expected generation %d, got %d", step, want, meta.Generation)
+			}
+			stored, err := storage.GetMeta(ctx, hash)
+			if err != nil {
+				t.Fatalf("%s: GetMeta failed: %v", step, err)
+			}
+			if stored.Generation != want {
+				t.Errorf("%s: expected stored generation %d, got %d", step, want, stored.Generation)
+			}
+		}
+
+		created, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), createTestMeta(hash, "first", "docker:test", int64(len(testData))))
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		expectGeneration("create", created, 1)
+
+		merged, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), createTestMeta(hash, "second", "docker:test", int64(len(testData))))
+		if err != nil {
+			t.Fatalf("Merging Create failed: %v", err)
+		}
+		expectGeneration("merge", merged, 2)
+
+		// A caller's stale generation is ignored: the stored one is bumped
+		merged.Generation = 0
+		updated, err := storage.UpdateMeta(ctx, *merged)
+		if err != nil {
+			t.Fatalf("UpdateMeta failed: %v", err)
+		}
+		expectGeneration("update", updated, 3)
+
+		if ts, ok := storage.(TruncateStorage); ok {
+			if err := ts.Truncate(ctx, hash, 2); err != nil {
+				t.Fatalf("Truncate failed: %v", err)
+			}
+			stored, err := storage.GetMeta(ctx, hash)
+			if err != nil {
+				t.Fatalf("GetMeta failed: %v", err)
+			}
+			expectGeneration("truncate", stored, 4)
+		}
+
+		before, err := storage.GetMeta(ctx, hash)
+		if err != nil {
+			t.Fatalf("GetMeta failed: %v", err)
+		}
+		remaining, err := storage.Delete(ctx, hash, models.ArtifactReference{Name: "first", Repo: "docker:test"})
+		if err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		expectGeneration("delete", remaining, before.Generation+1)
+	})
 }
 
 // testArtifactStorageFullWorkflow tests a complete workflow combining multiple operations
@@ -863,3 +922,49 @@ func testArtifactStorageFullWorkflow(t *testing.T, storage models.ArtifactStorag
 		t.Error("Expected error when reading deleted artifact")
 	}
 }
+
+// testArtifactStorageMediaType tests that the media type round-trips through Create, GetMeta and
+// UpdateMeta, and that Create records it on an existing artifact lacking one without replacing it
+func testArtifactStorageMediaType(t *testing.T, storage models.ArtifactStorage) {
+	ctx := context.Background()
+	data := []byte("typed artifact")
+	meta := createTestMeta("typed123", "repo", "manifest", int64(len(data)))
+	meta.MediaType = "application/vnd.oci.image.manifest.v1+json"
+	if _, err := storage.Create(ctx, "typed123", bytes.NewReader(data), int64(len(data)), meta); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	got, err := storage.GetMeta(ctx, "typed123")
+	if err != nil || got.MediaType != meta.MediaType {
+		t.Fatalf("Expected media type %q, got %+v (%v)", meta.MediaType, got, err)
+	}
+	other := createTestMeta("typed123", "other", "manifest", int64(len(data)))
+	other.MediaType = "application/octet-stream"
+	if _, err := storage.Create(ctx, "typed123", bytes.NewReader(data), int64(len(data)), other); err != nil {
+		t.Fatalf("Create with reference failed: %v", err)
+	}
+	if got, _ := storage.GetMeta(ctx, "typed123"); got.MediaType != meta.MediaType {
+		t.Errorf("Expected the recorded media type to be kept, got %q", got.MediaType)
+	}
+
+	// Untyped artifacts (e.g. written by older versions) get one from the next Create
+	if _, err := storage.Create(ctx, "untyped123", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if got, _ := storage.GetMeta(ctx, "untyped123"); got.MediaType != "" {
+		t.Errorf("Expected no media type, got %q", got.MediaType)
+	}
+	if _, err := storage.Create(ctx, "untyped123", nil, -1, &models.ArtifactMeta{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip"}); err != nil {
+		t.Fatalf("Create with media type failed: %v", err)
+	}
+	got, _ = storage.GetMeta(ctx, "untyped123")
+	if got.MediaType != "application/vnd.oci.image.layer.v1.tar+gzip" {
+		t.Errorf("Expected the media type to be recorded, got %q", got.MediaType)
+	}
+	got.MediaType = "text/plain"
+	if _, err := storage.UpdateMeta(ctx, *got); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	if got, _ := storage.GetMeta(ctx, "untyped123"); got.MediaType != "text/plain" {
+		t.Errorf("Expected UpdateMeta to set the media type, got %q", got.MediaType)
+	}
+}
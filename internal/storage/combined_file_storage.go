@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// Combined artifact file format: a fixed prefix (magic, header capacity and metadata length, both
+// big-endian uint32), the metadata JSON padded to the header capacity, then the artifact data.
+// The spare capacity lets metadata updates (reference merges) rewrite the header in place.
+const (
+	combinedMagic      = "BRMC"
+	combinedPrefixSize = 12
+	combinedMinHeader  = 512
+)
+
+// CombinedFileStorage implements models.ArtifactStorage with a single file per artifact holding
+// a length-prefixed metadata header followed by the data. Compared to SimpleFileStorage it halves
+// inode usage and syscalls, and creates and deletes are atomic (one rename each).
+type CombinedFileStorage struct {
+	models.BaseStorage
+	baseDir string
+	layout  Layout
+}
+
+// combinedHeader locates the parts of a combined artifact file
+type combinedHeader struct {
+	capacity int64 // Bytes reserved for the metadata JSON
+	metaLen  int64 // Bytes of metadata JSON in use
+}
+
+// dataOffset returns the file offset of the artifact data
+func (h combinedHeader) dataOffset() int64 {
+	return combinedPrefixSize + h.capacity
+}
+
+// NewCombinedFileStorage creates a new storage instance and ensures the base directory exists.
+func NewCombinedFileStorage(alias, baseDir string) (*CombinedFileStorage, error) {
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create base directory: %w", err)
+	}
+	s := &CombinedFileStorage{
+		baseDir: baseDir,
+		layout:  DefaultLayout,
+	}
+	s.BaseStorage.SetAlias(alias)
+	return s, nil
+}
+
+// getPath returns the file path for a given hash
+func (s *CombinedFileStorage) getPath(hash string) string {
+	return s.layout.path(s.baseDir, hash)
+}
+
+// getTrashPath returns the trash file path for a given hash, sharded like the artifacts
+func (s *CombinedFileStorage) getTrashPath(hash string) string {
+	return s.layout.path(filepath.Join(s.baseDir, ".trash"), hash)
+}
+
+// headerCapacity returns the header capacity reserved for metadata of the given encoded size
+func headerCapacity(metaLen int) int64 {
+	capacity := int64(combinedMinHeader)
+	for capacity < int64(2*metaLen) {
+		capacity *= 2
+	}
+	return capacity
+}
+
+// readHeader reads and validates the header prefix of f
+func readHeader(f *os.File) (combinedHeader, error) {
+	var prefix [combinedPrefixSize]byte
+	if _, err := f.ReadAt(prefix[:], 0); err != nil {
+		return combinedHeader{}, fmt.Errorf("failed to read header of %s: %w", f.Name(), err)
+	}
+	if string(prefix[:4]) != combinedMagic {
+		return combinedHeader{}, fmt.Errorf("invalid combined artifact file %s", f.Name())
+	}
+	header := combinedHeader{
+		capacity: int64(binary.BigEndian.Uint32(prefix[4:8])),
+		metaLen:  int64(binary.BigEndian.Uint32(prefix[8:12])),
+	}
+	if header.metaLen > header.capacity {
+		return combinedHeader{}, fmt.Errorf("invalid header in %s", f.Name())
+	}
+	return header, nil
+}
+
+// readMeta decodes the metadata of an open combined artifact file
+func readMeta(f *os.File) (*models.ArtifactMeta, combinedHeader, error) {
+	header, err := readHeader(f)
+	if err != nil {
+		return nil, header, err
+	}
+	var meta models.ArtifactMeta
+	if err := json.NewDecoder(io.NewSectionReader(f, combinedPrefixSize, header.metaLen)).Decode(&meta); err != nil {
+		return nil, header, fmt.Errorf("failed to decode metadata of %s: %w", f.Name(), err)
+	}
+	meta.Normalize()
+	return &meta, header, nil
+}
+
+// writeMeta writes meta into the header of f, which must have room for it
+func writeMeta(f *os.File, capacity int64, meta *models.ArtifactMeta) error {
+	meta.Normalize()
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if int64(len(encoded)) > capacity {
+		return errHeaderFull
+	}
+	buf := make([]byte, combinedPrefixSize+len(encoded))
+	copy(buf, combinedMagic)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(capacity))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(encoded)))
+	copy(buf[combinedPrefixSize:], encoded)
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return nil
+}
+
+// errHeaderFull reports metadata that outgrew the header capacity
+var errHeaderFull = fmt.Errorf("metadata exceeds header capacity")
+
+// Create stores the artifact and optional metadata in one file, written under a temporary name
+// and renamed into place. If the artifact already exists, validates length and merges references
+// without writing data.
+func (s *CombinedFileStorage) Create(ctx context.Context, hash string, r io.Reader, size int64, meta *models.ArtifactMeta) (*models.ArtifactMeta, error) {
+	path := s.getPath(hash)
+	if existingMeta, err := s.GetMeta(ctx, hash); err == nil {
+		if size != -1 && size != existingMeta.Length {
+			return nil, &models.HashConflictError{
+				Hash:           hash,
+				ExistingLength: existingMeta.Length,
+				ProvidedLength: size,
+			}
+		}
+		if meta == nil || (len(meta.References) == 0 && (meta.MediaType == "" || existingMeta.MediaType != "")) {
+			return existingMeta, nil
+		}
+		existingMeta.References = mergeReferences(existingMeta.References, meta.References)
+		if existingMeta.MediaType == "" {
+			existingMeta.MediaType = meta.MediaType
+		}
+		return s.UpdateMeta(ctx, *existingMeta)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read existing metadata: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create subdirectory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".artifact-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	fail := func(err error) (*models.ArtifactMeta, error) {
+		tmp.Close()
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+
+	finalMeta := &models.ArtifactMeta{Hash: hash, References: []models.ArtifactReference{}}
+	if meta != nil {
+		finalMeta.CreatedTimestamp = meta.CreatedTimestamp
+		if meta.References != nil {
+			finalMeta.References = meta.References
+		}
+		finalMeta.ContentDigest = meta.ContentDigest
+		finalMeta.MediaType = meta.MediaType
+	}
+	finalMeta.Generation = 1
+
+	// Reserve the header before the data is known: size it for the largest possible length
+	provisional := *finalMeta
+	provisional.Length = 1<<63 - 1
+	provisional.CreatedTimestamp = 1<<63 - 1
+	encoded, err := json.Marshal(&provisional)
+	if err != nil {
+		return fail(fmt.Errorf("failed to encode metadata: %w", err))
+	}
+	header := combinedHeader{capacity: headerCapacity(len(encoded))}
+	if _, err := tmp.Seek(header.dataOffset(), io.SeekStart); err != nil {
+		return fail(fmt.Errorf("failed to write artifact data: %w", err))
+	}
+	written, err := CopyBuffer(tmp, r)
+	if err != nil {
+		return fail(fmt.Errorf("failed to write artifact data: %w", err))
+	}
+
+	finalMeta.Length = written
+	if finalMeta.CreatedTimestamp == 0 {
+		stat, err := tmp.Stat()
+		if err != nil {
+			return fail(fmt.Errorf("failed to stat artifact file: %w", err))
+		}
+		finalMeta.CreatedTimestamp = stat.ModTime().Unix()
+	}
+	if err := writeMeta(tmp, header.capacity, finalMeta); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to write artifact file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to store artifact file: %w", err)
+	}
+	return finalMeta, nil
+}
+
+// Read retrieves a range of the artifact data, past the metadata header.
+func (s *CombinedFileStorage) Read(ctx context.Context, req models.ArtifactRange) (io.ReadCloser, models.ArtifactRange, error) {
+	f, err := os.Open(s.getPath(req.Hash))
+	if err != nil {
+		return nil, models.ArtifactRange{}, err
+	}
+	header, err := readHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, models.ArtifactRange{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, models.ArtifactRange{}, err
+	}
+	dataSize := stat.Size() - header.dataOffset()
+
+	offset := req.Range.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	length := req.Range.Length
+	// If length is -1 or extends past EOF, limit it to available bytes
+	if length == -1 || offset+length > dataSize {
+		length = dataSize - offset
+	}
+	if length < 0 {
+		length = 0
+	}
+
+	actualRange := models.ArtifactRange{
+		Hash: req.Hash,
+		Range: models.ByteRange{
+			Offset: offset,
+			Length: length,
+		},
+	}
+	rc := &closingSectionReader{
+		SectionReader: io.NewSectionReader(f, header.dataOffset()+offset, length),
+		closer:        f,
+	}
+	return rc, actualRange, nil
+}
+
+// Update modifies a range of the artifact data. An offset beyond the current size zero-fills the gap.
+func (s *CombinedFileStorage) Update(ctx context.Context, req models.ArtifactRange, r io.Reader) error {
+	if req.Range.Offset < 0 {
+		return fmt.Errorf("invalid update offset: %d", req.Range.Offset)
+	}
+	f, err := os.OpenFile(s.getPath(req.Hash), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header, err := s.openHeader(f)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := header.dataOffset() + req.Range.Offset
+	if offset > stat.Size() {
+		if err := f.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to zero-fill artifact: %w", err)
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if req.Range.Length > 0 {
+		_, err = io.CopyN(f, r, req.Range.Length)
+	} else {
+		_, err = CopyBuffer(f, r)
+	}
+	return err
+}
+
+// openHeader reads the header of a file opened write-only
+func (s *CombinedFileStorage) openHeader(f *os.File) (combinedHeader, error) {
+	rf, err := os.Open(f.Name())
+	if err != nil {
+		return combinedHeader{}, err
+	}
+	defer rf.Close()
+	return readHeader(rf)
+}
+
+// Truncate changes the size of the artifact data and updates the metadata Length.
+// Shrinking discards trailing bytes; growing zero-fills.
+func (s *CombinedFileStorage) Truncate(ctx context.Context, hash string, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid truncate size: %d", size)
+	}
+	f, err := os.OpenFile(s.getPath(hash), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	meta, header, err := readMeta(f)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(header.dataOffset() + size); err != nil {
+		return err
+	}
+	meta.Length = size
+	meta.Generation++
+	return s.writeMetaTo(f, header, meta)
+}
+
+// Delete removes a specific reference to an artifact.
+// If no references remain, the artifact is moved to trash (one rename) and nil is returned.
+// If references remain, only the metadata is updated and the updated metadata is returned.
+func (s *CombinedFileStorage) Delete(ctx context.Context, hash string, ref models.ArtifactReference) (*models.ArtifactMeta, error) {
+	existingMeta, err := s.GetMeta(ctx, hash)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("artifact with hash %s does not exist", hash)
+		}
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	found := false
+	newReferences := make([]models.ArtifactReference, 0, len(existingMeta.References))
+	for _, existingRef := range existingMeta.References {
+		if existingRef.Name == ref.Name && existingRef.Repo == ref.Repo {
+			found = true
+		} else {
+			newReferences = append(newReferences, existingRef)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("reference with name %s and repo %s not found for artifact %s", ref.Name, ref.Repo, hash)
+	}
+
+	if len(newReferences) == 0 {
+		trashPath := s.getTrashPath(hash)
+		if err := os.MkdirAll(filepath.Dir(trashPath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create trash directory: %w", err)
+		}
+		if err := os.Rename(s.getPath(hash), trashPath); err != nil {
+			return nil, fmt.Errorf("failed to move artifact to trash: %w", err)
+		}
+		return nil, nil
+	}
+
+	existingMeta.References = newReferences
+	return s.UpdateMeta(ctx, *existingMeta)
+}
+
+// GetMeta reads the metadata header.
+func (s *CombinedFileStorage) GetMeta(ctx context.Context, hash string) (*models.ArtifactMeta, error) {
+	f, err := os.Open(s.getPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	meta, _, err := readMeta(f)
+	return meta, err
+}
+
+// UpdateMeta overwrites the metadata header, in place when it fits the reserved capacity,
+// bumping the generation of the stored metadata.
+func (s *CombinedFileStorage) UpdateMeta(ctx context.Context, meta models.ArtifactMeta) (*models.ArtifactMeta, error) {
+	f, err := os.OpenFile(s.getPath(meta.Hash), os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	meta.Generation = 1
+	current, header, err := readMeta(f)
+	if err == nil {
+		meta.Generation = current.Generation + 1
+	} else if header, err = readHeader(f); err != nil {
+		// Only an undecodable metadata header can be overwritten
+		return nil, err
+	}
+	if err := s.writeMetaTo(f, header, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// writeMetaTo writes meta into the header of f, rewriting the whole file with a larger header
+// (under a temporary name, then renamed into place) if it no longer fits
+func (s *CombinedFileStorage) writeMetaTo(f *os.File, header combinedHeader, meta *models.ArtifactMeta) error {
+	err := writeMeta(f, header.capacity, meta)
+	if err != errHeaderFull {
+		return err
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	grown := combinedHeader{capacity: headerCapacity(len(encoded))}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Name()), ".artifact-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	if err := writeMeta(tmp, grown.capacity, meta); err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data := io.NewSectionReader(f, header.dataOffset(), stat.Size()-header.dataOffset())
+	if _, err := tmp.Seek(grown.dataOffset(), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to copy artifact data: %w", err)
+	}
+	if _, err := CopyBuffer(tmp, data); err != nil {
+		return fmt.Errorf("failed to copy artifact data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write artifact file: %w", err)
+	}
+	if err := os.Rename(tmpPath, f.Name()); err != nil {
+		return fmt.Errorf("failed to store artifact file: %w", err)
+	}
+	return nil
+}
+
+// Exists checks if the artifact exists with a single stat call; data and metadata always exist together.
+func (s *CombinedFileStorage) Exists(ctx context.Context, hash string) (bool, bool, error) {
+	if _, err := os.Stat(s.getPath(hash)); err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, true, nil
+}
+
+// Size returns the data length of the artifact from its file size and header, without decoding the metadata.
+func (s *CombinedFileStorage) Size(ctx context.Context, hash string) (int64, bool, error) {
+	f, err := os.Open(s.getPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	defer f.Close()
+	header, err := readHeader(f)
+	if err != nil {
+		return 0, false, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	return stat.Size() - header.dataOffset(), true, nil
+}
+
+// MoveIfAbsent moves an artifact like Move, failing with ErrDestinationExists if the destination exists.
+func (s *CombinedFileStorage) MoveIfAbsent(ctx context.Context, srcHash, destHash string) error {
+	if exists, _, err := s.Exists(ctx, destHash); err != nil {
+		return err
+	} else if exists {
+		return ErrDestinationExists
+	}
+	return s.Move(ctx, srcHash, destHash)
+}
+
+// Move renames an artifact to a new hash location and rewrites the hash in its metadata.
+// Like SimpleFileStorage.Move, it leaves the source shard directory in place for concurrent writers.
+func (s *CombinedFileStorage) Move(ctx context.Context, srcHash, destHash string) error {
+	srcPath, destPath := s.getPath(srcHash), s.getPath(destHash)
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dest directory: %w", err)
+	}
+	if err := os.Rename(srcPath, destPath); err != nil {
+		return fmt.Errorf("failed to move artifact from %s to %s: %w", srcPath, destPath, err)
+	}
+
+	f, err := os.OpenFile(destPath, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open moved artifact: %w", err)
+	}
+	defer f.Close()
+	meta, header, err := readMeta(f)
+	if err != nil {
+		return err
+	}
+	meta.Hash = destHash
+	return s.writeMetaTo(f, header, meta)
+}
+
+// Walk calls fn with the metadata of every stored artifact.
+// Returning an error from fn stops the walk and returns that error.
+func (s *CombinedFileStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	return walkLayoutFiles(ctx, s.baseDir, s.layout, false, func(path, hash string) error {
+		meta, err := s.GetMeta(ctx, hash)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to read metadata for %s: %w", hash, err)
+		}
+		return fn(meta)
+	})
+}
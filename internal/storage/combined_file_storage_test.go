@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// setupCombinedStorage creates a CombinedFileStorage via StorageManager for testing
+func setupCombinedStorage(t *testing.T) (models.ArtifactStorage, string) {
+	t.Helper()
+	baseDir := t.TempDir()
+	alias := "combined-" + strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(t.Name(), "TestCombinedFileStorage"), "/", "-"))
+	storage, err := GetManager().Create("combined.filestorage", alias, baseDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return storage, baseDir
+}
+
+func TestCombinedFileStorageContract(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, models.ArtifactStorage)
+	}{
+		{"create", testArtifactStorageCreate},
+		{"read", testArtifactStorageRead},
+		{"update", testArtifactStorageUpdate},
+		{"delete", testArtifactStorageDelete},
+		{"getmeta", testArtifactStorageGetMeta},
+		{"updatemeta", testArtifactStorageUpdateMeta},
+		{"workflow", testArtifactStorageFullWorkflow},
+		{"mediatype", testArtifactStorageMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, _ := setupCombinedStorage(t)
+			tt.fn(t, storage)
+		})
+	}
+}
+
+// TestCombinedFileStorageSingleFile tests that data and metadata round-trip through one file
+func TestCombinedFileStorageSingleFile(t *testing.T) {
+	storage, baseDir := setupCombinedStorage(t)
+	ctx := context.Background()
+	hash := "abc123def456"
+	data := []byte("combined artifact data")
+	meta := createTestMeta(hash, "repo", "blob", int64(len(data)))
+	meta.ContentDigest = "sha256:feed"
+
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(data), int64(len(data)), meta); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(baseDir, "ab"))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "c123def456" {
+		t.Fatalf("Expected a single artifact file, got %v", entries)
+	}
+
+	got, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if got.Length != int64(len(data)) || got.ContentDigest != "sha256:feed" || len(got.References) != 1 {
+		t.Errorf("Unexpected metadata: %+v", got)
+	}
+	rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	verifyData(t, readAllData(t, rc), data)
+
+	// Reopening the directory sees the same artifact
+	reopened, err := NewCombinedFileStorage("combined-reopened", baseDir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	if exists, metaExists, err := reopened.Exists(ctx, hash); err != nil || !exists || !metaExists {
+		t.Errorf("Exists = %v, %v, %v; want true, true, nil", exists, metaExists, err)
+	}
+}
+
+// TestCombinedFileStorageRangedReads tests that ranges are offset past the header
+func TestCombinedFileStorageRangedReads(t *testing.T) {
+	storage, _ := setupCombinedStorage(t)
+	ctx := context.Background()
+	hash := "range123"
+	data := createTestData(4096)
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	tests := []struct {
+		offset, length int64
+		want           []byte
+	}{
+		{0, 10, data[:10]},
+		{100, 50, data[100:150]},
+		{4000, -1, data[4000:]},
+		{4090, 100, data[4090:]},
+		{5000, 10, []byte{}},
+	}
+	for _, tt := range tests {
+		rc, actual, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: tt.offset, Length: tt.length}})
+		if err != nil {
+			t.Fatalf("Read(%d, %d) failed: %v", tt.offset, tt.length, err)
+		}
+		got := readAllData(t, rc)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("Read(%d, %d) returned %d bytes, want %d", tt.offset, tt.length, len(got), len(tt.want))
+		}
+		if actual.Range.Length != int64(len(tt.want)) {
+			t.Errorf("Read(%d, %d) range length = %d, want %d", tt.offset, tt.length, actual.Range.Length, len(tt.want))
+		}
+	}
+}
+
+// TestCombinedFileStorageSize tests that Size reports the data length past the header
+func TestCombinedFileStorageSize(t *testing.T) {
+	storage, _ := setupCombinedStorage(t)
+	ctx := context.Background()
+	hash := "size123"
+	data := createTestData(1000)
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	sizer := storage.(SizeStorage)
+	if size, exists, err := sizer.Size(ctx, hash); err != nil || !exists || size != int64(len(data)) {
+		t.Errorf("Expected size %d, got %d (exists=%v, err=%v)", len(data), size, exists, err)
+	}
+	if _, exists, err := sizer.Size(ctx, "missing123"); err != nil || exists {
+		t.Errorf("Expected a missing artifact to not exist, got exists=%v, err=%v", exists, err)
+	}
+}
+
+// TestCombinedFileStorageHeaderGrowth tests that metadata outgrowing the header keeps the data intact
+func TestCombinedFileStorageHeaderGrowth(t *testing.T) {
+	storage, _ := setupCombinedStorage(t)
+	ctx := context.Background()
+	hash := "grow123"
+	data := createTestData(1000)
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(data), int64(len(data)), createTestMeta(hash, "repo", "blob", 1000)); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		ref := models.ArtifactReference{Name: strings.Repeat("n", 20) + string(rune('a'+i%26)) + strings.Repeat("x", i), Repo: "blob"}
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(nil), -1, &models.ArtifactMeta{References: []models.ArtifactReference{ref}}); err != nil {
+			t.Fatalf("Create with reference %d failed: %v", i, err)
+		}
+	}
+
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if len(meta.References) != 201 || meta.Length != 1000 {
+		t.Errorf("Got %d references and length %d, want 201 and 1000", len(meta.References), meta.Length)
+	}
+	rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 10, Length: 20}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	verifyData(t, readAllData(t, rc), data[10:30])
+}
+
+// TestCombinedFileStorageMoveAndTruncate tests Move and Truncate keep metadata consistent, and MoveIfAbsent keeps an existing destination
+func TestCombinedFileStorageMoveAndTruncate(t *testing.T) {
+	storage, _ := setupCombinedStorage(t)
+	ctx := context.Background()
+	data := []byte("0123456789")
+	if _, err := storage.Create(ctx, "tmp-123", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := storage.(TruncateStorage).Truncate(ctx, "tmp-123", 4); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if err := storage.(MoveStorage).Move(ctx, "tmp-123", "final456"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+
+	meta, err := storage.GetMeta(ctx, "final456")
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if meta.Hash != "final456" || meta.Length != 4 {
+		t.Errorf("Got hash %s and length %d, want final456 and 4", meta.Hash, meta.Length)
+	}
+	rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: "final456", Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	verifyData(t, readAllData(t, rc), data[:4])
+
+	var walked []string
+	if err := storage.(EnumerableStorage).Walk(ctx, func(meta *models.ArtifactMeta) error {
+		walked = append(walked, meta.Hash)
+		return nil
+	}); err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(walked) != 1 || walked[0] != "final456" {
+		t.Errorf("Walk visited %v, want [final456]", walked)
+	}
+
+	if _, err := storage.Create(ctx, "tmp-789", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := storage.(MoveIfAbsentStorage).MoveIfAbsent(ctx, "tmp-789", "final456"); !errors.Is(err, ErrDestinationExists) {
+		t.Fatalf("MoveIfAbsent onto an existing artifact returned %v, want ErrDestinationExists", err)
+	}
+	if meta, err := storage.GetMeta(ctx, "final456"); err != nil || meta.Length != 4 {
+		t.Errorf("MoveIfAbsent replaced the destination: %v, %v", meta, err)
+	}
+}
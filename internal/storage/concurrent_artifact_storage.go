@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,27 @@ import (
 	"github.com/gofrs/flock"
 )
 
+// ErrLockTimeout matches (errors.Is) failures to acquire an artifact lock in time, i.e. contention
+// on a hot hash. The operation didn't run and can be retried; see LockTimeoutError.
+var ErrLockTimeout = errors.New("lock acquisition timeout")
+
+// LockTimeoutError reports a lock on Hash that couldn't be acquired within Timeout, the storage's
+// configured lock timeout. Registries answer it with 503 and a Retry-After of Timeout.
+type LockTimeoutError struct {
+	Hash    string
+	Timeout time.Duration
+}
+
+// Error implements the error interface
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("lock acquisition timeout for hash %s after %v", e.Hash, e.Timeout)
+}
+
+// Is makes errors.Is(err, ErrLockTimeout) match
+func (e *LockTimeoutError) Is(target error) bool {
+	return target == ErrLockTimeout
+}
+
 // ConcurrentArtifactStorage wraps an ArtifactStorage implementation with file-based locking
 // to ensure thread-safe and process-safe concurrent operations.
 type ConcurrentArtifactStorage struct {
@@ -97,12 +119,12 @@ func (c *ConcurrentArtifactStorage) acquireLock(ctx context.Context, hash string
 	locked, err := fileLock.TryLockContext(lockCtx, retryDelay)
 	if err != nil {
 		if err == context.DeadlineExceeded || err == context.Canceled {
-			return nil, fmt.Errorf("lock acquisition timeout for hash %s after %v", hash, c.lockTimeout)
+			return nil, &LockTimeoutError{Hash: hash, Timeout: c.lockTimeout}
 		}
 		return nil, fmt.Errorf("failed to acquire lock: %w", err)
 	}
 	if !locked {
-		return nil, fmt.Errorf("lock acquisition timeout for hash %s after %v", hash, c.lockTimeout)
+		return nil, &LockTimeoutError{Hash: hash, Timeout: c.lockTimeout}
 	}
 
 	return fileLock, nil
@@ -132,6 +154,53 @@ func (c *ConcurrentArtifactStorage) Update(ctx context.Context, req models.Artif
 	return c.storage.Update(ctx, req, r)
 }
 
+// Truncate changes the size of an artifact with locking.
+// Locking is required since both the data and the metadata Length change.
+func (c *ConcurrentArtifactStorage) Truncate(ctx context.Context, hash string, size int64) error {
+	fileLock, err := c.acquireLock(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+
+	// Delegate to underlying storage (which must implement TruncateStorage)
+	truncateStorage, ok := c.storage.(TruncateStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Truncate method")
+	}
+
+	return truncateStorage.Truncate(ctx, hash, size)
+}
+
+// Walk iterates the metadata of all artifacts without locking.
+// Entries reflect a point-in-time read and may change concurrently.
+func (c *ConcurrentArtifactStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	enumerable, ok := c.storage.(EnumerableStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Walk method")
+	}
+	return enumerable.Walk(ctx, fn)
+}
+
+// Exists checks for the artifact's data and metadata without locking.
+func (c *ConcurrentArtifactStorage) Exists(ctx context.Context, hash string) (bool, bool, error) {
+	return existsIn(ctx, c.storage, hash)
+}
+
+// Size reports the artifact's data length without locking.
+func (c *ConcurrentArtifactStorage) Size(ctx context.Context, hash string) (int64, bool, error) {
+	return sizeIn(ctx, c.storage, hash)
+}
+
+// RedirectURL delegates to the underlying storage if it implements RedirectStorage, without locking.
+func (c *ConcurrentArtifactStorage) RedirectURL(ctx context.Context, hash string) (string, bool) {
+	redirect, ok := c.storage.(RedirectStorage)
+	if !ok {
+		return "", false
+	}
+	return redirect.RedirectURL(ctx, hash)
+}
+
 // Delete removes a specific reference to an artifact with locking.
 // If no references remain, the artifact is moved to trash and nil is returned.
 // If references remain, only the metadata is updated and the updated metadata is returned.
@@ -162,6 +231,30 @@ func (c *ConcurrentArtifactStorage) UpdateMeta(ctx context.Context, meta models.
 	return c.storage.UpdateMeta(ctx, meta)
 }
 
+// CompareAndSwapMeta replaces the metadata under the hash lock, which makes the comparison and the
+// write atomic even when the underlying storage doesn't implement CASStorage.
+func (c *ConcurrentArtifactStorage) CompareAndSwapMeta(ctx context.Context, expected, updated models.ArtifactMeta) (bool, error) {
+	fileLock, err := c.acquireLock(ctx, expected.Hash)
+	if err != nil {
+		return false, err
+	}
+	defer fileLock.Unlock()
+
+	return compareAndSwapMeta(ctx, c.storage, expected, updated)
+}
+
+// MoveIfAbsent moves an artifact like Move unless the destination exists, checking it under the
+// destination hash lock so a concurrent Create can't be replaced.
+func (c *ConcurrentArtifactStorage) MoveIfAbsent(ctx context.Context, srcHash, destHash string) error {
+	fileLock, err := c.acquireLock(ctx, destHash)
+	if err != nil {
+		return err
+	}
+	defer fileLock.Unlock()
+
+	return moveIfAbsentIn(ctx, c.storage, srcHash, destHash)
+}
+
 // Move renames an artifact and its metadata to a new hash location with locking.
 // Locks the destination hash to prevent concurrent operations.
 func (c *ConcurrentArtifactStorage) Move(ctx context.Context, srcHash, destHash string) error {
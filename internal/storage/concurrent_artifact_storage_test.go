@@ -3,6 +3,7 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -181,6 +182,46 @@ func TestConcurrentArtifactStorageDelegation(t *testing.T) {
 	}
 }
 
+// TestConcurrentArtifactStorageTruncate tests that Truncate is delegated under lock
+func TestConcurrentArtifactStorageTruncate(t *testing.T) {
+	simple, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	wrapper, err := NewConcurrentArtifactStorage(simple, t.TempDir(), 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+
+	ctx := context.Background()
+	hash := "truncate123"
+	testData := []byte("0123456789")
+	if _, err := wrapper.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := wrapper.Truncate(ctx, hash, 4); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	meta, err := wrapper.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if meta.Length != 4 {
+		t.Errorf("Expected metadata length 4, got %d", meta.Length)
+	}
+
+	// Mock storage does not support truncation
+	mockWrapper, err := NewConcurrentArtifactStorage(newMockStorage(), t.TempDir(), 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	if err := mockWrapper.Truncate(ctx, hash, 4); err == nil {
+		t.Error("Expected error when underlying storage does not implement Truncate")
+	}
+}
+
 // TestConcurrentArtifactStorageConcurrentCreate tests concurrent Create operations on same hash
 func TestConcurrentArtifactStorageConcurrentCreate(t *testing.T) {
 	lockDir := t.TempDir()
@@ -469,6 +510,10 @@ func TestConcurrentArtifactStorageLockTimeout(t *testing.T) {
 	if err.Error() == "" || err.Error() == "context canceled" {
 		t.Fatalf("Expected timeout error message, got: %v", err)
 	}
+	var lockErr *LockTimeoutError
+	if !errors.Is(err, ErrLockTimeout) || !errors.As(err, &lockErr) || lockErr.Timeout != shortTimeout {
+		t.Errorf("Expected a LockTimeoutError carrying the lock timeout, got %v", err)
+	}
 }
 
 // TestConcurrentArtifactStorageReadOperationsNoLock tests that Read and GetMeta don't require locks
@@ -551,3 +596,64 @@ func TestConcurrentArtifactStorageInvalidParams(t *testing.T) {
 		t.Error("Expected error for negative timeout")
 	}
 }
+
+// TestConcurrentArtifactStorageModifyMeta tests that concurrent read-modify-write updates through
+// compare-and-swap retries all land, and that a stale swap is refused
+func TestConcurrentArtifactStorageModifyMeta(t *testing.T) {
+	simple, err := NewSimpleFileStorage("test", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	wrapper, err := NewConcurrentArtifactStorage(simple, t.TempDir(), 30*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create wrapper: %v", err)
+	}
+	ctx := context.Background()
+	hash := "cas-hash"
+	if _, err := wrapper.Create(ctx, hash, bytes.NewReader([]byte("data")), 4, nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	const numGoroutines = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			_, err := ModifyMeta(ctx, wrapper, hash, func(meta *models.ArtifactMeta) error {
+				meta.References = append(meta.References, models.ArtifactReference{Name: fmt.Sprintf("ref%d", id), Repo: "repo"})
+				return nil
+			})
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("ModifyMeta failed: %v", err)
+	}
+
+	meta, err := wrapper.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if len(meta.References) != numGoroutines {
+		t.Errorf("Expected %d references, got %d", numGoroutines, len(meta.References))
+	}
+	if meta.Generation != numGoroutines+1 {
+		t.Errorf("Expected generation %d, got %d", numGoroutines+1, meta.Generation)
+	}
+
+	stale := *meta
+	stale.Generation--
+	swapped, err := wrapper.CompareAndSwapMeta(ctx, stale, models.ArtifactMeta{Hash: hash})
+	if err != nil || swapped {
+		t.Errorf("Expected a stale swap to be refused, got %v: %v", swapped, err)
+	}
+	if swapped, err := wrapper.CompareAndSwapMeta(ctx, *meta, models.ArtifactMeta{Hash: hash, Length: 4}); err != nil || !swapped {
+		t.Errorf("Expected a current swap to succeed, got %v: %v", swapped, err)
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCopyBufferSize is the buffer size used to stream artifact content (see SetCopyBufferSize)
+const DefaultCopyBufferSize = 128 * 1024
+
+// copyBufferPool reuses copy buffers of one size
+type copyBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newCopyBufferPool(size int) *copyBufferPool {
+	p := &copyBufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// copyBuffers is the process-wide pool, swapped as a whole when the size changes
+var copyBuffers atomic.Pointer[copyBufferPool]
+
+func init() {
+	copyBuffers.Store(newCopyBufferPool(DefaultCopyBufferSize))
+}
+
+// SetCopyBufferSize sets the buffer size CopyBuffer streams artifact content with, for blob reads
+// and writes alike (<= 0 = DefaultCopyBufferSize). Larger buffers mean fewer syscalls per blob.
+func SetCopyBufferSize(size int) {
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+	if copyBuffers.Load().size != size {
+		copyBuffers.Store(newCopyBufferPool(size))
+	}
+}
+
+// CopyBufferSize returns the buffer size CopyBuffer uses
+func CopyBufferSize() int {
+	return copyBuffers.Load().size
+}
+
+// writerOnly and readerOnly hide io.ReaderFrom and io.WriterTo, which would copy with their own buffer
+type writerOnly struct {
+	io.Writer
+}
+
+type readerOnly struct {
+	io.Reader
+}
+
+// CopyBuffer copies src to dst like io.Copy, through a pooled buffer of CopyBufferSize bytes
+func CopyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	pool := copyBuffers.Load()
+	buf := pool.pool.Get().(*[]byte)
+	defer pool.pool.Put(buf)
+	return io.CopyBuffer(writerOnly{dst}, readerOnly{src}, *buf)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// TestCopyBufferSizes tests that content written and read through storage is intact whatever the
+// copy buffer size, including sizes smaller than, equal to and not dividing the content length
+func TestCopyBufferSizes(t *testing.T) {
+	t.Cleanup(func() { SetCopyBufferSize(0) })
+	data := make([]byte, 300*1024+7)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, size := range []int{1, 4096, 32 * 1024, DefaultCopyBufferSize, len(data), 1 << 20} {
+		t.Run(fmt.Sprintf("size-%d", size), func(t *testing.T) {
+			SetCopyBufferSize(size)
+			if got := CopyBufferSize(); got != size {
+				t.Fatalf("Expected buffer size %d, got %d", size, got)
+			}
+
+			storage, err := NewSimpleFileStorage("copy-buffer-storage", t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			ctx := context.Background()
+			if _, err := storage.Create(ctx, "abc123", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: "abc123", Range: models.ByteRange{Offset: 0, Length: -1}})
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			defer rc.Close()
+
+			var out bytes.Buffer
+			written, err := CopyBuffer(&out, rc)
+			if err != nil {
+				t.Fatalf("CopyBuffer failed: %v", err)
+			}
+			if written != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+				t.Errorf("Content mismatch: copied %d of %d bytes", written, len(data))
+			}
+		})
+	}
+
+	SetCopyBufferSize(-1)
+	if got := CopyBufferSize(); got != DefaultCopyBufferSize {
+		t.Errorf("Expected a non-positive size to restore the default, got %d", got)
+	}
+}
+
+// BenchmarkCopyBuffer measures streaming a blob file with different copy buffer sizes
+// (32KB is io.Copy's buffer)
+func BenchmarkCopyBuffer(b *testing.B) {
+	b.Cleanup(func() { SetCopyBufferSize(0) })
+	path := filepath.Join(b.TempDir(), "blob")
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{32 * 1024, DefaultCopyBufferSize, 256 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			SetCopyBufferSize(size)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := CopyBuffer(io.Discard, f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
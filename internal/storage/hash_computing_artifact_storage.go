@@ -3,8 +3,8 @@ package storage
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -21,10 +21,61 @@ type MoveStorage interface {
 	Move(ctx context.Context, srcHash, destHash string) error
 }
 
+// MoveIfAbsentStorage is an optional interface for storage backends that can move an artifact only
+// if the destination doesn't exist yet, failing with ErrDestinationExists otherwise. The check and
+// the move are atomic only when writes to the destination are serialized, as under
+// ConcurrentArtifactStorage's per-hash lock.
+type MoveIfAbsentStorage interface {
+	MoveIfAbsent(ctx context.Context, srcHash, destHash string) error
+}
+
+// ErrDestinationExists is returned by MoveIfAbsent when the destination artifact already exists
+var ErrDestinationExists = errors.New("destination artifact already exists")
+
+// TruncateStorage is an optional interface for storage backends that can shrink (or extend) an artifact.
+type TruncateStorage interface {
+	Truncate(ctx context.Context, hash string, size int64) error
+}
+
+// ReindexStorage is an optional interface for enumerable storage backends that can rebuild lost metadata.
+type ReindexStorage interface {
+	Reindex(ctx context.Context) (*ReindexReport, error)
+}
+
+// EnumerableStorage is an optional interface for storage backends that can iterate their artifacts.
+type EnumerableStorage interface {
+	Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error
+}
+
+// ExistsStorage is an optional interface for storage backends that can check for an artifact's data
+// and metadata without reading them.
+type ExistsStorage interface {
+	Exists(ctx context.Context, hash string) (artifactExists, metaExists bool, err error)
+}
+
+// SizeStorage is an optional interface for storage backends that can report the data length of a
+// complete artifact (data and metadata present) without decoding its metadata.
+type SizeStorage interface {
+	Size(ctx context.Context, hash string) (size int64, exists bool, err error)
+}
+
+// CASStorage is an optional interface for storage backends that can replace metadata only if it is
+// unchanged since it was read, so read-modify-write updates can retry instead of losing concurrent ones.
+type CASStorage interface {
+	CompareAndSwapMeta(ctx context.Context, expected, updated models.ArtifactMeta) (bool, error)
+}
+
+// RedirectStorage is an optional interface for storage backends that can serve artifacts directly,
+// e.g. through presigned URLs. ok is false when the artifact can't be redirected to.
+type RedirectStorage interface {
+	RedirectURL(ctx context.Context, hash string) (url string, ok bool)
+}
+
 // HashComputingArtifactStorage wraps an ArtifactStorage implementation to automatically
 // compute SHA-256 hashes when the hash is unknown (empty, length<3, or "UNKNOWN").
 type HashComputingArtifactStorage struct {
 	storage models.ArtifactStorage
+	limiter *HashLimiter // Caps concurrent hash computations (nil = unlimited)
 }
 
 // NewHashComputingArtifactStorage creates a new HashComputingArtifactStorage wrapper.
@@ -37,6 +88,16 @@ func NewHashComputingArtifactStorage(storage models.ArtifactStorage) *HashComput
 	}
 }
 
+// SetHashLimiter caps the number of concurrent hash computations (nil = unlimited)
+func (h *HashComputingArtifactStorage) SetHashLimiter(limiter *HashLimiter) {
+	h.limiter = limiter
+}
+
+// HashLimiter returns the limiter capping concurrent hash computations
+func (h *HashComputingArtifactStorage) HashLimiter() *HashLimiter {
+	return h.limiter
+}
+
 // Alias returns the alias/name of the storage by delegating to the wrapped storage.
 func (h *HashComputingArtifactStorage) Alias() string {
 	return h.storage.Alias()
@@ -52,10 +113,18 @@ func (h *HashComputingArtifactStorage) isUnknownHash(hash string) bool {
 	return strings.EqualFold(hash, "UNKNOWN")
 }
 
+// TempHashPrefix starts the temporary hashes unknown-hash artifacts are stored under until their hash is computed
+const TempHashPrefix = "temp-"
+
+// IsTempHash reports whether hash is a temporary hash generated by HashComputingArtifactStorage
+func IsTempHash(hash string) bool {
+	return strings.HasPrefix(hash, TempHashPrefix)
+}
+
 // generateTempHash generates a temporary UUID-based hash for initial storage.
 func (h *HashComputingArtifactStorage) generateTempHash() string {
 	id := uuid.New()
-	return "temp-" + id.String()
+	return TempHashPrefix + id.String()
 }
 
 // cleanupTempHash removes a temporary artifact by adding a cleanup reference and then deleting it.
@@ -67,18 +136,17 @@ func (h *HashComputingArtifactStorage) cleanupTempHash(ctx context.Context, temp
 		return nil
 	}
 
-	// If no references exist, add a temporary one so we can delete it
-	if len(tempMeta.References) == 0 {
-		tempRef := models.ArtifactReference{
-			Name:                "temp-cleanup",
-			Repo:                "temp-cleanup",
-			ReferencedTimestamp: time.Now().Unix(),
-		}
-		tempMeta.References = []models.ArtifactReference{tempRef}
-		_, err = h.storage.UpdateMeta(ctx, *tempMeta)
-		if err != nil {
-			return fmt.Errorf("failed to add cleanup reference: %w", err)
-		}
+	// Replace the references (copied from the caller's metadata, which now live on the
+	// final hash) with a temporary one so deleting it trashes the artifact
+	tempRef := models.ArtifactReference{
+		Name:                "temp-cleanup",
+		Repo:                "temp-cleanup",
+		ReferencedTimestamp: time.Now().Unix(),
+	}
+	tempMeta.References = []models.ArtifactReference{tempRef}
+	_, err = h.storage.UpdateMeta(ctx, *tempMeta)
+	if err != nil {
+		return fmt.Errorf("failed to add cleanup reference: %w", err)
 	}
 
 	// Find and delete the cleanup reference
@@ -177,17 +245,22 @@ func (h *HashComputingArtifactStorage) Create(ctx context.Context, hash string,
 		return h.storage.Create(ctx, hash, r, size, meta)
 	}
 
-	// Unknown hash: compute it using temp storage approach
+	// Unknown hash: wait for a hashing slot, then compute it using temp storage approach
+	if err := h.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
 	tempHash := h.generateTempHash()
 
-	// Create SHA-256 hasher
-	hasher := sha256.New()
+	// Take a pooled SHA-256 hasher
+	hasher := AcquireSHA256()
+	defer ReleaseSHA256(hasher)
 
 	// Use TeeReader to compute hash while streaming to storage
 	teeReader := io.TeeReader(r, hasher)
 
 	// Create artifact with temp hash (this streams the data)
 	tempMeta, err := h.storage.Create(ctx, tempHash, teeReader, size, meta)
+	h.limiter.Release()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create with temp hash: %w", err)
 	}
@@ -230,3 +303,83 @@ func (h *HashComputingArtifactStorage) GetMeta(ctx context.Context, hash string)
 func (h *HashComputingArtifactStorage) UpdateMeta(ctx context.Context, meta models.ArtifactMeta) (*models.ArtifactMeta, error) {
 	return h.storage.UpdateMeta(ctx, meta)
 }
+
+// Walk iterates the metadata of all artifacts in the underlying storage.
+func (h *HashComputingArtifactStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	enumerable, ok := h.storage.(EnumerableStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Walk method")
+	}
+	return enumerable.Walk(ctx, fn)
+}
+
+// Exists delegates to the underlying storage, falling back to GetMeta if it doesn't implement ExistsStorage.
+func (h *HashComputingArtifactStorage) Exists(ctx context.Context, hash string) (bool, bool, error) {
+	return existsIn(ctx, h.storage, hash)
+}
+
+// Size delegates to the underlying storage, falling back to GetMeta if it doesn't implement SizeStorage.
+func (h *HashComputingArtifactStorage) Size(ctx context.Context, hash string) (int64, bool, error) {
+	return sizeIn(ctx, h.storage, hash)
+}
+
+// MoveIfAbsent delegates to the underlying storage (emulated if it doesn't implement MoveIfAbsentStorage).
+func (h *HashComputingArtifactStorage) MoveIfAbsent(ctx context.Context, srcHash, destHash string) error {
+	return moveIfAbsentIn(ctx, h.storage, srcHash, destHash)
+}
+
+// RedirectURL delegates to the underlying storage if it implements RedirectStorage.
+func (h *HashComputingArtifactStorage) RedirectURL(ctx context.Context, hash string) (string, bool) {
+	redirect, ok := h.storage.(RedirectStorage)
+	if !ok {
+		return "", false
+	}
+	return redirect.RedirectURL(ctx, hash)
+}
+
+// CompareAndSwapMeta delegates to the underlying storage (emulated if it doesn't implement CASStorage).
+func (h *HashComputingArtifactStorage) CompareAndSwapMeta(ctx context.Context, expected, updated models.ArtifactMeta) (bool, error) {
+	return compareAndSwapMeta(ctx, h.storage, expected, updated)
+}
+
+// existsIn checks for an artifact with ExistsStorage when supported, else with GetMeta
+// (which can only report data and metadata together)
+func existsIn(ctx context.Context, storage models.ArtifactStorage, hash string) (bool, bool, error) {
+	if exists, ok := storage.(ExistsStorage); ok {
+		return exists.Exists(ctx, hash)
+	}
+	if _, err := storage.GetMeta(ctx, hash); err != nil {
+		return false, false, nil
+	}
+	return true, true, nil
+}
+
+// moveIfAbsentIn moves an artifact with MoveIfAbsentStorage when supported, else checks the
+// destination with existsIn and moves with MoveStorage
+func moveIfAbsentIn(ctx context.Context, storage models.ArtifactStorage, srcHash, destHash string) error {
+	if mover, ok := storage.(MoveIfAbsentStorage); ok {
+		return mover.MoveIfAbsent(ctx, srcHash, destHash)
+	}
+	moveStorage, ok := storage.(MoveStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Move method")
+	}
+	if artifactExists, metaExists, err := existsIn(ctx, storage, destHash); err != nil {
+		return err
+	} else if artifactExists || metaExists {
+		return ErrDestinationExists
+	}
+	return moveStorage.Move(ctx, srcHash, destHash)
+}
+
+// sizeIn reports the data length of an artifact with SizeStorage when supported, else with GetMeta
+func sizeIn(ctx context.Context, storage models.ArtifactStorage, hash string) (int64, bool, error) {
+	if sizer, ok := storage.(SizeStorage); ok {
+		return sizer.Size(ctx, hash)
+	}
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		return 0, false, nil
+	}
+	return meta.Length, true, nil
+}
@@ -529,3 +529,52 @@ func TestHashComputingArtifactStorageWithMetadata(t *testing.T) {
 		t.Errorf("Expected reference name ref1, got %s", createdMeta.References[0].Name)
 	}
 }
+
+// slowReader delays every read, keeping a hashing operation busy for a while
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	if len(p) > 4096 {
+		p = p[:4096]
+	}
+	return s.r.Read(p)
+}
+
+// TestHashComputingArtifactStorageHashLimiter tests that concurrent hashing never exceeds the limiter size
+func TestHashComputingArtifactStorageHashLimiter(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	wrapper := NewHashComputingArtifactStorage(storage)
+	limiter := NewHashLimiter(2)
+	wrapper.SetHashLimiter(limiter)
+	ctx := context.Background()
+
+	const numUploads = 8
+	errs := make(chan error, numUploads)
+	for i := 0; i < numUploads; i++ {
+		go func(i int) {
+			data := bytes.Repeat([]byte{byte(i)}, 64*1024)
+			reader := &slowReader{r: bytes.NewReader(data), delay: time.Millisecond}
+			_, err := wrapper.Create(ctx, "", reader, int64(len(data)), nil)
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < numUploads; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Concurrent create error: %v", err)
+		}
+	}
+
+	if peak := limiter.Peak(); peak < 1 || peak > 2 {
+		t.Errorf("Expected peak hashing concurrency between 1 and 2, got %d", peak)
+	}
+	if active := limiter.Active(); active != 0 {
+		t.Errorf("Expected no active hashing after uploads finished, got %d", active)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// HashLimiter caps the number of concurrent digest computations.
+// Hashing is streamed, so a slot is held for the whole hashed transfer; excess work
+// waits for a free slot. All methods are safe to call on a nil limiter (no limit).
+type HashLimiter struct {
+	slots  chan struct{}
+	active atomic.Int64
+	peak   atomic.Int64
+}
+
+// NewHashLimiter creates a limiter allowing size concurrent hashing operations.
+// Returns nil if size <= 0 (unlimited).
+func NewHashLimiter(size int) *HashLimiter {
+	if size <= 0 {
+		return nil
+	}
+	return &HashLimiter{slots: make(chan struct{}, size)}
+}
+
+// Acquire waits for a free hashing slot or until ctx is done
+func (l *HashLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	active := l.active.Add(1)
+	for {
+		peak := l.peak.Load()
+		if active <= peak || l.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release frees a slot taken by Acquire
+func (l *HashLimiter) Release() {
+	if l == nil {
+		return
+	}
+	l.active.Add(-1)
+	<-l.slots
+}
+
+// Size returns the maximum number of concurrent hashing operations (0 means unlimited)
+func (l *HashLimiter) Size() int {
+	if l == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
+
+// Active returns the number of hashing operations currently running
+func (l *HashLimiter) Active() int {
+	if l == nil {
+		return 0
+	}
+	return int(l.active.Load())
+}
+
+// Peak returns the highest number of concurrent hashing operations observed
+func (l *HashLimiter) Peak() int {
+	if l == nil {
+		return 0
+	}
+	return int(l.peak.Load())
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"hash"
+	"sync"
+)
+
+// sha256Hashers reuses SHA-256 hashers across uploads and integrity checks
+var sha256Hashers = sync.Pool{
+	New: func() any { return sha256.New() },
+}
+
+// AcquireSHA256 returns a reset SHA-256 hasher from the pool.
+// Hand it back with ReleaseSHA256 once its sum has been taken and nothing writes to it anymore.
+func AcquireSHA256() hash.Hash {
+	return sha256Hashers.Get().(hash.Hash)
+}
+
+// ReleaseSHA256 resets a hasher from AcquireSHA256 and returns it to the pool (nil is ignored)
+func ReleaseSHA256(hasher hash.Hash) {
+	if hasher == nil {
+		return
+	}
+	hasher.Reset()
+	sha256Hashers.Put(hasher)
+}
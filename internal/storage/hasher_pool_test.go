@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"testing"
+)
+
+// TestSHA256PoolReset tests that pooled hashers produce correct digests after being reused,
+// including concurrently
+func TestSHA256PoolReset(t *testing.T) {
+	data := []byte("pooled hasher content")
+	want := sha256.Sum256(data)
+
+	dirty := AcquireSHA256()
+	dirty.Write([]byte("leftover state from a previous upload"))
+	ReleaseSHA256(dirty)
+	ReleaseSHA256(nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hasher := AcquireSHA256()
+				hasher.Write(data)
+				sum := hasher.Sum(nil)
+				// Leave some hashers dirty: Release must reset them
+				hasher.Write([]byte(fmt.Sprintf("%d-%d", i, j)))
+				ReleaseSHA256(hasher)
+				if !bytes.Equal(sum, want[:]) {
+					errs <- fmt.Errorf("digest mismatch: got %x, want %x", sum, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkSHA256Hasher compares allocating a hasher per upload with taking one from the pool,
+// hashing through a TeeReader as uploads do
+func BenchmarkSHA256Hasher(b *testing.B) {
+	data := make([]byte, 4096)
+	hashUpload := func(hasher hash.Hash) []byte {
+		io.Copy(io.Discard, io.TeeReader(bytes.NewReader(data), hasher))
+		return hasher.Sum(nil)
+	}
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hashUpload(sha256.New())
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			hasher := AcquireSHA256()
+			hashUpload(hasher)
+			ReleaseSHA256(hasher)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// ComputeContentDigest reads the whole stored artifact and returns its "sha256:<hex>" digest
+func ComputeContentDigest(ctx context.Context, storage models.ArtifactStorage, hash string) (string, error) {
+	rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		return "", fmt.Errorf("failed to read artifact %s: %w", hash, err)
+	}
+	defer rc.Close()
+
+	hasher := AcquireSHA256()
+	defer ReleaseSHA256(hasher)
+	if _, err := io.Copy(hasher, rc); err != nil {
+		return "", fmt.Errorf("failed to read artifact %s: %w", hash, err)
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// VerifyIntegrity recomputes the digest of the stored artifact and compares it with the digest
+// recorded in its metadata (ContentDigest), or with the hash itself when that is a SHA-256 digest.
+// A mismatch is reported as *models.IntegrityError.
+func VerifyIntegrity(ctx context.Context, storage models.ArtifactStorage, hash string) error {
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata for %s: %w", hash, err)
+	}
+	expected := meta.ContentDigest
+	if expected == "" {
+		expected = sha256Digest(hash)
+	}
+	if expected == "" {
+		return fmt.Errorf("no content digest recorded for artifact %s", hash)
+	}
+
+	actual, err := ComputeContentDigest(ctx, storage, hash)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &models.IntegrityError{Hash: hash, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// sha256Digest normalizes a "sha256:<hex>" or bare 64-char hex key to "sha256:<hex>"; "" if it is neither
+func sha256Digest(hash string) string {
+	hexPart := strings.TrimPrefix(hash, "sha256:")
+	if len(hexPart) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return ""
+	}
+	return "sha256:" + hexPart
+}
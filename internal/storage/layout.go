@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Layout describes how SimpleFileStorage shards artifact files into directories:
+// Depth directory levels of Width hash characters each, followed by the rest of the hash
+// as the file name. Hashes too short to fill every level use fewer levels, always leaving
+// at least one character for the file name.
+type Layout struct {
+	Depth int
+	Width int
+}
+
+// DefaultLayout is the git-like "<hash[:2]>/<hash[2:]>" layout
+var DefaultLayout = Layout{Depth: 1, Width: 2}
+
+// Validate checks that the layout can shard hashes
+func (l Layout) Validate() error {
+	if l.Depth < 0 {
+		return fmt.Errorf("invalid layout depth: %d", l.Depth)
+	}
+	if l.Depth > 0 && l.Width <= 0 {
+		return fmt.Errorf("invalid layout width: %d", l.Width)
+	}
+	return nil
+}
+
+// levels returns the number of directory levels used for hash
+func (l Layout) levels(hash string) int {
+	if l.Depth == 0 || len(hash) <= l.Width {
+		return 0
+	}
+	return min(l.Depth, (len(hash)-1)/l.Width)
+}
+
+// path returns the artifact file path of hash under root
+func (l Layout) path(root, hash string) string {
+	parts := []string{root}
+	rest := hash
+	for i := 0; i < l.levels(hash); i++ {
+		parts = append(parts, rest[:l.Width])
+		rest = rest[l.Width:]
+	}
+	return filepath.Join(append(parts, rest)...)
+}
+
+// hashFromPath reverses path; ok is false if path is not an artifact file of this layout
+func (l Layout) hashFromPath(root, path string) (string, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if len(dir) != l.Width {
+			return "", false
+		}
+	}
+	hash := strings.Join(parts, "")
+	if l.levels(hash) != len(parts)-1 {
+		return "", false
+	}
+	return hash, true
+}
+
+// maxPrewarmChars bounds the hash characters sharded into directories that Prewarm accepts
+// (16^4 = 65536 leaf directories)
+const maxPrewarmChars = 4
+
+// shardPrefixes returns every hex prefix of n characters
+func shardPrefixes(n int) []string {
+	prefixes := []string{""}
+	for i := 0; i < n; i++ {
+		next := make([]string, 0, len(prefixes)*16)
+		for _, prefix := range prefixes {
+			for _, c := range "0123456789abcdef" {
+				next = append(next, prefix+string(c))
+			}
+		}
+		prefixes = next
+	}
+	return prefixes
+}
+
+// Prewarm creates every shard directory of the layout for hex hashes up front (the 256 "00".."ff"
+// directories with DefaultLayout), so the first artifact of a shard doesn't pay for creating it.
+// Layouts sharding more than maxPrewarmChars characters are refused.
+func (s *SimpleFileStorage) Prewarm() error {
+	chars := s.layout.Depth * s.layout.Width
+	if chars > maxPrewarmChars {
+		return fmt.Errorf("layout shards %d hash characters, too many directories to prewarm (max %d)", chars, maxPrewarmChars)
+	}
+	// One more character for the file name, so the path uses every level
+	for _, prefix := range shardPrefixes(chars) {
+		dir := filepath.Dir(s.layout.path(s.baseDir, prefix+"0"))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create shard directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to (excluding) root while they are empty
+func removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
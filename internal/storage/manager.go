@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
@@ -47,7 +50,7 @@ func GetManager() *StorageManager {
 // init registers built-in storage factory functions
 func (sm *StorageManager) init() {
 	// Register SimpleFileStorage factory
-	// Parameters: [alias, basePath]
+	// Parameters: [alias, basePath] or [alias, basePath, FileStorageOptions]
 	sm.RegisterFactory("std.filestorage", func(params ...interface{}) (models.ArtifactStorage, error) {
 		if len(params) < 2 {
 			return nil, fmt.Errorf("filestorage requires alias and basePath parameters")
@@ -60,7 +63,46 @@ func (sm *StorageManager) init() {
 		if !ok {
 			return nil, fmt.Errorf("filestorage basePath must be a string")
 		}
-		return NewSimpleFileStorage(alias, basePath)
+		storage, err := NewSimpleFileStorage(alias, basePath)
+		if err != nil {
+			return nil, err
+		}
+		// Optional third parameter: FileStorageOptions
+		if len(params) >= 3 {
+			opts, ok := params[2].(FileStorageOptions)
+			if !ok {
+				return nil, fmt.Errorf("filestorage options must be a FileStorageOptions")
+			}
+			if opts.Layout != (Layout{}) {
+				if err := opts.Layout.Validate(); err != nil {
+					return nil, fmt.Errorf("filestorage: %w", err)
+				}
+			}
+			storage.ApplyOptions(opts)
+			if opts.Prewarm {
+				if err := storage.Prewarm(); err != nil {
+					return nil, fmt.Errorf("filestorage: %w", err)
+				}
+			}
+		}
+		return storage, nil
+	})
+
+	// Register CombinedFileStorage factory
+	// Parameters: [alias, baseDir]
+	sm.RegisterFactory("combined.filestorage", func(params ...interface{}) (models.ArtifactStorage, error) {
+		if len(params) < 2 {
+			return nil, fmt.Errorf("combined.filestorage requires alias and baseDir parameters")
+		}
+		alias, ok := params[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("combined.filestorage alias must be a string")
+		}
+		baseDir, ok := params[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("combined.filestorage baseDir must be a string")
+		}
+		return NewCombinedFileStorage(alias, baseDir)
 	})
 
 	// Register ConcurrentArtifactStorage factory
@@ -101,7 +143,7 @@ func (sm *StorageManager) init() {
 	})
 
 	// Register HashComputingArtifactStorage factory
-	// Parameters: [alias, baseDir] or [alias, baseDir, lockDir, lockTimeout]
+	// Parameters: [alias, baseDir] or [alias, baseDir, lockDir, lockTimeout], optionally followed by a *HashLimiter
 	// If 2 parameters: wraps SimpleFileStorage
 	// If 4 parameters: wraps ConcurrentArtifactStorage
 	sm.RegisterFactory("hashcomputing.filestorage", func(params ...interface{}) (models.ArtifactStorage, error) {
@@ -109,6 +151,13 @@ func (sm *StorageManager) init() {
 			return nil, fmt.Errorf("hashcomputing.filestorage requires at least alias and baseDir parameters")
 		}
 
+		// Optional trailing parameter: *HashLimiter
+		var limiter *HashLimiter
+		if l, ok := params[len(params)-1].(*HashLimiter); ok {
+			limiter = l
+			params = params[:len(params)-1]
+		}
+
 		alias, ok := params[0].(string)
 		if !ok {
 			return nil, fmt.Errorf("hashcomputing.filestorage alias must be a string")
@@ -156,7 +205,9 @@ func (sm *StorageManager) init() {
 		}
 
 		// Wrap with HashComputingArtifactStorage (alias is already set on innermost storage)
-		return NewHashComputingArtifactStorage(underlyingStorage), nil
+		hashStorage := NewHashComputingArtifactStorage(underlyingStorage)
+		hashStorage.SetHashLimiter(limiter)
+		return hashStorage, nil
 	})
 }
 
@@ -193,7 +244,9 @@ func (sm *StorageManager) RegisterFactory(className string, factory func(...inte
 }
 
 // Create creates a new storage instance with the given class name and alias
-// The alias must be a valid DNS name (lowercase). The params are passed to the factory function.
+// The alias must be a valid DNS name (lowercase). The params are passed to the factory function,
+// except a trailing WriteConcurrency, which wraps the storage with WriteLimitedArtifactStorage
+// (inside a HashComputingArtifactStorage, so the instance keeps its type).
 func (sm *StorageManager) Create(className, alias string, params ...interface{}) (models.ArtifactStorage, error) {
 	// Validate alias is valid DNS name
 	if !isValidDNSName(alias) {
@@ -207,18 +260,61 @@ func (sm *StorageManager) Create(className, alias string, params ...interface{})
 	if _, exists := sm.storages[alias]; exists {
 		return nil, fmt.Errorf("storage alias already exists: %s", alias)
 	}
+	return sm.create(className, alias, params)
+}
+
+// GetOrCreate returns the storage registered as alias, creating it like Create if it doesn't exist.
+// It is safe to call concurrently and repeatedly with the same arguments (e.g. from tests sharing
+// the singleton manager); an existing alias whose class or params (as recorded for SaveToConfig)
+// differ from the requested ones is an error rather than silently reused.
+func (sm *StorageManager) GetOrCreate(className, alias string, params ...interface{}) (models.ArtifactStorage, error) {
+	if !isValidDNSName(alias) {
+		return nil, fmt.Errorf("invalid DNS name for alias: %s", alias)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
+	if existing, exists := sm.storages[alias]; exists {
+		cfg := sm.configs[alias]
+		if cfg == nil || cfg.Class != className {
+			return nil, fmt.Errorf("storage alias %s already exists with a different class", alias)
+		}
+		if want := sm.storageParams(className, params); !reflect.DeepEqual(cfg.Params, want) {
+			return nil, fmt.Errorf("storage alias %s already exists with different params: %v, requested %v", alias, cfg.Params, want)
+		}
+		return existing, nil
+	}
+	return sm.create(className, alias, params)
+}
+
+// create instantiates and registers storage alias; the caller holds sm.mu and checked the alias is free
+func (sm *StorageManager) create(className, alias string, params []interface{}) (models.ArtifactStorage, error) {
 	// Look up factory
 	factory, exists := sm.factories[className]
 	if !exists {
 		return nil, fmt.Errorf("storage class not found: %s", className)
 	}
+	recorded := sm.storageParams(className, params)
+
+	var writeLimit WriteConcurrency
+	if len(params) > 0 {
+		if limit, ok := params[len(params)-1].(WriteConcurrency); ok {
+			writeLimit = limit
+			params = params[:len(params)-1]
+		}
+	}
 
 	// Create storage instance (pass alias as first parameter)
 	storage, err := factory(append([]interface{}{alias}, params...)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage instance: %w", err)
 	}
+	if writeLimit > 0 {
+		if storage, err = withWriteLimit(storage, int(writeLimit)); err != nil {
+			return nil, fmt.Errorf("failed to create storage instance: %w", err)
+		}
+	}
 
 	// Store instance
 	sm.storages[alias] = storage
@@ -227,12 +323,41 @@ func (sm *StorageManager) Create(className, alias string, params ...interface{})
 	sm.configs[alias] = &StorageConfig{
 		Class:  className,
 		Alias:  alias,
-		Params: sm.extractParams(className, params),
+		Params: recorded,
 	}
 
 	return storage, nil
 }
 
+// storageParams returns the config params Create records for className and params
+func (sm *StorageManager) storageParams(className string, params []interface{}) map[string]interface{} {
+	var writeLimit WriteConcurrency
+	if len(params) > 0 {
+		if limit, ok := params[len(params)-1].(WriteConcurrency); ok {
+			writeLimit = limit
+			params = params[:len(params)-1]
+		}
+	}
+	result := sm.extractParams(className, params)
+	if writeLimit > 0 {
+		result["writeConcurrency"] = int(writeLimit)
+	}
+	return result
+}
+
+// withWriteLimit caps concurrent writes of storage, limiting the storage a HashComputingArtifactStorage wraps
+func withWriteLimit(storage models.ArtifactStorage, limit int) (models.ArtifactStorage, error) {
+	if hashStorage, ok := storage.(*HashComputingArtifactStorage); ok {
+		limited, err := NewWriteLimitedArtifactStorage(hashStorage.storage, limit)
+		if err != nil {
+			return nil, err
+		}
+		hashStorage.storage = limited
+		return hashStorage, nil
+	}
+	return NewWriteLimitedArtifactStorage(storage, limit)
+}
+
 // extractParams extracts configuration parameters based on storage class
 // Note: params here are the parameters passed to Create (not including alias)
 func (sm *StorageManager) extractParams(className string, params []interface{}) map[string]interface{} {
@@ -247,6 +372,40 @@ func (sm *StorageManager) extractParams(className string, params []interface{})
 				result["basePath"] = basePath
 			}
 		}
+		if len(params) >= 2 {
+			if opts, ok := params[1].(FileStorageOptions); ok {
+				if opts.VerifyUnknownSize {
+					result["verifyUnknownSize"] = true
+				}
+				if opts.StrictMetadata {
+					result["strictMetadata"] = true
+				}
+				if opts.Reflink {
+					result["reflink"] = true
+				}
+				if opts.Prewarm {
+					result["prewarm"] = true
+				}
+				if opts.MaxWriteLength > 0 {
+					result["maxWriteLength"] = opts.MaxWriteLength
+				}
+				if opts.Layout != (Layout{}) && opts.Layout != DefaultLayout {
+					result["shardDepth"] = opts.Layout.Depth
+					result["shardWidth"] = opts.Layout.Width
+				}
+				if opts.Sync != "" && opts.Sync != DefaultSyncPolicy {
+					result["sync"] = string(opts.Sync)
+				}
+			}
+		}
+	case "combined.filestorage":
+		// Factory receives: [alias, baseDir]
+		// params passed to Create: [baseDir]
+		if len(params) >= 1 {
+			if baseDir, ok := params[0].(string); ok {
+				result["baseDir"] = baseDir
+			}
+		}
 	case "concurrent.filestorage":
 		// Factory receives: [alias, baseDir, lockDir, lockTimeout]
 		// params passed to Create: [baseDir, lockDir, lockTimeout]
@@ -277,6 +436,11 @@ func (sm *StorageManager) extractParams(className string, params []interface{})
 				result["lockTimeout"] = lockTimeout.String()
 			}
 		}
+		if len(params) >= 2 {
+			if limiter, ok := params[len(params)-1].(*HashLimiter); ok && limiter.Size() > 0 {
+				result["hashConcurrency"] = limiter.Size()
+			}
+		}
 	}
 
 	return result
@@ -300,6 +464,15 @@ func (sm *StorageManager) SaveToConfig() map[string]interface{} {
 
 // LoadFromConfig creates storage instances from configuration
 func (sm *StorageManager) LoadFromConfig(cfg *config.Config) error {
+	// copyBufferSize (bytes) is the buffer blob content is streamed with (0 = DefaultCopyBufferSize)
+	if cfg.Exists("copyBufferSize") {
+		size := cfg.GetInt("copyBufferSize")
+		if size < 0 {
+			return fmt.Errorf("invalid copyBufferSize: %d", size)
+		}
+		SetCopyBufferSize(size)
+	}
+
 	storagesConfig := cfg.GetSubConfig("storages")
 	if storagesConfig == nil {
 		return nil // No storages configured
@@ -317,6 +490,7 @@ func (sm *StorageManager) LoadFromConfig(cfg *config.Config) error {
 		// Extract parameters based on class
 		paramsConfig := storageConfig.GetSubConfig("params")
 		var params []interface{}
+		recoveryAge := DefaultTempRecoveryAge
 
 		switch className {
 		case "std.filestorage":
@@ -325,6 +499,20 @@ func (sm *StorageManager) LoadFromConfig(cfg *config.Config) error {
 				return fmt.Errorf("storage %s: basePath is required", alias)
 			}
 			params = []interface{}{basePath}
+			opts, err := loadFileStorageOptions(paramsConfig)
+			if err != nil {
+				return fmt.Errorf("storage %s: %w", alias, err)
+			}
+			if opts != (FileStorageOptions{}) {
+				params = append(params, opts)
+			}
+
+		case "combined.filestorage":
+			baseDir := paramsConfig.GetString("baseDir")
+			if baseDir == "" {
+				return fmt.Errorf("storage %s: baseDir is required", alias)
+			}
+			params = []interface{}{baseDir}
 
 		case "concurrent.filestorage":
 			baseDir := paramsConfig.GetString("baseDir")
@@ -355,21 +543,100 @@ func (sm *StorageManager) LoadFromConfig(cfg *config.Config) error {
 			} else {
 				params = []interface{}{baseDir}
 			}
+			// hashConcurrency caps concurrent hash computations (0 = unlimited)
+			if limiter := NewHashLimiter(paramsConfig.GetInt("hashConcurrency")); limiter != nil {
+				params = append(params, limiter)
+			}
+			// tempRecoveryAge is the age after which orphaned temp artifacts are reconciled on load
+			if value := paramsConfig.GetString("tempRecoveryAge"); value != "" {
+				var err error
+				if recoveryAge, err = time.ParseDuration(value); err != nil {
+					return fmt.Errorf("storage %s: invalid tempRecoveryAge: %w", alias, err)
+				}
+			}
 
 		default:
 			return fmt.Errorf("storage %s: unknown class %s", alias, className)
 		}
+		// writeConcurrency caps concurrent write operations; excess writers queue (0 = unlimited)
+		if limit := paramsConfig.GetInt("writeConcurrency"); limit > 0 {
+			params = append(params, WriteConcurrency(limit))
+		}
 
 		// Create storage instance
-		_, err := sm.Create(className, alias, params...)
+		instance, err := sm.Create(className, alias, params...)
 		if err != nil {
 			return fmt.Errorf("failed to create storage %s: %w", alias, err)
 		}
+
+		// Reconcile temp artifacts left behind by a crash
+		if hashStorage, ok := instance.(*HashComputingArtifactStorage); ok {
+			if _, err := hashStorage.RecoverTempArtifacts(context.Background(), recoveryAge); err != nil {
+				return fmt.Errorf("storage %s: failed to recover temp artifacts: %w", alias, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// loadFileStorageOptions reads optional SimpleFileStorage flags from the params configuration
+func loadFileStorageOptions(paramsConfig *config.Config) (FileStorageOptions, error) {
+	var opts FileStorageOptions
+	flags := []struct {
+		key    string
+		target *bool
+	}{
+		{"verifyUnknownSize", &opts.VerifyUnknownSize},
+		{"strictMetadata", &opts.StrictMetadata},
+		{"reflink", &opts.Reflink},
+		{"prewarm", &opts.Prewarm},
+	}
+	for _, flag := range flags {
+		if !paramsConfig.Exists(flag.key) {
+			continue
+		}
+		value, err := strconv.ParseBool(paramsConfig.GetString(flag.key))
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", flag.key, err)
+		}
+		*flag.target = value
+	}
+
+	// shardDepth/shardWidth select the directory layout (default: DefaultLayout)
+	if paramsConfig.Exists("shardDepth") || paramsConfig.Exists("shardWidth") {
+		opts.Layout = DefaultLayout
+		if paramsConfig.Exists("shardDepth") {
+			opts.Layout.Depth = paramsConfig.GetInt("shardDepth")
+		}
+		if paramsConfig.Exists("shardWidth") {
+			opts.Layout.Width = paramsConfig.GetInt("shardWidth")
+		}
+		if err := opts.Layout.Validate(); err != nil {
+			return opts, err
+		}
+	}
+
+	// maxWriteLength caps the bytes of writes without a length (default: unbounded)
+	if paramsConfig.Exists("maxWriteLength") {
+		limit := paramsConfig.GetInt("maxWriteLength")
+		if limit < 0 {
+			return opts, fmt.Errorf("invalid maxWriteLength: %d", limit)
+		}
+		opts.MaxWriteLength = int64(limit)
+	}
+
+	// sync selects how writes are flushed (default: DefaultSyncPolicy)
+	if paramsConfig.Exists("sync") {
+		policy, err := ParseSyncPolicy(paramsConfig.GetString("sync"))
+		if err != nil {
+			return opts, fmt.Errorf("invalid sync: %w", err)
+		}
+		opts.Sync = policy
+	}
+	return opts, nil
+}
+
 // Get retrieves a storage instance by alias
 func (sm *StorageManager) Get(alias string) (models.ArtifactStorage, error) {
 	sm.mu.RLock()
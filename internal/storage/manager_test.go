@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -59,6 +60,49 @@ func TestStorageManagerCreate(t *testing.T) {
 	})
 }
 
+// TestStorageManagerGetOrCreate tests that GetOrCreate registers an alias once and returns the same
+// instance on repeated and concurrent calls, rejecting a different class or params
+func TestStorageManagerGetOrCreate(t *testing.T) {
+	manager := GetManager()
+	baseDir := t.TempDir()
+	alias := "get-or-create-test"
+
+	first, err := manager.GetOrCreate("std.filestorage", alias, baseDir)
+	if err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	instances := make([]models.ArtifactStorage, 8)
+	errs := make([]error, 8)
+	for i := range instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instances[i], errs[i] = manager.GetOrCreate("std.filestorage", alias, baseDir)
+		}(i)
+	}
+	wg.Wait()
+	for i, instance := range instances {
+		if errs[i] != nil || instance != first {
+			t.Errorf("Expected call %d to return the registered instance, got %p, %v", i, instance, errs[i])
+		}
+	}
+	if registered, err := manager.Get(alias); err != nil || registered != first {
+		t.Errorf("Expected Get to return the same instance, got %p, %v", registered, err)
+	}
+
+	if _, err := manager.GetOrCreate("std.filestorage", alias, t.TempDir()); err == nil {
+		t.Error("Expected an error for the alias with a different baseDir")
+	}
+	if _, err := manager.GetOrCreate("combined.filestorage", alias, baseDir); err == nil {
+		t.Error("Expected an error for the alias with a different class")
+	}
+	if _, err := manager.GetOrCreate("std.filestorage", "Invalid-Alias", baseDir); err == nil {
+		t.Error("Expected an error for an invalid DNS alias")
+	}
+}
+
 func TestStorageManagerGetManager(t *testing.T) {
 	// Test that GetManager returns a singleton
 	manager1 := GetManager()
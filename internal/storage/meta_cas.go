@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// maxModifyMetaAttempts bounds the compare-and-swap retries of ModifyMeta on a contended hash
+const maxModifyMetaAttempts = 100
+
+// ErrMetaConflict is returned by ModifyMeta when the metadata kept changing concurrently
+var ErrMetaConflict = errors.New("metadata changed concurrently")
+
+// CompareAndSwapMeta replaces the metadata of expected.Hash with updated if its generation is
+// still expected.Generation, storing updated with the next generation. It returns false, leaving
+// the metadata alone, if another write came first. The comparison and the write are atomic only
+// when writes to the hash are serialized, as under ConcurrentArtifactStorage's per-hash lock.
+func (s *SimpleFileStorage) CompareAndSwapMeta(ctx context.Context, expected, updated models.ArtifactMeta) (bool, error) {
+	if updated.Hash != expected.Hash {
+		return false, fmt.Errorf("metadata hash mismatch: expected %s, got %s", expected.Hash, updated.Hash)
+	}
+	current, err := s.GetMeta(ctx, expected.Hash)
+	if err != nil {
+		return false, err
+	}
+	if current.Generation != expected.Generation {
+		return false, nil
+	}
+
+	updated.Generation = current.Generation + 1
+	updated.Normalize()
+	_, _, metaPath := s.getPaths(updated.Hash)
+	if err := s.writeMetaFile(metaPath, &updated); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// compareAndSwapMeta is CompareAndSwapMeta on storage, emulated with GetMeta and UpdateMeta for
+// storages without CASStorage (atomic only when writes to the hash are serialized)
+func compareAndSwapMeta(ctx context.Context, storage models.ArtifactStorage, expected, updated models.ArtifactMeta) (bool, error) {
+	if cas, ok := storage.(CASStorage); ok {
+		return cas.CompareAndSwapMeta(ctx, expected, updated)
+	}
+	if updated.Hash != expected.Hash {
+		return false, fmt.Errorf("metadata hash mismatch: expected %s, got %s", expected.Hash, updated.Hash)
+	}
+	current, err := storage.GetMeta(ctx, expected.Hash)
+	if err != nil {
+		return false, err
+	}
+	if current.Generation != expected.Generation {
+		return false, nil
+	}
+	updated.Generation = current.Generation + 1
+	if _, err := storage.UpdateMeta(ctx, updated); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ModifyMeta applies modify to the metadata of hash and stores the result, rereading and retrying
+// when a concurrent write came first, so no update is lost and no lock is held while modify runs.
+// modify may run several times and must only change the metadata it is given; an error from it
+// aborts the update.
+func ModifyMeta(ctx context.Context, storage models.ArtifactStorage, hash string, modify func(meta *models.ArtifactMeta) error) (*models.ArtifactMeta, error) {
+	for attempt := 0; attempt < maxModifyMetaAttempts; attempt++ {
+		meta, err := storage.GetMeta(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		expected := models.ArtifactMeta{Hash: hash, Generation: meta.Generation}
+		if err := modify(meta); err != nil {
+			return nil, err
+		}
+		meta.Hash = hash
+
+		swapped, err := compareAndSwapMeta(ctx, storage, expected, *meta)
+		if err != nil {
+			return nil, err
+		}
+		if swapped {
+			meta.Generation = expected.Generation + 1
+			return meta, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("failed to update metadata of %s: %w", hash, ErrMetaConflict)
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// MigrationReport summarizes a Migrate run
+type MigrationReport struct {
+	Total int // Artifacts found in the source layout (including trash)
+	Moved int // Artifacts moved to the target layout so far
+}
+
+// MigrationProgress is called by Migrate after each moved artifact
+type MigrationProgress func(report MigrationReport)
+
+// Migrate moves every artifact and its metadata, including trashed ones, from the from layout
+// to the to layout, then switches the storage to the to layout. The storage must not be
+// in use while migrating.
+//
+// Each artifact is moved with renames, metadata first, so an interrupted run loses no data:
+// running Migrate again with the same layouts picks up whatever is left in the source layout,
+// and running it after completion is a no-op. progress, if not nil, is called after each move.
+func (s *SimpleFileStorage) Migrate(ctx context.Context, from, to Layout, progress MigrationProgress) (*MigrationReport, error) {
+	if err := from.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid source layout: %w", err)
+	}
+	if err := to.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid target layout: %w", err)
+	}
+	report := &MigrationReport{}
+	if from == to {
+		s.SetLayout(to)
+		return report, nil
+	}
+
+	// Collect first: moves into the target layout must not be picked up by the walk
+	roots := []string{s.baseDir, s.trashDir()}
+	pending := make(map[string][]string, len(roots))
+	for _, root := range roots {
+		seen := make(map[string]bool)
+		err := walkLayoutFiles(ctx, root, from, true, func(path, hash string) error {
+			if !seen[hash] {
+				seen[hash] = true
+				pending[root] = append(pending[root], hash)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+		report.Total += len(pending[root])
+	}
+
+	for _, root := range roots {
+		for _, hash := range pending[root] {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			if err := migrateArtifact(root, hash, from, to, s.reflink); err != nil {
+				return report, err
+			}
+			report.Moved++
+			if progress != nil {
+				progress(*report)
+			}
+		}
+	}
+
+	s.SetLayout(to)
+	return report, nil
+}
+
+// migrateArtifact moves one artifact's metadata and data file under root from one layout to another.
+// Missing source files are skipped, which makes repeated calls safe. Files on another filesystem
+// are copied (cloned with reflink) and removed.
+func migrateArtifact(root, hash string, from, to Layout, reflink bool) error {
+	srcArt := from.path(root, hash)
+	destArt := to.path(root, hash)
+	if err := os.MkdirAll(filepath.Dir(destArt), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", hash, err)
+	}
+
+	for _, suffix := range []string{".meta.json", ""} {
+		if err := moveFile(srcArt+suffix, destArt+suffix, reflink); err != nil && !os.IsNotExist(err) {
+			what := "artifact"
+			if suffix != "" {
+				what = "metadata"
+			}
+			return fmt.Errorf("failed to move %s of %s: %w", what, hash, err)
+		}
+	}
+
+	removeEmptyDirs(filepath.Dir(srcArt), root)
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// TestSimpleFileStorageMigrate tests re-sharding the default layout into a two-level layout
+func TestSimpleFileStorageMigrate(t *testing.T) {
+	baseDir := t.TempDir()
+	storage, err := NewSimpleFileStorage("test-storage", baseDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+
+	contents := map[string]string{}
+	for i := 0; i < 5; i++ {
+		hash := fmt.Sprintf("sha256:%0*d", 64, i)
+		contents[hash] = fmt.Sprintf("artifact %d", i)
+		meta := &models.ArtifactMeta{References: []models.ArtifactReference{{Name: "tag", Repo: "repo"}}}
+		if _, err := storage.Create(ctx, hash, bytes.NewReader([]byte(contents[hash])), int64(len(contents[hash])), meta); err != nil {
+			t.Fatalf("Create %s failed: %v", hash, err)
+		}
+	}
+
+	// Simulate a run interrupted after moving one artifact's metadata
+	target := Layout{Depth: 2, Width: 2}
+	interrupted := fmt.Sprintf("sha256:%0*d", 64, 0)
+	srcMeta := DefaultLayout.path(baseDir, interrupted) + ".meta.json"
+	destMeta := target.path(baseDir, interrupted) + ".meta.json"
+	if err := os.MkdirAll(filepath.Dir(destMeta), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.Rename(srcMeta, destMeta); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	var calls int
+	report, err := storage.Migrate(ctx, DefaultLayout, target, func(MigrationReport) { calls++ })
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if report.Total != 5 || report.Moved != 5 || calls != 5 {
+		t.Errorf("Expected 5 artifacts moved with 5 progress calls, got %+v and %d calls", report, calls)
+	}
+	if storage.Layout() != target {
+		t.Errorf("Expected storage to use layout %+v, got %+v", target, storage.Layout())
+	}
+
+	for hash, content := range contents {
+		if _, err := os.Stat(target.path(baseDir, hash)); err != nil {
+			t.Errorf("Expected %s in the two-level layout: %v", hash, err)
+		}
+		meta, err := storage.GetMeta(ctx, hash)
+		if err != nil || len(meta.References) != 1 {
+			t.Errorf("Expected metadata of %s after migration, got %+v, %v", hash, meta, err)
+		}
+		rc, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+		if err != nil {
+			t.Fatalf("Read %s failed: %v", hash, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != content {
+			t.Errorf("Expected %q for %s, got %q", content, hash, data)
+		}
+	}
+
+	// Re-running is a no-op
+	report, err = storage.Migrate(ctx, DefaultLayout, target, nil)
+	if err != nil || report.Moved != 0 {
+		t.Errorf("Expected idempotent re-run, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/basakil/brm-server/pkg/models"
+	"github.com/google/uuid"
+)
+
+// ProbeHash is the hash readiness probes write to. Concurrent probes share it with distinct
+// references, so at most one probe artifact (live or trashed) ever exists per storage.
+const ProbeHash = "readiness-probe"
+
+// probeRepo is the repository of the references readiness probes create
+const probeRepo = "readiness-probe"
+
+// ProbeWritable checks that storage accepts writes by creating a tiny artifact under ProbeHash
+// and deleting it again. Fails when the storage is read-only, full or otherwise unwritable.
+func ProbeWritable(ctx context.Context, storage models.ArtifactStorage) error {
+	ref := models.ArtifactReference{
+		Name:                uuid.New().String(),
+		Repo:                probeRepo,
+		ReferencedTimestamp: time.Now().Unix(),
+	}
+	data := []byte{0}
+	_, err := storage.Create(ctx, ProbeHash, bytes.NewReader(data), int64(len(data)), &models.ArtifactMeta{
+		Hash:       ProbeHash,
+		Length:     int64(len(data)),
+		References: []models.ArtifactReference{ref},
+	})
+	if err != nil {
+		return fmt.Errorf("storage %s is not writable: %w", storage.Alias(), err)
+	}
+	if _, err := storage.Delete(ctx, ProbeHash, ref); err != nil {
+		return fmt.Errorf("storage %s failed to delete probe: %w", storage.Alias(), err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errReflinkUnsupported is returned by cloneFile where the platform or filesystem can't clone files
+var errReflinkUnsupported = errors.New("reflink not supported")
+
+// copyFile copies src to dst (replacing it). With reflink set, the data is first cloned
+// copy-on-write (Btrfs, XFS, ...), falling back to a regular copy if cloning fails.
+func copyFile(src, dst string, reflink bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if reflink && cloneFile(out, in) == nil {
+		return out.Close()
+	}
+	if _, err := CopyBuffer(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// moveFile renames src to dst, falling back to copying (see copyFile) and removing src when
+// they are on different filesystems
+func moveFile(src, dst string, reflink bool) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(src, dst, reflink); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// SetReflink makes file copies (Move and Migrate across filesystems) clone data copy-on-write
+// where the filesystem supports it, so they don't use extra space. Copies fall back to regular
+// copies otherwise.
+func (s *SimpleFileStorage) SetReflink(reflink bool) {
+	s.reflink = reflink
+}
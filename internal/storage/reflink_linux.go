@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share src's data copy-on-write with the FICLONE ioctl
+func cloneFile(dst, src *os.File) error {
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	var errno unix.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case unix.EOPNOTSUPP, unix.EXDEV, unix.EINVAL, unix.ENOTTY, unix.ENOSYS:
+			return fmt.Errorf("%w: %v", errReflinkUnsupported, errno)
+		}
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// cloneFile is not supported on this platform
+func cloneFile(dst, src *os.File) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// ReindexReport summarizes a Reindex run
+type ReindexReport struct {
+	Scanned    int      // Artifact data files found
+	Repaired   int      // Metadata files regenerated
+	Unverified int      // Repaired entries whose key is not a SHA-256 digest, so content could not be verified
+	Mismatched []string // Hashes whose content does not match the key; left without metadata
+}
+
+// Reindex walks the artifact data files, regenerating minimal metadata (length from stat,
+// empty references) for every artifact missing its .meta.json. Keys that are SHA-256 digests
+// ("sha256:<hex>" or bare 64-char hex) are verified against the recomputed content hash first;
+// mismatching artifacts are reported and not repaired. The trash directory is skipped.
+func (s *SimpleFileStorage) Reindex(ctx context.Context) (*ReindexReport, error) {
+	report := &ReindexReport{}
+
+	err := s.walkArtifactFiles(ctx, func(path, hash string) error {
+		report.Scanned++
+
+		if _, _, metaPath := s.getPaths(hash); fileExists(metaPath) {
+			return nil
+		}
+
+		verified, match, err := verifyArtifactHash(path, hash)
+		if err != nil {
+			return fmt.Errorf("failed to verify artifact %s: %w", hash, err)
+		}
+		if verified && !match {
+			report.Mismatched = append(report.Mismatched, hash)
+			return nil
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat artifact %s: %w", hash, err)
+		}
+		meta := models.ArtifactMeta{
+			Hash:             hash,
+			Length:           stat.Size(),
+			CreatedTimestamp: stat.ModTime().Unix(),
+			References:       []models.ArtifactReference{},
+		}
+		if _, err := s.UpdateMeta(ctx, meta); err != nil {
+			return fmt.Errorf("failed to write metadata for %s: %w", hash, err)
+		}
+
+		report.Repaired++
+		if !verified {
+			report.Unverified++
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// walkArtifactFiles calls fn with the path and hash of every artifact data file.
+// Metadata, temp files and dot-directories (such as the trash) are skipped.
+func (s *SimpleFileStorage) walkArtifactFiles(ctx context.Context, fn func(path, hash string) error) error {
+	return walkLayoutFiles(ctx, s.baseDir, s.layout, false, fn)
+}
+
+// walkLayoutFiles calls fn with the path and hash of every artifact data file under root in the
+// given layout; with includeMeta, metadata files are reported too (path ends in .meta.json).
+// Temp files, dot-directories and files not matching the layout are skipped.
+func walkLayoutFiles(ctx context.Context, root string, layout Layout, includeMeta bool, fn func(path, hash string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name := d.Name()
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") {
+			return nil
+		}
+		artifactPath, isMeta := strings.CutSuffix(path, ".meta.json")
+		if isMeta && !includeMeta {
+			return nil
+		}
+
+		hash, ok := layout.hashFromPath(root, artifactPath)
+		if !ok {
+			return nil
+		}
+		return fn(path, hash)
+	})
+}
+
+// Walk calls fn with the metadata of every stored artifact; artifacts without metadata are skipped.
+// Returning an error from fn stops the walk and returns that error.
+func (s *SimpleFileStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	return s.walkArtifactFiles(ctx, func(path, hash string) error {
+		meta, err := s.GetMeta(ctx, hash)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to read metadata for %s: %w", hash, err)
+		}
+		return fn(meta)
+	})
+}
+
+// verifyArtifactHash recomputes the SHA-256 of the file when the hash is a SHA-256 digest.
+// Returns verified=false when the hash format can't be checked.
+func verifyArtifactHash(path, hash string) (verified, match bool, err error) {
+	expected := strings.TrimPrefix(hash, "sha256:")
+	if len(expected) != sha256.Size*2 {
+		return false, false, nil
+	}
+	if _, err := hex.DecodeString(expected); err != nil {
+		return false, false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, false, err
+	}
+	defer f.Close()
+
+	hasher := AcquireSHA256()
+	defer ReleaseSHA256(hasher)
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false, false, err
+	}
+	return true, strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), expected), nil
+}
+
+// fileExists checks if a regular file or directory exists at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
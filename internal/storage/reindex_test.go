@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// TestSimpleFileStorageReindex tests that lost metadata is regenerated from on-disk data
+func TestSimpleFileStorageReindex(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+
+	testData := []byte("layer data to reindex")
+	sum := sha256.Sum256(testData)
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+	intact := "intact123"
+
+	for _, h := range []string{hash, intact} {
+		if _, err := storage.Create(ctx, h, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+			t.Fatalf("Create %s failed: %v", h, err)
+		}
+	}
+
+	_, _, metaPath := storage.getPaths(hash)
+	if err := os.Remove(metaPath); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+
+	report, err := storage.Reindex(ctx)
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if report.Scanned != 2 {
+		t.Errorf("Expected 2 scanned artifacts, got %d", report.Scanned)
+	}
+	if report.Repaired != 1 {
+		t.Errorf("Expected 1 repaired artifact, got %d", report.Repaired)
+	}
+	if report.Unverified != 0 {
+		t.Errorf("Expected digest key to be verified, got %d unverified", report.Unverified)
+	}
+
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("Metadata was not regenerated: %v", err)
+	}
+	if meta.Hash != hash {
+		t.Errorf("Expected hash %s, got %s", hash, meta.Hash)
+	}
+	if meta.Length != int64(len(testData)) {
+		t.Errorf("Expected length %d, got %d", len(testData), meta.Length)
+	}
+	if len(meta.References) != 0 {
+		t.Errorf("Expected no references, got %d", len(meta.References))
+	}
+}
+
+// TestSimpleFileStorageReindexMismatch tests that content not matching its digest key is not repaired
+func TestSimpleFileStorageReindexMismatch(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+
+	sum := sha256.Sum256([]byte("expected content"))
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+	corrupt := []byte("corrupted content")
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(corrupt), int64(len(corrupt)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_, _, metaPath := storage.getPaths(hash)
+	if err := os.Remove(metaPath); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+
+	report, err := storage.Reindex(ctx)
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if report.Repaired != 0 {
+		t.Errorf("Expected no repaired artifacts, got %d", report.Repaired)
+	}
+	if len(report.Mismatched) != 1 || report.Mismatched[0] != hash {
+		t.Errorf("Expected %s reported as mismatched, got %v", hash, report.Mismatched)
+	}
+	if _, metaExists, _ := storage.Exists(ctx, hash); metaExists {
+		t.Error("Metadata must not be regenerated for mismatching content")
+	}
+}
+
+// TestSimpleFileStorageWalk tests that Walk visits every artifact with metadata
+func TestSimpleFileStorageWalk(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, h := range []string{"walk-one", "walk-two", "walk-lost"} {
+		if _, err := storage.Create(ctx, h, bytes.NewReader([]byte(h)), int64(len(h)), nil); err != nil {
+			t.Fatalf("Create %s failed: %v", h, err)
+		}
+	}
+	_, _, metaPath := storage.getPaths("walk-lost")
+	if err := os.Remove(metaPath); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+
+	seen := map[string]int64{}
+	err = storage.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		seen[meta.Hash] = meta.Length
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(seen) != 2 || seen["walk-one"] != 8 || seen["walk-two"] != 8 {
+		t.Errorf("Expected walk-one and walk-two, got %v", seen)
+	}
+}
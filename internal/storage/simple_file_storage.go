@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -14,51 +15,117 @@ import (
 // SimpleFileStorage implements models.ArtifactStorage
 type SimpleFileStorage struct {
 	models.BaseStorage
-	baseDir string
+	baseDir           string
+	verifyUnknownSize bool
+	strictMetadata    bool
+	reflink           bool
+	layout            Layout
+	syncPolicy        SyncPolicy
+	maxWriteLength    int64
 }
 
+// FileStorageOptions holds optional SimpleFileStorage behaviors; the zero value keeps the defaults.
+type FileStorageOptions struct {
+	VerifyUnknownSize bool       // See SetVerifyUnknownSize
+	StrictMetadata    bool       // See SetStrictMetadata
+	Reflink           bool       // See SetReflink
+	Layout            Layout     // See SetLayout; the zero value keeps DefaultLayout
+	Sync              SyncPolicy // See SetSyncPolicy; empty keeps DefaultSyncPolicy
+	Prewarm           bool       // Create the shard directories when the storage is created, see Prewarm
+	MaxWriteLength    int64      // See SetMaxWriteLength; 0 keeps unbounded writes
+}
+
+// ErrWriteLimitExceeded is returned by Update when an unbounded write exceeds the maximum write length
+var ErrWriteLimitExceeded = errors.New("write exceeds the maximum write length")
+
 // NewSimpleFileStorage creates a new storage instance and ensures the base directory exists.
 func NewSimpleFileStorage(alias, baseDir string) (*SimpleFileStorage, error) {
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
 	}
 	s := &SimpleFileStorage{
-		baseDir: baseDir,
+		baseDir:    baseDir,
+		layout:     DefaultLayout,
+		syncPolicy: DefaultSyncPolicy,
 	}
 	s.BaseStorage.SetAlias(alias)
 	return s, nil
 }
 
+// SetVerifyUnknownSize enables length validation for Create calls with unknown size (-1)
+// on an existing artifact. The reader is streamed and discarded to count its length,
+// trading an extra pass over the input for detecting content mismatches.
+func (s *SimpleFileStorage) SetVerifyUnknownSize(verify bool) {
+	s.verifyUnknownSize = verify
+}
+
+// SetStrictMetadata controls how Create handles artifact data that exists without metadata.
+// By default a minimal metadata is synthesized from file stats; in strict mode a
+// *models.MissingMetadataError is returned instead so partial writes are surfaced.
+func (s *SimpleFileStorage) SetStrictMetadata(strict bool) {
+	s.strictMetadata = strict
+}
+
+// SetMaxWriteLength caps the bytes an Update without a length (Length <= 0) writes (0 = unbounded).
+// Past the cap Update fails with ErrWriteLimitExceeded, leaving the capped bytes written, so an
+// endless reader can't fill the disk.
+func (s *SimpleFileStorage) SetMaxWriteLength(limit int64) {
+	s.maxWriteLength = limit
+}
+
+// MaxWriteLength returns the cap of Update writes without a length (0 = unbounded)
+func (s *SimpleFileStorage) MaxWriteLength() int64 {
+	return s.maxWriteLength
+}
+
+// ApplyOptions applies all optional behaviors at once
+func (s *SimpleFileStorage) ApplyOptions(opts FileStorageOptions) {
+	s.SetVerifyUnknownSize(opts.VerifyUnknownSize)
+	s.SetStrictMetadata(opts.StrictMetadata)
+	s.SetReflink(opts.Reflink)
+	if opts.Layout != (Layout{}) {
+		s.SetLayout(opts.Layout)
+	}
+	if opts.Sync != "" {
+		s.SetSyncPolicy(opts.Sync)
+	}
+	if opts.MaxWriteLength > 0 {
+		s.SetMaxWriteLength(opts.MaxWriteLength)
+	}
+}
+
+// SetLayout sets the directory sharding layout (validate it with Layout.Validate first).
+// Existing artifacts stay where they are: use Migrate to move them to a new layout.
+func (s *SimpleFileStorage) SetLayout(layout Layout) {
+	s.layout = layout
+}
+
+// Layout returns the directory sharding layout
+func (s *SimpleFileStorage) Layout() Layout {
+	return s.layout
+}
+
 // getPaths returns the directory, artifact path, and metadata path for a given hash.
 func (s *SimpleFileStorage) getPaths(hash string) (dir, artifactPath, metaPath string) {
-	if len(hash) < 2 {
-		dir = s.baseDir
-		artifactPath = filepath.Join(s.baseDir, hash)
-	} else {
-		subDir := hash[:2]
-		fileName := hash[2:]
-		dir = filepath.Join(s.baseDir, subDir)
-		artifactPath = filepath.Join(dir, fileName)
-	}
+	artifactPath = s.layout.path(s.baseDir, hash)
+	dir = filepath.Dir(artifactPath)
 	metaPath = artifactPath + ".meta.json"
 	return
 }
 
-// getTrashPath returns the trash directory path for a given hash using git-like structure.
+// getTrashPath returns the trash directory path for a given hash, sharded like the artifacts.
 func (s *SimpleFileStorage) getTrashPath(hash string) (dir, artifactPath, metaPath string) {
-	if len(hash) < 2 {
-		dir = filepath.Join(s.baseDir, ".trash")
-		artifactPath = filepath.Join(dir, hash)
-	} else {
-		subDir := hash[:2]
-		fileName := hash[2:]
-		dir = filepath.Join(s.baseDir, ".trash", subDir)
-		artifactPath = filepath.Join(dir, fileName)
-	}
+	artifactPath = s.layout.path(s.trashDir(), hash)
+	dir = filepath.Dir(artifactPath)
 	metaPath = artifactPath + ".meta.json"
 	return
 }
 
+// trashDir returns the root of the trash directory
+func (s *SimpleFileStorage) trashDir() string {
+	return filepath.Join(s.baseDir, ".trash")
+}
+
 // mergeReferences merges new references into existing references, deduplicating by Name+Repo.
 // If a reference with the same Name+Repo exists, updates ReferencedTimestamp to the latest.
 func mergeReferences(existing, new []models.ArtifactReference) []models.ArtifactReference {
@@ -138,7 +205,10 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 			return nil, fmt.Errorf("failed to read existing metadata: %w", err)
 		}
 
-		// If metadata doesn't exist, create a basic one from file stats
+		// If metadata doesn't exist, create a basic one from file stats (unless strict)
+		if existingMeta == nil && s.strictMetadata {
+			return nil, &models.MissingMetadataError{Hash: hash}
+		}
 		if existingMeta == nil {
 			stat, err := os.Stat(artifactPath)
 			if err != nil {
@@ -152,6 +222,15 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 			}
 		}
 
+		// Unknown size: optionally count the provided data so the length can still be validated
+		if size == -1 && s.verifyUnknownSize && r != nil {
+			n, err := io.Copy(io.Discard, r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read artifact data: %w", err)
+			}
+			size = n
+		}
+
 		// Validate length if size is provided and not -1
 		if size != -1 && size != existingMeta.Length {
 			return nil, &models.HashConflictError{
@@ -161,24 +240,22 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 			}
 		}
 
-		// Merge references if meta is provided
+		// Merge references if meta is provided, and record a media type the artifact lacks
 		if meta != nil && len(meta.References) > 0 {
 			existingMeta.References = mergeReferences(existingMeta.References, meta.References)
 		}
+		if meta != nil && existingMeta.MediaType == "" {
+			existingMeta.MediaType = meta.MediaType
+		}
 
 		// Update metadata file
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, fmt.Errorf("failed to create subdirectory: %w", err)
 		}
 
-		metaFile, err := os.Create(metaPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create metadata file: %w", err)
-		}
-		defer metaFile.Close()
-
-		if err := json.NewEncoder(metaFile).Encode(existingMeta); err != nil {
-			return nil, fmt.Errorf("failed to encode metadata: %w", err)
+		existingMeta.Generation++
+		if err := s.writeMetaFile(metaPath, existingMeta); err != nil {
+			return nil, err
 		}
 
 		return existingMeta, nil
@@ -196,9 +273,18 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 	}
 	defer f.Close()
 
-	if _, err := io.Copy(f, r); err != nil {
+	if _, err := CopyBuffer(f, r); err != nil {
+		// Never leave a partially written artifact behind
+		f.Close()
+		_ = os.Remove(artifactPath)
 		return nil, fmt.Errorf("failed to write artifact data: %w", err)
 	}
+	// Data first: the metadata written next must never describe data lost on power failure
+	if err := s.syncFile(f); err != nil {
+		f.Close()
+		_ = os.Remove(artifactPath)
+		return nil, fmt.Errorf("failed to sync artifact data: %w", err)
+	}
 
 	// Get file size for metadata
 	stat, err := f.Stat()
@@ -216,6 +302,8 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 			Length:           fileSize,
 			CreatedTimestamp: meta.CreatedTimestamp,
 			References:       meta.References,
+			MediaType:        meta.MediaType,
+			ExpiresTimestamp: meta.ExpiresTimestamp,
 		}
 		// If no CreatedTimestamp provided, use current time
 		if finalMeta.CreatedTimestamp == 0 {
@@ -231,15 +319,12 @@ func (s *SimpleFileStorage) Create(ctx context.Context, hash string, r io.Reader
 		}
 	}
 
-	// 3. Write Metadata
-	metaFile, err := os.Create(metaPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create metadata file: %w", err)
-	}
-	defer metaFile.Close()
+	finalMeta.Generation = 1
+	finalMeta.Normalize()
 
-	if err := json.NewEncoder(metaFile).Encode(finalMeta); err != nil {
-		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	// 3. Write Metadata
+	if err := s.writeMetaFile(metaPath, finalMeta); err != nil {
+		return nil, err
 	}
 
 	return finalMeta, nil
@@ -298,26 +383,100 @@ func (s *SimpleFileStorage) Read(ctx context.Context, req models.ArtifactRange)
 }
 
 // Update modifies a range of the artifact.
+// An offset equal to the current size is a pure append and is written with O_APPEND (no seek).
+// An offset beyond the current size zero-fills the gap explicitly before appending,
+// so the result never depends on sparse-file support of the filesystem.
 func (s *SimpleFileStorage) Update(ctx context.Context, req models.ArtifactRange, r io.Reader) error {
 	_, artifactPath, _ := s.getPaths(req.Hash)
 
-	f, err := os.OpenFile(artifactPath, os.O_WRONLY, 0644)
+	if req.Range.Offset < 0 {
+		return fmt.Errorf("invalid update offset: %d", req.Range.Offset)
+	}
+	stat, err := os.Stat(artifactPath)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	if _, err := f.Seek(req.Range.Offset, io.SeekStart); err != nil {
-		return err
+	var f *os.File
+	if req.Range.Offset >= stat.Size() {
+		// Append path: extend with zeros up to the offset, then append
+		if req.Range.Offset > stat.Size() {
+			if err := os.Truncate(artifactPath, req.Range.Offset); err != nil {
+				return fmt.Errorf("failed to zero-fill artifact: %w", err)
+			}
+		}
+		f, err = os.OpenFile(artifactPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	} else {
+		f, err = os.OpenFile(artifactPath, os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(req.Range.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
 	}
+	defer f.Close()
 
 	if req.Range.Length > 0 {
 		_, err = io.CopyN(f, r, req.Range.Length)
+	} else if s.maxWriteLength > 0 {
+		err = s.copyCapped(f, r)
 	} else {
-		_, err = io.Copy(f, r)
+		_, err = CopyBuffer(f, r)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.syncFile(f); err != nil {
+		return fmt.Errorf("failed to sync artifact data: %w", err)
+	}
+	return nil
+}
+
+// copyCapped copies r to f up to the maximum write length, failing with ErrWriteLimitExceeded
+// if r has more
+func (s *SimpleFileStorage) copyCapped(f *os.File, r io.Reader) error {
+	if _, err := CopyBuffer(f, io.LimitReader(r, s.maxWriteLength)); err != nil {
+		return err
+	}
+	var probe [1]byte
+	if n, err := io.ReadFull(r, probe[:]); n > 0 {
+		return fmt.Errorf("%w (%d bytes)", ErrWriteLimitExceeded, s.maxWriteLength)
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	return nil
+}
+
+// Truncate changes the size of the artifact data and updates the metadata Length.
+// Shrinking discards trailing bytes; growing zero-fills.
+func (s *SimpleFileStorage) Truncate(ctx context.Context, hash string, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid truncate size: %d", size)
+	}
+	_, artifactPath, _ := s.getPaths(hash)
+
+	if err := os.Truncate(artifactPath, size); err != nil {
+		return err
 	}
 
-	return err
+	meta, err := s.GetMeta(ctx, hash)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// No metadata to keep in sync
+			return nil
+		}
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	meta.Length = size
+	if _, err := s.UpdateMeta(ctx, *meta); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	return nil
 }
 
 // Delete removes a specific reference to an artifact.
@@ -371,15 +530,10 @@ func (s *SimpleFileStorage) Delete(ctx context.Context, hash string, ref models.
 	}
 
 	// Update metadata file
+	existingMeta.Generation++
 	_, _, metaPath := s.getPaths(hash)
-	metaFile, err := os.Create(metaPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to update metadata file: %w", err)
-	}
-	defer metaFile.Close()
-
-	if err := json.NewEncoder(metaFile).Encode(existingMeta); err != nil {
-		return nil, fmt.Errorf("failed to encode updated metadata: %w", err)
+	if err := s.writeMetaFile(metaPath, existingMeta); err != nil {
+		return nil, err
 	}
 
 	return existingMeta, nil
@@ -398,20 +552,22 @@ func (s *SimpleFileStorage) GetMeta(ctx context.Context, hash string) (*models.A
 	if err := json.NewDecoder(f).Decode(&meta); err != nil {
 		return nil, err
 	}
+	meta.Normalize()
 
 	return &meta, nil
 }
 
-// UpdateMeta overwrites the metadata JSON file.
+// UpdateMeta overwrites the metadata JSON file, bumping the generation of the stored metadata
+// (see CompareAndSwapMeta).
 func (s *SimpleFileStorage) UpdateMeta(ctx context.Context, meta models.ArtifactMeta) (*models.ArtifactMeta, error) {
 	_, _, metaPath := s.getPaths(meta.Hash)
-	f, err := os.Create(metaPath)
-	if err != nil {
-		return nil, err
+	meta.Generation = 1
+	if current, err := s.GetMeta(ctx, meta.Hash); err == nil {
+		meta.Generation = current.Generation + 1
 	}
-	defer f.Close()
 
-	if err := json.NewEncoder(f).Encode(meta); err != nil {
+	meta.Normalize()
+	if err := s.writeMetaFile(metaPath, &meta); err != nil {
 		return nil, err
 	}
 	return &meta, nil
@@ -442,30 +598,122 @@ func (s *SimpleFileStorage) Exists(ctx context.Context, hash string) (bool, bool
 	return artifactExists, metaExists, nil
 }
 
+// Size returns the data length of a complete artifact (metadata is written last) from stat calls,
+// without decoding the metadata. exists is false when the data or the metadata is missing.
+func (s *SimpleFileStorage) Size(ctx context.Context, hash string) (int64, bool, error) {
+	_, artifactPath, metaPath := s.getPaths(hash)
+	stat, err := os.Stat(artifactPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if _, err := os.Stat(metaPath); err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return stat.Size(), true, nil
+}
+
+// MoveIfAbsent moves an artifact like Move, failing with ErrDestinationExists if the destination
+// data or metadata exists.
+func (s *SimpleFileStorage) MoveIfAbsent(ctx context.Context, srcHash, destHash string) error {
+	if artifactExists, metaExists, err := s.Exists(ctx, destHash); err != nil {
+		return err
+	} else if artifactExists || metaExists {
+		return ErrDestinationExists
+	}
+	return s.Move(ctx, srcHash, destHash)
+}
+
 // Move renames an artifact and its metadata to a new hash location.
+// The source shard directory is left in place even if empty: other writers may be creating files
+// in it (all temp artifacts share one shard), and Prewarm creates the directories up front.
 func (s *SimpleFileStorage) Move(ctx context.Context, srcHash, destHash string) error {
-	srcDir, srcArt, srcMeta := s.getPaths(srcHash)
+	_, srcArt, srcMeta := s.getPaths(srcHash)
 	destDir, destArt, destMeta := s.getPaths(destHash)
 
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return fmt.Errorf("failed to create dest directory: %w", err)
 	}
 
-	// 1. Move Artifact
-	if err := os.Rename(srcArt, destArt); err != nil {
+	// 1. Move Artifact (copied across filesystems)
+	if err := moveFile(srcArt, destArt, s.reflink); err != nil {
 		return fmt.Errorf("failed to move artifact from %s to %s: %w", srcArt, destArt, err)
 	}
 
-	// 2. Move Metadata (if it exists)
-	if err := os.Rename(srcMeta, destMeta); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to move metadata: %w", err)
+	// 2. Move Metadata (if it exists), rewriting its hash so readers never see the source hash
+	if err := s.moveMeta(srcMeta, destMeta, destHash); err != nil {
+		return err
 	}
 
-	// 3. Cleanup source directory if it's empty
-	if srcDir != destDir {
-		_ = os.Remove(srcDir)
+	return nil
+}
+
+// moveMeta writes the source metadata under the destination path with the new hash.
+// The file is written to a temp path and renamed so the destination is replaced atomically.
+func (s *SimpleFileStorage) moveMeta(srcMeta, destMeta, destHash string) error {
+	f, err := os.Open(srcMeta)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open metadata: %w", err)
 	}
+	var meta models.ArtifactMeta
+	err = json.NewDecoder(f).Decode(&meta)
+	f.Close()
+	if err != nil {
+		// Unreadable metadata: move it as-is
+		if err := os.Rename(srcMeta, destMeta); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move metadata: %w", err)
+		}
+		return nil
+	}
+	meta.Hash = destHash
+	meta.Normalize()
+
+	if err := s.writeMetaFile(destMeta, &meta); err != nil {
+		return err
+	}
+	if err := os.Remove(srcMeta); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove source metadata: %w", err)
+	}
+	return nil
+}
 
+// writeMetaFile writes meta to metaPath through a temp file renamed into place, so readers never
+// see a partially written metadata file, synced per the sync policy
+func (s *SimpleFileStorage) writeMetaFile(metaPath string, meta *models.ArtifactMeta) error {
+	tmp, err := os.CreateTemp(filepath.Dir(metaPath), ".meta-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create metadata file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	if err := json.NewEncoder(tmp).Encode(meta); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if err := s.syncFile(tmp); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to sync metadata: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tmpPath, metaPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to move metadata: %w", err)
+	}
+	if err := s.syncDir(filepath.Dir(metaPath)); err != nil {
+		return fmt.Errorf("failed to sync metadata directory: %w", err)
+	}
 	return nil
 }
 
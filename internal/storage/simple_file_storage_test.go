@@ -3,6 +3,9 @@ package storage
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -289,6 +292,283 @@ func TestSimpleFileStorageHashConflict(t *testing.T) {
 	}
 }
 
+// TestSimpleFileStorageUnknownSizeVerification tests length validation for existing artifacts created with size -1
+func TestSimpleFileStorageUnknownSizeVerification(t *testing.T) {
+	ctx := context.Background()
+	hash := "unknownsize123"
+	testData1 := []byte("test data 1")
+	testData2 := []byte("different size data")
+
+	t.Run("flag_off", func(t *testing.T) {
+		storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(testData1), int64(len(testData1)), nil); err != nil {
+			t.Fatalf("First Create failed: %v", err)
+		}
+
+		// Fast path: unknown size is not validated
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(testData2), -1, nil); err != nil {
+			t.Fatalf("Expected unknown-size Create to succeed without verification, got: %v", err)
+		}
+	})
+
+	t.Run("flag_on", func(t *testing.T) {
+		storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		storage.SetVerifyUnknownSize(true)
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(testData1), int64(len(testData1)), nil); err != nil {
+			t.Fatalf("First Create failed: %v", err)
+		}
+
+		_, err = storage.Create(ctx, hash, bytes.NewReader(testData2), -1, nil)
+		hashErr, ok := err.(*models.HashConflictError)
+		if !ok {
+			t.Fatalf("Expected HashConflictError, got %T: %v", err, err)
+		}
+		if hashErr.ProvidedLength != int64(len(testData2)) {
+			t.Errorf("Expected provided length %d, got %d", len(testData2), hashErr.ProvidedLength)
+		}
+
+		// Matching content is still accepted
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(testData1), -1, nil); err != nil {
+			t.Errorf("Expected matching unknown-size Create to succeed, got: %v", err)
+		}
+	})
+}
+
+// TestSimpleFileStorageStrictMetadata tests Create on artifact data that lacks metadata in both modes
+func TestSimpleFileStorageStrictMetadata(t *testing.T) {
+	ctx := context.Background()
+	hash := "nometa123"
+	testData := []byte("orphaned data")
+
+	// setupBareArtifact creates an artifact and removes its metadata file
+	setupBareArtifact := func(t *testing.T, storage *SimpleFileStorage) {
+		if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		_, _, metaPath := storage.getPaths(hash)
+		if err := os.Remove(metaPath); err != nil {
+			t.Fatalf("Failed to remove metadata: %v", err)
+		}
+	}
+
+	t.Run("lenient", func(t *testing.T) {
+		storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		setupBareArtifact(t, storage)
+
+		meta, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil)
+		if err != nil {
+			t.Fatalf("Expected metadata to be synthesized, got: %v", err)
+		}
+		if meta.Length != int64(len(testData)) {
+			t.Errorf("Expected length %d, got %d", len(testData), meta.Length)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		storage.ApplyOptions(FileStorageOptions{StrictMetadata: true})
+		setupBareArtifact(t, storage)
+
+		_, err = storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil)
+		missingErr, ok := err.(*models.MissingMetadataError)
+		if !ok {
+			t.Fatalf("Expected MissingMetadataError, got %T: %v", err, err)
+		}
+		if missingErr.Hash != hash {
+			t.Errorf("Expected hash %s, got %s", hash, missingErr.Hash)
+		}
+
+		// Metadata must not have been fabricated
+		if _, metaExists, _ := storage.Exists(ctx, hash); metaExists {
+			t.Error("Strict mode must not write metadata for a bare artifact")
+		}
+	})
+}
+
+// TestSimpleFileStorageTruncate tests shrinking an artifact updates both data and metadata
+func TestSimpleFileStorageTruncate(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+	hash := "truncate123"
+	testData := []byte("0123456789abcdef")
+
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if err := storage.Truncate(ctx, hash, 10); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	reader, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if !bytes.Equal(data, testData[:10]) {
+		t.Errorf("Expected data %q, got %q", testData[:10], data)
+	}
+
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if meta.Length != 10 {
+		t.Errorf("Expected metadata length 10, got %d", meta.Length)
+	}
+
+	if err := storage.Truncate(ctx, hash, -1); err == nil {
+		t.Error("Expected error for negative truncate size")
+	}
+	if err := storage.Truncate(ctx, "missing123", 0); err == nil {
+		t.Error("Expected error truncating a non-existent artifact")
+	}
+}
+
+// TestSimpleFileStorageUpdateAppend tests that an update at the current size appends data
+func TestSimpleFileStorageUpdateAppend(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+	hash := "append123"
+	testData := []byte("hello")
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	appendData := []byte(" world")
+	req := models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: int64(len(testData)), Length: -1}}
+	if err := storage.Update(ctx, req, bytes.NewReader(appendData)); err != nil {
+		t.Fatalf("Append update failed: %v", err)
+	}
+
+	reader, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", data)
+	}
+}
+
+// endlessReader yields 'x' bytes forever
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+// TestSimpleFileStorageUpdateMaxWriteLength tests that an update without a length stops at the
+// maximum write length and fails, while one fitting it succeeds
+func TestSimpleFileStorageUpdateMaxWriteLength(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	storage.ApplyOptions(FileStorageOptions{MaxWriteLength: 64})
+
+	ctx := context.Background()
+	hash := "capped123"
+	testData := []byte("head")
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_, artifactPath, _ := storage.getPaths(hash)
+	size := func() int64 {
+		stat, err := os.Stat(artifactPath)
+		if err != nil {
+			t.Fatalf("Failed to stat artifact: %v", err)
+		}
+		return stat.Size()
+	}
+
+	req := models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: int64(len(testData)), Length: -1}}
+	if err := storage.Update(ctx, req, endlessReader{}); !errors.Is(err, ErrWriteLimitExceeded) {
+		t.Fatalf("Expected ErrWriteLimitExceeded, got %v", err)
+	}
+	if got := size(); got != int64(len(testData))+64 {
+		t.Errorf("Expected the write capped at %d bytes, got %d", len(testData)+64, got)
+	}
+
+	// Exactly the cap fits
+	req.Range.Offset = size()
+	if err := storage.Update(ctx, req, bytes.NewReader(bytes.Repeat([]byte("y"), 64))); err != nil {
+		t.Fatalf("Expected a write of exactly the cap to succeed, got %v", err)
+	}
+	if got := size(); got != int64(len(testData))+128 {
+		t.Errorf("Expected size %d, got %d", len(testData)+128, got)
+	}
+}
+
+// TestSimpleFileStorageUpdateBeyondEOF tests that an unbounded update past EOF zero-fills the gap
+func TestSimpleFileStorageUpdateBeyondEOF(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	ctx := context.Background()
+	hash := "beyondeof123"
+	testData := []byte("hello")
+	if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	req := models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 8, Length: -1}}
+	if err := storage.Update(ctx, req, bytes.NewReader([]byte("gap"))); err != nil {
+		t.Fatalf("Update beyond EOF failed: %v", err)
+	}
+
+	reader, _, err := storage.Read(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: 0, Length: -1}})
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read data: %v", err)
+	}
+	expected := []byte("hello\x00\x00\x00gap")
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+
+	if err := storage.Update(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: -1}}, bytes.NewReader(nil)); err == nil {
+		t.Error("Expected error for negative update offset")
+	}
+}
+
 // TestSimpleFileStorageDeleteWithMultipleReferences tests deleting one reference while keeping others
 func TestSimpleFileStorageDeleteWithMultipleReferences(t *testing.T) {
 	baseDir := t.TempDir()
@@ -462,3 +742,232 @@ func TestSimpleFileStorageReferenceDeduplication(t *testing.T) {
 		t.Errorf("Expected timestamp 1234567895, got %d", createdMeta2.References[0].ReferencedTimestamp)
 	}
 }
+
+// TestSimpleFileStorageNullReferences tests that null references decode and persist as an empty slice
+func TestSimpleFileStorageNullReferences(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	ctx := context.Background()
+	hash := "nullrefs123"
+	if _, err := storage.Create(ctx, hash, bytes.NewReader([]byte("data")), 4, nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Metadata as written by an older version
+	_, _, metaPath := storage.getPaths(hash)
+	legacy := `{"hash":"nullrefs123","length":4,"createdTimestamp":1,"references":null}`
+	if err := os.WriteFile(metaPath, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	meta, err := storage.GetMeta(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetMeta failed: %v", err)
+	}
+	if meta.References == nil || len(meta.References) != 0 {
+		t.Fatalf("Expected an empty non-nil references slice, got %#v", meta.References)
+	}
+
+	// Null references are never written back
+	meta.References = nil
+	if _, err := storage.UpdateMeta(ctx, *meta); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	raw, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if !bytes.Contains(raw, []byte(`"references":[]`)) {
+		t.Errorf("Expected empty references array, got %s", raw)
+	}
+
+	// The usable slice accepts new references through Create's merge
+	ref := models.ArtifactReference{Name: "repo", Repo: "blob"}
+	merged, err := storage.Create(ctx, hash, nil, 4, &models.ArtifactMeta{References: []models.ArtifactReference{ref}})
+	if err != nil {
+		t.Fatalf("Create merge failed: %v", err)
+	}
+	if len(merged.References) != 1 || merged.References[0].Name != "repo" {
+		t.Errorf("Expected merged reference, got %v", merged.References)
+	}
+
+	created, err := storage.Create(ctx, "nilrefs456", bytes.NewReader([]byte("x")), 1, &models.ArtifactMeta{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if created.References == nil {
+		t.Error("Expected Create to return non-nil references")
+	}
+}
+
+// TestSimpleFileStorageCopyFileReflink tests copying a file with reflinks enabled, which
+// falls back to a regular copy where the filesystem can't clone
+func TestSimpleFileStorageCopyFileReflink(t *testing.T) {
+	baseDir := t.TempDir()
+	data := bytes.Repeat([]byte("reflinked blob "), 1000)
+	source := filepath.Join(baseDir, "source")
+	if err := os.WriteFile(source, data, 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	copied := filepath.Join(baseDir, "copy")
+	if err := copyFile(source, copied, true); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+	if content, _ := os.ReadFile(copied); !bytes.Equal(content, data) {
+		t.Error("Expected the copy to have the file's content")
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("Expected the source file to be left in place: %v", err)
+	}
+
+	// Clone directly, where the filesystem supports it
+	clone := filepath.Join(baseDir, "clone")
+	src, err := os.Open(source)
+	if err != nil {
+		t.Fatalf("Failed to open source: %v", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(clone)
+	if err != nil {
+		t.Fatalf("Failed to create clone: %v", err)
+	}
+	err = cloneFile(dst, src)
+	dst.Close()
+	if errors.Is(err, errReflinkUnsupported) {
+		t.Skipf("Filesystem doesn't support reflinks: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("cloneFile failed: %v", err)
+	}
+	if cloned, _ := os.ReadFile(clone); !bytes.Equal(cloned, data) {
+		t.Error("Expected the reflink copy to have identical content")
+	}
+}
+
+// TestSimpleFileStorageSyncPolicy tests that every sync policy writes data and metadata through,
+// leaving no temp files behind, and that unknown policies are rejected
+func TestSimpleFileStorageSyncPolicy(t *testing.T) {
+	ctx := context.Background()
+	hash := "sync123"
+	testData := []byte("synced data")
+
+	for _, policy := range []SyncPolicy{SyncNone, SyncFsync, SyncFsyncDir} {
+		t.Run(string(policy), func(t *testing.T) {
+			baseDir := t.TempDir()
+			storage, err := NewSimpleFileStorage("test-storage", baseDir)
+			if err != nil {
+				t.Fatalf("Failed to create storage: %v", err)
+			}
+			storage.ApplyOptions(FileStorageOptions{Sync: policy})
+			if storage.SyncPolicy() != policy {
+				t.Fatalf("Expected sync policy %s, got %s", policy, storage.SyncPolicy())
+			}
+
+			meta := createTestMeta(hash, "synced", "docker:test", int64(len(testData)))
+			if _, err := storage.Create(ctx, hash, bytes.NewReader(testData), int64(len(testData)), meta); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			more := []byte(" and more")
+			if err := storage.Update(ctx, models.ArtifactRange{Hash: hash, Range: models.ByteRange{Offset: int64(len(testData)), Length: -1}}, bytes.NewReader(more)); err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			meta.Length = int64(len(testData) + len(more))
+			if _, err := storage.UpdateMeta(ctx, *meta); err != nil {
+				t.Fatalf("UpdateMeta failed: %v", err)
+			}
+
+			// Read back from disk, bypassing the storage
+			_, artifactPath, metaPath := storage.getPaths(hash)
+			data, err := os.ReadFile(artifactPath)
+			if err != nil {
+				t.Fatalf("Failed to read artifact: %v", err)
+			}
+			verifyData(t, data, append(append([]byte{}, testData...), more...))
+			var stored models.ArtifactMeta
+			raw, err := os.ReadFile(metaPath)
+			if err != nil {
+				t.Fatalf("Failed to read metadata: %v", err)
+			}
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				t.Fatalf("Failed to decode metadata: %v", err)
+			}
+			if stored.Length != meta.Length || stored.Generation != 2 {
+				t.Errorf("Expected length %d at generation 2, got %d at %d", meta.Length, stored.Length, stored.Generation)
+			}
+
+			temps, _ := filepath.Glob(filepath.Join(filepath.Dir(metaPath), ".meta-*.tmp"))
+			if len(temps) != 0 {
+				t.Errorf("Expected no temp metadata files, found %v", temps)
+			}
+		})
+	}
+
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an unknown sync policy to be rejected")
+	}
+	if storage, err := NewSimpleFileStorage("test-storage", t.TempDir()); err == nil && storage.SyncPolicy() != DefaultSyncPolicy {
+		t.Errorf("Expected default sync policy %s, got %s", DefaultSyncPolicy, storage.SyncPolicy())
+	}
+}
+
+// TestSimpleFileStoragePrewarm tests that Prewarm creates every shard directory of the layout
+func TestSimpleFileStoragePrewarm(t *testing.T) {
+	baseDir := t.TempDir()
+	storage, err := NewSimpleFileStorage("test-storage", baseDir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := storage.Prewarm(); err != nil {
+		t.Fatalf("Prewarm failed: %v", err)
+	}
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		t.Fatalf("Failed to read base directory: %v", err)
+	}
+	if len(entries) != 256 {
+		t.Errorf("Expected 256 shard directories, got %d", len(entries))
+	}
+	for _, shard := range []string{"00", "7f", "ff"} {
+		if info, err := os.Stat(filepath.Join(baseDir, shard)); err != nil || !info.IsDir() {
+			t.Errorf("Expected shard directory %s to exist", shard)
+		}
+	}
+
+	// Artifacts land in the existing shards
+	ctx := context.Background()
+	testData := []byte("prewarmed")
+	if _, err := storage.Create(ctx, "ab12cd", bytes.NewReader(testData), int64(len(testData)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "ab", "12cd")); err != nil {
+		t.Errorf("Expected the artifact in its shard: %v", err)
+	}
+
+	// Moving the only artifact out of a shard keeps the shard directory
+	if err := storage.Move(ctx, "ab12cd", "cd34ef"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(baseDir, "ab")); err != nil || !info.IsDir() {
+		t.Errorf("Expected shard directory ab to survive the move")
+	}
+
+	// Deeper layouts get their nested levels
+	nested, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	nested.SetLayout(Layout{Depth: 2, Width: 1})
+	if err := nested.Prewarm(); err != nil {
+		t.Fatalf("Prewarm failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(nested.baseDir, "f", "0")); err != nil {
+		t.Errorf("Expected nested shard directory f/0: %v", err)
+	}
+
+	nested.SetLayout(Layout{Depth: 1, Width: maxPrewarmChars + 1})
+	if err := nested.Prewarm(); err == nil {
+		t.Error("Expected Prewarm to refuse a layout with too many directories")
+	}
+}
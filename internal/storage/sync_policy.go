@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+)
+
+// SyncPolicy selects how SimpleFileStorage flushes writes to stable storage
+type SyncPolicy string
+
+// Sync policies, from fastest to most durable
+const (
+	SyncNone     SyncPolicy = "none"      // Leave flushing to the OS: a power failure can lose recent writes
+	SyncFsync    SyncPolicy = "fsync"     // Fsync data and metadata files before a write returns
+	SyncFsyncDir SyncPolicy = "fsync+dir" // Also fsync the directory, so created and renamed files survive too
+)
+
+// DefaultSyncPolicy is the sync policy of a new SimpleFileStorage
+const DefaultSyncPolicy = SyncFsyncDir
+
+// ParseSyncPolicy parses a sync policy name
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(name); policy {
+	case SyncNone, SyncFsync, SyncFsyncDir:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown sync policy %q (expected %s, %s or %s)", name, SyncNone, SyncFsync, SyncFsyncDir)
+}
+
+// SetSyncPolicy sets how artifact data and metadata writes are flushed (default DefaultSyncPolicy).
+// Syncing trades write throughput for not losing metadata, or data it describes, on power failure.
+func (s *SimpleFileStorage) SetSyncPolicy(policy SyncPolicy) {
+	s.syncPolicy = policy
+}
+
+// SyncPolicy returns how artifact data and metadata writes are flushed
+func (s *SimpleFileStorage) SyncPolicy() SyncPolicy {
+	return s.syncPolicy
+}
+
+// syncFile flushes the written content of f, unless the policy is SyncNone
+func (s *SimpleFileStorage) syncFile(f *os.File) error {
+	if s.syncPolicy == SyncNone {
+		return nil
+	}
+	return f.Sync()
+}
+
+// syncPath flushes the written content of the file at path, unless the policy is SyncNone
+func (s *SimpleFileStorage) syncPath(path string) error {
+	if s.syncPolicy == SyncNone {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir flushes the entries of dir (files created or renamed into it) under SyncFsyncDir
+func (s *SimpleFileStorage) syncDir(dir string) error {
+	if s.syncPolicy != SyncFsyncDir {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
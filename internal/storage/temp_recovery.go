@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// DefaultTempRecoveryAge is the minimum age of a temp artifact before RecoverTempArtifacts touches it,
+// so creates still in progress are left alone
+const DefaultTempRecoveryAge = 24 * time.Hour
+
+// TempRecoveryReport summarizes a RecoverTempArtifacts run
+type TempRecoveryReport struct {
+	Committed int // Temp artifacts re-hashed and moved to their computed hash
+	Trashed   int // Temp artifacts moved to trash
+}
+
+// RecoverTempArtifacts reconciles temp artifacts left behind by a crash during Create, for use at startup.
+// Temp artifacts created more than olderThan ago are committed under their computed hash if they
+// carry references (their data and metadata were fully written; only the final move was lost), and
+// trashed otherwise. Data files without metadata are not listed by Walk; Reindex restores their
+// metadata, after which they are trashed here. Requires storage implementing EnumerableStorage.
+func (h *HashComputingArtifactStorage) RecoverTempArtifacts(ctx context.Context, olderThan time.Duration) (*TempRecoveryReport, error) {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	var orphans []*models.ArtifactMeta
+	err := h.Walk(ctx, func(meta *models.ArtifactMeta) error {
+		if IsTempHash(meta.Hash) && meta.CreatedTimestamp <= cutoff {
+			orphans = append(orphans, meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for temp artifacts: %w", err)
+	}
+
+	report := &TempRecoveryReport{}
+	for _, meta := range orphans {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if len(meta.References) == 0 {
+			if err := h.cleanupTempHash(ctx, meta.Hash); err != nil {
+				return report, fmt.Errorf("failed to trash %s: %w", meta.Hash, err)
+			}
+			report.Trashed++
+			continue
+		}
+		if err := h.commitTempHash(ctx, meta); err != nil {
+			return report, err
+		}
+		report.Committed++
+	}
+	return report, nil
+}
+
+// commitTempHash re-hashes a complete temp artifact and moves it to its computed hash,
+// merging its references into an existing artifact with that hash
+func (h *HashComputingArtifactStorage) commitTempHash(ctx context.Context, tempMeta *models.ArtifactMeta) error {
+	rc, _, err := h.storage.Read(ctx, models.ArtifactRange{
+		Hash:  tempMeta.Hash,
+		Range: models.ByteRange{Offset: 0, Length: -1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", tempMeta.Hash, err)
+	}
+	hasher := AcquireSHA256()
+	defer ReleaseSHA256(hasher)
+	_, err = io.Copy(hasher, rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", tempMeta.Hash, err)
+	}
+	computedHash := hex.EncodeToString(hasher.Sum(nil))
+
+	meta := &models.ArtifactMeta{References: tempMeta.References}
+	if existingMeta, err := h.storage.GetMeta(ctx, computedHash); err == nil && existingMeta != nil {
+		_, err = h.handleExistingHash(ctx, computedHash, tempMeta.Hash, tempMeta, meta)
+	} else {
+		_, err = h.moveToFinalHash(ctx, tempMeta.Hash, computedHash, tempMeta, meta)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to commit %s: %w", tempMeta.Hash, err)
+	}
+	return nil
+}
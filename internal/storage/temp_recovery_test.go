@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// TestHashComputingArtifactStorageRecoverTempArtifacts tests that orphaned temp artifacts are
+// committed or trashed once old enough, and fresh ones are left alone
+func TestHashComputingArtifactStorageRecoverTempArtifacts(t *testing.T) {
+	storage, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	wrapper := NewHashComputingArtifactStorage(storage)
+	ctx := context.Background()
+
+	stale := time.Now().Add(-2 * DefaultTempRecoveryAge).Unix()
+	seed := func(data []byte, created int64, refs ...models.ArtifactReference) string {
+		t.Helper()
+		tempHash := wrapper.generateTempHash()
+		if !IsTempHash(tempHash) {
+			t.Fatalf("Expected %s to be recognized as a temp hash", tempHash)
+		}
+		meta := &models.ArtifactMeta{CreatedTimestamp: created, References: refs}
+		if _, err := storage.Create(ctx, tempHash, bytes.NewReader(data), int64(len(data)), meta); err != nil {
+			t.Fatalf("Failed to seed temp artifact: %v", err)
+		}
+		return tempHash
+	}
+
+	// A crash before the move: complete data and metadata with the caller's references
+	complete := []byte("complete upload")
+	ref := models.ArtifactReference{Name: "ref1", Repo: "repo1", ReferencedTimestamp: stale}
+	completeTemp := seed(complete, stale, ref)
+	// A crash mid-create of an unreferenced artifact, and a create still in progress
+	orphanTemp := seed([]byte("orphaned"), stale)
+	freshTemp := seed([]byte("in progress"), time.Now().Unix())
+
+	report, err := wrapper.RecoverTempArtifacts(ctx, DefaultTempRecoveryAge)
+	if err != nil {
+		t.Fatalf("RecoverTempArtifacts failed: %v", err)
+	}
+	if report.Committed != 1 || report.Trashed != 1 {
+		t.Errorf("Expected 1 committed and 1 trashed, got %+v", report)
+	}
+
+	for _, hash := range []string{completeTemp, orphanTemp} {
+		if _, err := storage.GetMeta(ctx, hash); err == nil {
+			t.Errorf("Expected temp artifact %s to be reconciled", hash)
+		}
+	}
+	if _, err := storage.GetMeta(ctx, freshTemp); err != nil {
+		t.Errorf("Expected fresh temp artifact to be kept: %v", err)
+	}
+
+	sum := sha256.Sum256(complete)
+	meta, err := storage.GetMeta(ctx, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Expected complete upload to be committed under its hash: %v", err)
+	}
+	if meta.Length != int64(len(complete)) || len(meta.References) != 1 || meta.References[0].Name != "ref1" {
+		t.Errorf("Expected committed artifact to keep its length and references, got %+v", meta)
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// WriteConcurrency is an optional trailing StorageManager.Create parameter capping concurrent
+// write operations of the created storage with WriteLimitedArtifactStorage (<= 0 = unlimited)
+type WriteConcurrency int
+
+// WriteLimitedArtifactStorage wraps an ArtifactStorage to cap the number of concurrent write
+// operations (Create, Update, Delete, UpdateMeta, Move, Truncate). Excess writers wait for a free
+// slot, so heavy push load queues instead of saturating the disk; reads are never throttled.
+type WriteLimitedArtifactStorage struct {
+	storage models.ArtifactStorage
+	slots   chan struct{}
+	active  atomic.Int64
+	peak    atomic.Int64
+}
+
+// NewWriteLimitedArtifactStorage wraps storage allowing limit concurrent writes (limit must be positive)
+func NewWriteLimitedArtifactStorage(storage models.ArtifactStorage, limit int) (*WriteLimitedArtifactStorage, error) {
+	if storage == nil {
+		return nil, fmt.Errorf("storage cannot be nil")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("write limit must be positive")
+	}
+	return &WriteLimitedArtifactStorage{
+		storage: storage,
+		slots:   make(chan struct{}, limit),
+	}, nil
+}
+
+// Limit returns the maximum number of concurrent writes
+func (w *WriteLimitedArtifactStorage) Limit() int {
+	return cap(w.slots)
+}
+
+// ActiveWrites returns the number of writes currently running
+func (w *WriteLimitedArtifactStorage) ActiveWrites() int {
+	return int(w.active.Load())
+}
+
+// PeakWrites returns the highest number of concurrent writes observed
+func (w *WriteLimitedArtifactStorage) PeakWrites() int {
+	return int(w.peak.Load())
+}
+
+// acquire waits for a free write slot or until ctx is done
+func (w *WriteLimitedArtifactStorage) acquire(ctx context.Context) error {
+	select {
+	case w.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	active := w.active.Add(1)
+	for {
+		peak := w.peak.Load()
+		if active <= peak || w.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	return nil
+}
+
+// release frees a slot taken by acquire
+func (w *WriteLimitedArtifactStorage) release() {
+	w.active.Add(-1)
+	<-w.slots
+}
+
+// Alias returns the alias of the wrapped storage.
+func (w *WriteLimitedArtifactStorage) Alias() string {
+	return w.storage.Alias()
+}
+
+// Create stores a new artifact once a write slot is free; the slot is held while the content streams.
+func (w *WriteLimitedArtifactStorage) Create(ctx context.Context, hash string, r io.Reader, size int64, meta *models.ArtifactMeta) (*models.ArtifactMeta, error) {
+	if err := w.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer w.release()
+	return w.storage.Create(ctx, hash, r, size, meta)
+}
+
+// Read returns a stream for the requested data without waiting for a write slot.
+func (w *WriteLimitedArtifactStorage) Read(ctx context.Context, req models.ArtifactRange) (io.ReadCloser, models.ArtifactRange, error) {
+	return w.storage.Read(ctx, req)
+}
+
+// Update modifies a specific range once a write slot is free.
+func (w *WriteLimitedArtifactStorage) Update(ctx context.Context, req models.ArtifactRange, r io.Reader) error {
+	if err := w.acquire(ctx); err != nil {
+		return err
+	}
+	defer w.release()
+	return w.storage.Update(ctx, req, r)
+}
+
+// Delete removes a specific reference to an artifact once a write slot is free.
+func (w *WriteLimitedArtifactStorage) Delete(ctx context.Context, hash string, ref models.ArtifactReference) (*models.ArtifactMeta, error) {
+	if err := w.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer w.release()
+	return w.storage.Delete(ctx, hash, ref)
+}
+
+// GetMeta reads the metadata without waiting for a write slot.
+func (w *WriteLimitedArtifactStorage) GetMeta(ctx context.Context, hash string) (*models.ArtifactMeta, error) {
+	return w.storage.GetMeta(ctx, hash)
+}
+
+// UpdateMeta overwrites the metadata once a write slot is free.
+func (w *WriteLimitedArtifactStorage) UpdateMeta(ctx context.Context, meta models.ArtifactMeta) (*models.ArtifactMeta, error) {
+	if err := w.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer w.release()
+	return w.storage.UpdateMeta(ctx, meta)
+}
+
+// CompareAndSwapMeta replaces the metadata once a write slot is free (emulated if the wrapped
+// storage doesn't implement CASStorage).
+func (w *WriteLimitedArtifactStorage) CompareAndSwapMeta(ctx context.Context, expected, updated models.ArtifactMeta) (bool, error) {
+	if err := w.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer w.release()
+	return compareAndSwapMeta(ctx, w.storage, expected, updated)
+}
+
+// Move moves an artifact once a write slot is free, if the wrapped storage implements MoveStorage.
+func (w *WriteLimitedArtifactStorage) Move(ctx context.Context, srcHash, destHash string) error {
+	moveStorage, ok := w.storage.(MoveStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Move method")
+	}
+	if err := w.acquire(ctx); err != nil {
+		return err
+	}
+	defer w.release()
+	return moveStorage.Move(ctx, srcHash, destHash)
+}
+
+// MoveIfAbsent moves an artifact unless the destination exists, once a write slot is free.
+func (w *WriteLimitedArtifactStorage) MoveIfAbsent(ctx context.Context, srcHash, destHash string) error {
+	if err := w.acquire(ctx); err != nil {
+		return err
+	}
+	defer w.release()
+	return moveIfAbsentIn(ctx, w.storage, srcHash, destHash)
+}
+
+// Truncate resizes an artifact once a write slot is free, if the wrapped storage implements TruncateStorage.
+func (w *WriteLimitedArtifactStorage) Truncate(ctx context.Context, hash string, size int64) error {
+	truncateStorage, ok := w.storage.(TruncateStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Truncate method")
+	}
+	if err := w.acquire(ctx); err != nil {
+		return err
+	}
+	defer w.release()
+	return truncateStorage.Truncate(ctx, hash, size)
+}
+
+// Walk iterates the metadata of all artifacts in the wrapped storage.
+func (w *WriteLimitedArtifactStorage) Walk(ctx context.Context, fn func(meta *models.ArtifactMeta) error) error {
+	enumerable, ok := w.storage.(EnumerableStorage)
+	if !ok {
+		return fmt.Errorf("underlying storage does not implement Walk method")
+	}
+	return enumerable.Walk(ctx, fn)
+}
+
+// Exists delegates to the wrapped storage, falling back to GetMeta if it doesn't implement ExistsStorage.
+func (w *WriteLimitedArtifactStorage) Exists(ctx context.Context, hash string) (bool, bool, error) {
+	return existsIn(ctx, w.storage, hash)
+}
+
+// Size delegates to the wrapped storage, falling back to GetMeta if it doesn't implement SizeStorage.
+func (w *WriteLimitedArtifactStorage) Size(ctx context.Context, hash string) (int64, bool, error) {
+	return sizeIn(ctx, w.storage, hash)
+}
+
+// RedirectURL delegates to the wrapped storage if it implements RedirectStorage.
+func (w *WriteLimitedArtifactStorage) RedirectURL(ctx context.Context, hash string) (string, bool) {
+	redirect, ok := w.storage.(RedirectStorage)
+	if !ok {
+		return "", false
+	}
+	return redirect.RedirectURL(ctx, hash)
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/basakil/brm-server/pkg/models"
+)
+
+// gatedReader blocks its first Read until gate is closed
+type gatedReader struct {
+	r    io.Reader
+	gate chan struct{}
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	<-g.gate
+	return g.r.Read(p)
+}
+
+// TestWriteLimitedArtifactStorage tests that writes beyond the limit queue while reads proceed
+func TestWriteLimitedArtifactStorage(t *testing.T) {
+	simple, err := NewSimpleFileStorage("test-storage", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	limited, err := NewWriteLimitedArtifactStorage(simple, 2)
+	if err != nil {
+		t.Fatalf("Failed to create write-limited storage: %v", err)
+	}
+	ctx := context.Background()
+
+	existing := []byte("readable while writes queue")
+	if _, err := limited.Create(ctx, "existing", bytes.NewReader(existing), int64(len(existing)), nil); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	const numWrites = 5
+	gate := make(chan struct{})
+	errs := make(chan error, numWrites)
+	for i := 0; i < numWrites; i++ {
+		go func(i int) {
+			data := bytes.Repeat([]byte{byte(i)}, 1024)
+			_, err := limited.Create(ctx, fmt.Sprintf("blob-%d", i), &gatedReader{r: bytes.NewReader(data), gate: gate}, int64(len(data)), nil)
+			errs <- err
+		}(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for limited.ActiveWrites() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if active := limited.ActiveWrites(); active != 2 {
+		t.Fatalf("Expected 2 writes in flight, got %d", active)
+	}
+
+	// Reads don't wait for the blocked writers
+	readDone := make(chan error, 1)
+	go func() {
+		if _, err := limited.GetMeta(ctx, "existing"); err != nil {
+			readDone <- err
+			return
+		}
+		rc, _, err := limited.Read(ctx, models.ArtifactRange{Hash: "existing", Range: models.ByteRange{Offset: 0, Length: -1}})
+		if err != nil {
+			readDone <- err
+			return
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err == nil && !bytes.Equal(data, existing) {
+			t.Error("Read data mismatch")
+		}
+		readDone <- err
+	}()
+	select {
+	case err := <-readDone:
+		if err != nil {
+			t.Errorf("Read failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read blocked behind queued writes")
+	}
+
+	// A queued writer gives up when its context is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := limited.UpdateMeta(timeoutCtx, models.ArtifactMeta{Hash: "existing"}); err != context.DeadlineExceeded {
+		t.Errorf("Expected a queued write to time out, got %v", err)
+	}
+
+	close(gate)
+	for i := 0; i < numWrites; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Create failed: %v", err)
+		}
+	}
+	if peak := limited.PeakWrites(); peak != 2 {
+		t.Errorf("Expected peak write concurrency 2, got %d", peak)
+	}
+	if active := limited.ActiveWrites(); active != 0 {
+		t.Errorf("Expected no writes in flight, got %d", active)
+	}
+}
+
+// TestStorageManagerWriteConcurrency tests that WriteConcurrency limits writes without changing the storage type
+func TestStorageManagerWriteConcurrency(t *testing.T) {
+	manager := GetManager()
+	instance, err := manager.Create("hashcomputing.filestorage", "write-limited-test", t.TempDir(), WriteConcurrency(3))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	hashStorage, ok := instance.(*HashComputingArtifactStorage)
+	if !ok {
+		t.Fatalf("Expected *HashComputingArtifactStorage, got %T", instance)
+	}
+	if limited, ok := hashStorage.storage.(*WriteLimitedArtifactStorage); !ok || limited.Limit() != 3 {
+		t.Errorf("Expected writes limited to 3, got %T", hashStorage.storage)
+	}
+
+	saved := manager.SaveToConfig()["write-limited-test"].(map[string]interface{})
+	if limit := saved["params"].(map[string]interface{})["writeConcurrency"]; limit != 3 {
+		t.Errorf("Expected writeConcurrency 3 in saved config, got %v", limit)
+	}
+}
@@ -17,8 +17,20 @@ type ArtifactReference struct {
 type ArtifactMeta struct {
 	Hash             string              `json:"hash"`
 	Length           int64               `json:"length"`
-	CreatedTimestamp int64               `json:"createdTimestamp"` // When artifact data was first created
-	References       []ArtifactReference `json:"references"`       // List of references to this artifact
+	CreatedTimestamp int64               `json:"createdTimestamp"`           // When artifact data was first created
+	References       []ArtifactReference `json:"references"`                 // List of references to this artifact
+	ContentDigest    string              `json:"contentDigest,omitempty"`    // Verified "sha256:<hex>" of the content, if recorded
+	MediaType        string              `json:"mediaType,omitempty"`        // Content media type, if known (older metadata lacks it)
+	ExpiresTimestamp int64               `json:"expiresTimestamp,omitempty"` // When the artifact expires (Unix seconds, 0 = never)
+	Generation       int64               `json:"generation,omitempty"`       // 1 on creation, bumped on every metadata write (0 = older metadata), for compare-and-swap
+}
+
+// Normalize replaces a nil References slice (e.g. decoded from "references": null, as written by
+// older versions) with an empty one, so metadata never carries or persists null references.
+func (m *ArtifactMeta) Normalize() {
+	if m.References == nil {
+		m.References = []ArtifactReference{}
+	}
 }
 
 // HashConflictError is returned when Create is called with a size that doesn't match an existing artifact
@@ -36,6 +48,26 @@ func (e *HashConflictError) Error() string {
 	return fmt.Sprintf("hash conflict: artifact with hash %s already exists with length %d, but provided length is %d", e.Hash, e.ExistingLength, e.ProvidedLength)
 }
 
+// MissingMetadataError is returned in strict mode when artifact data exists without its metadata
+type MissingMetadataError struct {
+	Hash string
+}
+
+func (e *MissingMetadataError) Error() string {
+	return fmt.Sprintf("metadata missing: artifact with hash %s exists without metadata", e.Hash)
+}
+
+// IntegrityError is returned when stored content no longer matches its expected digest
+type IntegrityError struct {
+	Hash     string
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check failed: artifact with hash %s has content digest %s, expected %s", e.Hash, e.Actual, e.Expected)
+}
+
 // Artifact struct is REMOVED.
 // We do not want a struct representing the binary data in memory.
 // We use io.Reader and io.ReadCloser instead.
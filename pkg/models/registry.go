@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"net"
+	"net/http"
 )
 
 // ServiceBinding represents a network address binding (IP and port) for a registry service.
@@ -84,6 +85,10 @@ type Registry interface {
 
 	// Alias returns the alias/name of the registry.
 	Alias() string
+
+	// Handlers returns the HTTP handler serving the registry's API routes,
+	// so servers can mount any registry without knowing its implementation.
+	Handlers() http.Handler
 }
 
 // UpstreamRegistry represents the configuration for an upstream registry used by proxy registries.
@@ -101,6 +106,15 @@ type UpstreamRegistry struct {
 	// TTL is the cache time-to-live in seconds. After this period, cached artifacts may be refreshed.
 	// If 0, uses default TTL (typically 168 hours / 604800 seconds).
 	TTL int64 `json:"ttl,omitempty"`
+
+	// Mirrors is an optional ordered list of fallback upstreams, tried after URL fails or
+	// doesn't have the requested content. Each mirror carries its own credentials.
+	Mirrors []UpstreamRegistry `json:"mirrors,omitempty"`
+
+	// NormalizeLibrary prepends "library/" to single-segment repository names (official images,
+	// e.g. "ubuntu" -> "library/ubuntu") in requests to this upstream. If nil, it is enabled for
+	// Docker Hub upstreams only.
+	NormalizeLibrary *bool `json:"normalizeLibrary,omitempty"`
 }
 
 // PrivateRegistry represents a private registry that stores artifacts locally.
@@ -0,0 +1,6 @@
+// Package version holds the build version of brm-server
+package version
+
+// Version is the brm-server version, overridden at build time with
+// -ldflags "-X github.com/basakil/brm-server/pkg/version.Version=<version>"
+var Version = "dev"